@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// checkProject 以--check参数运行项目，由框架在打开服务后输出自检结果，有错误时退出码为1
+func checkProject(dir string, env []string) error {
+	if _, err := readModule(dir); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("go", append([]string{"run", ".", "--check"}, env...)...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("self check failed: %v", err)
+	}
+	return nil
+}
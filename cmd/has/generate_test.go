@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//生成的文件与testdata中的golden文件比较，修改模板后以 go test ./cmd/has -update 更新
+
+var update = flag.Bool("update", false, "update golden files")
+
+// checkGolden 比较dir下的全部文件与testdata/golden下的同名文件(加.golden后缀)
+func checkGolden(t *testing.T, dir string, golden string) {
+	t.Helper()
+
+	files := make(map[string]bool)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = true
+
+		got, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		gf := filepath.Join(golden, rel+".golden")
+		if *update {
+			if err = os.MkdirAll(filepath.Dir(gf), 0755); err != nil {
+				return err
+			}
+			return os.WriteFile(gf, got, 0644)
+		}
+		want, err := os.ReadFile(gf)
+		if err != nil {
+			t.Errorf("unexpected file %s", rel)
+			return nil
+		}
+		if string(got) != string(want) {
+			t.Errorf("%s differs from %s:\n%s", rel, gf, got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//golden中有而未生成的文件
+	_ = filepath.Walk(golden, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(golden, path)
+		if rel = strings.TrimSuffix(filepath.ToSlash(rel), ".golden"); !files[rel] {
+			t.Errorf("file %s not generated", rel)
+		}
+		return nil
+	})
+}
+
+func TestGenerateProject(t *testing.T) {
+	dir := t.TempDir()
+	if err := newProject(dir, "example.com/demo"); err != nil {
+		t.Fatal(err)
+	}
+	entities := []struct {
+		kind string
+		name string
+		typ  string
+	}{
+		{entityService, "Order", ""},
+		{entityPlugin, "Cache", ""},
+		{entityMiddleware, "Audit", "in"},
+		{entityConnector, "Grpc", ""},
+	}
+	for _, e := range entities {
+		if err := newEntity(dir, e.kind, e.name, e.typ); err != nil {
+			t.Fatalf("failed to create %s %s: %v", e.kind, e.name, err)
+		}
+	}
+	err := addSlot("order", "CreateOrder", &slotArgs{
+		Dir:     dir,
+		Params:  []string{"name:string:require", "count:int", "tags:[]string"},
+		Version: "v1",
+		Limit:   "10:20",
+		Cache:   60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = addSlot("order", "createOrder", &slotArgs{Dir: dir, Version: "v1"}); err == nil {
+		t.Error("duplicated slot added")
+	}
+	//接口已存在时不修改任何文件，生成的文件仍与golden一致
+	err = addSlot("order", "ListOrders", &slotArgs{Dir: dir, API: "createOrder", Version: "v1", Limit: "5", Cache: 30})
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("duplicated api added: %v", err)
+	}
+
+	checkGolden(t, dir, filepath.Join("testdata", "project"))
+}
+
+func TestAddAPIPreserves(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fileAPI)
+	src, err := os.ReadFile(filepath.Join("testdata", "api.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, src, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = addAPI(path, "v1", "createOrder", "order", "CreateOrder"); err != nil {
+		t.Fatal(err)
+	}
+	if err = addAPI(path, "v3", "listOrders", "order", "ListOrders"); err != nil {
+		t.Fatal(err)
+	}
+	if err = addAPI(path, "v1", "getOrder", "order", "GetOrder"); err == nil {
+		t.Error("duplicated api added")
+	}
+
+	checkGolden(t, filepath.Dir(path), filepath.Join("testdata", "api"))
+}
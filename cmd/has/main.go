@@ -0,0 +1,146 @@
+package main
+
+//has 项目脚手架及代码生成工具：
+//	has new demo                              创建项目，包括main.go、conf.toml、api.json及lang目录
+//	has service Order                         创建服务services/ordersvs，服务名为order
+//	has plugin Cache                          创建插件plugins/cacheplugin
+//	has middleware Audit --type in            创建middleware middlewares/auditmw
+//	has connector Grpc                        创建connector connectors/grpcconnector
+//	has slot order CreateOrder -p name:string:require -p count:int --api createOrder --version v1
+//	has check dev                             以dev环境配置运行项目自检，检查配置段、api.json映射的slot及引用的接口
+//生成的实体在conf.toml中添加配置段，并注册到main.go中的has:标记处
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mkideal/cli"
+)
+
+type rootArgs struct {
+	cli.Helper
+}
+
+type dirArgs struct {
+	cli.Helper
+	Dir string `cli:"d,dir" usage:"project directory" dft:"."`
+}
+
+type newArgs struct {
+	cli.Helper
+	Module string `cli:"m,module" usage:"module path, defaults to the directory name"`
+}
+
+type middlewareArgs struct {
+	cli.Helper
+	Dir  string `cli:"d,dir" usage:"project directory" dft:"."`
+	Type string `cli:"t,type" usage:"middleware type: in, out or inout" dft:"inout"`
+}
+
+type slotArgs struct {
+	cli.Helper
+	Dir     string   `cli:"d,dir" usage:"project directory" dft:"."`
+	Params  []string `cli:"p,param" usage:"slot parameter name:type[:require], type is string, int, float, bool or []string"`
+	API     string   `cli:"api" usage:"api name in api.json, defaults to the slot name in lower camel case"`
+	Version string   `cli:"version" usage:"api version in api.json" dft:"v1"`
+	NoAPI   bool     `cli:"no-api" usage:"do not add the slot to api.json"`
+	Limit   string   `cli:"limit" usage:"rate limit of the slot added to LimitedSlots, rate[:burst]"`
+	Cache   int      `cli:"cache" usage:"cache ttl(seconds) of the slot added to CachedSlots"`
+}
+
+var root = &cli.Command{
+	Desc: "has project scaffolding and code generation",
+	Argv: func() interface{} { return new(rootArgs) },
+	Fn: func(ctx *cli.Context) error {
+		ctx.WriteUsage()
+		return nil
+	},
+}
+
+var newCmd = &cli.Command{
+	Name:   "new",
+	Desc:   "create a project: has new <dir>",
+	Argv:   func() interface{} { return new(newArgs) },
+	NumArg: cli.ExactN(1),
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*newArgs)
+		return newProject(ctx.Args()[0], argv.Module)
+	},
+}
+
+var serviceCmd = &cli.Command{
+	Name:   "service",
+	Desc:   "create a service: has service <Name>",
+	Argv:   func() interface{} { return new(dirArgs) },
+	NumArg: cli.ExactN(1),
+	Fn: func(ctx *cli.Context) error {
+		return newEntity(ctx.Argv().(*dirArgs).Dir, entityService, ctx.Args()[0], "")
+	},
+}
+
+var pluginCmd = &cli.Command{
+	Name:   "plugin",
+	Desc:   "create a plugin: has plugin <Name>",
+	Argv:   func() interface{} { return new(dirArgs) },
+	NumArg: cli.ExactN(1),
+	Fn: func(ctx *cli.Context) error {
+		return newEntity(ctx.Argv().(*dirArgs).Dir, entityPlugin, ctx.Args()[0], "")
+	},
+}
+
+var middlewareCmd = &cli.Command{
+	Name:   "middleware",
+	Desc:   "create a middleware: has middleware <Name>",
+	Argv:   func() interface{} { return new(middlewareArgs) },
+	NumArg: cli.ExactN(1),
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*middlewareArgs)
+		return newEntity(argv.Dir, entityMiddleware, ctx.Args()[0], argv.Type)
+	},
+}
+
+var connectorCmd = &cli.Command{
+	Name:   "connector",
+	Desc:   "create a connector: has connector <Name>",
+	Argv:   func() interface{} { return new(dirArgs) },
+	NumArg: cli.ExactN(1),
+	Fn: func(ctx *cli.Context) error {
+		return newEntity(ctx.Argv().(*dirArgs).Dir, entityConnector, ctx.Args()[0], "")
+	},
+}
+
+var slotCmd = &cli.Command{
+	Name:   "slot",
+	Desc:   "add a slot to a service: has slot <service> <Slot>",
+	Argv:   func() interface{} { return new(slotArgs) },
+	NumArg: cli.ExactN(2),
+	Fn: func(ctx *cli.Context) error {
+		return addSlot(ctx.Args()[0], ctx.Args()[1], ctx.Argv().(*slotArgs))
+	},
+}
+
+var checkCmd = &cli.Command{
+	Name:   "check",
+	Desc:   "run the self check of a project: has check [env]",
+	Argv:   func() interface{} { return new(dirArgs) },
+	NumArg: cli.AtMost(1),
+	Fn: func(ctx *cli.Context) error {
+		return checkProject(ctx.Argv().(*dirArgs).Dir, ctx.Args())
+	},
+}
+
+func main() {
+	err := cli.Root(root,
+		cli.Tree(newCmd),
+		cli.Tree(serviceCmd),
+		cli.Tree(pluginCmd),
+		cli.Tree(middlewareCmd),
+		cli.Tree(connectorCmd),
+		cli.Tree(slotCmd),
+		cli.Tree(checkCmd),
+	).Run(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
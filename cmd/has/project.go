@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/drharryhe/has/core"
+)
+
+const (
+	entityService    = "service"
+	entityPlugin     = "plugin"
+	entityMiddleware = "middleware"
+	entityConnector  = "connector"
+
+	fileGoMod   = "go.mod"
+	fileMain    = "main.go"
+	fileConf    = "conf.toml"
+	fileAPI     = "api.json"
+	defaultLang = "cn-zh"
+)
+
+var (
+	identPattern   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+	modulePattern  = regexp.MustCompile(`(?m)^module\s+(\S+)`)
+	sectionPattern = regexp.MustCompile(`^\s*\[([^\]]+)\]\s*$`)
+)
+
+// entityLayout 生成的实体：目录、包名、配置结构名及在main.go中的注册位置
+type entityLayout struct {
+	Dir     string
+	Pkg     string
+	Conf    string
+	Base    string //middleware的基类
+	In      bool
+	Out     bool
+	Name    string //服务名或connector名
+	Mark    string
+	Entry   string //注册代码
+	Section string //conf.toml中的配置段
+}
+
+// newProject 创建项目，已存在的文件不覆盖
+func newProject(dir string, module string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if module == "" {
+		module = filepath.Base(abs)
+	}
+	data := map[string]string{"Module": module, "Name": filepath.Base(module)}
+
+	if err = os.MkdirAll(filepath.Join(dir, core.LangDir), 0755); err != nil {
+		return err
+	}
+
+	def, _ := jsoniter.MarshalIndent(&core.APIDefine{
+		Name:        filepath.Base(module),
+		APIVersions: []core.OpenAPI{{Version: "v1", APIs: []core.API{}}},
+	}, "", "  ")
+
+	files := []struct {
+		name string
+		tpl  string
+	}{
+		{fileGoMod, tplGoMod},
+		{fileMain, tplMain},
+		{fileConf, tplConf},
+		{fileAPI, string(def) + "\n"},
+		{filepath.Join(core.LangDir, defaultLang+".json"), tplLang},
+	}
+	for _, f := range files {
+		if err = writeTemplate(filepath.Join(dir, f.name), f.tpl, data); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("project %s created in %s, run 'go mod tidy' to fetch dependencies\n", module, dir)
+	return nil
+}
+
+// newEntity 创建服务、插件、middleware或connector，添加配置段并注册到main.go
+func newEntity(dir string, kind string, name string, mwType string) error {
+	if !identPattern.MatchString(name) {
+		return fmt.Errorf("invalid name [%s], letters and digits only", name)
+	}
+	name = exported(name)
+	lower := strings.ToLower(name)
+
+	module, err := readModule(dir)
+	if err != nil {
+		return err
+	}
+
+	var l entityLayout
+	var files map[string]string
+	switch kind {
+	case entityService:
+		l = entityLayout{Dir: "services", Pkg: lower + "svs", Conf: name + "Service", Name: lowerCamel(name), Mark: markServices}
+		l.Entry = fmt.Sprintf("gw.Server().RegisterService(%s.New(), nil)", l.Pkg)
+		l.Section = fmt.Sprintf("Name = '%s'\nLimitedSlots = ''\nCachedSlots = ''\n", l.Name)
+		files = map[string]string{"conf.go": tplServiceConf, "service.go": tplService, "slots.go": tplSlots}
+	case entityPlugin:
+		l = entityLayout{Dir: "plugins", Pkg: lower + "plugin", Conf: name + "Plugin", Mark: markPlugins}
+		l.Entry = l.Pkg + ".New(),"
+		files = map[string]string{"conf.go": tplPluginConf, "plugin.go": tplPlugin}
+	case entityMiddleware:
+		l = entityLayout{Dir: "middlewares", Pkg: lower + "mw", Conf: name + "Middleware", Mark: markMiddlewares}
+		switch strings.ToLower(mwType) {
+		case core.MiddlewareTypeIn:
+			l.Base, l.In = "InMiddleware", true
+		case core.MiddlewareTypeOut:
+			l.Base, l.Out = "OutMiddleware", true
+		case "inout", core.MiddlewareTypeInOut:
+			l.Base, l.In, l.Out = "InOutMiddleware", true, true
+		default:
+			return fmt.Errorf("invalid middleware type [%s], must be in, out or inout", mwType)
+		}
+		l.Entry = l.Pkg + ".New(),"
+		files = map[string]string{"conf.go": tplMiddlewareConf, "middleware.go": tplMiddleware}
+	case entityConnector:
+		l = entityLayout{Dir: "connectors", Pkg: lower + "connector", Conf: name + "Connector", Name: lowerCamel(name), Mark: markConnectors}
+		l.Entry = l.Pkg + ".New(),"
+		l.Section = fmt.Sprintf("Name = '%s'\nPacker = 'JsonPacker'\n", l.Name)
+		files = map[string]string{"conf.go": tplConnectorConf, "connector.go": tplConnector}
+	default:
+		return fmt.Errorf("unknown entity type [%s]", kind)
+	}
+
+	pkgDir := filepath.Join(dir, l.Dir, l.Pkg)
+	if _, err = os.Stat(pkgDir); err == nil {
+		return fmt.Errorf("%s already exists", pkgDir)
+	}
+	if err = os.MkdirAll(pkgDir, 0755); err != nil {
+		return err
+	}
+	for f, tpl := range files {
+		if err = writeTemplate(filepath.Join(pkgDir, f), tpl, &l); err != nil {
+			return err
+		}
+	}
+
+	if err = addConfSection(filepath.Join(dir, fileConf), l.Conf, l.Section); err != nil {
+		return err
+	}
+	mainFile := filepath.Join(dir, fileMain)
+	if err = insertAtMark(mainFile, markImports, fmt.Sprintf("%q", module+"/"+l.Dir+"/"+l.Pkg)); err != nil {
+		return err
+	}
+	if err = insertAtMark(mainFile, l.Mark, l.Entry); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s %s created in %s\n", kind, name, pkgDir)
+	return nil
+}
+
+// writeTemplate 按模板生成文件，文件已存在时跳过
+func writeTemplate(path string, tpl string, data interface{}) error {
+	if _, err := os.Stat(path); err == nil {
+		fmt.Printf("%s exists, skipped\n", path)
+		return nil
+	}
+
+	t, err := template.New(filepath.Base(path)).Parse(tpl)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, data); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeSource(path, buf.Bytes())
+}
+
+// writeSource 写入文件，Go源文件先格式化
+func writeSource(path string, src []byte) error {
+	if filepath.Ext(path) == ".go" {
+		if bs, err := format.Source(src); err == nil {
+			src = bs
+		}
+	}
+	return os.WriteFile(path, src, 0644)
+}
+
+// readModule 读取项目go.mod中的模块路径
+func readModule(dir string) (string, error) {
+	bs, err := os.ReadFile(filepath.Join(dir, fileGoMod))
+	if err != nil {
+		return "", fmt.Errorf("%s is not a has project: %v", dir, err)
+	}
+	m := modulePattern.FindSubmatch(bs)
+	if m == nil {
+		return "", fmt.Errorf("module not found in %s", filepath.Join(dir, fileGoMod))
+	}
+	return string(m[1]), nil
+}
+
+// insertAtMark 在标记行之前插入一行，缩进与标记相同。没有标记时提示手工添加
+func insertAtMark(path string, mark string, line string) error {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(bs), "\n")
+	for i, l := range lines {
+		if strings.TrimSpace(l) != mark {
+			continue
+		}
+		indent := l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+		lines = append(lines[:i], append([]string{indent + line}, lines[i:]...)...)
+		return writeSource(path, []byte(strings.Join(lines, "\n")))
+	}
+
+	fmt.Printf("mark %s not found in %s, please add manually: %s\n", mark, path, line)
+	return nil
+}
+
+// addConfSection 在conf.toml末尾添加配置段，已存在时跳过
+func addConfSection(path string, section string, body string) error {
+	bs, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if confSectionRange(strings.Split(string(bs), "\n"), section) != nil {
+		return nil
+	}
+
+	s := strings.TrimRight(string(bs), "\n")
+	if s != "" {
+		s += "\n\n"
+	}
+	s += "[" + section + "]\n" + body
+	return os.WriteFile(path, []byte(s), 0644)
+}
+
+// setConfItem 设置配置段中字符串配置项的值，配置项不存在时添加到段末。
+// 只修改内存中的配置文件内容，path仅用于错误信息
+func setConfItem(bs []byte, path string, section string, key string, update func(old string) string) ([]byte, error) {
+	lines := strings.Split(string(bs), "\n")
+	r := confSectionRange(lines, section)
+	if r == nil {
+		return nil, fmt.Errorf("section [%s] not found in %s", section, path)
+	}
+
+	item := confItemPattern(key)
+	for i := r[0]; i < r[1]; i++ {
+		if m := item.FindStringSubmatch(lines[i]); m != nil {
+			lines[i] = fmt.Sprintf("%s = '%s'", key, update(m[1]))
+			return []byte(strings.Join(lines, "\n")), nil
+		}
+	}
+
+	end := r[1]
+	for end > r[0] && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	lines = append(lines[:end], append([]string{fmt.Sprintf("%s = '%s'", key, update(""))}, lines[end:]...)...)
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// getConfItem 读取配置段中字符串配置项的值
+func getConfItem(path string, section string, key string) string {
+	bs, _ := os.ReadFile(path)
+	lines := strings.Split(string(bs), "\n")
+	r := confSectionRange(lines, section)
+	if r == nil {
+		return ""
+	}
+
+	item := confItemPattern(key)
+	for i := r[0]; i < r[1]; i++ {
+		if m := item.FindStringSubmatch(lines[i]); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+func confItemPattern(key string) *regexp.Regexp {
+	return regexp.MustCompile(`^\s*` + regexp.QuoteMeta(key) + `\s*=\s*['"](.*)['"]\s*$`)
+}
+
+// confSectionRange 配置段的内容行范围[begin, end)，不存在时返回nil
+func confSectionRange(lines []string, section string) []int {
+	begin := -1
+	for i, l := range lines {
+		m := sectionPattern.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		if begin >= 0 {
+			return []int{begin, i}
+		}
+		if strings.TrimSpace(m[1]) == section {
+			begin = i + 1
+		}
+	}
+	if begin >= 0 {
+		return []int{begin, len(lines)}
+	}
+	return nil
+}
+
+// findServiceConf 按服务名查找服务目录及配置结构名
+func findServiceConf(dir string, service string) (string, string, error) {
+	pkgDir := filepath.Join(dir, "services", strings.ToLower(service)+"svs")
+	f, err := os.Open(filepath.Join(pkgDir, "conf.go"))
+	if err != nil {
+		return "", "", fmt.Errorf("service [%s] not found: %v", service, err)
+	}
+	defer f.Close()
+
+	confType := regexp.MustCompile(`^type\s+(\w+)\s+struct`)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if m := confType.FindStringSubmatch(scanner.Text()); m != nil {
+			return pkgDir, m[1], nil
+		}
+	}
+	return "", "", fmt.Errorf("config struct of service [%s] not found", service)
+}
+
+func exported(s string) string {
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+func lowerCamel(s string) string {
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/drharryhe/has/core"
+)
+
+// slotParamTypes 参数类型 -> 请求结构中的字段类型
+var slotParamTypes = map[string]string{
+	"string":   "*string",
+	"int":      "*int",
+	"float":    "*float64",
+	"bool":     "*bool",
+	"[]string": "[]string",
+	"any":      "interface{}",
+}
+
+type slotParam struct {
+	Name    string
+	Field   string
+	Type    string
+	Require bool
+}
+
+// addSlot 在服务的slots.go中添加slot，并按参数更新api.json及conf.toml
+func addSlot(service string, slot string, argv *slotArgs) error {
+	if !identPattern.MatchString(slot) {
+		return fmt.Errorf("invalid slot name [%s], letters and digits only", slot)
+	}
+	slot = exported(slot)
+
+	pkgDir, confType, err := findServiceConf(argv.Dir, service)
+	if err != nil {
+		return err
+	}
+
+	params, err := parseSlotParams(argv.Params)
+	if err != nil {
+		return err
+	}
+
+	slotsFile := filepath.Join(pkgDir, "slots.go")
+	src, err := os.ReadFile(slotsFile)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(src, []byte(fmt.Sprintf(") %s(", slot))) {
+		return fmt.Errorf("slot [%s] already exists in %s", slot, slotsFile)
+	}
+	if !bytes.Contains(src, []byte(`"github.com/drharryhe/has/core"`)) {
+		fmt.Printf("please import github.com/drharryhe/has/core in %s\n", slotsFile)
+	}
+
+	var buf bytes.Buffer
+	t := template.Must(template.New("slot").Parse(tplSlot))
+	if err = t.Execute(&buf, map[string]interface{}{"Slot": slot, "Params": params}); err != nil {
+		return err
+	}
+	src = append(bytes.TrimRight(src, "\n"), "\n\n"...)
+	src = append(src, bytes.TrimRight(buf.Bytes(), "\n")...)
+
+	//先生成全部文件的新内容，检查通过后再写入，避免只修改了部分文件
+	var apiFile string
+	var apiSrc []byte
+	confFile := filepath.Join(argv.Dir, fileConf)
+	if !argv.NoAPI {
+		//接口映射到服务配置的名称
+		if name := getConfItem(confFile, confType, "Name"); name != "" {
+			service = name
+		}
+		api := argv.API
+		if api == "" {
+			api = lowerCamel(slot)
+		}
+		apiFile = filepath.Join(argv.Dir, fileAPI)
+		if apiSrc, err = os.ReadFile(apiFile); err != nil {
+			return err
+		}
+		if apiSrc, err = appendAPI(apiSrc, apiFile, argv.Version, api, service, slot); err != nil {
+			return err
+		}
+	}
+
+	var confSrc []byte
+	if argv.Limit != "" || argv.Cache > 0 {
+		if confSrc, err = os.ReadFile(confFile); err != nil {
+			return err
+		}
+	}
+	if argv.Limit != "" {
+		if confSrc, err = setConfItem(confSrc, confFile, confType, "LimitedSlots", appendItem(slot+":"+argv.Limit)); err != nil {
+			return err
+		}
+	}
+	if argv.Cache > 0 {
+		if confSrc, err = setConfItem(confSrc, confFile, confType, "CachedSlots", appendItem(fmt.Sprintf("%s:%d", slot, argv.Cache))); err != nil {
+			return err
+		}
+	}
+
+	if err = writeSource(slotsFile, append(src, '\n')); err != nil {
+		return err
+	}
+	if apiSrc != nil {
+		if err = os.WriteFile(apiFile, apiSrc, 0644); err != nil {
+			return err
+		}
+	}
+	if confSrc != nil {
+		if err = os.WriteFile(confFile, confSrc, 0644); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("slot %s added to service %s\n", slot, service)
+	return nil
+}
+
+// parseSlotParams 解析 name:type[:require]，type缺省为string
+func parseSlotParams(ps []string) ([]slotParam, error) {
+	var ret []slotParam
+	for _, p := range ps {
+		vv := strings.Split(p, ":")
+		if len(vv) > 3 || vv[0] == "" {
+			return nil, fmt.Errorf("invalid slot parameter [%s]", p)
+		}
+
+		sp := slotParam{Name: vv[0], Field: exported(vv[0]), Type: slotParamTypes["string"]}
+		if !identPattern.MatchString(sp.Name) {
+			return nil, fmt.Errorf("invalid slot parameter name [%s]", sp.Name)
+		}
+		if len(vv) > 1 && vv[1] != "" {
+			if sp.Type = slotParamTypes[vv[1]]; sp.Type == "" {
+				return nil, fmt.Errorf("invalid type of slot parameter [%s]", p)
+			}
+		}
+		if len(vv) == 3 {
+			if vv[2] != "require" {
+				return nil, fmt.Errorf("invalid slot parameter [%s]", p)
+			}
+			sp.Require = true
+		}
+		ret = append(ret, sp)
+	}
+	return ret, nil
+}
+
+// addAPI 在api.json的指定版本中添加接口，版本不存在时新建。
+// 只修改接口列表，其余内容(包括未识别的字段)及键的顺序保持不变
+func addAPI(path string, version string, name string, service string, slot string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if src, err = appendAPI(src, path, version, name, service, slot); err != nil {
+		return err
+	}
+	return os.WriteFile(path, src, 0644)
+}
+
+// appendAPI 同addAPI，返回添加接口后的api.json内容，path仅用于错误信息
+func appendAPI(src []byte, path string, version string, name string, service string, slot string) ([]byte, error) {
+	var err error
+	var def jsonObject
+	if err = json.Unmarshal(src, &def); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	var versions []jsonObject
+	if err = def.decode("versions", &versions); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	var ver *jsonObject
+	for i := range versions {
+		var v string
+		if err = versions[i].decode("version", &v); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		if v == version {
+			ver = &versions[i]
+		}
+	}
+	if ver == nil {
+		versions = append(versions, jsonObject{})
+		ver = &versions[len(versions)-1]
+		if err = ver.encode("version", version); err != nil {
+			return nil, err
+		}
+	}
+
+	var apis []json.RawMessage
+	if err = ver.decode("apis", &apis); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	for _, raw := range apis {
+		var a struct {
+			Name string `json:"name"`
+		}
+		if err = json.Unmarshal(raw, &a); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		if a.Name == name {
+			return nil, fmt.Errorf("api [%s] already exists in version %s", name, version)
+		}
+	}
+	api, err := marshalJSON(&core.API{Name: name, EndPoint: core.EndPoint{Service: service, Slot: slot}})
+	if err != nil {
+		return nil, err
+	}
+	if err = ver.encode("apis", append(apis, api)); err != nil {
+		return nil, err
+	}
+	if err = def.encode("versions", versions); err != nil {
+		return nil, err
+	}
+
+	bs, err := marshalJSON(def)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = json.Indent(&buf, bs, "", jsonIndent(src)); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// jsonIndent 沿用文件原有的缩进，缺省两个空格
+func jsonIndent(src []byte) string {
+	for _, l := range strings.Split(string(src), "\n") {
+		if indent := l[:len(l)-len(strings.TrimLeft(l, " \t"))]; indent != "" {
+			return indent
+		}
+	}
+	return "  "
+}
+
+type jsonField struct {
+	Key   string
+	Value json.RawMessage
+}
+
+// jsonObject 保持键顺序的JSON对象，值保留原始内容
+type jsonObject []jsonField
+
+func (this *jsonObject) UnmarshalJSON(bs []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	if t, err := dec.Token(); err != nil {
+		return err
+	} else if t != json.Delim('{') {
+		return fmt.Errorf("object expected, but got %v", t)
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		f := jsonField{Key: t.(string)}
+		if err = dec.Decode(&f.Value); err != nil {
+			return err
+		}
+		*this = append(*this, f)
+	}
+	_, err := dec.Token()
+	return err
+}
+
+func (this jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range this {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := marshalJSON(f.Key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(f.Value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// decode 解码字段值，字段不存在或为null时v不变
+func (this jsonObject) decode(key string, v interface{}) error {
+	for _, f := range this {
+		if f.Key == key {
+			return json.Unmarshal(f.Value, v)
+		}
+	}
+	return nil
+}
+
+// encode 设置字段值，字段不存在时添加到末尾
+func (this *jsonObject) encode(key string, v interface{}) error {
+	bs, err := marshalJSON(v)
+	if err != nil {
+		return err
+	}
+	for i := range *this {
+		if (*this)[i].Key == key {
+			(*this)[i].Value = bs
+			return nil
+		}
+	}
+	*this = append(*this, jsonField{Key: key, Value: bs})
+	return nil
+}
+
+// marshalJSON 不转义HTML字符，保持原有的描述文字不变
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// appendItem 向逗号分隔的配置项添加一项
+func appendItem(item string) func(string) string {
+	return func(old string) string {
+		if strings.TrimSpace(old) == "" {
+			return item
+		}
+		return old + "," + item
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+//生成代码使用的模板。main.go中的has:标记用于注册新生成的实体，删除标记后需手工注册
+
+const (
+	markImports     = "//has:imports"
+	markPlugins     = "//has:plugins"
+	markMiddlewares = "//has:middlewares"
+	markConnectors  = "//has:connectors"
+	markServices    = "//has:services"
+)
+
+const tplGoMod = `module {{.Module}}
+
+go 1.18
+`
+
+const tplMain = `package main
+
+import (
+	"github.com/drharryhe/has/connectors/hwebconnector"
+	"github.com/drharryhe/has/core"
+	"github.com/drharryhe/has/datapackers/hjsonpacker"
+	"github.com/drharryhe/has/plugins/hmemcacheplugin"
+	"github.com/drharryhe/has/routers/hlocalrouter"
+	//has:imports
+)
+
+func main() {
+	gw := core.NewAPIGateway(&core.APIGatewayOptions{
+		ServerOptions: core.ServerOptions{
+			Router: hlocalrouter.New(),
+			Plugins: []core.IPlugin{
+				hmemcacheplugin.New(),
+				//has:plugins
+			},
+		},
+		Connectors: []core.IAPIConnector{
+			hwebconnector.New(),
+			//has:connectors
+		},
+		Middlewares: []core.IAPIMiddleware{
+			//has:middlewares
+		},
+		Packers: []core.IAPIDataPacker{
+			hjsonpacker.New(),
+		},
+		I18n: &core.DefaultAPIi18n{},
+	})
+
+	//has:services
+	gw.Start()
+}
+`
+
+const tplConf = `LogFileName = '{{.Name}}.log'
+LogOutputs = ['file', 'console']
+Version = '1.0'
+Debug = true
+LogLevel = 'debug'
+StateFile = './state.json'
+ConfWritable = false
+
+[Server]
+MaxProcs = 1
+StrictCheck = false
+ShutdownTimeout = 10
+ConfigWatchInterval = 5
+
+[APIGateway]
+AddressField = 'IP'
+UserField = 'User'
+AppKeyField = 'AppKey'
+RequestTimeout = 0
+ManageToken = ''
+APIWatchInterval = 0
+
+[LocalRouter]
+
+[MemCachePlugin]
+ExpireDuration = 600
+CleanupDuration = 1800
+
+[JsonPacker]
+
+[WebConnector]
+Name = 'web'
+Port = 1976
+Timeout = 5
+Packer = 'JsonPacker'
+BodyLimit = 4
+Tls = false
+APIDocPath = '/openapi'
+`
+
+const tplLang = `{
+}
+`
+
+const tplServiceConf = `package {{.Pkg}}
+
+import "github.com/drharryhe/has/core"
+
+type {{.Conf}} struct {
+	core.ServiceConf
+}
+`
+
+const tplService = `package {{.Pkg}}
+
+import (
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/core"
+)
+
+func New() *Service {
+	return &Service{}
+}
+
+type Service struct {
+	core.Service
+	conf {{.Conf}}
+}
+
+func (this *Service) Open(s core.IServer, instance core.IService, options htypes.Any) *herrors.Error {
+	if err := this.Service.Open(s, instance, options); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (this *Service) Config() core.IEntityConf {
+	return &this.conf
+}
+
+func (this *Service) EntityStub() *core.EntityStub {
+	return core.NewEntityStub(
+		&core.EntityStubOptions{
+			Owner: this,
+		})
+}
+`
+
+const tplSlots = `package {{.Pkg}}
+
+import (
+	"github.com/drharryhe/has/core"
+)
+`
+
+const tplSlot = `type {{.Slot}}Request struct {
+	core.SlotRequestBase
+{{range .Params}}
+	{{.Field}} {{.Type}} ` + "`" + `json:"{{.Name}}"{{if .Require}} param:"require"{{end}}` + "`" + `{{end}}
+}
+
+func (this *Service) {{.Slot}}(req *{{.Slot}}Request, res *core.SlotResponse) {
+	this.Response(res, nil, nil)
+}
+
+`
+
+const tplPluginConf = `package {{.Pkg}}
+
+import "github.com/drharryhe/has/core"
+
+type {{.Conf}} struct {
+	core.PluginConf
+}
+`
+
+const tplPlugin = `package {{.Pkg}}
+
+import (
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/core"
+)
+
+var plugin = &Plugin{}
+
+func New() *Plugin {
+	return plugin
+}
+
+type Plugin struct {
+	core.BasePlugin
+	conf {{.Conf}}
+}
+
+func (this *Plugin) Open(s core.IServer, ins core.IPlugin) *herrors.Error {
+	_ = this.BasePlugin.Open(s, ins)
+	return nil
+}
+
+func (this *Plugin) Capability() htypes.Any {
+	return nil
+}
+
+func (this *Plugin) Config() core.IEntityConf {
+	return &this.conf
+}
+
+func (this *Plugin) EntityStub() *core.EntityStub {
+	return core.NewEntityStub(
+		&core.EntityStubOptions{
+			Owner: this,
+		})
+}
+`
+
+const tplMiddlewareConf = `package {{.Pkg}}
+
+import "github.com/drharryhe/has/core"
+
+type {{.Conf}} struct {
+	core.EntityConfBase
+}
+`
+
+const tplMiddleware = `package {{.Pkg}}
+
+import (
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/core"
+)
+
+func New() core.IAPIMiddleware {
+	return new(Middleware)
+}
+
+type Middleware struct {
+	core.{{.Base}}
+	conf {{.Conf}}
+}
+
+func (this *Middleware) Open(gw core.IAPIGateway, ins core.IAPIMiddleware) *herrors.Error {
+	return this.BaseMiddleware.Open(gw, ins)
+}
+{{if .In}}
+func (this *Middleware) HandleIn(seq uint64, version string, api string, data htypes.Map) (bool, *herrors.Error) {
+	return false, nil
+}
+{{end}}{{if .Out}}
+func (this *Middleware) HandleOut(seq uint64, version string, api string, result htypes.Any, e *herrors.Error) (bool, *herrors.Error) {
+	return false, nil
+}
+{{end}}
+func (this *Middleware) Config() core.IEntityConf {
+	return &this.conf
+}
+
+func (this *Middleware) EntityStub() *core.EntityStub {
+	return core.NewEntityStub(
+		&core.EntityStubOptions{
+			Owner: this,
+		})
+}
+`
+
+const tplConnectorConf = `package {{.Pkg}}
+
+import "github.com/drharryhe/has/core"
+
+type {{.Conf}} struct {
+	core.ConnectorConf
+}
+`
+
+const tplConnector = `package {{.Pkg}}
+
+import (
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/core"
+)
+
+func New() *Connector {
+	return new(Connector)
+}
+
+type Connector struct {
+	core.BaseConnector
+	conf {{.Conf}}
+}
+
+func (this *Connector) Open(gw core.IAPIGateway, ins core.IAPIConnector) *herrors.Error {
+	if err := this.BaseConnector.Open(gw, ins); err != nil {
+		return err
+	}
+
+	//接收请求，经this.Gateway.RequestAPIContext处理
+	return nil
+}
+
+func (this *Connector) Name() string {
+	return this.conf.Name
+}
+
+func (this *Connector) Close() {
+}
+
+func (this *Connector) Config() core.IEntityConf {
+	return &this.conf
+}
+
+func (this *Connector) EntityStub() *core.EntityStub {
+	return core.NewEntityStub(
+		&core.EntityStubOptions{
+			Owner: this,
+		})
+}
+`
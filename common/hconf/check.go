@@ -0,0 +1,77 @@
+package hconf
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/drharryhe/has/utils/hruntime"
+)
+
+// CheckSection 比较conf对应的配置段与conf的字段，返回配置段是否存在、段中未定义的配置项及段中缺少的必需字段。
+// 与Load相同，字段名不区分大小写，嵌入结构的字段属于同一配置段；必需字段以标签require:"true"声明，其余字段缺少时使用缺省值
+func CheckSection(conf interface{}) (found bool, unknown []string, missing []string) {
+	config.lock.Lock()
+	c, ok := config.raw[hruntime.GetObjectName(conf)]
+	config.lock.Unlock()
+	if !ok {
+		return false, nil, nil
+	}
+	section, _ := c.(map[string]interface{})
+
+	fields := make(map[string]string)
+	required := make(map[string]bool)
+	sectionFields(reflect.TypeOf(conf), fields, required)
+
+	keys := make(map[string]bool)
+	for k := range section {
+		keys[strings.ToLower(k)] = true
+		if fields[strings.ToLower(k)] == "" {
+			unknown = append(unknown, k)
+		}
+	}
+
+	for k := range required {
+		if !keys[k] {
+			missing = append(missing, fields[k])
+		}
+	}
+
+	sort.Strings(unknown)
+	sort.Strings(missing)
+	return true, unknown, missing
+}
+
+// sectionFields 配置段可设置的字段，小写名称 -> 字段名，required为必需字段的小写名称
+func sectionFields(t reflect.Type, fields map[string]string, required map[string]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("toml")
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" {
+			sectionFields(f.Type, fields, required)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+
+		name := f.Name
+		if n := strings.Split(tag, ",")[0]; n != "" {
+			name = n
+		}
+		fields[strings.ToLower(name)] = name
+		if f.Tag.Get("require") == "true" {
+			required[strings.ToLower(name)] = true
+		}
+	}
+}
@@ -3,14 +3,17 @@ package hconf
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/drharryhe/has/common/htypes"
-	jsoniter "github.com/json-iterator/go"
-	"github.com/pelletier/go-toml/v2"
-	"io/ioutil"
 	"os"
+	"reflect"
 	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pelletier/go-toml/v2"
 
-	"github.com/drharryhe/has/utils/hio"
+	"github.com/drharryhe/has/common/hstate"
+	"github.com/drharryhe/has/common/htypes"
 	"github.com/drharryhe/has/utils/hruntime"
 )
 
@@ -18,6 +21,10 @@ var (
 	ConfFile = "./conf.toml"
 )
 
+const (
+	GlobalSection = "" //Reload返回的顶层配置项，如Debug、LogLevel
+)
+
 var config Config
 
 type Config struct {
@@ -25,8 +32,16 @@ type Config struct {
 	LogOutputs  []string
 	LogFileName string
 	Debug       bool
+	LogLevel    string
+	StateFile   string //运行状态文件，见hstate
+	Writable    bool   //是否允许Save写回配置文件，对应配置项ConfWritable
 
+	lock       sync.Mutex
 	configures map[string]interface{}
+	raw        map[string]interface{} //配置文件内容，用于比较重新加载时发生变化的部分
+	layers     *layered
+	modTime    time.Time
+	inMemory   bool //由InitFromMap初始化，与配置文件无关
 }
 
 func Version() string {
@@ -45,181 +60,168 @@ func IsDebug() bool {
 	return config.Debug
 }
 
+func LogLevel() string {
+	return config.LogLevel
+}
+
+func StateFile() string {
+	return config.StateFile
+}
+
 func Init() {
-	bs, err := hio.ReadFile(ConfFile)
+	l, err := readLayers()
 	if err != nil {
 		pwd, _ := os.Getwd()
 		fmt.Println("Project Work Path is ", pwd)
 		panic("failed to read config file \r\n" + err.Error())
 	}
 
-	config.configures = make(map[string]interface{})
-	err = toml.Unmarshal(bs, &config.configures)
+	config.lock.Lock()
+	config.inMemory = false
+	config.layers = l
+	config.raw, _ = readRaw()
+	config.modTime = confModTime()
+	config.lock.Unlock()
+	apply(l.values)
+}
+
+// InitFromMap 使用内存中的配置(段名 -> 配置项)，不读取配置文件，用于测试或嵌入其他程序。
+// 配置值按TOML规范化，Reload不再检查配置文件，Save及Persist不写入文件
+func InitFromMap(values map[string]interface{}) {
+	bs, err := toml.Marshal(values)
 	if err != nil {
-		panic("failed to parse config file. \r\n" + err.Error())
+		panic("failed to init config from map \r\n" + err.Error())
 	}
+	m := make(map[string]interface{})
+	if err = toml.Unmarshal(bs, &m); err != nil {
+		panic("failed to init config from map \r\n" + err.Error())
+	}
+	raw := make(map[string]interface{})
+	_ = toml.Unmarshal(bs, &raw)
+
+	config.lock.Lock()
+	config.inMemory = true
+	config.layers = nil
+	config.raw = raw
+	config.lock.Unlock()
+	apply(m)
+}
+
+func apply(values map[string]interface{}) {
+	config.configures = values
+	config.LogOutputs = nil
 
 	config.Version, _ = config.configures["Version"].(string)
 	config.LogFileName, _ = config.configures["LogFileName"].(string)
 	config.Debug, _ = config.configures["Debug"].(bool)
+	config.LogLevel, _ = config.configures["LogLevel"].(string)
+	config.StateFile, _ = config.configures["StateFile"].(string)
+	config.Writable, _ = config.configures["ConfWritable"].(bool)
 	if config.configures["LogOutputs"] != nil {
 		outputs := config.configures["LogOutputs"].([]interface{})
 		for _, out := range outputs {
 			config.LogOutputs = append(config.LogOutputs, out.(string))
 		}
 	}
-
 }
 
-func Load(conf interface{}) {
-	name := hruntime.GetObjectName(conf)
-	c, ok := config.configures[name]
-	if !ok {
-		panic(fmt.Sprintf("failed to load conf, config section [%s] not found", name))
-	}
-
-	bs, _ := toml.Marshal(c)
-	err := toml.Unmarshal(bs, conf)
+// readRaw 重新读取合并后的配置，用于比较发生变化的部分
+func readRaw() (map[string]interface{}, error) {
+	l, err := readLayers()
 	if err != nil {
-		panic(fmt.Sprintf("failed to parse config section [%s]:%v", name, err))
+		return nil, err
 	}
-
-	config.configures[name] = conf
+	return l.values, nil
 }
 
-func Save() {
-	//利用JSON去掉多层结构
-	bs, _ := jsoniter.Marshal(config.configures)
-	tmp := make(htypes.Map)
-	decoder := jsoniter.NewDecoder(strings.NewReader(string(bs)))
-	decoder.UseNumber()
-	_ = decoder.Decode(&tmp)
-	handleNumberInMap(tmp)
+// Reload 配置文件修改后重新读取，返回发生变化的配置段(段名 -> 配置内容)，文件未修改时返回nil。
+// 顶层配置项的变化以GlobalSection返回，其中Debug及LogLevel立即生效。
+// 已Load的配置段不会被直接修改，由使用方决定是否接受
+func Reload() (map[string]interface{}, error) {
+	config.lock.Lock()
+	defer config.lock.Unlock()
 
-	//保存到文件
-	bs, err := toml.Marshal(tmp)
-	if err != nil {
-		panic("failed to save configures,failed to write config file")
+	if config.inMemory {
+		return nil, nil
 	}
+	mt := confModTime()
+	if mt.Equal(config.modTime) {
+		return nil, nil
+	}
+	config.modTime = mt
 
-	err = ioutil.WriteFile(ConfFile, bs, 0x666)
+	l, err := readLayers()
 	if err != nil {
-		panic("failed to save configures,failed to write config file")
+		return nil, err
 	}
-}
-
-func handleNumberInMap(m map[string]interface{}) {
-	for k, v := range m {
-		switch v.(type) {
-		case map[string]interface{}:
-			handleNumberInMap(v.(map[string]interface{}))
-		case []interface{}:
-			handleNumberInSlice(v.([]interface{}))
-		case json.Number:
-			if num, err := v.(json.Number).Int64(); err == nil {
-				m[k] = num
-				continue
-			}
-			if num, err := v.(json.Number).Float64(); err == nil {
-				m[k] = num
-				continue
+	raw := l.values
+	config.layers = l
+
+	changed := make(map[string]interface{})
+	globals := make(map[string]interface{})
+	for k, v := range raw {
+		if _, ok := v.(map[string]interface{}); !ok {
+			globals[k] = v
+			continue
+		}
+		if !reflect.DeepEqual(v, config.raw[k]) {
+			changed[k] = v
+			if _, loaded := config.configures[k].(map[string]interface{}); loaded || config.configures[k] == nil {
+				config.configures[k] = v
 			}
 		}
 	}
-}
 
-func handleNumberInSlice(m []interface{}) {
-	for i, v := range m {
-		switch v.(type) {
-		case json.Number:
-			if num, err := v.(json.Number).Int64(); err == nil {
-				m[i] = num
-				continue
-			}
-			if num, err := v.(json.Number).Float64(); err == nil {
-				m[i] = num
-				continue
-			}
-		case map[string]interface{}:
-			handleNumberInMap(m[i].(map[string]interface{}))
-		case []interface{}:
-			handleNumberInSlice(m[i].([]interface{}))
+	for k, v := range globals {
+		if reflect.DeepEqual(v, config.raw[k]) {
+			continue
+		}
+		changed[GlobalSection] = globals
+		config.configures[k] = v
+		switch k {
+		case "Debug":
+			config.Debug, _ = v.(bool)
+		case "LogLevel":
+			config.LogLevel, _ = v.(string)
 		}
 	}
-}
-=======
-package hconf
-
-import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"os"
-	"strings"
-
-	jsoniter "github.com/json-iterator/go"
-	"github.com/pelletier/go-toml/v2"
-
-	"github.com/drharryhe/has/common/htypes"
-	"github.com/drharryhe/has/utils/hio"
-	"github.com/drharryhe/has/utils/hruntime"
-)
-
-var (
-	ConfFile = "./conf.toml"
-)
-
-var config Config
-
-type Config struct {
-	Version     string
-	LogOutputs  []string
-	LogFileName string
-	Debug       bool
-
-	configures map[string]interface{}
-}
-
-func Version() string {
-	return config.Version
-}
-
-func LogOutputs() []string {
-	return config.LogOutputs
-}
 
-func LogFileName() string {
-	return config.LogFileName
+	config.raw = raw
+	return changed, nil
 }
 
-func IsDebug() bool {
-	return config.Debug
-}
+// Watch 定期检查配置文件，发生变化时调用onChange，返回停止监视的函数
+func Watch(interval time.Duration, onChange func(changed map[string]interface{}, err error)) func() {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				changed, err := Reload()
+				if err != nil || len(changed) > 0 {
+					onChange(changed, err)
+				}
+			}
+		}
+	}()
 
-func Init() {
-	bs, err := hio.ReadFile(ConfFile)
-	if err != nil {
-		pwd, _ := os.Getwd()
-		fmt.Println("Project Work Path is ", pwd)
-		panic("failed to read config file \r\n" + err.Error())
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stop) })
 	}
+}
 
-	config.configures = make(map[string]interface{})
-	err = toml.Unmarshal(bs, &config.configures)
+// Section 将配置段内容解析到conf
+func Section(raw interface{}, conf interface{}) error {
+	bs, err := toml.Marshal(raw)
 	if err != nil {
-		panic("failed to parse config file. \r\n" + err.Error())
+		return err
 	}
-
-	config.Version, _ = config.configures["Version"].(string)
-	config.LogFileName, _ = config.configures["LogFileName"].(string)
-	config.Debug, _ = config.configures["Debug"].(bool)
-	if config.configures["LogOutputs"] != nil {
-		outputs := config.configures["LogOutputs"].([]interface{})
-		for _, out := range outputs {
-			config.LogOutputs = append(config.LogOutputs, out.(string))
-		}
-	}
-
+	return toml.Unmarshal(bs, conf)
 }
 
 func Load(conf interface{}) {
@@ -238,7 +240,25 @@ func Load(conf interface{}) {
 	config.configures[name] = conf
 }
 
+// Save 配置文件缺省只读，配置项ConfWritable为true时才写回配置文件。运行期间产生的状态应保存在hstate中
 func Save() {
+	if !config.Writable {
+		return
+	}
+	if err := Persist(); err != nil {
+		panic("failed to save configures," + err.Error())
+	}
+}
+
+// Persist 将当前配置原子写入配置文件，不受ConfWritable限制
+func Persist() error {
+	config.lock.Lock()
+	defer config.lock.Unlock()
+
+	if config.inMemory {
+		return fmt.Errorf("config initialized from memory, no file to persist")
+	}
+
 	//利用JSON去掉多层结构
 	bs, _ := jsoniter.Marshal(config.configures)
 	tmp := make(htypes.Map)
@@ -246,13 +266,35 @@ func Save() {
 	decoder.UseNumber()
 	_ = decoder.Decode(&tmp)
 	handleNumberInMap(tmp)
+	if config.layers != nil {
+		config.layers.restore(tmp)
+	}
+
+	//使用环境配置文件时只写入与基础配置不同的部分，基础配置文件不变
+	file := ConfFile
+	if envLayer() {
+		overlay, err := overlayValues(tmp)
+		if err != nil {
+			return err
+		}
+		tmp, file = overlay, EnvConfFile
+	}
 
 	//保存到文件
-	bs, _ = toml.Marshal(tmp)
-	err := ioutil.WriteFile(ConfFile, bs, 0x666)
+	bs, err := toml.Marshal(tmp)
 	if err != nil {
-		panic("failed to save configures,failed to write config file")
+		return err
+	}
+	if err = hstate.WriteFileAtomic(file, bs, 0644); err != nil {
+		return err
+	}
+
+	//自身写入的修改不需要重新加载
+	if raw, err := readRaw(); err == nil {
+		config.raw = raw
 	}
+	config.modTime = confModTime()
+	return nil
 }
 
 func handleNumberInMap(m map[string]interface{}) {
@@ -294,4 +336,3 @@ func handleNumberInSlice(m []interface{}) {
 		}
 	}
 }
->>>>>>> dev
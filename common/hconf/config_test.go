@@ -10,8 +10,8 @@ type BaseConf struct {
 
 type TestConf struct {
 	BaseConf
-	Name string
-	Age  int
+	Name string `require:"true"`
+	Age  int    `require:"true"`
 	Man  bool
 	Mark float64
 }
@@ -51,3 +51,26 @@ func TestConfig(t *testing.T) {
 
 	Save()
 }
+
+func TestCheckSection(t *testing.T) {
+	keepConfig(t)
+	InitFromMap(map[string]interface{}{
+		"TestConf": map[string]interface{}{"id": 1, "Name": "a", "Nmae": "b"},
+	})
+
+	//只返回缺少的必需字段，可选字段Man、Mark使用缺省值
+	found, unknown, missing := CheckSection(&TestConf{})
+	if !found {
+		t.Fatal("section TestConf not found")
+	}
+	if len(unknown) != 1 || unknown[0] != "Nmae" {
+		t.Errorf("unknown keys: %v", unknown)
+	}
+	if len(missing) != 1 || missing[0] != "Age" {
+		t.Errorf("missing keys: %v", missing)
+	}
+
+	if found, _, _ = CheckSection(&DatabasePlugin{}); found {
+		t.Error("section DatabasePlugin should not be found")
+	}
+}
@@ -0,0 +1,460 @@
+package hconf
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pelletier/go-toml/v2"
+
+	"github.com/drharryhe/has/utils/hcrypto"
+	"github.com/drharryhe/has/utils/hio"
+)
+
+//分层配置：依次合并基础配置文件ConfFile、环境配置文件EnvConfFile及HAS_开头的环境变量，后者覆盖前者。
+//指定了EnvConfFile时该文件必须存在。保存时环境配置文件只写入与基础配置不同的配置项。
+//环境变量名为 HAS_段名_配置项，数组元素用下标表示，如 HAS_DatabasePlugin_Connections_0_Pwd。
+//只处理段名或顶层配置项在配置文件中存在的环境变量，其他HAS_开头的环境变量忽略。
+//ENC(...)格式的配置值为加密内容，加载时使用环境变量HAS_SECRET_KEY中的AES密钥解密，密文由Encrypt生成
+
+const (
+	EnvPrefix    = "HAS_"
+	SecretKeyEnv = "HAS_SECRET_KEY" //AES密钥，长度为16、24或32字节，也可以是其base64编码
+
+	secretPrefix = "ENC("
+	secretSuffix = ")"
+	secretIVSize = 16
+)
+
+var (
+	EnvConfFile = "" //环境配置文件，如conf_dev.toml，为空时只使用ConfFile
+)
+
+// layered 合并后的配置，记录解密及环境变量覆盖前的原值，保存时还原
+type layered struct {
+	values    map[string]interface{}
+	secrets   []layerValue //解密前的密文
+	overrides []layerValue //被环境变量覆盖前的值
+}
+
+type layerValue struct {
+	path   []string
+	value  interface{}
+	exists bool
+}
+
+func confFiles() []string {
+	files := []string{ConfFile}
+	if EnvConfFile != "" && EnvConfFile != ConfFile {
+		files = append(files, EnvConfFile)
+	}
+	return files
+}
+
+func envLayer() bool {
+	return EnvConfFile != "" && EnvConfFile != ConfFile
+}
+
+func confModTime() time.Time {
+	var t time.Time
+	for _, f := range confFiles() {
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(t) {
+			t = info.ModTime()
+		}
+	}
+	return t
+}
+
+func readLayers() (*layered, error) {
+	l := &layered{values: make(map[string]interface{})}
+	for _, f := range confFiles() {
+		m, err := readConfFile(f)
+		if err != nil {
+			return nil, err
+		}
+		mergeValues(l.values, m)
+	}
+
+	if err := l.applyEnv(os.Environ()); err != nil {
+		return nil, err
+	}
+	if err := l.decrypt(l.values, nil); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func readConfFile(file string) (map[string]interface{}, error) {
+	bs, err := hio.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("config file %s not found", file)
+		}
+		return nil, err
+	}
+
+	m := make(map[string]interface{})
+	if err = toml.Unmarshal(bs, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", file, err)
+	}
+	return m, nil
+}
+
+// overlayValues 环境配置文件保存的内容：原有内容加上values中与配置文件不同的配置项。
+// values为还原密文及环境变量覆盖后的配置，配置文件中没有且为零值的配置项(如未配置的可选项)不写入
+func overlayValues(values map[string]interface{}) (map[string]interface{}, error) {
+	files := make(map[string]interface{})
+	for _, f := range confFiles() {
+		m, err := readConfFile(f)
+		if err != nil {
+			return nil, err
+		}
+		mergeValues(files, m)
+	}
+	overlay, err := readConfFile(EnvConfFile)
+	if err != nil {
+		return nil, err
+	}
+
+	//按TOML规范化，与读取配置文件得到的值类型一致
+	bs, err := toml.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	cur := make(map[string]interface{})
+	if err = toml.Unmarshal(bs, &cur); err != nil {
+		return nil, err
+	}
+
+	mergeValues(overlay, diffValues(cur, files))
+	return overlay, nil
+}
+
+// diffValues cur中与files不同的配置项，配置段逐项比较
+func diffValues(cur map[string]interface{}, files map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for k, v := range cur {
+		old, exists := files[matchKey(files, k)]
+		if cm, ok := v.(map[string]interface{}); ok {
+			om, _ := old.(map[string]interface{})
+			if d := diffValues(cm, om); len(d) > 0 {
+				ret[k] = d
+			}
+			continue
+		}
+		if !exists {
+			if v != nil && !reflect.ValueOf(v).IsZero() && !isEmptySlice(v) {
+				ret[k] = v
+			}
+			continue
+		}
+		if !reflect.DeepEqual(v, old) {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+func isEmptySlice(v interface{}) bool {
+	s, ok := v.([]interface{})
+	return ok && len(s) == 0
+}
+
+// mergeValues 将src合并到dst，配置段逐项合并，其余值整体覆盖
+func mergeValues(dst map[string]interface{}, src map[string]interface{}) {
+	for k, v := range src {
+		sm, ok := v.(map[string]interface{})
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		dm, ok := dst[k].(map[string]interface{})
+		if !ok {
+			dm = make(map[string]interface{})
+			dst[k] = dm
+		}
+		mergeValues(dm, sm)
+	}
+}
+
+func (this *layered) applyEnv(environ []string) error {
+	sort.Strings(environ)
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, EnvPrefix) {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i < 0 || kv[:i] == SecretKeyEnv {
+			continue
+		}
+
+		//与配置无关的环境变量(如HAS_HOME)不处理
+		name, raw := kv[:i], kv[i+1:]
+		path := strings.Split(name[len(EnvPrefix):], "_")
+		if _, ok := this.values[matchKey(this.values, path[0])]; !ok {
+			continue
+		}
+		old, exists, err := setValue(this.values, path, func(old interface{}) (interface{}, error) {
+			return parseEnvValue(raw, old)
+		})
+		if err != nil {
+			return fmt.Errorf("invalid environment variable %s: %v", name, err)
+		}
+		this.overrides = append(this.overrides, layerValue{path: path, value: old, exists: exists})
+	}
+	return nil
+}
+
+func (this *layered) decrypt(v interface{}, path []string) error {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			p := append(append([]string{}, path...), k)
+			if s, ok := item.(string); ok && isSecret(s) {
+				plain, err := decryptSecret(s)
+				if err != nil {
+					return fmt.Errorf("failed to decrypt config [%s]: %v", strings.Join(p, "."), err)
+				}
+				val[k] = plain
+				this.secrets = append(this.secrets, layerValue{path: p, value: s, exists: true})
+				continue
+			}
+			if err := this.decrypt(item, p); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			p := append(append([]string{}, path...), strconv.Itoa(i))
+			if s, ok := item.(string); ok && isSecret(s) {
+				plain, err := decryptSecret(s)
+				if err != nil {
+					return fmt.Errorf("failed to decrypt config [%s]: %v", strings.Join(p, "."), err)
+				}
+				val[i] = plain
+				this.secrets = append(this.secrets, layerValue{path: p, value: s, exists: true})
+				continue
+			}
+			if err := this.decrypt(item, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// restore 保存前还原密文及被环境变量覆盖的配置，避免写入明文密码及环境相关的值
+func (this *layered) restore(values map[string]interface{}) {
+	for _, s := range this.secrets {
+		_, _, _ = setValue(values, s.path, func(_ interface{}) (interface{}, error) {
+			return s.value, nil
+		})
+	}
+	for i := len(this.overrides) - 1; i >= 0; i-- {
+		o := this.overrides[i]
+		if !o.exists {
+			deleteValue(values, o.path)
+			continue
+		}
+		_, _, _ = setValue(values, o.path, func(_ interface{}) (interface{}, error) {
+			return o.value, nil
+		})
+	}
+}
+
+// setValue 按路径设置配置值，配置段名及配置项名不区分大小写，不存在的配置段自动创建
+func setValue(root map[string]interface{}, path []string, f func(old interface{}) (interface{}, error)) (interface{}, bool, error) {
+	var cur interface{} = root
+	for i, seg := range path {
+		last := i == len(path)-1
+		switch c := cur.(type) {
+		case map[string]interface{}:
+			key := matchKey(c, seg)
+			old, exists := c[key]
+			if last {
+				v, err := f(old)
+				if err != nil {
+					return nil, false, err
+				}
+				c[key] = v
+				return old, exists, nil
+			}
+			if !exists {
+				old = make(map[string]interface{})
+				c[key] = old
+			}
+			cur = old
+		case []interface{}:
+			idx, err := strconv.Atoi(seg)
+			if err != nil || idx < 0 || idx >= len(c) {
+				return nil, false, fmt.Errorf("array index [%s] out of range", seg)
+			}
+			if last {
+				v, err := f(c[idx])
+				if err != nil {
+					return nil, false, err
+				}
+				old := c[idx]
+				c[idx] = v
+				return old, true, nil
+			}
+			cur = c[idx]
+		default:
+			return nil, false, fmt.Errorf("[%s] is not a section or array", strings.Join(path[:i], "."))
+		}
+	}
+	return nil, false, fmt.Errorf("empty config path")
+}
+
+func deleteValue(root map[string]interface{}, path []string) {
+	var cur interface{} = root
+	for i, seg := range path {
+		switch c := cur.(type) {
+		case map[string]interface{}:
+			key := matchKey(c, seg)
+			if i == len(path)-1 {
+				delete(c, key)
+				return
+			}
+			cur = c[key]
+		case []interface{}:
+			idx, err := strconv.Atoi(seg)
+			if err != nil || idx < 0 || idx >= len(c) {
+				return
+			}
+			cur = c[idx]
+		default:
+			return
+		}
+	}
+}
+
+func matchKey(m map[string]interface{}, key string) string {
+	if _, ok := m[key]; ok {
+		return key
+	}
+	for k := range m {
+		if strings.EqualFold(k, key) {
+			return k
+		}
+	}
+	return key
+}
+
+// parseEnvValue 按原配置值的类型转换环境变量的值，原值不存在时按TOML值解析，解析失败作为字符串
+func parseEnvValue(raw string, old interface{}) (interface{}, error) {
+	switch old.(type) {
+	case string:
+		return raw, nil
+	case bool:
+		return strconv.ParseBool(raw)
+	case int64:
+		return strconv.ParseInt(raw, 10, 64)
+	case float64:
+		return strconv.ParseFloat(raw, 64)
+	case []interface{}, map[string]interface{}:
+		return parseTomlValue(raw)
+	default:
+		if v, err := parseTomlValue(raw); err == nil {
+			return v, nil
+		}
+		return raw, nil
+	}
+}
+
+func parseTomlValue(raw string) (interface{}, error) {
+	var m map[string]interface{}
+	if err := toml.Unmarshal([]byte("v = "+raw), &m); err != nil {
+		return nil, err
+	}
+	return m["v"], nil
+}
+
+func isSecret(s string) bool {
+	return strings.HasPrefix(s, secretPrefix) && strings.HasSuffix(s, secretSuffix)
+}
+
+func secretKey() ([]byte, error) {
+	s := os.Getenv(SecretKeyEnv)
+	if s == "" {
+		return nil, fmt.Errorf("secret key not set, environment variable %s required", SecretKeyEnv)
+	}
+
+	key := []byte(s)
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		var err error
+		if key, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return nil, fmt.Errorf("invalid secret key in %s", SecretKeyEnv)
+		}
+	}
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return nil, fmt.Errorf("secret key in %s must be 16, 24 or 32 bytes", SecretKeyEnv)
+	}
+	return key, nil
+}
+
+// EncryptFrom 读取r中的全部内容(去掉末尾换行)并加密，用于从标准输入读取明文，避免明文出现在命令行参数中
+func EncryptFrom(r io.Reader) (string, error) {
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	plain := strings.TrimRight(string(bs), "\r\n")
+	if plain == "" {
+		return "", fmt.Errorf("empty value")
+	}
+	return Encrypt(plain)
+}
+
+// Encrypt 使用环境变量HAS_SECRET_KEY中的密钥加密配置值，返回ENC(...)格式的密文
+func Encrypt(plain string) (string, error) {
+	key, err := secretKey()
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, secretIVSize)
+	if _, err = rand.Read(iv); err != nil {
+		return "", err
+	}
+	bs, err := hcrypto.CBCEncrypt([]byte(plain), key, iv)
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + base64.StdEncoding.EncodeToString(append(iv, bs...)) + secretSuffix, nil
+}
+
+func decryptSecret(s string) (plain string, err error) {
+	key, err := secretKey()
+	if err != nil {
+		return "", err
+	}
+
+	bs, err := base64.StdEncoding.DecodeString(s[len(secretPrefix) : len(s)-len(secretSuffix)])
+	if err != nil || len(bs) < 2*secretIVSize {
+		return "", fmt.Errorf("invalid encrypted value")
+	}
+
+	//密钥错误时去除填充可能越界
+	defer func() {
+		if e := recover(); e != nil {
+			plain, err = "", fmt.Errorf("wrong secret key")
+		}
+	}()
+	ret, err := hcrypto.CBCDecrypt(bs[secretIVSize:], key, bs[:secretIVSize])
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(ret) {
+		return "", fmt.Errorf("wrong secret key")
+	}
+	return string(ret), nil
+}
@@ -0,0 +1,190 @@
+package hconf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+const testSecretKey = "0123456789abcdef"
+
+type LayerConf struct {
+	Name  string
+	Port  int
+	Pwd   string
+	Hosts []string
+	Extra string
+}
+
+// keepConfig 测试结束时恢复全局配置及配置文件路径
+func keepConfig(t *testing.T) {
+	t.Helper()
+
+	confFile, envFile := ConfFile, EnvConfFile
+	config.lock.Lock()
+	saved := struct {
+		version, logFileName, logLevel, stateFile string
+		logOutputs                                []string
+		debug, writable, inMemory                 bool
+		configures, raw                           map[string]interface{}
+		layers                                    *layered
+	}{config.Version, config.LogFileName, config.LogLevel, config.StateFile, config.LogOutputs,
+		config.Debug, config.Writable, config.inMemory, config.configures, config.raw, config.layers}
+	config.lock.Unlock()
+
+	t.Cleanup(func() {
+		ConfFile, EnvConfFile = confFile, envFile
+		config.lock.Lock()
+		defer config.lock.Unlock()
+		config.Version, config.LogFileName, config.LogLevel, config.StateFile = saved.version, saved.logFileName, saved.logLevel, saved.stateFile
+		config.LogOutputs, config.Debug, config.Writable, config.inMemory = saved.logOutputs, saved.debug, saved.writable, saved.inMemory
+		config.configures, config.raw, config.layers = saved.configures, saved.raw, saved.layers
+	})
+}
+
+func writeConf(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLayers(t *testing.T) {
+	keepConfig(t)
+	t.Setenv(SecretKeyEnv, testSecretKey)
+
+	secret, err := Encrypt("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	ConfFile = writeConf(t, dir, "conf.toml", `
+Version = "1"
+[LayerConf]
+Name = "base"
+Port = 1
+Pwd = "`+secret+`"
+Hosts = ["a", "b"]
+`)
+	EnvConfFile = writeConf(t, dir, "conf_dev.toml", `
+[LayerConf]
+Port = 2
+`)
+	t.Setenv("HAS_LayerConf_Name", "env")
+	t.Setenv("HAS_LAYERCONF_HOSTS_1", "c")
+	t.Setenv("HAS_HOME", "/home/has")
+	t.Setenv("HAS_Unknown_Item", "1")
+
+	l, err := readLayers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conf LayerConf
+	if err = Section(l.values["LayerConf"], &conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Name != "env" || conf.Port != 2 || conf.Pwd != "s3cret" || strings.Join(conf.Hosts, ",") != "a,c" {
+		t.Errorf("unexpected config %+v", conf)
+	}
+	if _, ok := l.values["Unknown"]; ok {
+		t.Error("unrelated environment variable applied")
+	}
+
+	//环境变量的值与原配置类型不符
+	t.Setenv("HAS_LayerConf_Port", "abc")
+	if _, err = readLayers(); err == nil {
+		t.Error("invalid environment variable accepted")
+	}
+}
+
+func TestLayersMissingEnvFile(t *testing.T) {
+	keepConfig(t)
+
+	dir := t.TempDir()
+	ConfFile = writeConf(t, dir, "conf.toml", "[LayerConf]\nName = \"base\"\n")
+	EnvConfFile = filepath.Join(dir, "conf_prod.toml")
+	if _, err := readLayers(); err == nil {
+		t.Error("missing environment config file accepted")
+	}
+
+	EnvConfFile = ""
+	if _, err := readLayers(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLayersPersist(t *testing.T) {
+	keepConfig(t)
+	t.Setenv(SecretKeyEnv, testSecretKey)
+
+	secret, err := Encrypt("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	base := `Version = "1"
+[LayerConf]
+Name = "base"
+Port = 1
+Pwd = "` + secret + `"
+`
+	ConfFile = writeConf(t, dir, "conf.toml", base)
+	EnvConfFile = writeConf(t, dir, "conf_dev.toml", "[LayerConf]\nPort = 2\n")
+	t.Setenv("HAS_LayerConf_Name", "env")
+
+	Init()
+	var conf LayerConf
+	Load(&conf)
+	conf.Port = 3
+	conf.Extra = "x"
+	if err = Persist(); err != nil {
+		t.Fatal(err)
+	}
+
+	//基础配置文件不变，环境配置文件只有修改的配置项，不含环境变量覆盖的值及解密后的明文
+	if bs, _ := os.ReadFile(ConfFile); string(bs) != base {
+		t.Errorf("base config file changed:\n%s", bs)
+	}
+	bs, _ := os.ReadFile(EnvConfFile)
+	m := make(map[string]interface{})
+	if err = toml.Unmarshal(bs, &m); err != nil {
+		t.Fatal(err)
+	}
+	section, _ := m["LayerConf"].(map[string]interface{})
+	if len(m) != 1 || len(section) != 2 || section["Port"] != int64(3) || section["Extra"] != "x" {
+		t.Errorf("unexpected environment config file:\n%s", bs)
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	t.Setenv(SecretKeyEnv, testSecretKey)
+
+	secret, err := EncryptFrom(strings.NewReader("s3cret\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSecret(secret) {
+		t.Fatalf("unexpected encrypted value %s", secret)
+	}
+	if plain, err := decryptSecret(secret); err != nil || plain != "s3cret" {
+		t.Errorf("decrypt: %q %v", plain, err)
+	}
+	if _, err = EncryptFrom(strings.NewReader("\n")); err == nil {
+		t.Error("empty value encrypted")
+	}
+
+	t.Setenv(SecretKeyEnv, "fedcba9876543210")
+	if _, err = decryptSecret(secret); err == nil {
+		t.Error("decrypted with wrong key")
+	}
+	t.Setenv(SecretKeyEnv, "")
+	if _, err = decryptSecret(secret); err == nil {
+		t.Error("decrypted without key")
+	}
+}
@@ -12,10 +12,11 @@ import (
 )
 
 type Error struct {
-	Code        int    `json:"code"`
-	Desc        string `json:"desc"`
-	Fingerprint string `json:"fingerprint,omitempty"`
-	Cause       string `json:"cause"`
+	Code        int         `json:"code"`
+	Desc        string      `json:"desc"`
+	Fingerprint string      `json:"fingerprint,omitempty"`
+	Cause       string      `json:"cause"`
+	Details     interface{} `json:"details,omitempty"` //结构化的错误详情，如参数校验失败的字段列表
 
 	stack []string
 }
@@ -59,6 +60,12 @@ func (this *Error) D(format string, v ...interface{}) *Error {
 	return this
 }
 
+func (this *Error) WithDetails(details interface{}) *Error {
+	this.Details = details
+
+	return this
+}
+
 func (this *Error) log() {
 	s := fmt.Sprintf("ERROR:%s", this.Desc)
 	s = fmt.Sprintf("%s\r\n\t|CODE: %d", s, this.Code)
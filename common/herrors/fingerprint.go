@@ -3,11 +3,13 @@ package herrors
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/drharryhe/has/utils/hencoder"
 )
 
 var (
+	fpLock            sync.Mutex //调试模式下并发创建错误时保护以下各表
 	stackFingerprints = make(map[string][]string)
 	pointFingerPrints = make(map[string]*fingerprintItem)
 	filesMap          = make(map[string]string)
@@ -23,15 +25,17 @@ type fingerprintItem struct {
 
 func addPointFingerprint(file string, fun string, line int) string {
 	f1 := hencoder.Md5ToString([]byte(file))
+	f2 := hencoder.Md5ToString([]byte(fun))
+	sp := hencoder.Md5ToString([]byte(fmt.Sprintf("%s-%s:%d", f1, f2, line)))
+
+	fpLock.Lock()
+	defer fpLock.Unlock()
 	if filesMap[f1] == "" {
 		filesMap[f1] = file
 	}
-	f2 := hencoder.Md5ToString([]byte(fun))
 	if funcsMap[f2] == "" {
 		funcsMap[f2] = fun
 	}
-	sp := hencoder.Md5ToString([]byte(fmt.Sprintf("%s-%s:%d", f1, f2, line)))
-
 	if pointFingerPrints[sp] == nil {
 		pointFingerPrints[sp] = &fingerprintItem{
 			File:     f1,
@@ -47,10 +51,15 @@ func addPointFingerprint(file string, fun string, line int) string {
 }
 
 func addStackFingerprint(sp string, errsps []string) {
+	fpLock.Lock()
+	defer fpLock.Unlock()
 	stackFingerprints[sp] = errsps
 }
 
 func QueryFingerprint(fp string) string {
+	fpLock.Lock()
+	defer fpLock.Unlock()
+
 	fingerprint := stackFingerprints[fp]
 	if fingerprint == nil {
 		return ""
@@ -66,6 +75,9 @@ func QueryFingerprint(fp string) string {
 }
 
 func StaticsFingerprint() string {
+	fpLock.Lock()
+	defer fpLock.Unlock()
+
 	res := "RESULTS: [\r\n"
 	for finger, item := range pointFingerPrints {
 		res += fmt.Sprintf("\t%s:%d\t\t%s:%d\r\n", finger, item.Count, filesMap[item.File], item.Line)
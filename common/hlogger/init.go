@@ -1,12 +1,17 @@
 package hlogger
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 const (
 	defaultLogFile = "has.log"
 )
 
+// Init 设置日志输出，重复调用时替换原有输出
 func Init(outputs []string, args ...interface{}) {
+	Reset()
 	for _, o := range outputs {
 		switch o {
 		case AdapterFile:
@@ -38,3 +43,27 @@ func Init(outputs []string, args ...interface{}) {
 	EnableFuncCallDepth(true)
 	SetLogFuncCallDepth(3)
 }
+
+// ParseLevel 日志级别名称转换为级别，如 debug、info、warn、error
+func ParseLevel(name string) (int, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "emergency":
+		return LevelEmergency, true
+	case "alert":
+		return LevelAlert, true
+	case "critical":
+		return LevelCritical, true
+	case "error":
+		return LevelError, true
+	case "warn", "warning":
+		return LevelWarning, true
+	case "notice":
+		return LevelNotice, true
+	case "info", "informational":
+		return LevelInformational, true
+	case "debug", "trace":
+		return LevelDebug, true
+	default:
+		return 0, false
+	}
+}
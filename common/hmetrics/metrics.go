@@ -0,0 +1,256 @@
+package hmetrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+//运行指标：计数器、仪表及直方图，按标签值区分，基于prometheus/client_golang记录及导出。
+//由其他组件维护的值(如连接池状态)通过Collector在导出时采集
+
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+var (
+	Default = NewRegistry()
+
+	DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10} //缺省的耗时分布(秒)
+)
+
+type Labels map[string]string
+
+// Sample 由Collector采集的指标值
+type Sample struct {
+	Name   string
+	Help   string
+	Type   string //TypeCounter或TypeGauge，缺省为TypeGauge
+	Labels Labels
+	Value  float64
+}
+
+type Collector func() []Sample
+
+// Registry 对prometheus.Registry的封装，同名指标重复创建时沿用已有的指标
+type Registry struct {
+	lock    sync.Mutex
+	reg     *prometheus.Registry
+	vectors map[string]*vector
+}
+
+type vector struct {
+	key string //类型及标签名
+	c   prometheus.Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		reg:     prometheus.NewRegistry(),
+		vectors: make(map[string]*vector),
+	}
+}
+
+// Prometheus 底层的prometheus.Registry，可注册其他prometheus指标
+func (this *Registry) Prometheus() *prometheus.Registry {
+	return this.reg
+}
+
+func (this *Registry) Register(c Collector) {
+	this.reg.MustRegister(&sampleCollector{collect: c})
+}
+
+// vector 同名指标已存在时沿用，类型或标签不同时panic
+func (this *Registry) vector(name string, typ string, labels []string, create func() prometheus.Collector) prometheus.Collector {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	key := typ + ":" + strings.Join(labels, ",")
+	if v := this.vectors[name]; v != nil {
+		if v.key != key {
+			panic("metric " + name + " registered with different type or labels")
+		}
+		return v.c
+	}
+
+	c := create()
+	this.reg.MustRegister(c)
+	this.vectors[name] = &vector{key: key, c: c}
+	return c
+}
+
+// each 遍历标签值与match相符的指标
+func each(c prometheus.Collector, match Labels, f func(m *dto.Metric)) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	for pm := range ch {
+		m := &dto.Metric{}
+		if err := pm.Write(m); err != nil {
+			continue
+		}
+		ok := true
+		for _, lp := range m.GetLabel() {
+			if v, exists := match[lp.GetName()]; exists && v != lp.GetValue() {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			f(m)
+		}
+	}
+}
+
+type CounterVec struct {
+	vec *prometheus.CounterVec
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+func (this *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	v := this.vector(name, TypeCounter, labels, func() prometheus.Collector {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels)
+	})
+	return &CounterVec{vec: v.(*prometheus.CounterVec)}
+}
+
+func (this *CounterVec) With(values ...string) *Counter {
+	return &Counter{c: this.vec.WithLabelValues(values...)}
+}
+
+// Sum 标签值与match相符的计数之和，match为空时统计全部
+func (this *CounterVec) Sum(match Labels) float64 {
+	var sum float64
+	each(this.vec, match, func(m *dto.Metric) {
+		sum += m.GetCounter().GetValue()
+	})
+	return sum
+}
+
+type Counter struct {
+	c prometheus.Counter
+}
+
+func (this *Counter) Inc() {
+	this.c.Inc()
+}
+
+// Add 增加计数，delta不能为负数
+func (this *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	this.c.Add(delta)
+}
+
+func (this *Counter) Value() float64 {
+	m := &dto.Metric{}
+	_ = this.c.Write(m)
+	return m.GetCounter().GetValue()
+}
+
+type GaugeVec struct {
+	vec *prometheus.GaugeVec
+}
+
+func NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+func (this *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	v := this.vector(name, TypeGauge, labels, func() prometheus.Collector {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels)
+	})
+	return &GaugeVec{vec: v.(*prometheus.GaugeVec)}
+}
+
+func (this *GaugeVec) With(values ...string) *Gauge {
+	return &Gauge{g: this.vec.WithLabelValues(values...)}
+}
+
+func (this *GaugeVec) Sum(match Labels) float64 {
+	var sum float64
+	each(this.vec, match, func(m *dto.Metric) {
+		sum += m.GetGauge().GetValue()
+	})
+	return sum
+}
+
+type Gauge struct {
+	g prometheus.Gauge
+}
+
+func (this *Gauge) Set(v float64) {
+	this.g.Set(v)
+}
+
+func (this *Gauge) Add(delta float64) {
+	this.g.Add(delta)
+}
+
+func (this *Gauge) Inc() {
+	this.g.Inc()
+}
+
+func (this *Gauge) Dec() {
+	this.g.Dec()
+}
+
+func (this *Gauge) Value() float64 {
+	m := &dto.Metric{}
+	_ = this.g.Write(m)
+	return m.GetGauge().GetValue()
+}
+
+type HistogramVec struct {
+	vec *prometheus.HistogramVec
+}
+
+// NewHistogramVec buckets为各区间上限，为空时使用DefBuckets
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+func (this *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	bs := append([]float64{}, buckets...)
+	sort.Float64s(bs)
+	v := this.vector(name, TypeHistogram, labels, func() prometheus.Collector {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: bs}, labels)
+	})
+	return &HistogramVec{vec: v.(*prometheus.HistogramVec)}
+}
+
+func (this *HistogramVec) With(values ...string) *Histogram {
+	return &Histogram{h: this.vec.WithLabelValues(values...)}
+}
+
+// Stats 标签值与match相符的样本数及总和
+func (this *HistogramVec) Stats(match Labels) (count uint64, sum float64) {
+	each(this.vec, match, func(m *dto.Metric) {
+		count += m.GetHistogram().GetSampleCount()
+		sum += m.GetHistogram().GetSampleSum()
+	})
+	return
+}
+
+type Histogram struct {
+	h prometheus.Observer
+}
+
+func (this *Histogram) Observe(v float64) {
+	this.h.Observe(v)
+}
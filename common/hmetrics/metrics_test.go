@@ -0,0 +1,67 @@
+package hmetrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_requests_total", "requests", "api")
+	c.With("login").Inc()
+	c.With("login").Add(2)
+	c.With(`a"b`).Inc()
+
+	h := r.NewHistogramVec("test_duration_seconds", "", []float64{0.1, 1}, "api")
+	h.With("login").Observe(0.05)
+	h.With("login").Observe(0.5)
+	h.With("login").Observe(3)
+
+	r.Register(func() []Sample {
+		return []Sample{{Name: "test_pool_idle", Labels: Labels{"key": "db"}, Value: 4}}
+	})
+
+	buf := &bytes.Buffer{}
+	if err := r.WriteText(buf); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+
+	for _, line := range []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{api="login"} 3`,
+		`test_requests_total{api="a\"b"} 1`,
+		`test_duration_seconds_bucket{api="login",le="0.1"} 1`,
+		`test_duration_seconds_bucket{api="login",le="1"} 2`,
+		`test_duration_seconds_bucket{api="login",le="+Inf"} 3`,
+		`test_duration_seconds_count{api="login"} 3`,
+		`test_pool_idle{key="db"} 4`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("missing line %s in\n%s", line, text)
+		}
+	}
+
+	if c.Sum(Labels{"api": "login"}) != 3 || c.Sum(nil) != 4 {
+		t.Error("wrong counter sum")
+	}
+	if n, sum := h.Stats(nil); n != 3 || sum != 3.55 {
+		t.Errorf("wrong histogram stats %d %v", n, sum)
+	}
+}
+
+func TestSameName(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "", "api").With("a").Inc()
+	if r.NewCounterVec("test_total", "", "api").Sum(nil) != 1 {
+		t.Error("counter with same name not reused")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("metric with different labels registered")
+		}
+	}()
+	r.NewGaugeVec("test_total", "", "api")
+}
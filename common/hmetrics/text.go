@@ -0,0 +1,76 @@
+package hmetrics
+
+import (
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+)
+
+var (
+	ContentType = string(expfmt.FmtText)
+)
+
+func WriteText(w io.Writer) error {
+	return Default.WriteText(w)
+}
+
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// WriteText 按Prometheus文本格式输出全部指标
+func (this *Registry) WriteText(w io.Writer) error {
+	mfs, err := this.reg.Gather()
+	if err != nil {
+		return err
+	}
+
+	enc := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, mf := range mfs {
+		if err = enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *Registry) Handler() http.Handler {
+	return promhttp.HandlerFor(this.reg, promhttp.HandlerOpts{})
+}
+
+// sampleCollector 导出时调用Collector采集指标值，采集的指标名不固定，不预先声明
+type sampleCollector struct {
+	collect Collector
+}
+
+func (this *sampleCollector) Describe(chan<- *prometheus.Desc) {
+}
+
+func (this *sampleCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range this.collect() {
+		names := make([]string, 0, len(s.Labels))
+		for l := range s.Labels {
+			names = append(names, l)
+		}
+		sort.Strings(names)
+		values := make([]string, len(names))
+		for i, l := range names {
+			values[i] = s.Labels[l]
+		}
+
+		typ := prometheus.GaugeValue
+		if s.Type == TypeCounter {
+			typ = prometheus.CounterValue
+		}
+		m, err := prometheus.NewConstMetric(prometheus.NewDesc(s.Name, s.Help, names, nil), typ, s.Value, values...)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(prometheus.NewDesc(s.Name, s.Help, nil, nil), err)
+			continue
+		}
+		ch <- m
+	}
+}
@@ -0,0 +1,128 @@
+package hstate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fileStore 保存在本地JSON文件中的状态，每次修改后整体原子写入
+type fileStore struct {
+	lock   sync.Mutex
+	file   string
+	values map[string]string
+}
+
+func NewFileStore(file string) (IStore, error) {
+	s := &fileStore{
+		file:   file,
+		values: make(map[string]string),
+	}
+
+	bs, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(bs) > 0 {
+		if err = json.Unmarshal(bs, &s.values); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (this *fileStore) Get(key string) (string, bool, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	v, ok := this.values[key]
+	return v, ok, nil
+}
+
+func (this *fileStore) Set(key string, val string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if old, ok := this.values[key]; ok && old == val {
+		return nil
+	}
+	this.values[key] = val
+	return this.save()
+}
+
+func (this *fileStore) Delete(key string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if _, ok := this.values[key]; !ok {
+		return nil
+	}
+	delete(this.values, key)
+	return this.save()
+}
+
+func (this *fileStore) save() error {
+	bs, err := json.MarshalIndent(this.values, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(this.file, bs, 0644)
+}
+
+// WriteFileAtomic 先写入同目录下的临时文件再改名，避免写入中断时破坏原文件。原文件存在时沿用其权限
+func WriteFileAtomic(file string, data []byte, perm os.FileMode) error {
+	if info, err := os.Stat(file); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// memStore 未调用Init时使用，状态不持久化
+type memStore struct {
+	values sync.Map
+}
+
+func newMemStore() *memStore {
+	return &memStore{}
+}
+
+func (this *memStore) Get(key string) (string, bool, error) {
+	v, ok := this.values.Load(key)
+	if !ok {
+		return "", false, nil
+	}
+	return v.(string), true, nil
+}
+
+func (this *memStore) Set(key string, val string) error {
+	this.values.Store(key, val)
+	return nil
+}
+
+func (this *memStore) Delete(key string) error {
+	this.values.Delete(key)
+	return nil
+}
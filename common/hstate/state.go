@@ -0,0 +1,167 @@
+package hstate
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+//运行期间产生的状态，如实体EID、计数器及一次性标志，与配置文件分开保存。
+//缺省保存在本地文件中，也可以通过Use替换为其他存储(如数据库)
+
+const (
+	DefaultStateFile = "./state.json"
+	MemoryStateFile  = ":memory:" //状态仅保存在内存中，用于测试
+)
+
+// IStore 状态存储
+type IStore interface {
+	Get(key string) (string, bool, error)
+	Set(key string, val string) error
+	Delete(key string) error
+}
+
+var (
+	lock  sync.RWMutex
+	store IStore = newMemStore()
+)
+
+// Init 使用本地文件保存状态，file为空时使用DefaultStateFile，为MemoryStateFile时不持久化
+func Init(file string) error {
+	if file == "" {
+		file = DefaultStateFile
+	}
+
+	var s IStore = newMemStore()
+	if file != MemoryStateFile {
+		var err error
+		if s, err = NewFileStore(file); err != nil {
+			return err
+		}
+	}
+
+	lock.Lock()
+	store = s
+	lock.Unlock()
+	return nil
+}
+
+// Use 替换状态存储，新存储中不存在的状态仍从原存储读取
+func Use(s IStore) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	store = &chainStore{primary: s, fallback: store}
+}
+
+func current() IStore {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return store
+}
+
+func Get(key string) (string, bool, error) {
+	return current().Get(key)
+}
+
+func Set(key string, val string) error {
+	return current().Set(key, val)
+}
+
+func Delete(key string) error {
+	return current().Delete(key)
+}
+
+// GetString 读取状态，不存在或读取失败时返回def
+func GetString(key string, def string) string {
+	v, ok, err := current().Get(key)
+	if err != nil || !ok {
+		return def
+	}
+	return v
+}
+
+// GetInt 读取整数状态，不存在或读取失败时返回def
+func GetInt(key string, def int64) int64 {
+	v, ok, err := current().Get(key)
+	if err != nil || !ok {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+func SetInt(key string, val int64) error {
+	return current().Set(key, strconv.FormatInt(val, 10))
+}
+
+// Incr 计数器加delta，返回新值
+func Incr(key string, delta int64) (int64, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	n := int64(0)
+	v, ok, err := store.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		if n, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return 0, fmt.Errorf("state [%s] is not a number", key)
+		}
+	}
+	n += delta
+	return n, store.Set(key, strconv.FormatInt(n, 10))
+}
+
+// Pending 一次性标志(如配置项AutoMigrate、Reset)是否尚未执行，执行完成后调用Done。
+// enabled为false时清除标志，以便再次开启时重新执行
+func Pending(key string, enabled bool) (bool, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	_, done, err := store.Get(key)
+	if err != nil {
+		return false, err
+	}
+	if !enabled {
+		if done {
+			return false, store.Delete(key)
+		}
+		return false, nil
+	}
+	return !done, nil
+}
+
+// Done 标记一次性标志已执行
+func Done(key string) error {
+	return current().Set(key, "done")
+}
+
+type chainStore struct {
+	primary  IStore
+	fallback IStore
+}
+
+func (this *chainStore) Get(key string) (string, bool, error) {
+	v, ok, err := this.primary.Get(key)
+	if err != nil || ok {
+		return v, ok, err
+	}
+	return this.fallback.Get(key)
+}
+
+func (this *chainStore) Set(key string, val string) error {
+	return this.primary.Set(key, val)
+}
+
+func (this *chainStore) Delete(key string) error {
+	if err := this.primary.Delete(key); err != nil {
+		return err
+	}
+	return this.fallback.Delete(key)
+}
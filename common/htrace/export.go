@@ -0,0 +1,186 @@
+package htrace
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drharryhe/has/common/hlogger"
+)
+
+const (
+	defaultBatchSize     = 512
+	defaultQueueSize     = 4096
+	defaultFlushInterval = 5 * time.Second
+)
+
+// IExporter 导出已结束的Span，由后台协程调用
+type IExporter interface {
+	Export(spans []*Span) error
+	Close() error
+}
+
+type Options struct {
+	ServiceName   string        //导出时的service.name
+	SampleRatio   float64       //新trace的采样比例，不大于0或不小于1时全部采样
+	BatchSize     int           //每批导出的最大数量
+	FlushInterval time.Duration //导出间隔
+}
+
+var (
+	lock sync.RWMutex
+	proc *processor
+)
+
+// Init 启用追踪，已启用时先关闭原导出
+func Init(exporter IExporter, opt Options) {
+	if opt.BatchSize <= 0 {
+		opt.BatchSize = defaultBatchSize
+	}
+	if opt.FlushInterval <= 0 {
+		opt.FlushInterval = defaultFlushInterval
+	}
+
+	p := &processor{
+		exporter: exporter,
+		opt:      opt,
+		queue:    make(chan *Span, defaultQueueSize),
+		done:     make(chan struct{}),
+	}
+	p.SetSampleRatio(opt.SampleRatio)
+
+	lock.Lock()
+	old := proc
+	proc = p
+	lock.Unlock()
+
+	if old != nil {
+		old.shutdown()
+	}
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Shutdown 导出剩余的Span并停止追踪
+func Shutdown() {
+	lock.Lock()
+	p := proc
+	proc = nil
+	lock.Unlock()
+
+	if p != nil {
+		p.shutdown()
+	}
+}
+
+func Enabled() bool {
+	return current() != nil
+}
+
+// SetSampleRatio 修改新trace的采样比例
+func SetSampleRatio(ratio float64) {
+	if p := current(); p != nil {
+		p.SetSampleRatio(ratio)
+	}
+}
+
+func ServiceName() string {
+	if p := current(); p != nil {
+		return p.opt.ServiceName
+	}
+	return ""
+}
+
+func current() *processor {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return proc
+}
+
+type processor struct {
+	exporter  IExporter
+	opt       Options
+	threshold uint64 //traceID低8字节小于该值时采样
+	queue     chan *Span
+	done      chan struct{}
+	wg        sync.WaitGroup
+	dropped   uint64
+}
+
+func (this *processor) SetSampleRatio(ratio float64) {
+	if ratio <= 0 || ratio >= 1 {
+		atomic.StoreUint64(&this.threshold, math.MaxUint64)
+	} else {
+		atomic.StoreUint64(&this.threshold, uint64(ratio*math.MaxUint64))
+	}
+}
+
+// sample 按traceID采样，同一trace在各节点的结果一致
+func (this *processor) sample(id TraceID) bool {
+	threshold := atomic.LoadUint64(&this.threshold)
+	return threshold == math.MaxUint64 || binary.BigEndian.Uint64(id[8:]) < threshold
+}
+
+// add 队列已满时丢弃，不阻塞请求
+func (this *processor) add(span *Span) {
+	select {
+	case this.queue <- span:
+	default:
+		atomic.AddUint64(&this.dropped, 1)
+	}
+}
+
+func (this *processor) run() {
+	defer this.wg.Done()
+
+	ticker := time.NewTicker(this.opt.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	for {
+		select {
+		case span := <-this.queue:
+			batch = append(batch, span)
+			if len(batch) >= this.opt.BatchSize {
+				this.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			this.export(batch)
+			batch = nil
+		case <-this.done:
+			for {
+				select {
+				case span := <-this.queue:
+					batch = append(batch, span)
+				default:
+					this.export(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (this *processor) export(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := this.exporter.Export(batch); err != nil {
+		hlogger.Error("failed to export %d spans: %s", len(batch), err.Error())
+	}
+	if n := atomic.SwapUint64(&this.dropped, 0); n > 0 {
+		hlogger.Warn("%d spans dropped, trace queue full", n)
+	}
+}
+
+func (this *processor) shutdown() {
+	close(this.done)
+	this.wg.Wait()
+	if err := this.exporter.Close(); err != nil {
+		hlogger.Error("failed to close trace exporter: %s", err.Error())
+	}
+}
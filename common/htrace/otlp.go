@@ -0,0 +1,245 @@
+package htrace
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/drharryhe/has/common/hlogger"
+)
+
+//OTLP/HTTP导出，使用OTLP的JSON编码。文件导出每批写入一行同样格式的JSON，可由OpenTelemetry Collector读取
+
+const (
+	DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+	otlpTimeout = 10 * time.Second
+	scopeName   = "github.com/drharryhe/has"
+)
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func otlpValue(v interface{}) map[string]interface{} {
+	switch val := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": val}
+	case bool:
+		return map[string]interface{}{"boolValue": val}
+	case int:
+		return map[string]interface{}{"intValue": strconv.FormatInt(int64(val), 10)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(val, 10)}
+	case uint64:
+		return map[string]interface{}{"intValue": strconv.FormatUint(val, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": val}
+	default:
+		return map[string]interface{}{"stringValue": fmt.Sprint(val)}
+	}
+}
+
+func otlpAttributes(attrs map[string]interface{}) []otlpAttribute {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ret := make([]otlpAttribute, 0, len(keys))
+	for _, k := range keys {
+		ret = append(ret, otlpAttribute{Key: k, Value: otlpValue(attrs[k])})
+	}
+	return ret
+}
+
+// encodeOTLP 将Span编码为OTLP JSON格式的ExportTraceServiceRequest
+func encodeOTLP(spans []*Span) ([]byte, error) {
+	ss := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.lock.Lock()
+		span := otlpSpan{
+			TraceID:           s.Context.TraceID.String(),
+			SpanID:            s.Context.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+			Status:            otlpStatus{Code: s.StatusCode, Message: s.StatusMessage},
+		}
+		if s.Parent.IsValid() {
+			span.ParentSpanID = s.Parent.String()
+		}
+		s.lock.Unlock()
+		ss = append(ss, span)
+	}
+
+	return jsoniter.Marshal(&otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: otlpAttributes(map[string]interface{}{"service.name": ServiceName()}),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: scopeName},
+				Spans: ss,
+			}},
+		}},
+	})
+}
+
+type otlpExporter struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+}
+
+// NewOTLPExporter 通过OTLP/HTTP导出，endpoint为空时使用DefaultOTLPEndpoint
+func NewOTLPExporter(endpoint string, headers map[string]string) IExporter {
+	if endpoint == "" {
+		endpoint = DefaultOTLPEndpoint
+	}
+	return &otlpExporter{
+		endpoint: endpoint,
+		headers:  headers,
+		client:   &http.Client{Timeout: otlpTimeout},
+	}
+}
+
+func (this *otlpExporter) Export(spans []*Span) error {
+	bs, err := encodeOTLP(spans)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, this.endpoint, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range this.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp endpoint %s returned %s: %s", this.endpoint, resp.Status, string(body))
+	}
+	return nil
+}
+
+func (this *otlpExporter) Close() error {
+	this.client.CloseIdleConnections()
+	return nil
+}
+
+type fileExporter struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// NewFileExporter 追加写入本地文件，每批一行
+func NewFileExporter(file string) (IExporter, error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileExporter{file: f}, nil
+}
+
+func (this *fileExporter) Export(spans []*Span) error {
+	bs, err := encodeOTLP(spans)
+	if err != nil {
+		return err
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	_, err = this.file.Write(append(bs, '\n'))
+	return err
+}
+
+func (this *fileExporter) Close() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return this.file.Close()
+}
+
+type logExporter struct{}
+
+// NewLogExporter 每个Span输出一行日志
+func NewLogExporter() IExporter {
+	return &logExporter{}
+}
+
+func (this *logExporter) Export(spans []*Span) error {
+	for _, s := range spans {
+		s.lock.Lock()
+		status := "ok"
+		if s.StatusCode == StatusError {
+			status = "error: " + s.StatusMessage
+		}
+		hlogger.Info("[trace %s] span %s parent %s %s %v %s", s.Context.TraceID, s.Context.SpanID, s.Parent, s.Name, s.End.Sub(s.Start), status)
+		s.lock.Unlock()
+	}
+	return nil
+}
+
+func (this *logExporter) Close() error {
+	return nil
+}
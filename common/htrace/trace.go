@@ -0,0 +1,224 @@
+package htrace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+//分布式追踪。Span的标识及上下文格式与OpenTelemetry一致，跨进程时使用W3C traceparent传递，
+//结束的Span由后台批量交给IExporter导出，未调用Init时不记录
+
+const (
+	HeaderTraceparent = "traceparent"
+
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+
+	StatusUnset = 0
+	StatusOK    = 1
+	StatusError = 2
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (this TraceID) String() string {
+	return hex.EncodeToString(this[:])
+}
+
+func (this TraceID) IsValid() bool {
+	return this != TraceID{}
+}
+
+func (this SpanID) String() string {
+	return hex.EncodeToString(this[:])
+}
+
+func (this SpanID) IsValid() bool {
+	return this != SpanID{}
+}
+
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (this SpanContext) IsValid() bool {
+	return this.TraceID.IsValid() && this.SpanID.IsValid()
+}
+
+// Traceparent W3C traceparent格式，如 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func (this SpanContext) Traceparent() string {
+	flags := 0
+	if this.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", this.TraceID, this.SpanID, flags)
+}
+
+func ParseTraceparent(s string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// Span 一次操作的耗时记录。方法均可在nil上调用，未启用追踪时Start返回nil
+type Span struct {
+	Name          string
+	Kind          int
+	Context       SpanContext
+	Parent        SpanID
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]interface{}
+	StatusCode    int
+	StatusMessage string
+
+	lock  sync.Mutex
+	ended bool
+}
+
+func (this *Span) SetAttribute(key string, val interface{}) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.Attributes[key] = val
+}
+
+func (this *Span) SetError(msg string) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.StatusCode = StatusError
+	this.StatusMessage = msg
+}
+
+// Finish 结束Span，已采样的Span交由后台导出
+func (this *Span) Finish() {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	if this.ended {
+		this.lock.Unlock()
+		return
+	}
+	this.ended = true
+	this.End = time.Now()
+	this.lock.Unlock()
+
+	if this.Context.Sampled {
+		if p := current(); p != nil {
+			p.add(this)
+		}
+	}
+}
+
+// TraceID 所属trace的ID，nil时返回空字符串
+func (this *Span) TraceID() string {
+	if this == nil {
+		return ""
+	}
+	return this.Context.TraceID.String()
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemote 绑定调用方传入的traceparent，作为之后创建的Span的父级
+func ContextWithRemote(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	sc, ok := ParseTraceparent(traceparent)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Traceparent 当前Span的traceparent，用于向下游传递，没有时返回空字符串
+func Traceparent(ctx context.Context) string {
+	if sc, ok := parentContext(ctx); ok {
+		return sc.Traceparent()
+	}
+	return ""
+}
+
+func parentContext(ctx context.Context) (SpanContext, bool) {
+	if ctx == nil {
+		return SpanContext{}, false
+	}
+	if span := SpanFromContext(ctx); span != nil {
+		return span.Context, true
+	}
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok
+}
+
+// Start 创建Span并绑定到返回的context，ctx中已有Span或远程上下文时作为其子级
+func Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	p := current()
+	if p == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]interface{}),
+	}
+	if parent, ok := parentContext(ctx); ok {
+		span.Context.TraceID = parent.TraceID
+		span.Context.Sampled = parent.Sampled
+		span.Parent = parent.SpanID
+	} else {
+		_, _ = rand.Read(span.Context.TraceID[:])
+		span.Context.Sampled = p.sample(span.Context.TraceID)
+	}
+	_, _ = rand.Read(span.Context.SpanID[:])
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
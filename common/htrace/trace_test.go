@@ -0,0 +1,68 @@
+package htrace
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memExporter struct {
+	lock  sync.Mutex
+	spans []*Span
+}
+
+func (this *memExporter) Export(spans []*Span) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.spans = append(this.spans, spans...)
+	return nil
+}
+
+func (this *memExporter) Close() error {
+	return nil
+}
+
+func TestTraceparent(t *testing.T) {
+	s := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(s)
+	if !ok || !sc.Sampled || sc.Traceparent() != s {
+		t.Fatalf("failed to parse %s", s)
+	}
+	if _, ok = ParseTraceparent("00-00000000000000000000000000000000-00f067aa0ba902b7-01"); ok {
+		t.Error("zero trace id accepted")
+	}
+}
+
+func TestSpans(t *testing.T) {
+	exp := &memExporter{}
+	Init(exp, Options{ServiceName: "test", FlushInterval: time.Hour})
+
+	ctx := ContextWithRemote(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, parent := Start(ctx, "api", KindServer)
+	_, child := Start(ctx, "slot", KindInternal)
+	child.SetError("failed")
+	child.Finish()
+	parent.Finish()
+	if !strings.Contains(Traceparent(ctx), parent.Context.SpanID.String()) {
+		t.Error("wrong traceparent")
+	}
+	Shutdown()
+
+	if len(exp.spans) != 2 {
+		t.Fatalf("%d spans exported", len(exp.spans))
+	}
+	if parent.TraceID() != "4bf92f3577b34da6a3ce929d0e0e4736" || child.Parent != parent.Context.SpanID {
+		t.Error("wrong span parent")
+	}
+
+	bs, err := encodeOTLP(exp.spans)
+	if err != nil || !strings.Contains(string(bs), `"parentSpanId":"`+parent.Context.SpanID.String()+`"`) {
+		t.Errorf("wrong otlp encoding %s", string(bs))
+	}
+
+	if _, span := Start(context.Background(), "disabled", KindInternal); span != nil {
+		t.Error("span created after shutdown")
+	}
+}
@@ -14,7 +14,7 @@ func TestMap(t *testing.T) {
 	{
 		"name":"harry",
 		"sex":"man",
-		"age":16,
+		"age":16
 	}`
 	v := make(Map)
 
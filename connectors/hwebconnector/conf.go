@@ -6,10 +6,10 @@ type WebConnector struct {
 	core.ConnectorConf
 
 	AppKey           string
-	AppSecret        string
+	AppSecret        string `secret:"true"`
 	SignMethod       string
 	Port             int
-	Timeout          int
+	Timeout          int // 请求超时(秒)，0表示不限制
 	BodyLimit        int // Mbit
 	Tls              bool
 	TlsCertPath      string
@@ -18,5 +18,9 @@ type WebConnector struct {
 	WebSocketEnabled bool   // 是否开启websocket
 	WsUserField      string // Websocket User字段
 	WsTokenField     string // Websocket Token字段
+	APIDocPath       string // OpenAPI文档路径，如/openapi，为空(默认)时不提供。文档不校验身份，仅在内网或开发环境开启
+	JobPath          string // 异步任务查询路径，如/jobs，为空时不提供。查询经提交任务的接口的in middleware校验，只有提交者可以查询
+	ManagePath       string // 实体管理接口路径，如/manage，为空时不提供，口令为网关配置ManageToken
+	MetricsPath      string // Prometheus格式的运行指标路径，如/metrics，为空(默认)时不提供，口令为网关配置ManageToken
 	//WsMsgIDFile      string // 消息id
 }
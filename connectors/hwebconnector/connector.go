@@ -2,11 +2,14 @@ package hwebconnector
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"github.com/drharryhe/has/common/hconf"
 	"github.com/drharryhe/has/common/herrors"
 	"github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/common/hmetrics"
+	"github.com/drharryhe/has/common/htrace"
 	"github.com/drharryhe/has/common/htypes"
 	"github.com/drharryhe/has/core"
 	"github.com/gofiber/fiber/v2"
@@ -14,11 +17,15 @@ import (
 	"github.com/gofiber/websocket/v2"
 	jsoniter "github.com/json-iterator/go"
 	uuid "github.com/satori/go.uuid"
+	"go.uber.org/atomic"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -26,6 +33,15 @@ const (
 	PreviewFlag      = "FILE-PREVIEW"
 	defaultBodyLimit = 10
 	defaultPort      = 1976
+
+	HeaderRequestID         = "X-Request-ID"         //请求ID，调用方传入的ID合法时沿用
+	HeaderRequestAttributes = "X-Request-Attributes" //middleware公开的请求属性(JSON)
+	HeaderAsync             = "X-Async"              //值为true时异步请求，立即返回任务ID
+	HeaderManageToken       = "X-Manage-Token"       //实体管理接口口令
+	HeaderTraceID           = "X-Trace-ID"           //开启追踪时返回请求所属的trace ID
+	HeaderDeprecation       = "Deprecation"          //api.json中声明弃用的接口，值为弃用时间(@Unix时间戳)
+	HeaderSunset            = "Sunset"               //api.json中声明停用日期的接口，值为停用时间(HTTP-date)
+	HeaderRetryAfter        = "Retry-After"          //因限流被拒绝时建议的重试等待(秒)
 )
 
 func New() *Connector {
@@ -36,8 +52,9 @@ func New() *Connector {
 type Connector struct {
 	core.BaseConnector
 
-	conf      WebConnector
-	App       *fiber.App
+	conf     WebConnector
+	settings atomic.Value //*WebConnector，请求处理中使用的配置副本，重新加载配置时整体替换
+	App      *fiber.App
 	//WsConnMap map[string]*websocket.Conn // ws_id: ws
 	WsConnMap sync.Map // ws_id: ws
 }
@@ -55,13 +72,18 @@ func (this *Connector) Open(gw core.IAPIGateway, ins core.IAPIConnector) *herror
 		this.conf.BodyLimit = defaultBodyLimit
 	}
 
+	conf := this.conf
+	this.settings.Store(&conf)
+
 	this.App = fiber.New(fiber.Config{
 		BodyLimit: this.conf.BodyLimit * 1024 * 1024,
 	})
 
 	//this.WsConnMap = make(map[string]*websocket.Conn)
 
-	this.App.Use(cors.New())
+	this.App.Use(cors.New(cors.Config{
+		ExposeHeaders: strings.Join([]string{HeaderRequestID, HeaderRequestAttributes, HeaderTraceID, HeaderDeprecation, HeaderSunset, HeaderRetryAfter}, ","),
+	}))
 	if hconf.IsDebug() {
 		this.App.Get("/error/query/:fingerprint", this.handleErrFingerprint)
 		this.App.Get("/error/statics", this.handleErrStatics)
@@ -83,6 +105,23 @@ func (this *Connector) Open(gw core.IAPIGateway, ins core.IAPIConnector) *herror
 		this.App.Get(fmt.Sprintf("/ws/:version/:api"), websocket.New(this.handleWsServiceAPI))
 	}
 
+	if this.conf.APIDocPath != "" {
+		path := "/" + strings.Trim(this.conf.APIDocPath, "/")
+		this.App.Get(path, this.handleAPIVersions)
+		this.App.Get(path+"/:version", this.handleAPIDocument)
+	}
+	if this.conf.JobPath != "" {
+		this.App.Get("/"+strings.Trim(this.conf.JobPath, "/")+"/:id", this.handleJob)
+	}
+	if this.conf.ManagePath != "" {
+		path := "/" + strings.Trim(this.conf.ManagePath, "/")
+		this.App.Get(path+"/entities", this.handleEntities)
+		this.App.Post(path+"/entities/:eid/:act", this.handleManageEntity)
+	}
+	if this.conf.MetricsPath != "" {
+		this.App.Get("/"+strings.Trim(this.conf.MetricsPath, "/"), this.handleMetrics)
+	}
+
 	this.App.Get("/:version/*", this.handleServiceAPI)
 	this.App.Post("/:version/*", this.handleServiceAPI)
 	this.App.Get("/ping", func(ctx *fiber.Ctx) error {
@@ -125,6 +164,21 @@ func (this *Connector) Name() string {
 	return this.conf.Name
 }
 
+// Close 停止接收新请求。fasthttp会等待处理中的请求结束，因此在后台执行，等待由网关负责
+func (this *Connector) Close() {
+	//websocket连接已被接管，不在fasthttp的等待范围内，需主动关闭
+	this.WsConnMap.Range(func(key, value interface{}) bool {
+		_ = value.(*websocket.Conn).Close()
+		return true
+	})
+
+	go func() {
+		if err := this.App.Shutdown(); err != nil {
+			hlogger.Error(herrors.ErrSysInternal.New(err.Error()).D("failed to shutdown Fiber App"))
+		}
+	}()
+}
+
 func (this *Connector) handleErrFingerprint(c *fiber.Ctx) error {
 	if !hconf.IsDebug() {
 		_ = c.SendString("error fingerprint query not available")
@@ -152,27 +206,124 @@ func (this *Connector) handleErrStatics(c *fiber.Ctx) error {
 	return nil
 }
 
-func (this *Connector) handleServiceAPI(c *fiber.Ctx) error {
-	//api := c.Params("api")
-	version := c.Params("version")
-	api := strings.Replace(c.Path(), "/"+version+"/", "", 1)
-	hlogger.Info(api)
-	ps, err := this.ParseQueryParams(c)
+func (this *Connector) handleAPIVersions(c *fiber.Ctx) error {
+	return this.sendJSON(c, this.Gateway.APIVersions())
+}
+
+func (this *Connector) handleAPIDocument(c *fiber.Ctx) error {
+	doc, err := this.Gateway.APIDocument(c.Params("version"))
 	if err != nil {
-		return err
+		c.Status(http.StatusNotFound)
+		this.SendResponse(c, nil, err)
+		return nil
+	}
+	return this.sendJSON(c, doc)
+}
+
+// handleJob 查询异步任务。请求参数与调用接口时相同(如会话的User、Token)，由网关按提交任务的接口执行in middleware并校验提交者
+func (this *Connector) handleJob(c *fiber.Ctx) error {
+	conf := this.currentConf()
+	if conf.Disabled {
+		c.Status(http.StatusServiceUnavailable)
+		this.SendResponse(c, nil, herrors.ErrSysBusy.New("connector [%s] disabled", conf.Name))
+		return nil
 	}
 
-	err = this.ParseFormParams(c, ps)
+	ps, err := this.parseParams(c)
 	if err != nil {
-		return err
+		this.SendResponse(c, nil, err)
+		return nil
 	}
 
-	err = this.ParseHeaderParams(c, ps)
+	scope := core.NewRequestScope(c.Get(HeaderRequestID))
+	ctx, cancel := this.requestContext(c.UserContext())
+	defer cancel()
+
+	job, err := this.Gateway.Job(core.ContextWithScope(ctx, scope), c.Params("id"), ps)
+	this.setScopeHeaders(c, scope)
+	this.SendResponse(c, job, err)
+	return nil
+}
+
+// handleEntities 列出本节点及其他节点的全部实体
+func (this *Connector) handleEntities(c *fiber.Ctx) error {
+	if err := this.Gateway.AuthorizeManagement(c.Get(HeaderManageToken)); err != nil {
+		c.Status(http.StatusUnauthorized)
+		this.SendResponse(c, nil, err)
+		return nil
+	}
+
+	ret, err := this.Gateway.Entities()
+	this.SendResponse(c, ret, err)
+	return nil
+}
+
+// handleManageEntity 管理实体，请求体为管理参数(JSON)，查询参数server指定实体所在节点的ServerEID，缺省为本节点
+func (this *Connector) handleManageEntity(c *fiber.Ctx) error {
+	if err := this.Gateway.AuthorizeManagement(c.Get(HeaderManageToken)); err != nil {
+		c.Status(http.StatusUnauthorized)
+		this.SendResponse(c, nil, err)
+		return nil
+	}
+
+	ps := make(htypes.Map)
+	if body := c.Body(); len(body) > 0 {
+		if err := jsoniter.Unmarshal(body, &ps); err != nil {
+			this.SendResponse(c, nil, herrors.ErrCallerInvalidRequest.New(err.Error()))
+			return nil
+		}
+	}
+
+	ret, err := this.Gateway.ManageEntity(c.Query("server"), c.Params("eid"), c.Params("act"), ps)
+	this.SendResponse(c, ret, err)
+	return nil
+}
+
+// handleMetrics 导出运行指标，口令同实体管理接口，可由X-Manage-Token或Authorization: Bearer提供
+func (this *Connector) handleMetrics(c *fiber.Ctx) error {
+	token := c.Get(HeaderManageToken)
+	if token == "" {
+		token = strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	}
+	if err := this.Gateway.AuthorizeManagement(token); err != nil {
+		c.Status(http.StatusUnauthorized)
+		this.SendResponse(c, nil, err)
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	if err := hmetrics.WriteText(buf); err != nil {
+		this.SendResponse(c, nil, herrors.ErrSysInternal.New(err.Error()))
+		return nil
+	}
+	c.Set(fiber.HeaderContentType, hmetrics.ContentType)
+	return c.Send(buf.Bytes())
+}
+
+func (this *Connector) sendJSON(c *fiber.Ctx, data htypes.Any) error {
+	bs, err := jsoniter.Marshal(data)
 	if err != nil {
-		return err
+		this.SendResponse(c, nil, herrors.ErrSysInternal.New(err.Error()))
+		return nil
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(bs)
+}
+
+func (this *Connector) handleServiceAPI(c *fiber.Ctx) error {
+	conf := this.currentConf()
+	if conf.Disabled {
+		c.Status(http.StatusServiceUnavailable)
+		this.SendResponse(c, nil, herrors.ErrSysBusy.New("connector [%s] disabled", conf.Name))
+		return nil
 	}
 
-	err = this.ParseBodyParams(c, ps)
+	//api := c.Params("api")
+	version := c.Params("version")
+	api := strings.Replace(c.Path(), "/"+version+"/", "", 1)
+	scope := core.NewRequestScope(c.Get(HeaderRequestID))
+	hlogger.Info("[%s] %s", scope.ID, api)
+	ps, err := this.parseParams(c)
 	if err != nil {
 		return err
 	}
@@ -187,9 +338,37 @@ func (this *Connector) handleServiceAPI(c *fiber.Ctx) error {
 	//	}
 	//}
 
-	ps[this.conf.AddressField] = c.IP()
-	ret, err := this.Gateway.RequestAPI(version, api, ps)
+	ctx, cancel := this.requestContext(c.UserContext())
+	defer cancel()
+
+	ctx = core.ContextWithScope(ctx, scope)
+	ctx, span := htrace.Start(htrace.ContextWithRemote(ctx, c.Get(htrace.HeaderTraceparent)), "HTTP "+c.Method()+" "+c.Path(), htrace.KindServer)
+	defer span.Finish()
+	span.SetAttribute("http.method", c.Method())
+	span.SetAttribute("http.target", c.Path())
+	span.SetAttribute("request.id", scope.ID)
+	if span != nil {
+		c.Set(HeaderTraceID, span.TraceID())
+	}
+
+	if c.Get(HeaderAsync) == "true" {
+		job, err := this.Gateway.RequestAPIAsync(ctx, version, api, ps)
+		this.setScopeHeaders(c, scope)
+		if err != nil {
+			span.SetError(err.Error())
+			setRetryAfter(c, err)
+			this.SendResponse(c, nil, err)
+		} else {
+			this.SendResponse(c, htypes.Map{"job": job.ID}, nil)
+		}
+		return nil
+	}
+
+	ret, err := this.Gateway.RequestAPIContext(ctx, version, api, ps)
+	this.setScopeHeaders(c, scope)
 	if err != nil {
+		span.SetError(err.Error())
+		setRetryAfter(c, err)
 		this.SendResponse(c, nil, err)
 		return nil
 	}
@@ -205,24 +384,34 @@ func (this *Connector) handleServiceAPI(c *fiber.Ctx) error {
 }
 
 func (this *Connector) handleWsServiceAPI(c *websocket.Conn) {
+	conf := this.currentConf()
 	if hconf.IsDebug() {
 		hlogger.Info("websocket连接建立: ", c.RemoteAddr().String())
 	}
-	user := c.Query(this.conf.WsUserField)
-	token := c.Query(this.conf.WsTokenField)
+	user := c.Query(conf.WsUserField)
+	token := c.Query(conf.WsTokenField)
+
+	//连接断开后，取消该连接上仍在执行的请求
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	uid := uuid.NewV4().String()
 	//this.WsConnMap[uid] = c
 	this.WsConnMap.Store(uid, c)
+	if conf.Disabled {
+		this.SendWsResponse(uid, nil, herrors.ErrSysBusy.New("connector [%s] disabled", conf.Name))
+		this.WsConnMap.Delete(uid)
+		return
+	}
 	prePs := make(htypes.Map)
 	prePs = htypes.Map{
-		this.conf.WsTokenField: token,
-		this.conf.WsUserField:  user,
+		conf.WsTokenField: token,
+		conf.WsUserField:  user,
 		"WsID":                 uid,
 		"INITWS":               true,
 		"BREAK":                false,
 	}
-	_, err := this.Gateway.RequestWSAPI(c.Params("version"), c.Params("api"), prePs)
+	_, err := this.Gateway.RequestWSAPIContext(ctx, c.Params("version"), c.Params("api"), prePs)
 	if err != nil {
 		this.SendWsResponse(uid, nil, err)
 		return
@@ -238,9 +427,9 @@ func (this *Connector) handleWsServiceAPI(c *websocket.Conn) {
 			}
 		}
 		if errs != nil {
-			this.Gateway.RequestWSAPI(c.Params("version"), c.Params("api"), htypes.Map{
-				this.conf.WsTokenField: token,
-				this.conf.WsUserField:  user,
+			this.Gateway.RequestWSAPIContext(ctx, c.Params("version"), c.Params("api"), htypes.Map{
+				conf.WsTokenField: token,
+				conf.WsUserField:  user,
 				"WsID":                 uid,
 				"INITWS":               false,
 				"BREAK":                true,
@@ -261,13 +450,13 @@ func (this *Connector) handleWsServiceAPI(c *websocket.Conn) {
 				this.WsConnMap.Delete(uid)
 				break
 			}
-			ps[this.conf.AddressField] = c.Conn.RemoteAddr().String()
-			ps[this.conf.WsUserField] = user
-			ps[this.conf.WsTokenField] = token
+			ps[conf.AddressField] = c.Conn.RemoteAddr().String()
+			ps[conf.WsUserField] = user
+			ps[conf.WsTokenField] = token
 			ps["WsID"] = uid
 			ps["INITWS"] = false
 			ps["BREAK"] = false
-			_, err := this.Gateway.RequestWSAPI(c.Params("version"), c.Params("api"), ps)
+			_, err := this.Gateway.RequestWSAPIContext(ctx, c.Params("version"), c.Params("api"), ps)
 			if err != nil {
 				this.SendWsResponse(uid, nil, err)
 				//delete(this.WsConnMap, uid)
@@ -279,6 +468,65 @@ func (this *Connector) handleWsServiceAPI(c *websocket.Conn) {
 	}
 }
 
+func (this *Connector) setScopeHeaders(c *fiber.Ctx, scope *core.RequestScope) {
+	c.Set(HeaderRequestID, scope.ID)
+	if def := scope.Definition; def != nil {
+		if t := def.DeprecatedAt(); !t.IsZero() {
+			c.Set(HeaderDeprecation, fmt.Sprintf("@%d", t.Unix()))
+		}
+		if t := def.SunsetAt(); !t.IsZero() {
+			c.Set(HeaderSunset, t.UTC().Format(http.TimeFormat))
+		}
+	}
+
+	attrs := scope.Exposed()
+	if len(attrs) == 0 {
+		return
+	}
+	bs, err := jsoniter.Marshal(attrs)
+	if err != nil {
+		hlogger.Error(herrors.ErrSysInternal.New(err.Error()).D("failed to marshal request attributes"))
+		return
+	}
+	c.Set(HeaderRequestAttributes, string(bs))
+}
+
+// setRetryAfter 因限流被拒绝时设置Retry-After，不足1秒按1秒
+func setRetryAfter(c *fiber.Ctx, err *herrors.Error) {
+	if d := core.RetryAfter(err); d > 0 {
+		c.Set(HeaderRetryAfter, strconv.Itoa(int(math.Ceil(d.Seconds()))))
+	}
+}
+
+// parseParams 合并查询、表单、请求头及请求体中的参数，并加入调用方地址
+func (this *Connector) parseParams(c *fiber.Ctx) (htypes.Map, *herrors.Error) {
+	conf := this.currentConf()
+	ps, err := this.ParseQueryParams(c)
+	if err != nil {
+		return nil, err
+	}
+	if err = this.ParseFormParams(c, ps); err != nil {
+		return nil, err
+	}
+	if err = this.ParseHeaderParams(c, ps); err != nil {
+		return nil, err
+	}
+	if err = this.ParseBodyParams(c, ps); err != nil {
+		return nil, err
+	}
+
+	ps[conf.AddressField] = c.IP()
+	return ps, nil
+}
+
+func (this *Connector) requestContext(parent context.Context) (context.Context, context.CancelFunc) {
+	conf := this.currentConf()
+	if conf.Timeout > 0 {
+		return context.WithTimeout(parent, time.Duration(conf.Timeout)*time.Second)
+	}
+	return context.WithCancel(parent)
+}
+
 func (this *Connector) CloseWsConn(wsID string) (err error) {
 	//err = this.WsConnMap[wsID].Close()
 	//delete(this.WsConnMap, wsID)
@@ -288,10 +536,11 @@ func (this *Connector) CloseWsConn(wsID string) (err error) {
 }
 
 func (this *Connector) SendWsResponse(wsID string, data htypes.Any, err *herrors.Error) {
+	conf := this.currentConf()
 	if err != nil && err.Code != herrors.ECodeOK {
-		if this.conf.Lang != "" {
+		if conf.Lang != "" {
 			if trans := this.Gateway.I18n(); trans != nil {
-				err = err.D(trans.Translate(this.conf.Lang, err.Desc))
+				err = err.D(trans.Translate(conf.Lang, err.Desc))
 			}
 		}
 	}
@@ -310,10 +559,11 @@ func (this *Connector) SendWsResponse(wsID string, data htypes.Any, err *herrors
 }
 
 func (this *Connector) SendResponse(c *fiber.Ctx, data htypes.Any, err *herrors.Error) {
+	conf := this.currentConf()
 	if err != nil && err.Code != herrors.ECodeOK {
-		if this.conf.Lang != "" {
+		if conf.Lang != "" {
 			if trans := this.Gateway.I18n(); trans != nil {
-				err = err.D(trans.Translate(this.conf.Lang, err.Desc))
+				err = err.D(trans.Translate(conf.Lang, err.Desc))
 			}
 		}
 	}
@@ -449,3 +699,24 @@ func (this *Connector) EntityStub() *core.EntityStub {
 func (this *Connector) Config() core.IEntityConf {
 	return &this.conf
 }
+
+func (this *Connector) currentConf() *WebConnector {
+	return this.settings.Load().(*WebConnector)
+}
+
+// OnConfigReload 监听及路由相关设置无法在运行期间修改，签名、超时及参数字段设置立即生效
+func (this *Connector) OnConfigReload(old core.IEntityConf) *herrors.Error {
+	o := old.(*WebConnector)
+	if this.conf.BodyLimit <= 0 {
+		this.conf.BodyLimit = defaultBodyLimit
+	}
+	if o.Port != this.conf.Port || o.Tls != this.conf.Tls || o.TlsCertPath != this.conf.TlsCertPath ||
+		o.TlsKeyPath != this.conf.TlsKeyPath || o.BodyLimit != this.conf.BodyLimit ||
+		o.WebSocketEnabled != this.conf.WebSocketEnabled || o.APIDocPath != this.conf.APIDocPath || o.JobPath != this.conf.JobPath ||
+		o.ManagePath != this.conf.ManagePath || o.MetricsPath != this.conf.MetricsPath {
+		return herrors.ErrCallerInvalidRequest.New("Port, Tls, BodyLimit, WebSocketEnabled and paths can not be changed at runtime")
+	}
+	conf := this.conf
+	this.settings.Store(&conf)
+	return nil
+}
@@ -1,5 +1,9 @@
 package core
 
+import (
+	"time"
+)
+
 //API 接口定义
 
 type APIDefine struct {
@@ -8,8 +12,10 @@ type APIDefine struct {
 }
 
 type OpenAPI struct {
-	Version string `json:"version"`
-	APIs    []API  `json:"apis"`
+	Version string   `json:"version"`
+	Extends string   `json:"extends,omitempty"` //继承的版本，未覆盖及未移除的接口沿用该版本的定义
+	Removes []string `json:"removes,omitempty"` //不再继承的接口
+	APIs    []API    `json:"apis"`
 }
 
 type API struct {
@@ -17,6 +23,28 @@ type API struct {
 	Desc     string   `json:"desc"` //接口描述
 	Disabled bool     `json:"disabled"`
 	EndPoint EndPoint `json:"endpoint"` //API映射的slot
+
+	Aliases         []string  `json:"aliases,omitempty"`         //别名，以别名请求时按本接口处理，如接口改名后保留原名称
+	Timeout         int       `json:"timeout,omitempty"`         //处理超时(毫秒)，含重试，0表示使用网关配置RequestTimeout
+	RateLimit       int       `json:"rateLimit,omitempty"`       //每秒处理的请求数，超出时立即拒绝，0表示不限制
+	RateBurst       int       `json:"rateBurst,omitempty"`       //允许的突发请求数，缺省等于rateLimit
+	RateLimitBy     []string  `json:"rateLimitBy,omitempty"`     //分别计数的维度：user、ip、appkey，为空时接口整体计数
+	Middlewares     []string  `json:"middlewares,omitempty"`     //仅执行列出的middleware(Class)，为空时执行全部
+	SkipMiddlewares []string  `json:"skipMiddlewares,omitempty"` //不执行列出的middleware(Class)
+	Deprecated      string    `json:"deprecated,omitempty"`      //弃用日期(2006-01-02或RFC3339)，响应带Deprecation头
+	Sunset          string    `json:"sunset,omitempty"`          //停用日期，响应带Sunset头，此后拒绝请求
+	Idempotent      bool      `json:"idempotent,omitempty"`      //slot可重复调用而不改变结果，设置后才允许重试
+	Retries         int       `json:"retries,omitempty"`         //服务端错误时的重试次数
+	RetryBackoff    int       `json:"retryBackoff,omitempty"`    //首次重试前等待(毫秒)，此后每次加倍，缺省100
+	MaxConcurrent   int       `json:"maxConcurrent,omitempty"`   //同时处理的请求数上限，超出时立即拒绝，0表示不限制
+	Fallback        *EndPoint `json:"fallback,omitempty"`        //调用失败、超时或熔断时改为调用的slot
+
+	deprecatedAt time.Time
+	sunsetAt     time.Time
+	middlewares  map[string]bool //Middlewares或SkipMiddlewares
+	rateLimit    *rateRule
+	bulkhead     chan struct{} //MaxConcurrent
+	initialized  bool
 }
 
 type EndPoint struct {
@@ -4,7 +4,6 @@ import (
 	"github.com/drharryhe/has/common/hconf"
 	"github.com/drharryhe/has/common/herrors"
 	"github.com/drharryhe/has/common/htypes"
-	"github.com/drharryhe/has/utils/hrandom"
 	"github.com/drharryhe/has/utils/hruntime"
 )
 
@@ -74,11 +73,12 @@ func (this *BaseConnector) Server() IServer {
 	return this.server
 }
 
+func (this *BaseConnector) OnConfigReload(_ IEntityConf) *herrors.Error {
+	return nil
+}
+
 func (this *BaseConnector) EntityMeta() *EntityMeta {
-	if this.instance.(IEntity).Config().GetEID() == "" {
-		this.instance.(IEntity).Config().SetEID(hrandom.UuidWithoutDash())
-		hconf.Save()
-	}
+	ensureEID(this.instance.(IEntity).Config())
 
 	return &EntityMeta{
 		ServerEID: this.Server().(IEntity).Config().GetEID(),
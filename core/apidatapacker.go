@@ -4,7 +4,6 @@ import (
 	"github.com/drharryhe/has/common/hconf"
 	"github.com/drharryhe/has/common/herrors"
 	"github.com/drharryhe/has/common/htypes"
-	"github.com/drharryhe/has/utils/hrandom"
 	"github.com/drharryhe/has/utils/hruntime"
 )
 
@@ -30,6 +29,10 @@ func (this *BasePacker) Server() IServer {
 	return this.server
 }
 
+func (this *BasePacker) OnConfigReload(_ IEntityConf) *herrors.Error {
+	return nil
+}
+
 func (this *BasePacker) Class() string {
 	return this.class
 }
@@ -43,10 +46,7 @@ func (this *BasePacker) Unmarshal(bytes []byte) (htypes.Any, *herrors.Error) {
 }
 
 func (this *BasePacker) EntityMeta() *EntityMeta {
-	if this.instance.(IEntity).Config().GetEID() == "" {
-		this.instance.(IEntity).Config().SetEID(hrandom.UuidWithoutDash())
-		hconf.Save()
-	}
+	ensureEID(this.instance.(IEntity).Config())
 
 	return &EntityMeta{
 		ServerEID: this.server.(IEntity).Config().GetEID(),
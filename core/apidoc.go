@@ -0,0 +1,343 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/utils/hio"
+)
+
+//根据api.json及slot参数定义生成OpenAPI 3文档
+
+const (
+	openAPIVersion  = "3.0.3"
+	apiDocMediaType = "application/json"
+	apiDocFileName  = "openapi_%s.json"
+)
+
+type APIDocument struct {
+	OpenAPI string                 `json:"openapi"`
+	Info    APIDocInfo             `json:"info"`
+	Paths   map[string]*APIDocPath `json:"paths"`
+}
+
+type APIDocInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type APIDocPath struct {
+	Post *APIDocOperation `json:"post,omitempty"`
+}
+
+type APIDocOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Deprecated  bool                       `json:"deprecated,omitempty"`
+	RequestBody *APIDocBody                `json:"requestBody,omitempty"`
+	Responses   map[string]*APIDocResponse `json:"responses"`
+}
+
+type APIDocBody struct {
+	Required bool                      `json:"required,omitempty"`
+	Content  map[string]*APIDocContent `json:"content"`
+}
+
+type APIDocResponse struct {
+	Description string                    `json:"description"`
+	Content     map[string]*APIDocContent `json:"content,omitempty"`
+}
+
+type APIDocContent struct {
+	Schema *JSONSchema `json:"schema"`
+}
+
+type JSONSchema struct {
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Default              interface{}            `json:"default,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+}
+
+// APIVersions 返回api.json中定义的全部版本
+func (this *APIGateWayImplement) APIVersions() []string {
+	this.apiLock.RLock()
+	var vs []string
+	for v := range this.apiSet {
+		vs = append(vs, v)
+	}
+	this.apiLock.RUnlock()
+	sort.Strings(vs)
+	return vs
+}
+
+// APIDocument 生成指定版本的OpenAPI文档，API参数取自其映射的slot。非本地服务的slot无法获取参数定义
+func (this *APIGateWayImplement) APIDocument(version string) (*APIDocument, *herrors.Error) {
+	apis := this.versionAPIs(version)
+	if apis == nil {
+		return nil, herrors.ErrCallerInvalidRequest.New("api version [%s] not supported", version)
+	}
+	this.apiLock.RLock()
+	title := this.apiName
+	this.apiLock.RUnlock()
+
+	doc := &APIDocument{
+		OpenAPI: openAPIVersion,
+		Info: APIDocInfo{
+			Title:   title,
+			Version: version,
+		},
+		Paths: make(map[string]*APIDocPath),
+	}
+
+	for name, api := range apis {
+		if api.Disabled || name != api.Name {
+			continue
+		}
+
+		params := &JSONSchema{Type: "object"}
+		if slot := this.server.Slot(api.EndPoint.Service, api.EndPoint.Slot); slot != nil {
+			params = slotParamsSchema(slot)
+		} else {
+			params.Description = fmt.Sprintf("parameters of [%s.%s] unknown", api.EndPoint.Service, api.EndPoint.Slot)
+			params.AdditionalProperties = boolPtr(true)
+		}
+
+		doc.Paths[fmt.Sprintf("/%s/%s", version, name)] = &APIDocPath{
+			Post: &APIDocOperation{
+				OperationID: name,
+				Summary:     api.Desc,
+				Tags:        []string{api.EndPoint.Service},
+				Deprecated:  api.Deprecated != "",
+				RequestBody: &APIDocBody{
+					Required: len(params.Required) > 0,
+					Content: map[string]*APIDocContent{
+						apiDocMediaType: {Schema: params},
+					},
+				},
+				Responses: map[string]*APIDocResponse{
+					"200": {
+						Description: "OK",
+						Content: map[string]*APIDocContent{
+							apiDocMediaType: {Schema: responseSchema()},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	return doc, nil
+}
+
+// ExportAPIDocuments 将各版本的OpenAPI文档导出到dir目录，文件名为openapi_<version>.json
+func (this *APIGateWayImplement) ExportAPIDocuments(dir string) *herrors.Error {
+	for _, v := range this.APIVersions() {
+		doc, err := this.APIDocument(v)
+		if err != nil {
+			return err
+		}
+
+		bs, e := jsoniter.MarshalIndent(doc, "", "  ")
+		if e != nil {
+			return herrors.ErrSysInternal.New(e.Error())
+		}
+
+		fname := filepath.Join(dir, fmt.Sprintf(apiDocFileName, v))
+		if e = hio.CreateFile(fname, bs); e != nil {
+			return herrors.ErrSysInternal.New(e.Error()).D("failed to write %s", fname)
+		}
+		hlogger.Info("api document exported: %s", fname)
+	}
+	return nil
+}
+
+func slotParamsSchema(slot *Slot) *JSONSchema {
+	return objectSchema(slot.Params)
+}
+
+func objectSchema(params map[string]*SlotParameter) *JSONSchema {
+	s := &JSONSchema{
+		Type:       "object",
+		Properties: make(map[string]*JSONSchema),
+	}
+
+	var names []string
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := params[name]
+		s.Properties[name] = paramSchema(p)
+		if p.Require {
+			s.Required = append(s.Required, name)
+		}
+	}
+
+	return s
+}
+
+func paramSchema(p *SlotParameter) *JSONSchema {
+	var s *JSONSchema
+	if p.Type != "" {
+		s = htypeSchema(htypes.HType(p.Type))
+	} else if p.goType != nil {
+		s = goTypeSchema(p.goType)
+	} else {
+		s = &JSONSchema{}
+	}
+
+	//结构体字段
+	if p.Fields != nil {
+		if s.Type == "array" {
+			s.Items = objectSchema(p.Fields)
+		} else {
+			s = objectSchema(p.Fields)
+		}
+	}
+
+	var desc []string
+	if p.InsensitiveCase {
+		desc = append(desc, "case insensitive")
+	}
+	if p.Validate != "" {
+		desc = append(desc, "validate: "+p.Validate)
+	}
+	s.Description = strings.Join(desc, "; ")
+	s.Default = p.Default
+	s.Enum = p.Enum
+	if p.MinLen != nil {
+		s.MinItems = p.MinLen
+	}
+	if p.MaxLen != nil {
+		s.MaxItems = p.MaxLen
+	}
+
+	return s
+}
+
+// htypeSchema htypes类型到JSON schema的映射
+func htypeSchema(typ htypes.HType) *JSONSchema {
+	switch typ {
+	case htypes.HTypeBool:
+		return &JSONSchema{Type: "boolean"}
+	case htypes.HTypeString:
+		return &JSONSchema{Type: "string"}
+	case htypes.HTypeNumber:
+		return &JSONSchema{Type: "number"}
+	case htypes.HTypeBytes:
+		return &JSONSchema{Type: "string", Format: "byte"}
+	case htypes.HTypeDate:
+		return &JSONSchema{Type: "string", Format: "date"}
+	case htypes.HTypeDateTime:
+		return &JSONSchema{Type: "string", Pattern: `^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}$`}
+	case htypes.HTypeObject:
+		return &JSONSchema{Type: "object"}
+	case htypes.HTypeStringArray:
+		return arraySchema(htypes.HTypeString)
+	case htypes.HTypeNumberArray:
+		return arraySchema(htypes.HTypeNumber)
+	case htypes.HTypeBytesArray:
+		return arraySchema(htypes.HTypeBytes)
+	case htypes.HTypeDateArray:
+		return arraySchema(htypes.HTypeDate)
+	case htypes.HTypeDateTimeArray:
+		return arraySchema(htypes.HTypeDateTime)
+	case htypes.HTypeObjectArray:
+		return arraySchema(htypes.HTypeObject)
+	case htypes.HTypeNumberRange:
+		return rangeSchema(htypes.HTypeNumber)
+	case htypes.HTypeDateRange:
+		return rangeSchema(htypes.HTypeDate)
+	case htypes.HTypeDateTimeRange:
+		return rangeSchema(htypes.HTypeDateTime)
+	default:
+		return &JSONSchema{}
+	}
+}
+
+func arraySchema(elem htypes.HType) *JSONSchema {
+	return &JSONSchema{Type: "array", Items: htypeSchema(elem)}
+}
+
+// rangeSchema 边界类型，格式为[a,b]
+func rangeSchema(elem htypes.HType) *JSONSchema {
+	s := arraySchema(elem)
+	s.MinItems = intPtr(2)
+	s.MaxItems = intPtr(2)
+	return s
+}
+
+// goTypeSchema 未指定参数type时，根据字段类型生成schema
+func goTypeSchema(t reflect.Type) *JSONSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &JSONSchema{Type: "string", Format: "byte"}
+		}
+		return &JSONSchema{Type: "array", Items: goTypeSchema(t.Elem())}
+	case reflect.Map, reflect.Struct:
+		return &JSONSchema{Type: "object"}
+	default:
+		return &JSONSchema{}
+	}
+}
+
+// responseSchema 响应数据结构，与connector返回的{data, error}一致
+func responseSchema() *JSONSchema {
+	return &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"data": {},
+			"error": {
+				Type: "object",
+				Properties: map[string]*JSONSchema{
+					"code":        {Type: "integer"},
+					"desc":        {Type: "string"},
+					"fingerprint": {Type: "string"},
+					"cause":       {Type: "string"},
+				},
+			},
+		},
+	}
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
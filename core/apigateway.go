@@ -1,15 +1,20 @@
 package core
 
 import (
+	"context"
+	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/afex/hystrix-go/hystrix"
-	jsoniter "github.com/json-iterator/go"
+	"go.uber.org/atomic"
 
 	"github.com/drharryhe/has/common/hconf"
 	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/common/htrace"
 	"github.com/drharryhe/has/common/htypes"
-	"github.com/drharryhe/has/utils/hrandom"
 	"github.com/drharryhe/has/utils/hruntime"
 )
 
@@ -27,17 +32,24 @@ type APIGateway struct {
 	EntityConfBase
 
 	UseBreaker                    bool
-	BreakerLimitAPI               bool //针对API
-	BreakerLimitIP                bool //针对IP
-	BreakerLimitUser              bool //针对用户
-	BreakerRequestTimeout         int
-	BreakerMaxConcurrentRequest   int
-	BreakerRequestVolumeThreshold int
-	BreakerSleepWindow            int
-	BreakerErrorPercentThreshold  int
+	BreakerLimitAPI               bool //熔断器按API区分
+	BreakerLimitService           bool //熔断器按服务区分，同一服务的各接口共用熔断器。均未设置时全部接口共用一个熔断器
+	BreakerRequestTimeout         int  //熔断器超时(毫秒)，缺省1000
+	BreakerMaxConcurrentRequest   int  //每个熔断器同时处理的请求数上限，缺省10
+	BreakerRequestVolumeThreshold int  //统计窗口(10秒)内达到该请求数后才计算错误率，缺省20
+	BreakerSleepWindow            int  //熔断器打开后再次尝试的等待时间(毫秒)，缺省5000
+	BreakerErrorPercentThreshold  int  //打开熔断器的错误率(%)，缺省50
 	BreakerDashboard              bool
-	UserField                     string
+	RequestTimeout                int    //接口未设置timeout时的处理超时(毫秒)，0表示不限制
+	RateLimit                     int    //网关全局每秒处理的请求数，超出时立即拒绝，0表示不限制
+	RateBurst                     int    //全局允许的突发请求数，缺省等于RateLimit
+	RateLimitBy                   string //全局限流分别计数的维度，逗号分隔：api、user、ip、appkey，为空时网关整体计数
+	RateLimitPlugin               string //限流令牌桶存储插件Class，为空时使用本地内存，如RedisPlugin使各节点共享配额
+	UserField                     string //调用者对应的请求参数，用于按用户熔断、限流及校验异步任务的提交者
 	AddressField                  string
+	AppKeyField                   string //按appkey限流时取值的请求参数
+	ManageToken                   string `secret:"true"` //实体管理接口口令，为空时不提供管理接口
+	APIWatchInterval              int    //检查api.json修改的间隔(秒)，0表示不重新加载，需资源管理器支持IAssetWatcher
 }
 
 type APIGateWayImplement struct {
@@ -50,25 +62,36 @@ type APIGateWayImplement struct {
 	i18n        IAPIi18n
 	packers     map[string]IAPIDataPacker
 
-	apiSet map[string]map[string]*API
+	apiLock  sync.RWMutex
+	apiName  string                     //api.json中的名称，用作接口文档标题
+	apiSet   map[string]map[string]*API //重新加载时整体替换
+	stopAPIs func()                     //停止监视api.json
+	scopes   sync.Map                   //正在处理的请求，seq -> *RequestScope
+	inflight atomic.Int64
+	closing  atomic.Bool
 
-	conf           APIGateway             //Gateway配置
-	breakCmdConfig *hystrix.CommandConfig //熔断器设置
+	conf     APIGateway   //Gateway配置
+	settings atomic.Value //*gatewaySettings，请求处理中使用的设置
+}
+
+// gatewaySettings 请求处理中使用的配置副本及由其生成的熔断、限流设置。重新加载配置时先生成并校验新的设置，再整体替换
+type gatewaySettings struct {
+	conf      APIGateway
+	breaker   hystrix.CommandConfig
+	rateLimit *rateRule //全局限流
+	rateStore IRateLimitStore
 }
 
 func (this *APIGateWayImplement) init(opt *APIGatewayOptions, args ...htypes.Any) {
 	if opt == nil {
 		panic("failed to init APIGateWayImplement")
-		return
 	} else {
 		this.options = opt
 	}
 
-	this.server.init(&ServerOptions{
-		Router:        opt.Router,
-		Plugins:       opt.Plugins,
-		AssetsManager: opt.AssetsManager,
-	}, args)
+	this.server.entityConfs = this.optionConfs(opt)
+	this.server.checkAPIs = this.checkAPIs
+	this.server.init(&opt.ServerOptions, args)
 
 	this.class = hruntime.GetObjectName(this)
 	this.i18n = opt.I18n
@@ -106,37 +129,97 @@ func (this *APIGateWayImplement) init(opt *APIGatewayOptions, args ...htypes.Any
 	this.connectors = opt.Connectors
 
 	if this.i18n != nil {
-		if err := this.i18n.Open(); err != nil {
+		if err := this.i18n.Open(this); err != nil {
 			panic(err.D("failed to start APIGateWayImplement"))
 		}
 	}
 
 	this.loadAPIs()
 	hconf.Load(&this.conf)
+	settings, err := this.buildSettings(nil)
+	if err != nil {
+		panic(err.D("failed to init APIGateWayImplement"))
+	}
+	this.settings.Store(settings)
+	this.initBreaker()
+	this.server.beforeClose = this.drain
 
 	if err := this.router.RegisterEntity(this); err != nil {
 		panic(err.D("failed to init APIGateWayImplement"))
 	}
 }
 
-func (this *APIGateWayImplement) Start() {
-	this.server.Start()
+// optionConfs 网关及其middleware、packer、connector的配置，打开前自检
+func (this *APIGateWayImplement) optionConfs(opt *APIGatewayOptions) []IEntityConf {
+	confs := []IEntityConf{&this.conf}
+	var entities []interface{}
+	for _, m := range opt.Middlewares {
+		entities = append(entities, m)
+	}
+	for _, p := range opt.Packers {
+		entities = append(entities, p)
+	}
+	for _, c := range opt.Connectors {
+		entities = append(entities, c)
+	}
+	for _, e := range entities {
+		if e, ok := e.(IEntity); ok {
+			confs = append(confs, e.Config())
+		}
+	}
+	return confs
 }
 
-func (this *APIGateWayImplement) Shutdown() {
-	for _, c := range this.connectors {
-		c.Close()
+// SelfCheck 启动自检的结果，见ServerImplement.SelfCheck
+func (this *APIGateWayImplement) SelfCheck() *SelfCheckReport {
+	return this.server.SelfCheck()
+}
+
+func (this *APIGateWayImplement) Start() {
+	//仅输出各版本的API，不启动服务
+	if this.server.args.APITable {
+		this.runAndClose("print api tables", func() *herrors.Error {
+			return this.PrintAPITables(os.Stdout)
+		})
+		return
 	}
-	for _, m := range this.middlewares {
-		m.Close()
+
+	//仅导出接口文档，不启动服务
+	if dir := this.server.args.APIDoc; dir != "" {
+		this.runAndClose("export api documents", func() *herrors.Error {
+			this.server.openServices()
+			return this.ExportAPIDocuments(dir)
+		})
+		return
 	}
-	for _, p := range this.packers {
-		p.Close()
+
+	this.watchAPIs()
+	this.server.Start()
+}
+
+// runAndClose 执行命令行指定的一次性操作，结束(包括失败)后关闭已打开的连接器、服务、插件及路由
+func (this *APIGateWayImplement) runAndClose(what string, run func() *herrors.Error) {
+	defer this.server.close()
+	if err := run(); err != nil {
+		panic(err.D("failed to %s", what))
 	}
+}
 
+func (this *APIGateWayImplement) Shutdown() {
 	this.server.Shutdown()
 }
 
+// Open 打开已注册的服务，不启动退出等待，用于测试或嵌入其他程序。结束时调用Close
+func (this *APIGateWayImplement) Open() {
+	this.watchAPIs()
+	this.server.Open()
+}
+
+// Close 停止接收请求并关闭服务
+func (this *APIGateWayImplement) Close() {
+	this.server.Close()
+}
+
 func (this *APIGateWayImplement) Router() IRouter {
 	return this.router
 }
@@ -157,75 +240,68 @@ func (this *APIGateWayImplement) I18n() IAPIi18n {
 	return this.i18n
 }
 
-func (this *APIGateWayImplement) PreRequestMiddleware(version, api string, params htypes.Map) (err *herrors.Error) {
-	for _, m := range this.middlewares {
-		if m.Type() == MiddlewareTypeIn || m.Type() == MiddlewareTypeInOut {
-			stop, err := m.HandleIn(0, version, api, params)
-			if err != nil {
-				return err
-			}
-			if stop {
-				break
-			}
-		}
+func (this *APIGateWayImplement) PreRequestMiddleware(version, api string, params htypes.Map) *herrors.Error {
+	def, _ := this.lookupAPI(version, api)
+	_, scope := this.beginRequest(context.Background(), version, def)
+	defer this.endRequest(scope)
+	if def == nil {
+		scope.API = api
+	} else {
+		api = def.Name //别名按原接口处理
 	}
-	return
+
+	return this.handleIn(scope.Seq, version, api, params)
+}
+
+func (this *APIGateWayImplement) RequestScope(seq uint64) *RequestScope {
+	if v, ok := this.scopes.Load(seq); ok {
+		return v.(*RequestScope)
+	}
+	return nil
 }
 
 func (this *APIGateWayImplement) RequestAPI(version string, api string, params htypes.Map) (ret htypes.Any, err *herrors.Error) {
-	a := this.apiSet[version]
-	if a == nil {
-		return nil, herrors.ErrCallerInvalidRequest.New("api version [%s] not supported", version)
+	return this.RequestAPIContext(context.Background(), version, api, params)
+}
+
+func (this *APIGateWayImplement) RequestAPIContext(ctx context.Context, version string, api string, params htypes.Map) (ret htypes.Any, err *herrors.Error) {
+	v, err := this.lookupAPI(version, api)
+	if err != nil {
+		return nil, err
 	}
+	api = v.Name //别名按原接口处理
+	done := observe(apiRequests, apiErrors, apiDuration, apiInflight, version, api)
+	defer func() { done(err) }()
+	ctx, end := startSpan(ctx, "api "+version+"/"+api, htrace.KindInternal)
+	defer func() { end(err) }()
 
-	v := a[api]
-	if v == nil {
-		return nil, herrors.ErrCallerInvalidRequest.New("api  [%s] not supported", api)
+	ctx, scope := this.beginRequest(ctx, version, v)
+	defer this.endRequest(scope)
+
+	if this.closing.Load() {
+		return nil, herrors.ErrSysBusy.New("server is shutting down")
 	}
-	if v.Disabled {
-		return nil, herrors.ErrCallerInvalidRequest.New("api [%s] disabled", api)
+	if err = v.checkSunset(); err != nil {
+		return nil, err
 	}
 
-	if err = this.PreRequestMiddleware(version, api, params); err != nil {
+	if err = this.handleIn(scope.Seq, version, api, params); err != nil {
 		return nil, err
 	}
-	//for _, m := range this.middlewares {
-	//	if m.Type() == MiddlewareTypeIn || m.Type() == MiddlewareTypeInOut {
-	//		stop, err := m.HandleIn(0, version, api, params)
-	//		if err != nil {
-	//			return nil, err
-	//		}
-	//		if stop {
-	//			break
-	//		}
-	//	}
-	//}
-
-	//加入熔断控制
-	if this.conf.UseBreaker {
-		cmd := this.cmdName(v.Name, params)
-
-		if hystrix.GetCircuitSettings()[cmd] == nil {
-			hystrix.ConfigureCommand(cmd, *this.breakCmdConfig)
-		}
 
-		breakerErr := hystrix.Do(cmd, func() error {
-			ret, err = this.server.RequestService(v.EndPoint.Service, v.EndPoint.Slot, params)
-			return nil
-		}, func(e error) error {
-			return herrors.ErrSysBusy.New(e.Error())
-		})
-
-		if breakerErr != nil {
-			return nil, herrors.ErrSysInternal.New(breakerErr.Error())
-		}
-	} else {
-		ret, err = this.server.RequestService(v.EndPoint.Service, v.EndPoint.Slot, params)
+	if err = this.checkRateLimit(v, params); err != nil {
+		return nil, err
 	}
+	ret, err = this.callEndpoint(ctx, v, params)
 
 	for _, m := range this.middlewares {
+		if !this.useMiddleware(m, scope) {
+			continue
+		}
 		if m.Type() == MiddlewareTypeOut || m.Type() == MiddlewareTypeInOut {
-			stop, err := m.HandleOut(0, version, api, ret, err)
+			_, end := startSpan(scope.Context(), "middleware out "+m.(IEntity).Class(), htrace.KindInternal)
+			stop, err := m.HandleOut(scope.Seq, version, api, ret, err)
+			end(err)
 			if err != nil {
 				return nil, err
 			}
@@ -239,58 +315,104 @@ func (this *APIGateWayImplement) RequestAPI(version string, api string, params h
 }
 
 func (this *APIGateWayImplement) RequestWSAPI(version, api string, params htypes.Map) (ret htypes.Any, err *herrors.Error) {
-	a := this.apiSet[version]
-	if a == nil {
-		return nil, herrors.ErrCallerInvalidRequest.New("api version [%s] not supported", version)
+	return this.RequestWSAPIContext(context.Background(), version, api, params)
+}
+
+func (this *APIGateWayImplement) RequestWSAPIContext(ctx context.Context, version, api string, params htypes.Map) (ret htypes.Any, err *herrors.Error) {
+	v, err := this.lookupAPI(version, api)
+	if err != nil {
+		return nil, err
 	}
+	api = v.Name //别名按原接口处理
+	done := observe(apiRequests, apiErrors, apiDuration, apiInflight, version, api)
+	defer func() { done(err) }()
+	ctx, end := startSpan(ctx, "api "+version+"/"+api, htrace.KindInternal)
+	defer func() { end(err) }()
 
-	v := a[api]
-	if v == nil {
-		return nil, herrors.ErrCallerInvalidRequest.New("api  [%s] not supported", api)
+	ctx, scope := this.beginRequest(ctx, version, v)
+	defer this.endRequest(scope)
+
+	if this.closing.Load() {
+		return nil, herrors.ErrSysBusy.New("server is shutting down")
 	}
-	if v.Disabled {
-		return nil, herrors.ErrCallerInvalidRequest.New("api [%s] disabled", api)
+	if err = v.checkSunset(); err != nil {
+		return nil, err
 	}
 
-	if err = this.PreRequestMiddleware(version, api, params); err != nil {
+	if err = this.handleIn(scope.Seq, version, api, params); err != nil {
 		return nil, err
 	}
-	//for _, m := range this.middlewares {
-	//	if m.Type() == MiddlewareTypeIn || m.Type() == MiddlewareTypeInOut {
-	//		stop, err := m.HandleIn(0, version, api, params)
-	//		if err != nil {
-	//			return nil, err
-	//		}
-	//		if stop {
-	//			break
-	//		}
-	//	}
-	//}
-
-	//加入熔断控制
-	if this.conf.UseBreaker {
-		cmd := this.cmdName(v.Name, params)
-
-		if hystrix.GetCircuitSettings()[cmd] == nil {
-			hystrix.ConfigureCommand(cmd, *this.breakCmdConfig)
-		}
-
-		breakerErr := hystrix.Do(cmd, func() error {
-			ret, err = this.server.RequestService(v.EndPoint.Service, v.EndPoint.Slot, params)
-			return nil
-		}, func(e error) error {
-			return herrors.ErrSysBusy.New(e.Error())
-		})
 
-		if breakerErr != nil {
-			return nil, herrors.ErrSysInternal.New(breakerErr.Error())
-		}
-	} else {
-		ret, err = this.server.RequestService(v.EndPoint.Service, v.EndPoint.Slot, params)
+	if err = this.checkRateLimit(v, params); err != nil {
+		return nil, err
 	}
+	ret, err = this.callEndpoint(ctx, v, params)
 	return
 }
 
+// RequestAPIAsync 异步请求API，in middleware处理后立即返回任务句柄。out middleware不参与异步请求
+func (this *APIGateWayImplement) RequestAPIAsync(ctx context.Context, version string, api string, params htypes.Map) (job *JobHandle, err *herrors.Error) {
+	v, err := this.lookupAPI(version, api)
+	if err != nil {
+		return nil, err
+	}
+	api = v.Name //别名按原接口处理
+	done := observe(apiRequests, apiErrors, apiDuration, apiInflight, version, api)
+	defer func() { done(err) }()
+	ctx, end := startSpan(ctx, "api "+version+"/"+api, htrace.KindInternal)
+	defer func() { end(err) }()
+
+	ctx, scope := this.beginRequest(ctx, version, v)
+	defer this.endRequest(scope)
+
+	if this.closing.Load() {
+		return nil, herrors.ErrSysBusy.New("server is shutting down")
+	}
+	if err = v.checkSunset(); err != nil {
+		return nil, err
+	}
+
+	if err = this.handleIn(scope.Seq, version, api, params); err != nil {
+		return nil, err
+	}
+
+	if err = this.checkRateLimit(v, params); err != nil {
+		return nil, err
+	}
+	scope.jobOwner, _ = params[this.currentSettings().conf.UserField].(string)
+	return this.server.RequestServiceAsync(ctx, v.EndPoint.Service, v.EndPoint.Slot, params)
+}
+
+// Job 查询经网关提交的异步任务。params为查询请求的参数，按提交任务的接口执行in middleware(如会话校验)，
+// 并且只有提交者(UserField参数一致)可以查询，其他调用方得到与任务不存在相同的错误。仅经网关提交的任务可以查询
+func (this *APIGateWayImplement) Job(ctx context.Context, id string, params htypes.Map) (job *Job, err *herrors.Error) {
+	if job, err = this.server.Job(id); err != nil {
+		return nil, err
+	}
+	if job.API == "" {
+		return nil, herrors.ErrCallerInvalidRequest.New("job [%s] not found", id)
+	}
+
+	v, err := this.lookupAPI(job.Version, job.API)
+	if err != nil {
+		return nil, err
+	}
+	_, scope := this.beginRequest(ctx, job.Version, v)
+	defer this.endRequest(scope)
+
+	if this.closing.Load() {
+		return nil, herrors.ErrSysBusy.New("server is shutting down")
+	}
+	if err = this.handleIn(scope.Seq, job.Version, job.API, params); err != nil {
+		return nil, err
+	}
+
+	if owner, _ := params[this.currentSettings().conf.UserField].(string); owner != job.Owner {
+		return nil, herrors.ErrCallerInvalidRequest.New("job [%s] not found", id)
+	}
+	return job, nil
+}
+
 func (this *APIGateWayImplement) Class() string {
 	return this.class
 }
@@ -304,10 +426,7 @@ func (this *APIGateWayImplement) Config() IEntityConf {
 }
 
 func (this *APIGateWayImplement) EntityMeta() *EntityMeta {
-	if this.conf.EID == "" {
-		this.conf.EID = hrandom.UuidWithoutDash()
-		hconf.Save()
-	}
+	ensureEID(&this.conf)
 
 	return &EntityMeta{
 		ServerEID: this.Server().(IEntity).EntityMeta().EID,
@@ -324,74 +443,191 @@ func (this *APIGateWayImplement) EntityStub() *EntityStub {
 		})
 }
 
-func (this *APIGateWayImplement) loadAPIs() {
-	this.apiSet = make(map[string]map[string]*API)
+func (this *APIGateWayImplement) lookupAPI(version string, api string) (*API, *herrors.Error) {
+	a := this.versionAPIs(version)
+	if a == nil {
+		return nil, herrors.ErrCallerInvalidRequest.New("api version [%s] not supported", version)
+	}
 
-	file, err := this.server.Assets().File(apiFileName)
-	if err != nil {
-		panic(err.D("failed to load APIs"))
+	v := a[api]
+	if v == nil {
+		return nil, herrors.ErrCallerInvalidRequest.New("api  [%s] not supported", api)
 	}
+	if v.Disabled {
+		return nil, herrors.ErrCallerInvalidRequest.New("api [%s] disabled", api)
+	}
+	return v, nil
+}
+
+// beginRequest def为请求的接口定义，未知时为nil
+func (this *APIGateWayImplement) beginRequest(ctx context.Context, version string, def *API) (context.Context, *RequestScope) {
+	scope := ScopeFromContext(ctx)
+	if scope == nil {
+		scope = NewRequestScope("")
+		ctx = ContextWithScope(ctx, scope)
+	} else if scope.Seq != 0 {
+		//嵌套调用API时，沿用请求ID，使用新的作用域
+		scope = NewRequestScope(scope.ID)
+		ctx = ContextWithScope(ctx, scope)
+	}
+	scope.ctx = ctx //包含网关创建的Span，middleware经scope调用服务时延续trace
+	scope.Seq = this.server.newRequestNo()
+	scope.Version = version
+	scope.Definition = def
+	if def != nil {
+		scope.API = def.Name
+	}
+	this.scopes.Store(scope.Seq, scope)
+	this.inflight.Inc()
+
+	return ctx, scope
+}
+
+func (this *APIGateWayImplement) endRequest(scope *RequestScope) {
+	this.scopes.Delete(scope.Seq)
+	this.inflight.Dec()
+}
 
-	var apiDef APIDefine
-	if err := jsoniter.Unmarshal(file, &apiDef); err != nil {
-		panic(herrors.ErrSysInternal.New(err.Error()).D("failed to unmarshal %s", apiFileName))
+// drain 停止接收新请求，并在timeout内等待处理中的请求完成
+func (this *APIGateWayImplement) drain(timeout time.Duration, report *shutdownReport) {
+	this.closing.Store(true)
+	if this.stopAPIs != nil {
+		this.stopAPIs()
+	}
+	for _, c := range this.connectors {
+		c.Close()
+		report.add("connector [%s] stopped accepting requests", c.Name())
+	}
+
+	if waitUntil(timeout, func() bool { return this.inflight.Load() == 0 }) {
+		report.add("in-flight requests drained")
+	} else {
+		report.add("%d in-flight requests abandoned after %v", this.inflight.Load(), timeout)
 	}
 
-	for _, openAPI := range apiDef.APIVersions {
-		if this.apiSet[openAPI.Version] == nil {
-			this.apiSet[openAPI.Version] = make(map[string]*API)
+	for _, m := range this.middlewares {
+		m.Close()
+	}
+	for _, p := range this.packers {
+		p.Close()
+	}
+	report.add("middlewares and packers closed")
+}
+
+func (this *APIGateWayImplement) handleIn(seq uint64, version string, api string, params htypes.Map) *herrors.Error {
+	scope := this.RequestScope(seq)
+	for _, m := range this.middlewares {
+		if !this.useMiddleware(m, scope) {
+			continue
 		}
-		for i, a := range openAPI.APIs {
-			this.apiSet[openAPI.Version][a.Name] = &openAPI.APIs[i]
+		if m.Type() == MiddlewareTypeIn || m.Type() == MiddlewareTypeInOut {
+			_, end := startSpan(scope.Context(), "middleware in "+m.(IEntity).Class(), htrace.KindInternal)
+			stop, err := m.HandleIn(seq, version, api, params)
+			end(err)
+			if err != nil {
+				return err
+			}
+			if stop {
+				break
+			}
 		}
 	}
+	return nil
+}
+
+func (this *APIGateWayImplement) loadAPIs() {
+	if err := this.ReloadAPIs(); err != nil {
+		panic(err.D("failed to load APIs"))
+	}
 }
 
 func (this *APIGateWayImplement) initBreaker() {
-	if this.conf.BreakerRequestTimeout <= 0 {
-		this.conf.BreakerRequestTimeout = defaultRequestTimeout
+	if this.conf.BreakerDashboard {
+		hystrixStreamHandler := hystrix.NewStreamHandler()
+		hystrixStreamHandler.Start()
 	}
-	if this.conf.BreakerMaxConcurrentRequest <= 0 {
-		this.conf.BreakerMaxConcurrentRequest = defaultMaxConcurrentRequests
+
+	return
+}
+
+func breakerConfig(conf *APIGateway) hystrix.CommandConfig {
+	if conf.BreakerRequestTimeout <= 0 {
+		conf.BreakerRequestTimeout = defaultRequestTimeout
 	}
-	if this.conf.BreakerRequestVolumeThreshold <= 0 {
-		this.conf.BreakerRequestVolumeThreshold = defaultRequestVolumeThreshold
+	if conf.BreakerMaxConcurrentRequest <= 0 {
+		conf.BreakerMaxConcurrentRequest = defaultMaxConcurrentRequests
 	}
-	if this.conf.BreakerSleepWindow <= 0 {
-		this.conf.BreakerSleepWindow = defaultSleepWindow
+	if conf.BreakerRequestVolumeThreshold <= 0 {
+		conf.BreakerRequestVolumeThreshold = defaultRequestVolumeThreshold
 	}
-	if this.conf.BreakerErrorPercentThreshold <= 0 {
-		this.conf.BreakerErrorPercentThreshold = defaultErrorPercentThreshold
+	if conf.BreakerSleepWindow <= 0 {
+		conf.BreakerSleepWindow = defaultSleepWindow
+	}
+	if conf.BreakerErrorPercentThreshold <= 0 {
+		conf.BreakerErrorPercentThreshold = defaultErrorPercentThreshold
 	}
 
-	this.breakCmdConfig = &hystrix.CommandConfig{
-		Timeout:                this.conf.BreakerRequestTimeout,
-		MaxConcurrentRequests:  this.conf.BreakerMaxConcurrentRequest,
-		RequestVolumeThreshold: this.conf.BreakerRequestVolumeThreshold,
-		SleepWindow:            this.conf.BreakerSleepWindow,
-		ErrorPercentThreshold:  this.conf.BreakerErrorPercentThreshold,
+	return hystrix.CommandConfig{
+		Timeout:                conf.BreakerRequestTimeout,
+		MaxConcurrentRequests:  conf.BreakerMaxConcurrentRequest,
+		RequestVolumeThreshold: conf.BreakerRequestVolumeThreshold,
+		SleepWindow:            conf.BreakerSleepWindow,
+		ErrorPercentThreshold:  conf.BreakerErrorPercentThreshold,
 	}
+}
 
-	if this.conf.BreakerDashboard {
-		hystrixStreamHandler := hystrix.NewStreamHandler()
-		hystrixStreamHandler.Start()
+// buildSettings 按当前配置生成新的设置，不影响正在使用的设置。限流配置未变化时沿用prev的令牌桶
+func (this *APIGateWayImplement) buildSettings(prev *gatewaySettings) (*gatewaySettings, *herrors.Error) {
+	s := &gatewaySettings{conf: this.conf}
+	s.breaker = breakerConfig(&s.conf)
+
+	if prev != nil && prev.conf.RateLimit == s.conf.RateLimit && prev.conf.RateBurst == s.conf.RateBurst &&
+		prev.conf.RateLimitBy == s.conf.RateLimitBy && prev.conf.RateLimitPlugin == s.conf.RateLimitPlugin {
+		s.rateLimit, s.rateStore = prev.rateLimit, prev.rateStore
+		return s, nil
 	}
 
-	return
+	var err *herrors.Error
+	if s.rateLimit, s.rateStore, err = this.buildRateLimit(&s.conf); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
-func (this *APIGateWayImplement) cmdName(api string, data htypes.Map) string {
-	var ps []string
-	if this.conf.BreakerLimitAPI {
-		ps = append(ps, api)
+func (this *APIGateWayImplement) currentSettings() *gatewaySettings {
+	return this.settings.Load().(*gatewaySettings)
+}
+
+// OnConfigReload 熔断器及全局限流设置校验通过后整体替换，已创建的熔断器同时更新
+func (this *APIGateWayImplement) OnConfigReload(old IEntityConf) *herrors.Error {
+	o := old.(*APIGateway)
+	if o.BreakerDashboard != this.conf.BreakerDashboard {
+		return herrors.ErrCallerInvalidRequest.New("BreakerDashboard can not be changed at runtime")
+	}
+
+	settings, err := this.buildSettings(this.currentSettings())
+	if err != nil {
+		return err
 	}
-	if this.conf.BreakerLimitIP {
-		ip, _ := data[this.conf.AddressField].(string)
-		ps = append(ps, ip)
+	if o.APIWatchInterval != this.conf.APIWatchInterval {
+		hlogger.Warn("APIWatchInterval takes effect after restart")
+	}
+
+	for cmd := range hystrix.GetCircuitSettings() {
+		hystrix.ConfigureCommand(cmd, settings.breaker)
+	}
+	this.settings.Store(settings)
+	return nil
+}
+
+// cmdName 熔断器名称，按API或服务区分，不按调用方区分，避免熔断器数量随调用方增长
+func (this *APIGateWayImplement) cmdName(conf *APIGateway, v *API) string {
+	var ps []string
+	if conf.BreakerLimitAPI {
+		ps = append(ps, v.Name)
 	}
-	if this.conf.BreakerLimitUser {
-		user, _ := data[this.conf.UserField].(string)
-		ps = append(ps, user)
+	if conf.BreakerLimitService {
+		ps = append(ps, v.EndPoint.Service)
 	}
 	if len(ps) == 0 {
 		return "default"
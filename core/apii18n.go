@@ -1,8 +1,6 @@
 package core
 
 import (
-	"fmt"
-	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -10,7 +8,6 @@ import (
 	jsoniter "github.com/json-iterator/go"
 
 	"github.com/drharryhe/has/common/herrors"
-	"github.com/drharryhe/has/utils/hio"
 	"github.com/drharryhe/has/utils/hruntime"
 )
 
@@ -23,8 +20,8 @@ type BaseAPIi18n struct {
 	class string
 }
 
-func (this *BaseAPIi18n) Open() bool {
-	return true
+func (this *BaseAPIi18n) Open(_ IAPIGateway) *herrors.Error {
+	return nil
 }
 
 func (this *BaseAPIi18n) Close() {
@@ -40,37 +37,27 @@ func (this *DefaultAPIi18n) Class() string {
 	return this.class
 }
 
-func (this *DefaultAPIi18n) Open() *herrors.Error {
+// Open 读取资源中LangDir目录下的语言文件，文件名(不含扩展名)为语言，如cn-zh.json
+func (this *DefaultAPIi18n) Open(gw IAPIGateway) *herrors.Error {
 	this.dirs = make(map[string]map[string]string)
 
-	err := filepath.Walk(LangDir, func(p string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		ext := path.Ext(info.Name())
-		if strings.ToLower(ext) != ".json" {
-			return nil
-		}
-
-		ss := strings.Split(info.Name(), ".")
-		v := make(map[string]string)
-		bs, err := hio.ReadFile(fmt.Sprintf("%s%c%s", LangDir, os.PathSeparator, info.Name()))
+	files, err := gw.Server().Assets().Files(LangDir, ".json")
+	if err != nil {
+		return err.D("failed to list language files")
+	}
+	for _, f := range files {
+		bs, err := gw.Server().Assets().File(f)
 		if err != nil {
-			return herrors.ErrSysInternal.New(err.Error())
+			return err
 		}
 
-		err = jsoniter.Unmarshal(bs, &v)
-		if err != nil {
-			return herrors.ErrSysInternal.New(err.Error())
+		v := make(map[string]string)
+		if err := jsoniter.Unmarshal(bs, &v); err != nil {
+			return herrors.ErrSysInternal.New(err.Error()).D("failed to unmarshal %s", f)
 		}
 
-		this.dirs[ss[0]] = v
-
-		return nil
-	})
-
-	if err != nil {
-		return herrors.ErrSysInternal.New(err.Error())
+		name := path.Base(filepath.ToSlash(f))
+		this.dirs[strings.TrimSuffix(name, path.Ext(name))] = v
 	}
 
 	this.class = hruntime.GetObjectName(this)
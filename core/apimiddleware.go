@@ -4,7 +4,6 @@ import (
 	"github.com/drharryhe/has/common/hconf"
 	"github.com/drharryhe/has/common/herrors"
 	"github.com/drharryhe/has/common/htypes"
-	"github.com/drharryhe/has/utils/hrandom"
 	"github.com/drharryhe/has/utils/hruntime"
 )
 
@@ -35,15 +34,16 @@ func (this *BaseMiddleware) Server() IServer {
 	return this.server
 }
 
+func (this *BaseMiddleware) OnConfigReload(_ IEntityConf) *herrors.Error {
+	return nil
+}
+
 func (this *BaseMiddleware) Class() string {
 	return this.class
 }
 
 func (this *BaseMiddleware) EntityMeta() *EntityMeta {
-	if this.instance.(IEntity).Config().GetEID() == "" {
-		this.instance.(IEntity).Config().SetEID(hrandom.UuidWithoutDash())
-		hconf.Save()
-	}
+	ensureEID(this.instance.(IEntity).Config())
 
 	return &EntityMeta{
 		ServerEID: this.Server().(IEntity).Config().GetEID(),
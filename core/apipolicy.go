@@ -0,0 +1,106 @@
+package core
+
+import (
+	"strings"
+	"time"
+
+	"github.com/drharryhe/has/common/herrors"
+)
+
+//api.json中为每个接口声明的处理策略：别名、超时、限流、执行的middleware以及弃用和停用日期。
+//重试、并发上限及降级见resilience.go
+
+const (
+	apiDateLayout = "2006-01-02"
+)
+
+func parseAPIDate(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation(apiDateLayout, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// DeprecatedAt 弃用时间，未弃用时返回零值
+func (this *API) DeprecatedAt() time.Time {
+	return this.deprecatedAt
+}
+
+// SunsetAt 停用时间，未设置时返回零值
+func (this *API) SunsetAt() time.Time {
+	return this.sunsetAt
+}
+
+// initPolicy 检查并初始化接口策略，middlewares为网关已注册的middleware(Class -> true)
+func (this *API) initPolicy(middlewares map[string]bool) *herrors.Error {
+	var err error
+	if this.Deprecated != "" {
+		if this.deprecatedAt, err = parseAPIDate(this.Deprecated); err != nil {
+			return herrors.ErrSysInternal.New("invalid deprecated date [%s] of api [%s]", this.Deprecated, this.Name)
+		}
+	}
+	if this.Sunset != "" {
+		if this.sunsetAt, err = parseAPIDate(this.Sunset); err != nil {
+			return herrors.ErrSysInternal.New("invalid sunset date [%s] of api [%s]", this.Sunset, this.Name)
+		}
+	}
+
+	if len(this.Middlewares) > 0 && len(this.SkipMiddlewares) > 0 {
+		return herrors.ErrSysInternal.New("middlewares and skipMiddlewares of api [%s] can not be both set", this.Name)
+	}
+	names := this.Middlewares
+	if len(names) == 0 {
+		names = this.SkipMiddlewares
+	}
+	if len(names) > 0 {
+		this.middlewares = make(map[string]bool)
+		for _, n := range names {
+			if !middlewares[n] {
+				return herrors.ErrSysInternal.New("middleware [%s] of api [%s] not found", n, this.Name)
+			}
+			this.middlewares[n] = true
+		}
+	}
+
+	if this.RateLimit > 0 {
+		var by []string
+		for _, b := range this.RateLimitBy {
+			by = append(by, strings.ToLower(b))
+		}
+		if this.rateLimit, err = newRateRule(float64(this.RateLimit), this.RateBurst, by); err != nil {
+			return herrors.ErrSysInternal.New("api [%s] %v", this.Name, err)
+		}
+	}
+	if err := this.initResilience(); err != nil {
+		return err
+	}
+	this.initialized = true
+	return nil
+}
+
+// useMiddleware 该接口是否执行指定的middleware
+func (this *API) useMiddleware(class string) bool {
+	if this == nil || this.middlewares == nil {
+		return true
+	}
+	if len(this.Middlewares) > 0 {
+		return this.middlewares[class]
+	}
+	return !this.middlewares[class]
+}
+
+// checkSunset 已过停用日期时拒绝请求
+func (this *API) checkSunset() *herrors.Error {
+	if !this.sunsetAt.IsZero() && !time.Now().Before(this.sunsetAt) {
+		return herrors.ErrCallerInvalidRequest.New("api [%s] sunset at %s", this.Name, this.Sunset)
+	}
+	return nil
+}
+
+// useMiddleware middleware未停用且接口策略允许时执行
+func (this *APIGateWayImplement) useMiddleware(m IAPIMiddleware, scope *RequestScope) bool {
+	if EntityDisabled(m) {
+		return false
+	}
+	return scope == nil || scope.Definition.useMiddleware(m.(IEntity).Class())
+}
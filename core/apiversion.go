@@ -0,0 +1,185 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/drharryhe/has/common/herrors"
+)
+
+//API版本继承。api.json中的版本可声明extends继承另一版本的全部接口，同名接口覆盖所继承的接口，
+//removes中列出的接口不再继承。APITables输出各版本生效的接口及与上一版本相比的变化
+
+const (
+	APIAdded     = "added"
+	APIChanged   = "changed"
+	APIRemoved   = "removed"
+	APIUnchanged = "unchanged"
+)
+
+// APIChange 版本中的一个接口及其相对上一版本的变化
+type APIChange struct {
+	Name   string
+	Status string
+	API    *API //Status为APIRemoved时为上一版本中的接口
+}
+
+// APIVersionTable 版本生效的接口。Base为继承的版本，未继承时为api.json中的前一个版本
+type APIVersionTable struct {
+	Version string
+	Base    string
+	Changes []APIChange //按名称排序
+}
+
+// resolveAPIVersions 按继承关系计算各版本生效的接口(接口名 -> 接口，不含别名)
+func resolveAPIVersions(def *APIDefine) (map[string]map[string]*API, *herrors.Error) {
+	declared := make(map[string]*OpenAPI)
+	for i := range def.APIVersions {
+		v := &def.APIVersions[i]
+		if declared[v.Version] != nil {
+			return nil, herrors.ErrSysInternal.New("api version [%s] duplicated", v.Version)
+		}
+		declared[v.Version] = v
+	}
+
+	resolved := make(map[string]map[string]*API)
+	resolving := make(map[string]bool)
+	var resolve func(version string) (map[string]*API, *herrors.Error)
+	resolve = func(version string) (map[string]*API, *herrors.Error) {
+		if apis := resolved[version]; apis != nil {
+			return apis, nil
+		}
+		v := declared[version]
+		if v == nil {
+			return nil, herrors.ErrSysInternal.New("api version [%s] not found", version)
+		}
+		if resolving[version] {
+			return nil, herrors.ErrSysInternal.New("inheritance of api version [%s] is circular", version)
+		}
+		resolving[version] = true
+
+		apis := make(map[string]*API)
+		if v.Extends != "" {
+			base, err := resolve(v.Extends)
+			if err != nil {
+				return nil, err
+			}
+			for name, a := range base {
+				apis[name] = a
+			}
+		}
+		for _, name := range v.Removes {
+			if apis[name] == nil {
+				return nil, herrors.ErrSysInternal.New("api [%s] removed by version [%s] not inherited", name, version)
+			}
+			delete(apis, name)
+		}
+
+		own := make(map[string]bool)
+		for i := range v.APIs {
+			a := &v.APIs[i]
+			if own[a.Name] {
+				return nil, herrors.ErrSysInternal.New("api [%s] of version [%s] duplicated", a.Name, version)
+			}
+			own[a.Name] = true
+			apis[a.Name] = a
+		}
+
+		resolved[version] = apis
+		return apis, nil
+	}
+
+	for _, v := range def.APIVersions {
+		if _, err := resolve(v.Version); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
+// APITables 各版本生效的接口，按api.json中的版本顺序
+func APITables(def *APIDefine) ([]*APIVersionTable, *herrors.Error) {
+	resolved, err := resolveAPIVersions(def)
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []*APIVersionTable
+	for i, v := range def.APIVersions {
+		table := &APIVersionTable{Version: v.Version, Base: v.Extends}
+		if table.Base == "" && i > 0 {
+			table.Base = def.APIVersions[i-1].Version
+		}
+		apis, base := resolved[v.Version], resolved[table.Base]
+
+		for name, a := range apis {
+			change := APIChange{Name: name, Status: APIUnchanged, API: a}
+			if old := base[name]; old == nil {
+				change.Status = APIAdded
+			} else if old != a && !sameAPI(old, a) {
+				change.Status = APIChanged
+			}
+			table.Changes = append(table.Changes, change)
+		}
+		for name, a := range base {
+			if apis[name] == nil {
+				table.Changes = append(table.Changes, APIChange{Name: name, Status: APIRemoved, API: a})
+			}
+		}
+		sort.Slice(table.Changes, func(i, j int) bool {
+			return table.Changes[i].Name < table.Changes[j].Name
+		})
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// sameAPI 比较api.json中声明的内容
+func sameAPI(a, b *API) bool {
+	x, _ := jsoniter.Marshal(a)
+	y, _ := jsoniter.Marshal(b)
+	return string(x) == string(y)
+}
+
+// WriteAPITables 以文本表格输出各版本的接口，removed的接口不再可用
+func WriteAPITables(w io.Writer, tables []*APIVersionTable) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, t := range tables {
+		title := t.Version
+		if t.Base != "" {
+			title = fmt.Sprintf("%s (base %s)", t.Version, t.Base)
+		}
+		if _, err := fmt.Fprintf(tw, "%s\n", title); err != nil {
+			return err
+		}
+		for _, c := range t.Changes {
+			if _, err := fmt.Fprintf(tw, "\t%s\t%s.%s\t%s\n", c.Name, c.API.EndPoint.Service, c.API.EndPoint.Slot, c.Status); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(tw); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// PrintAPITables 输出api.json中各版本生效的接口
+func (this *APIGateWayImplement) PrintAPITables(w io.Writer) *herrors.Error {
+	def, err := this.readAPIDefine()
+	if err != nil {
+		return err
+	}
+	tables, err := APITables(def)
+	if err != nil {
+		return err
+	}
+	if err := WriteAPITables(w, tables); err != nil {
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+	return nil
+}
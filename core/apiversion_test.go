@@ -0,0 +1,178 @@
+package core
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func apiOf(name string, slot string) API {
+	return API{Name: name, EndPoint: EndPoint{Service: "svc", Slot: slot}}
+}
+
+func apiNames(apis map[string]*API) string {
+	var names []string
+	for name, a := range apis {
+		names = append(names, name+"="+a.EndPoint.Slot)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestResolveAPIVersions(t *testing.T) {
+	cases := []struct {
+		name     string
+		versions []OpenAPI
+		apis     map[string]string //版本 -> 生效的接口
+		err      string
+	}{
+		{
+			name: "independent versions",
+			versions: []OpenAPI{
+				{Version: "v1", APIs: []API{apiOf("a", "A")}},
+				{Version: "v2", APIs: []API{apiOf("b", "B")}},
+			},
+			apis: map[string]string{"v1": "a=A", "v2": "b=B"},
+		},
+		{
+			name: "extends, overrides and removes",
+			versions: []OpenAPI{
+				{Version: "v1", APIs: []API{apiOf("a", "A"), apiOf("b", "B"), apiOf("c", "C")}},
+				{Version: "v2", Extends: "v1", Removes: []string{"c"}, APIs: []API{apiOf("b", "B2"), apiOf("d", "D")}},
+				{Version: "v3", Extends: "v2"},
+			},
+			apis: map[string]string{"v1": "a=A,b=B,c=C", "v2": "a=A,b=B2,d=D", "v3": "a=A,b=B2,d=D"},
+		},
+		{
+			name: "extends later version",
+			versions: []OpenAPI{
+				{Version: "v2", Extends: "v1", APIs: []API{apiOf("b", "B")}},
+				{Version: "v1", APIs: []API{apiOf("a", "A")}},
+			},
+			apis: map[string]string{"v1": "a=A", "v2": "a=A,b=B"},
+		},
+		{
+			name:     "duplicated version",
+			versions: []OpenAPI{{Version: "v1"}, {Version: "v1"}},
+			err:      "api version [v1] duplicated",
+		},
+		{
+			name:     "base not found",
+			versions: []OpenAPI{{Version: "v2", Extends: "v1"}},
+			err:      "api version [v1] not found",
+		},
+		{
+			name:     "circular",
+			versions: []OpenAPI{{Version: "v1", Extends: "v2"}, {Version: "v2", Extends: "v1"}},
+			err:      "is circular",
+		},
+		{
+			name: "remove not inherited",
+			versions: []OpenAPI{
+				{Version: "v1", APIs: []API{apiOf("a", "A")}},
+				{Version: "v2", Extends: "v1", Removes: []string{"x"}},
+			},
+			err: "api [x] removed by version [v2] not inherited",
+		},
+		{
+			name:     "duplicated api",
+			versions: []OpenAPI{{Version: "v1", APIs: []API{apiOf("a", "A"), apiOf("a", "B")}}},
+			err:      "api [a] of version [v1] duplicated",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resolved, err := resolveAPIVersions(&APIDefine{APIVersions: c.versions})
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("expected error containing %q, got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for version, want := range c.apis {
+				if got := apiNames(resolved[version]); got != want {
+					t.Errorf("version %s: expected %s, got %s", version, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAPITables(t *testing.T) {
+	def := &APIDefine{APIVersions: []OpenAPI{
+		{Version: "v1", APIs: []API{apiOf("a", "A"), apiOf("b", "B"), apiOf("c", "C")}},
+		{Version: "v2", Extends: "v1", Removes: []string{"c"}, APIs: []API{apiOf("b", "B2"), apiOf("d", "D")}},
+		{Version: "v3", APIs: []API{apiOf("a", "A")}},
+	}}
+	tables, err := APITables(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"v1|:a=added,b=added,c=added",
+		"v2|v1:a=unchanged,b=changed,c=removed,d=added",
+		"v3|v2:a=unchanged,b=removed,d=removed",
+	}
+	for i, table := range tables {
+		var changes []string
+		for _, c := range table.Changes {
+			changes = append(changes, c.Name+"="+c.Status)
+		}
+		if got := table.Version + "|" + table.Base + ":" + strings.Join(changes, ","); got != want[i] {
+			t.Errorf("expected %s, got %s", want[i], got)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteAPITables(buf, tables); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "v2 (base v1)") || !strings.Contains(buf.String(), "svc.B2") {
+		t.Errorf("unexpected api tables:\n%s", buf.String())
+	}
+}
+
+func TestAPIDeprecationAndSunset(t *testing.T) {
+	day := func(d int) string {
+		return time.Now().AddDate(0, 0, d).Format(apiDateLayout)
+	}
+
+	a := apiOf("a", "A")
+	a.Deprecated, a.Sunset = day(-10), day(10)
+	if err := a.initPolicy(nil); err != nil {
+		t.Fatal(err)
+	}
+	if a.DeprecatedAt().IsZero() || a.SunsetAt().Format(apiDateLayout) != a.Sunset {
+		t.Errorf("unexpected dates %v %v", a.DeprecatedAt(), a.SunsetAt())
+	}
+	if err := a.checkSunset(); err != nil {
+		t.Errorf("api rejected before sunset: %v", err)
+	}
+
+	b := apiOf("b", "B")
+	b.Sunset = time.Now().Add(-time.Minute).Format(time.RFC3339)
+	if err := b.initPolicy(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.checkSunset(); err == nil {
+		t.Error("api accepted after sunset")
+	}
+
+	c := apiOf("c", "C")
+	if err := c.initPolicy(nil); err != nil || !c.DeprecatedAt().IsZero() || c.checkSunset() != nil {
+		t.Errorf("api without dates: %v", err)
+	}
+
+	d := apiOf("d", "D")
+	d.Deprecated = "2020/01/01"
+	if err := d.initPolicy(nil); err == nil {
+		t.Error("invalid deprecated date accepted")
+	}
+}
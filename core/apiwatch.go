@@ -0,0 +1,122 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hlogger"
+)
+
+//api.json热加载。资源管理器实现IAssetWatcher(如FileAssets)且APIGateway配置APIWatchInterval大于0时，
+//定期检查api.json的修改时间，修改后重新加载。新的接口定义无法解析时保留原定义
+
+// ReloadAPIs 重新读取api.json，失败时保留原接口定义
+func (this *APIGateWayImplement) ReloadAPIs() *herrors.Error {
+	apiDef, err := this.readAPIDefine()
+	if err != nil {
+		return err
+	}
+
+	middlewares := make(map[string]bool)
+	for _, m := range this.middlewares {
+		middlewares[m.(IEntity).Class()] = true
+	}
+
+	resolved, err := resolveAPIVersions(apiDef)
+	if err != nil {
+		return err.D("failed to load %s", apiFileName)
+	}
+
+	set := make(map[string]map[string]*API)
+	for version, apis := range resolved {
+		set[version] = make(map[string]*API)
+		for _, a := range apis {
+			//继承的接口与所属版本共用同一定义，只需初始化一次
+			if a.initialized {
+				continue
+			}
+			if err := a.initPolicy(middlewares); err != nil {
+				return err.D("failed to load %s", apiFileName)
+			}
+		}
+		for _, a := range apis {
+			for _, name := range append([]string{a.Name}, a.Aliases...) {
+				if set[version][name] != nil {
+					return herrors.ErrSysInternal.New("api [%s] of version [%s] duplicated", name, version).D("failed to load %s", apiFileName)
+				}
+				set[version][name] = a
+			}
+		}
+	}
+
+	this.apiLock.Lock()
+	this.apiName = apiDef.Name
+	this.apiSet = set
+	this.apiLock.Unlock()
+	return nil
+}
+
+func (this *APIGateWayImplement) readAPIDefine() (*APIDefine, *herrors.Error) {
+	file, err := this.server.Assets().File(apiFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiDef APIDefine
+	if err := jsoniter.Unmarshal(file, &apiDef); err != nil {
+		return nil, herrors.ErrSysInternal.New(err.Error()).D("failed to unmarshal %s", apiFileName)
+	}
+	return &apiDef, nil
+}
+
+// versionAPIs 指定版本的全部接口，返回的map在重新加载后不再变化
+func (this *APIGateWayImplement) versionAPIs(version string) map[string]*API {
+	this.apiLock.RLock()
+	defer this.apiLock.RUnlock()
+
+	return this.apiSet[version]
+}
+
+func (this *APIGateWayImplement) watchAPIs() {
+	if this.conf.APIWatchInterval <= 0 || this.stopAPIs != nil {
+		return
+	}
+	watcher, ok := this.server.Assets().(IAssetWatcher)
+	if !ok {
+		hlogger.Warn("assets manager can not be watched, %s will not be reloaded", apiFileName)
+		return
+	}
+
+	last, _ := watcher.ModTime(apiFileName)
+	ticker := time.NewTicker(time.Duration(this.conf.APIWatchInterval) * time.Second)
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				mt, err := watcher.ModTime(apiFileName)
+				if err != nil || mt.Equal(last) {
+					continue
+				}
+				last = mt
+				if err = this.ReloadAPIs(); err != nil {
+					hlogger.Error(err.D("failed to reload %s, previous APIs kept", apiFileName))
+					continue
+				}
+				hlogger.Info("%s reloaded", apiFileName)
+			}
+		}
+	}()
+
+	var once sync.Once
+	this.stopAPIs = func() {
+		once.Do(func() { close(done) })
+	}
+	hlogger.Info("watching %s", apiFileName)
+}
@@ -1,10 +1,22 @@
 package core
 
 import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/drharryhe/has/common/herrors"
 	"github.com/drharryhe/has/utils/hio"
 )
 
+//资源文件(api.json、语言文件、perms.json、初始化SQL等)统一通过IAssetManager读取。
+//FileAssets读取工作目录下的文件，EmbedAssets读取embed.FS，可将资源打包进单个可执行文件
+
 type BaseAssetManager struct {
 }
 
@@ -12,6 +24,11 @@ func (this *BaseAssetManager) Init() *herrors.Error {
 	return nil
 }
 
+// matchExt ext为空时匹配全部文件，不区分大小写
+func matchExt(name string, ext string) bool {
+	return ext == "" || strings.EqualFold(path.Ext(name), ext)
+}
+
 type FileAssets struct {
 	BaseAssetManager
 }
@@ -24,3 +41,94 @@ func (this *FileAssets) File(p string) ([]byte, *herrors.Error) {
 
 	return bs, nil
 }
+
+func (this *FileAssets) Files(dir string, ext string) ([]string, *herrors.Error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, herrors.ErrSysInternal.New(err.Error())
+	}
+
+	var ret []string
+	for _, info := range infos {
+		if !info.IsDir() && matchExt(info.Name(), ext) {
+			ret = append(ret, filepath.Join(dir, info.Name()))
+		}
+	}
+	return ret, nil
+}
+
+func (this *FileAssets) ModTime(p string) (time.Time, *herrors.Error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return time.Time{}, herrors.ErrSysInternal.New(err.Error())
+	}
+	return info.ModTime(), nil
+}
+
+// NewEmbedAssets root为资源在fsys中的目录，为空时使用fsys的根目录
+func NewEmbedAssets(fsys fs.FS, root string) *EmbedAssets {
+	return &EmbedAssets{
+		fsys: fsys,
+		root: root,
+	}
+}
+
+// EmbedAssets 只读资源，路径按fsys的根目录解析，"./api.json"与"api.json"相同。不支持热加载
+type EmbedAssets struct {
+	BaseAssetManager
+
+	fsys fs.FS
+	root string
+}
+
+func (this *EmbedAssets) Init() *herrors.Error {
+	if this.fsys == nil {
+		return herrors.ErrSysInternal.New("embed assets file system not set")
+	}
+	if this.root == "" || this.root == "." {
+		return nil
+	}
+
+	sub, err := fs.Sub(this.fsys, embedPath(this.root))
+	if err != nil {
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+	this.fsys = sub
+	this.root = ""
+	return nil
+}
+
+func (this *EmbedAssets) File(p string) ([]byte, *herrors.Error) {
+	bs, err := fs.ReadFile(this.fsys, embedPath(p))
+	if err != nil {
+		return nil, herrors.ErrSysInternal.New(err.Error())
+	}
+	return bs, nil
+}
+
+func (this *EmbedAssets) Files(dir string, ext string) ([]string, *herrors.Error) {
+	dir = embedPath(dir)
+	entries, err := fs.ReadDir(this.fsys, dir)
+	if err != nil {
+		return nil, herrors.ErrSysInternal.New(err.Error())
+	}
+
+	var ret []string
+	for _, e := range entries {
+		if !e.IsDir() && matchExt(e.Name(), ext) {
+			ret = append(ret, path.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// embedPath fs.FS只接受以/分隔、不以/或./开头的路径
+func embedPath(p string) string {
+	p = path.Clean(filepath.ToSlash(p))
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmbedAssets(t *testing.T) {
+	fsys := fstest.MapFS{
+		"assets/api.json":        {Data: []byte(`{"name":"test"}`)},
+		"assets/lang/zh.json":    {Data: []byte("zh")},
+		"assets/lang/en.JSON":    {Data: []byte("en")},
+		"assets/lang/readme.txt": {Data: []byte("readme")},
+		"assets/lang/sub/x.json": {Data: []byte("x")},
+		"other.json":             {Data: []byte("other")},
+	}
+
+	for _, root := range []string{"assets", "./assets", "/assets/"} {
+		a := NewEmbedAssets(fsys, root)
+		if err := a.Init(); err != nil {
+			t.Fatalf("root %s: %v", root, err)
+		}
+
+		for _, p := range []string{"api.json", "./api.json", "/api.json", "lang/../api.json"} {
+			if bs, err := a.File(p); err != nil || string(bs) != `{"name":"test"}` {
+				t.Errorf("root %s: file %s: %s %v", root, p, bs, err)
+			}
+		}
+		if _, err := a.File("../other.json"); err == nil {
+			t.Errorf("root %s: file outside root read", root)
+		}
+
+		cases := []struct {
+			dir   string
+			ext   string
+			files string
+		}{
+			{dir: "./lang", ext: ".json", files: "lang/en.JSON,lang/zh.json"},
+			{dir: "lang", ext: "", files: "lang/en.JSON,lang/readme.txt,lang/zh.json"},
+			{dir: "./", ext: ".json", files: "api.json"},
+		}
+		for _, c := range cases {
+			files, err := a.Files(c.dir, c.ext)
+			if err != nil || strings.Join(files, ",") != c.files {
+				t.Errorf("root %s: files of %s (%s): expected %s, got %v %v", root, c.dir, c.ext, c.files, files, err)
+			}
+		}
+		if _, err := a.Files("missing", ""); err == nil {
+			t.Errorf("root %s: files of missing dir listed", root)
+		}
+	}
+
+	//未指定root时使用fsys的根目录
+	a := NewEmbedAssets(fsys, "")
+	if err := a.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if bs, err := a.File("./other.json"); err != nil || string(bs) != "other" {
+		t.Errorf("file without root: %s %v", bs, err)
+	}
+
+	if err := NewEmbedAssets(nil, "").Init(); err == nil {
+		t.Error("embed assets without fsys initialized")
+	}
+}
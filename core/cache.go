@@ -0,0 +1,218 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"go.uber.org/atomic"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/utils/hencoder"
+	"github.com/drharryhe/has/utils/hruntime"
+)
+
+//slot结果缓存。可在请求对象的SlotRequestBase上声明：
+//	SlotRequestBase `cache:"ttl:60;keys:key,filter"`
+//也可在服务配置中声明(优先于标签)：
+//	CachedSlots = 'Query:60:key|filter,View:30'
+//keys为参与生成缓存key的参数，为空时使用全部参数
+
+const (
+	defaultCachePlugin = "MemCachePlugin"
+)
+
+// ISlotCache slot结果缓存的存储，bucket为 服务名.slot名
+type ISlotCache interface {
+	Get(bucket string, key string) (htypes.Any, bool)
+	Set(bucket string, key string, val htypes.Any, ttl time.Duration)
+	Delete(bucket string, key string)
+	Clear(bucket string)
+}
+
+// ISlotCacheInvalidator 可清除slot结果缓存的服务，嵌入core.Service的服务均已实现
+type ISlotCacheInvalidator interface {
+	InvalidateCache(slot string, params htypes.Map)
+}
+
+// ISlotCacheProvider 可提供slot结果缓存的插件，通过服务配置项CachePlugin指定
+type ISlotCacheProvider interface {
+	SlotCache() ISlotCache
+}
+
+type slotCachePolicy struct {
+	ttl    time.Duration
+	keys   []string
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func (this *slotCachePolicy) key(params htypes.Map) string {
+	ps := params
+	if len(this.keys) > 0 {
+		ps = make(htypes.Map, len(this.keys))
+		for _, k := range this.keys {
+			ps[k] = params[k]
+		}
+	}
+
+	//标准库兼容模式下map的key有序，相同参数生成相同的key
+	bs, _ := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(ps)
+	return hencoder.Md5ToString(bs)
+}
+
+// parseCachePolicy 解析缓存声明，格式为 ttl:60;keys:a,b
+func parseCachePolicy(tag string) (*slotCachePolicy, error) {
+	p := &slotCachePolicy{}
+	for k, v := range hruntime.ParseTag(tag) {
+		switch strings.TrimSpace(k) {
+		case "ttl":
+			ttl, err := strconv.Atoi(v)
+			if err != nil || ttl <= 0 {
+				return nil, fmt.Errorf("invalid cache ttl [%s]", v)
+			}
+			p.ttl = time.Duration(ttl) * time.Second
+		case "keys":
+			for _, key := range strings.Split(v, ",") {
+				if key = strings.TrimSpace(key); key != "" {
+					p.keys = append(p.keys, key)
+				}
+			}
+		}
+	}
+
+	if p.ttl == 0 {
+		return nil, fmt.Errorf("cache ttl not declared")
+	}
+	return p, nil
+}
+
+// parseCachedSlots 解析服务配置项CachedSlots，格式为 slot:ttl[:key1|key2],...
+func parseCachedSlots(conf string) (map[string]*slotCachePolicy, error) {
+	ret := make(map[string]*slotCachePolicy)
+	for _, s := range strings.Split(conf, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+
+		vv := strings.SplitN(s, ":", 3)
+		if len(vv) < 2 {
+			return nil, fmt.Errorf("invalid cached slot config [%s]", s)
+		}
+		tag := "ttl:" + vv[1]
+		if len(vv) == 3 {
+			tag += ";keys:" + strings.ReplaceAll(vv[2], "|", ",")
+		}
+
+		p, err := parseCachePolicy(tag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cached slot config [%s]: %v", s, err)
+		}
+		ret[strings.TrimSpace(vv[0])] = p
+	}
+	return ret, nil
+}
+
+// buildCache 生成缓存设置，未变化的slot沿用原有策略以保留命中统计
+func (this *Service) buildCache(rt *serviceRuntime) *herrors.Error {
+	prev := rt.cachePolicies
+	policies := make(map[string]*slotCachePolicy)
+
+	for name, tag := range this.cacheTags {
+		p, err := parseCachePolicy(tag)
+		if err != nil {
+			return herrors.ErrSysInternal.New("slot [%s] %v", name, err)
+		}
+		policies[name] = p
+	}
+
+	conf := hruntime.GetObjectFieldValue(this.instance.(IEntity).Config(), "CachedSlots")
+	if s, _ := conf.(string); s != "" {
+		ps, err := parseCachedSlots(s)
+		if err != nil {
+			return herrors.ErrSysInternal.New(err.Error())
+		}
+		for name, p := range ps {
+			if this.slots[name] == nil {
+				return herrors.ErrSysInternal.New("cached slot [%s] not found", name)
+			}
+			policies[name] = p
+		}
+	}
+	for name, p := range policies {
+		if o := prev[name]; o != nil && o.ttl == p.ttl && strings.Join(o.keys, ",") == strings.Join(p.keys, ",") {
+			policies[name] = o
+		}
+	}
+
+	if len(policies) == 0 {
+		rt.cachePolicies, rt.cache = policies, nil
+		return nil
+	}
+
+	cls, _ := hruntime.GetObjectFieldValue(this.instance.(IEntity).Config(), "CachePlugin").(string)
+	if cls == "" {
+		cls = defaultCachePlugin
+	}
+	provider, ok := this.UsePlugin(cls).(ISlotCacheProvider)
+	if !ok {
+		return herrors.ErrSysInternal.New("cache plugin [%s] not found or not implement ISlotCacheProvider", cls)
+	}
+	cache := rt.cache
+	if cache == nil || cls != rt.cacheClass {
+		cache = provider.SlotCache()
+	}
+	rt.cachePolicies, rt.cache, rt.cacheClass = policies, cache, cls
+	return nil
+}
+
+func (this *Service) cacheBucket(slot string) string {
+	return this.name + "." + slot
+}
+
+// InvalidateCache 使slot的缓存失效。params为nil时清除该slot的全部缓存，否则只清除与params对应的缓存
+func (this *Service) InvalidateCache(slot string, params htypes.Map) {
+	rt := this.currentRuntime()
+	p := rt.cachePolicies[slot]
+	if p == nil || rt.cache == nil {
+		return
+	}
+
+	if params == nil {
+		rt.cache.Clear(this.cacheBucket(slot))
+	} else {
+		rt.cache.Delete(this.cacheBucket(slot), p.key(params))
+	}
+}
+
+// Load 服务负载情况，包括各slot的请求情况及缓存命中统计
+func (this *Service) Load() htypes.Map {
+	stats := make(htypes.Map)
+	for name, p := range this.currentRuntime().cachePolicies {
+		stats[name] = htypes.Map{
+			"hits":   p.hits.Load(),
+			"misses": p.misses.Load(),
+		}
+	}
+	return htypes.Map{
+		"slots": this.slotLoad(),
+		"cache": stats,
+	}
+}
+
+// cacheTagOf 读取请求对象中SlotRequestBase上的cache标签
+func cacheTagOf(request reflect.Type) string {
+	t := derefType(request)
+	if t.Kind() != reflect.Struct {
+		return ""
+	}
+	f, ok := t.FieldByName("SlotRequestBase")
+	if !ok {
+		return ""
+	}
+	return f.Tag.Get("cache")
+}
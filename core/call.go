@@ -0,0 +1,197 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+)
+
+//服务间的类型化调用。请求对象按json标签转换为参数，结果解码为指定类型：
+//	token, err := core.Call[*hsessionsvs.CreateTokenRequest, string](server, "session", "CreateToken", req)
+//目标服务在本地时，先按slot的参数定义检查请求类型，字段未声明或缺少必需参数时返回错误；远程服务由目标slot校验参数
+
+var (
+	callChecks sync.Map //请求类型与slot参数定义的检查结果，callCheckKey -> error
+)
+
+type callCheckKey struct {
+	typ     reflect.Type
+	service string
+	slot    string
+}
+
+// Call 以请求对象调用服务slot，结果解码为Resp
+func Call[Req any, Resp any](s IServer, service string, slot string, req Req) (Resp, *herrors.Error) {
+	return CallContext[Req, Resp](context.Background(), s, service, slot, req)
+}
+
+// CallContext 同Call，ctx用于取消、超时及追踪
+func CallContext[Req any, Resp any](ctx context.Context, s IServer, service string, slot string, req Req) (Resp, *herrors.Error) {
+	var resp Resp
+
+	params, err := encodeCallRequest(s, service, slot, req)
+	if err != nil {
+		return resp, err
+	}
+
+	ret, err := s.RequestServiceContext(ctx, service, slot, params)
+	if err != nil {
+		return resp, err
+	}
+
+	if e := decodeCallResult(ret, &resp); e != nil {
+		return resp, herrors.ErrSysInternal.New("failed to decode result of slot [%s.%s] into %T: %v", service, slot, resp, e)
+	}
+	return resp, nil
+}
+
+// encodeCallRequest 请求对象转换为参数，值为nil的参数视为未传入
+func encodeCallRequest(s IServer, service string, slot string, req htypes.Any) (htypes.Map, *herrors.Error) {
+	if m, ok := req.(htypes.Map); ok {
+		return m, nil
+	}
+
+	v := reflect.ValueOf(req)
+	if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return htypes.Map{}, nil
+	}
+	if derefType(v.Type()).Kind() != reflect.Struct {
+		return nil, herrors.ErrCallerInvalidRequest.New("request of slot [%s.%s] must be a struct, but got %T", service, slot, req)
+	}
+
+	if def := s.Slot(service, slot); def != nil {
+		if err := checkCallRequest(v.Type(), service, slot, def); err != nil {
+			return nil, err
+		}
+	}
+
+	params, err := encodeParams(req)
+	if err != nil {
+		return nil, herrors.ErrCallerInvalidRequest.New("failed to encode request of slot [%s.%s]: %v", service, slot, err)
+	}
+	return params, nil
+}
+
+// encodeParams 按json标签将结构转换为参数，值为nil的字段不保留
+func encodeParams(v htypes.Any) (htypes.Map, error) {
+	bs, err := jsoniter.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	params := make(htypes.Map)
+	if err = jsoniter.Unmarshal(bs, &params); err != nil {
+		return nil, err
+	}
+	for k, val := range params {
+		if val == nil {
+			delete(params, k)
+		}
+	}
+	return params, nil
+}
+
+// checkCallRequest 请求类型的字段须为slot声明的参数，且包含全部必需参数。同一类型只检查一次
+func checkCallRequest(t reflect.Type, service string, slot string, def *Slot) *herrors.Error {
+	key := callCheckKey{typ: t, service: service, slot: slot}
+	if v, ok := callChecks.Load(key); ok {
+		err, _ := v.(*herrors.Error)
+		return err
+	}
+
+	var problems []string
+	fields := make(map[string]reflect.Type)
+	collectCallFields(derefType(t), fields)
+	for name, ft := range fields {
+		p := def.Params[name]
+		if p == nil {
+			problems = append(problems, fmt.Sprintf("[%s] not declared", name))
+		} else if p.goType != nil && !compatibleKind(derefType(ft), p.goType) {
+			problems = append(problems, fmt.Sprintf("[%s] is %s, but %s expected", name, derefType(ft).Kind(), p.goType.Kind()))
+		}
+	}
+	for name, p := range def.Params {
+		if p.Require && p.Default == nil && fields[name] == nil {
+			problems = append(problems, fmt.Sprintf("[%s] required", name))
+		}
+	}
+
+	var err *herrors.Error
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		err = herrors.ErrCallerInvalidRequest.New("request type %s does not match slot [%s.%s]: %s", t, service, slot, strings.Join(problems, "; "))
+	}
+	callChecks.Store(key, err)
+	return err
+}
+
+// collectCallFields 按json名称收集导出字段，与slot参数的解析相同，不含匿名字段(如SlotRequestBase)
+func collectCallFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous || !f.IsExported() {
+			continue
+		}
+		if name := paramName(f); name != "" {
+			fields[name] = f.Type
+		}
+	}
+}
+
+// compatibleKind 数值类型之间可以转换，其余须为同类
+func compatibleKind(a reflect.Type, b reflect.Type) bool {
+	if a.Kind() == reflect.Interface || b.Kind() == reflect.Interface {
+		return true
+	}
+	if isNumberKind(a.Kind()) && isNumberKind(b.Kind()) {
+		return true
+	}
+	if (a.Kind() == reflect.Slice || a.Kind() == reflect.Array) && (b.Kind() == reflect.Slice || b.Kind() == reflect.Array) {
+		return true
+	}
+	return a.Kind() == b.Kind()
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64
+}
+
+// decodeCallResult 结果可直接赋值时不转换，[]byte与string互相转换，其余经JSON解码
+func decodeCallResult(ret htypes.Any, resp htypes.Any) error {
+	if ret == nil {
+		return nil
+	}
+
+	rv := reflect.ValueOf(resp).Elem()
+	v := reflect.ValueOf(ret)
+	if v.Type().AssignableTo(rv.Type()) {
+		rv.Set(v)
+		return nil
+	}
+
+	switch p := resp.(type) {
+	case *string:
+		if bs, ok := ret.([]byte); ok {
+			*p = string(bs)
+			return nil
+		}
+	case *[]byte:
+		if s, ok := ret.(string); ok {
+			*p = []byte(s)
+			return nil
+		}
+	}
+
+	bs, err := jsoniter.Marshal(ret)
+	if err != nil {
+		return err
+	}
+	return jsoniter.Unmarshal(bs, resp)
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+)
+
+type callTestRequest struct {
+	SlotRequestBase
+
+	User   *string `json:"user" param:"require"`
+	Expire *int    `json:"expire"`
+}
+
+type callTestResult struct {
+	Token string `json:"token"`
+	Count int    `json:"count"`
+}
+
+// callTestServer slots中的服务在本地，其余为远程服务。记录调用参数，返回ret及err
+type callTestServer struct {
+	IServer
+	slots  map[string]*Slot
+	params htypes.Map
+	ret    htypes.Any
+	err    *herrors.Error
+}
+
+func (this *callTestServer) Slot(service string, slot string) *Slot {
+	return this.slots[service+"."+slot]
+}
+
+func (this *callTestServer) RequestServiceContext(ctx context.Context, service string, slot string, params htypes.Map) (htypes.Any, *herrors.Error) {
+	this.params = params
+	return this.ret, this.err
+}
+
+func newCallTestServer(t *testing.T) *callTestServer {
+	slot := &Slot{Name: "Create"}
+	if err := (&Service{}).parseRequestParameters(reflect.TypeOf(&callTestRequest{}), slot); err != nil {
+		t.Fatal(err)
+	}
+	return &callTestServer{slots: map[string]*Slot{"local.Create": slot}}
+}
+
+func TestCall(t *testing.T) {
+	s := newCallTestServer(t)
+	user, expire := "has", 10
+
+	s.ret = htypes.Map{"token": "abc", "count": 2}
+	ret, err := Call[*callTestRequest, callTestResult](s, "local", "Create", &callTestRequest{User: &user, Expire: &expire})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Token != "abc" || ret.Count != 2 {
+		t.Errorf("unexpected result %+v", ret)
+	}
+	if len(s.params) != 2 || s.params["user"] != "has" || s.params["expire"] != float64(10) {
+		t.Errorf("unexpected params %v", s.params)
+	}
+
+	//nil字段不传入，[]byte结果转换为string
+	s.ret = []byte("abc")
+	token, err := Call[*callTestRequest, string](s, "local", "Create", &callTestRequest{User: &user})
+	if err != nil || token != "abc" {
+		t.Errorf("unexpected result %v %v", token, err)
+	}
+	if _, ok := s.params["expire"]; ok || len(s.params) != 1 {
+		t.Errorf("nil field passed %v", s.params)
+	}
+
+	s.ret = "not a struct"
+	if _, err = Call[*callTestRequest, callTestResult](s, "local", "Create", &callTestRequest{User: &user}); err == nil || err.Code != herrors.ECodeSysInternal {
+		t.Errorf("expected decode error, got %v", err)
+	}
+}
+
+func TestCallTypeMismatch(t *testing.T) {
+	type missingRequired struct {
+		Expire *int `json:"expire"`
+	}
+	type undeclared struct {
+		User  *string `json:"user"`
+		Extra *string `json:"extra"`
+	}
+	type wrongKind struct {
+		User   *string `json:"user"`
+		Expire *string `json:"expire"`
+	}
+
+	s := newCallTestServer(t)
+	cases := []struct {
+		name string
+		call func() *herrors.Error
+		err  string
+	}{
+		{"missing required", func() *herrors.Error {
+			_, err := Call[missingRequired, string](s, "local", "Create", missingRequired{})
+			return err
+		}, "[user] required"},
+		{"undeclared", func() *herrors.Error {
+			_, err := Call[*undeclared, string](s, "local", "Create", &undeclared{})
+			return err
+		}, "[extra] not declared"},
+		{"wrong kind", func() *herrors.Error {
+			_, err := Call[*wrongKind, string](s, "local", "Create", &wrongKind{})
+			return err
+		}, "[expire] is string, but int expected"},
+		{"not struct", func() *herrors.Error {
+			_, err := Call[int, string](s, "local", "Create", 1)
+			return err
+		}, "must be a struct"},
+	}
+	for _, c := range cases {
+		s.params = nil
+		err := c.call()
+		if err == nil || err.Code != herrors.ECodeCallerInvalidRequest || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected error containing %q, got %v", c.name, c.err, err)
+		}
+		if s.params != nil {
+			t.Errorf("%s: service called with mismatched request", c.name)
+		}
+	}
+}
+
+func TestCallRemote(t *testing.T) {
+	type undeclared struct {
+		Extra string `json:"extra"`
+	}
+
+	//远程服务没有本地的slot定义，不检查请求类型，由目标slot校验参数
+	s := newCallTestServer(t)
+	s.ret = "ok"
+	ret, err := Call[undeclared, string](s, "remote", "Create", undeclared{Extra: "x"})
+	if err != nil || ret != "ok" || s.params["extra"] != "x" {
+		t.Errorf("unexpected result %v %v, params %v", ret, err, s.params)
+	}
+
+	s.err = herrors.ErrUserInvalidAct.New("rejected")
+	if _, err = Call[undeclared, string](s, "remote", "Create", undeclared{}); err != s.err {
+		t.Errorf("error not propagated: %v", err)
+	}
+
+	//htypes.Map原样传入
+	s.err = nil
+	params := htypes.Map{"any": 1}
+	if _, err = Call[htypes.Map, string](s, "local", "Create", params); err != nil || !reflect.DeepEqual(s.params, params) {
+		t.Errorf("map not passed through: %v %v", s.params, err)
+	}
+}
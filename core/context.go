@@ -0,0 +1,23 @@
+package core
+
+import (
+	"context"
+
+	"github.com/drharryhe/has/common/herrors"
+)
+
+// ContextError 将已结束的context转换为框架错误，context仍有效时返回nil
+func ContextError(ctx context.Context) *herrors.Error {
+	if ctx == nil {
+		return nil
+	}
+
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return herrors.ErrSysBusy.New("request timeout").D("request deadline exceeded")
+	default:
+		return herrors.ErrCallerInvalidRequest.New("request canceled").D("request canceled by caller")
+	}
+}
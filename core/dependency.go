@@ -0,0 +1,138 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/utils/hruntime"
+)
+
+const (
+	AllServices = "*" //依赖所有其他服务（声明了AllServices的服务除外）
+)
+
+// IServiceDependent 声明服务对插件及其他服务的依赖，服务将在其依赖之后打开
+type IServiceDependent interface {
+	Dependencies() *ServiceDependencies
+}
+
+type ServiceDependencies struct {
+	Plugins  []string `json:"plugins"`  //插件Class
+	Services []string `json:"services"` //服务名称
+}
+
+type DependencyGraph struct {
+	Order    []string                        `json:"order"`    //服务打开顺序
+	Services map[string]*ServiceDependencies `json:"services"` //服务名称 -> 依赖
+}
+
+type pendingService struct {
+	name    string
+	service IService
+	options htypes.Any
+	deps    *ServiceDependencies
+}
+
+func serviceDependencies(s IService) *ServiceDependencies {
+	if d, ok := s.(IServiceDependent); ok {
+		if deps := d.Dependencies(); deps != nil {
+			return deps
+		}
+	}
+	return &ServiceDependencies{}
+}
+
+// serviceNameOf 从配置中读取服务名称，服务打开之前也可以使用
+func serviceNameOf(s IService) string {
+	name, _ := hruntime.GetObjectFieldValue(s.(IEntity).Config(), "Name").(string)
+	return name
+}
+
+// resolveServiceOrder 按依赖关系对待打开的服务进行拓扑排序，相互无依赖的服务保持注册顺序。
+// opened为已打开的服务，plugins为已打开的插件
+func resolveServiceOrder(pending []*pendingService, opened map[string]IService, plugins map[string]IPlugin) ([]*pendingService, *herrors.Error) {
+	byName := make(map[string]*pendingService)
+	for _, ps := range pending {
+		if ps.name == "" {
+			return nil, herrors.ErrSysInternal.New("service [%s] name not configured", hruntime.GetObjectName(ps.service))
+		}
+		if byName[ps.name] != nil || opened[ps.name] != nil {
+			return nil, herrors.ErrSysInternal.New("service name [%s] duplicated", ps.name)
+		}
+		byName[ps.name] = ps
+	}
+
+	edges := make(map[string][]string)
+	for _, ps := range pending {
+		for _, p := range ps.deps.Plugins {
+			if plugins[p] == nil {
+				return nil, herrors.ErrSysInternal.New("service [%s] depends on plugin [%s], which is not registered", ps.name, p)
+			}
+		}
+
+		for _, s := range ps.deps.Services {
+			if s == AllServices {
+				for _, other := range pending {
+					if other != ps && !dependsOnAll(other.deps) {
+						edges[ps.name] = append(edges[ps.name], other.name)
+					}
+				}
+				continue
+			}
+			if s == ps.name {
+				return nil, herrors.ErrSysInternal.New("service [%s] depends on itself", ps.name)
+			}
+			if byName[s] != nil {
+				edges[ps.name] = append(edges[ps.name], s)
+			} else if opened[s] == nil {
+				return nil, herrors.ErrSysInternal.New("service [%s] depends on service [%s], which is not registered", ps.name, s)
+			}
+		}
+	}
+
+	var order []*pendingService
+	done := make(map[string]bool)
+	for len(order) < len(pending) {
+		progressed := false
+		for _, ps := range pending {
+			if done[ps.name] {
+				continue
+			}
+			ready := true
+			for _, d := range edges[ps.name] {
+				if !done[d] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				done[ps.name] = true
+				order = append(order, ps)
+				progressed = true
+				break
+			}
+		}
+
+		if !progressed {
+			var cycle []string
+			for _, ps := range pending {
+				if !done[ps.name] {
+					cycle = append(cycle, ps.name)
+				}
+			}
+			return nil, herrors.ErrSysInternal.New("dependency cycle detected among services [%s]", strings.Join(cycle, ", "))
+		}
+	}
+
+	return order, nil
+}
+
+func dependsOnAll(deps *ServiceDependencies) bool {
+	for _, s := range deps.Services {
+		if s == AllServices {
+			return true
+		}
+	}
+	return false
+}
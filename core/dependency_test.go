@@ -0,0 +1,87 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func pendingOf(name string, plugins []string, services ...string) *pendingService {
+	return &pendingService{name: name, deps: &ServiceDependencies{Plugins: plugins, Services: services}}
+}
+
+func orderNames(order []*pendingService) string {
+	var names []string
+	for _, ps := range order {
+		names = append(names, ps.name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestResolveServiceOrder(t *testing.T) {
+	plugins := map[string]IPlugin{"DbPlugin": &BasePlugin{}}
+
+	cases := []struct {
+		name    string
+		pending []*pendingService
+		order   string
+		err     string
+	}{
+		{
+			name:    "keep register order without dependencies",
+			pending: []*pendingService{pendingOf("a", nil), pendingOf("b", nil), pendingOf("c", nil)},
+			order:   "a,b,c",
+		},
+		{
+			name:    "dependencies first",
+			pending: []*pendingService{pendingOf("auth", nil, "user"), pendingOf("user", []string{"DbPlugin"}, "data"), pendingOf("data", nil)},
+			order:   "data,user,auth",
+		},
+		{
+			name:    "all services last",
+			pending: []*pendingService{pendingOf("gw", nil, AllServices), pendingOf("a", nil), pendingOf("b", nil, "a")},
+			order:   "a,b,gw",
+		},
+		{
+			name:    "cycle",
+			pending: []*pendingService{pendingOf("a", nil, "b"), pendingOf("b", nil, "c"), pendingOf("c", nil, "a"), pendingOf("d", nil)},
+			err:     "dependency cycle detected among services [a, b, c]",
+		},
+		{
+			name:    "self dependency",
+			pending: []*pendingService{pendingOf("a", nil, "a")},
+			err:     "depends on itself",
+		},
+		{
+			name:    "missing service",
+			pending: []*pendingService{pendingOf("a", nil, "x")},
+			err:     "depends on service [x], which is not registered",
+		},
+		{
+			name:    "missing plugin",
+			pending: []*pendingService{pendingOf("a", []string{"RedisPlugin"})},
+			err:     "depends on plugin [RedisPlugin], which is not registered",
+		},
+		{
+			name:    "duplicated name",
+			pending: []*pendingService{pendingOf("a", nil), pendingOf("a", nil)},
+			err:     "service name [a] duplicated",
+		},
+	}
+
+	for _, c := range cases {
+		order, err := resolveServiceOrder(c.pending, map[string]IService{}, plugins)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: expect error [%s], got %v", c.name, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		if got := orderNames(order); got != c.order {
+			t.Errorf("%s: expect order [%s], got [%s]", c.name, c.order, got)
+		}
+	}
+}
@@ -1,8 +1,15 @@
 package core
 
 import (
+	"reflect"
+	"sync"
+
+	"github.com/drharryhe/has/common/hconf"
 	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/common/hstate"
 	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/utils/hrandom"
 	"github.com/drharryhe/has/utils/hruntime"
 )
 
@@ -22,6 +29,8 @@ const (
 	ManageUpdateConfigItems = "UpdateConfigItems"
 	ManageGetConfig         = "GetConfig"
 	ManageGetConfigItems    = "GetConfigItems"
+	ManageGetDependencies   = "GetDependencies"
+	ManageReloadConfig      = "ReloadConfig"
 )
 
 func NewEntityStub(opt *EntityStubOptions) *EntityStub {
@@ -36,6 +45,9 @@ func NewEntityStub(opt *EntityStubOptions) *EntityStub {
 
 	if opt.GetLoad == nil {
 		opt.GetLoad = func(params htypes.Map) (htypes.Any, *herrors.Error) {
+			if r, ok := opt.Owner.(ILoadReporter); ok {
+				return r.Load(), nil
+			}
 			return nil, herrors.ErrSysUnhandled
 		}
 	}
@@ -57,9 +69,58 @@ func NewEntityStub(opt *EntityStubOptions) *EntityStub {
 	if opt.GetConfigItems == nil {
 		opt.GetConfigItems = m.getConfigItems
 	}
+
+	if opt.GetDependencies == nil {
+		opt.GetDependencies = m.getDependencies
+	}
+
+	if opt.ReloadConfig == nil {
+		opt.ReloadConfig = m.reloadConfig
+	}
+
+	if opt.Enable == nil {
+		opt.Enable = func(params htypes.Map) *herrors.Error {
+			return m.setDisabled(false)
+		}
+	}
+
+	if opt.Disable == nil {
+		opt.Disable = func(params htypes.Map) *herrors.Error {
+			return m.setDisabled(true)
+		}
+	}
+
+	if opt.GetDeadLetters == nil {
+		opt.GetDeadLetters = func(params htypes.Map) (htypes.Any, *herrors.Error) {
+			return nil, herrors.ErrSysUnhandled
+		}
+	}
+
+	if opt.RedeliverDeadLetters == nil {
+		opt.RedeliverDeadLetters = func(params htypes.Map) (htypes.Any, *herrors.Error) {
+			return nil, herrors.ErrSysUnhandled
+		}
+	}
+
+	if opt.GetSchedules == nil {
+		opt.GetSchedules = func(params htypes.Map) (htypes.Any, *herrors.Error) {
+			return nil, herrors.ErrSysUnhandled
+		}
+	}
+
+	if opt.RunSchedule == nil {
+		opt.RunSchedule = func(params htypes.Map) (htypes.Any, *herrors.Error) {
+			return nil, herrors.ErrSysUnhandled
+		}
+	}
 	return m
 }
 
+// ILoadReporter 未指定GetLoad时，由实现该接口的实体提供负载情况
+type ILoadReporter interface {
+	Load() htypes.Map
+}
+
 type EntitySetter func(params htypes.Map) *herrors.Error
 
 type EntityGetter func(params htypes.Map) (htypes.Any, *herrors.Error)
@@ -76,13 +137,21 @@ type EntityStub struct {
 }
 
 type EntityStubOptions struct {
-	Owner             IEntity
-	Ping              EntityGetter //联通情况
-	GetLoad           EntityGetter //负载情况
-	ResetConfig       EntitySetter //恢复设置
-	UpdateConfigItems EntitySetter //修改设置
-	GetConfig         EntityGetter //获取全部配置
-	GetConfigItems    EntityGetter //获取某项配置
+	Owner                IEntity
+	Ping                 EntityGetter //联通情况
+	GetLoad              EntityGetter //负载情况
+	ResetConfig          EntitySetter //恢复设置
+	UpdateConfigItems    EntitySetter //修改设置
+	GetConfig            EntityGetter //获取全部配置
+	GetConfigItems       EntityGetter //获取某项配置
+	GetDependencies      EntityGetter //获取依赖关系
+	ReloadConfig         EntitySetter //以配置文件中的配置段重新加载配置
+	Enable               EntitySetter //启用
+	Disable              EntitySetter //停用
+	GetDeadLetters       EntityGetter //获取投递失败的事件
+	RedeliverDeadLetters EntityGetter //重新投递失败的事件
+	GetSchedules         EntityGetter //获取定时任务的执行记录及下次执行时间
+	RunSchedule          EntityGetter //立即执行定时任务
 }
 
 type EntityConfBase struct {
@@ -113,38 +182,176 @@ func (this *EntityStub) Manage(act string, params htypes.Map) (htypes.Any, *herr
 	case ManageGetLoad:
 		return this.options.GetLoad(params)
 	case ManageGetConfig:
-		return this.options.GetConfigItems(params)
+		return this.options.GetConfig(params)
 	case ManageGetConfigItems:
 		return this.options.GetConfigItems(params)
 	case ManageUpdateConfigItems:
 		return nil, this.options.UpdateConfigItems(params)
 	case ManageResetConfig:
 		return nil, this.options.ResetConfig(params)
+	case ManageGetDependencies:
+		return this.options.GetDependencies(params)
+	case ManageReloadConfig:
+		return nil, this.options.ReloadConfig(params)
+	case ManageEnable:
+		return nil, this.options.Enable(params)
+	case ManageDisable:
+		return nil, this.options.Disable(params)
+	case ManageGetDeadLetters:
+		return this.options.GetDeadLetters(params)
+	case ManageRedeliverDeadLetters:
+		return this.options.RedeliverDeadLetters(params)
+	case ManageGetSchedules:
+		return this.options.GetSchedules(params)
+	case ManageRunSchedule:
+		return this.options.RunSchedule(params)
 	default:
 		return nil, herrors.ErrCallerInvalidRequest.New("invalid manage act [%s]", act)
 	}
 }
 
+// redactedValue GetConfig及GetConfigItems返回的配置中，标记为secret:"true"的非空字符串配置项(如密码)替换为该值
+const redactedValue = "******"
+
+// configLock 串行化各实体的配置修改(UpdateConfigItems、ReloadConfig、Enable、Disable)，读取配置时加读锁。
+// 请求处理中不直接读取可修改的配置，而是使用实体在OnConfigReload中整体替换的配置副本
+var configLock sync.RWMutex
+
 func (this *EntityStub) getConfigItems(params htypes.Map) (htypes.Any, *herrors.Error) {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
 	vals := make(htypes.Map)
 	for k := range params {
 		val := hruntime.GetObjectFieldValue(this.options.Owner.Config(), k)
 		if val == nil {
 			return nil, herrors.ErrCallerInvalidRequest.New("config item [%s] not found", k)
 		}
+		if s, ok := val.(string); ok && s != "" && isSecretItem(this.options.Owner.Config(), k) {
+			val = redactedValue
+		}
 		vals[k] = val
 	}
 
 	return vals, nil
 }
 
+func (this *EntityStub) getDependencies(_ htypes.Map) (htypes.Any, *herrors.Error) {
+	if s, ok := this.options.Owner.(IService); ok {
+		return serviceDependencies(s), nil
+	}
+	return nil, herrors.ErrSysUnhandled
+}
+
 func (this *EntityStub) getConfig(_ htypes.Map) (htypes.Any, *herrors.Error) {
-	return this.options.Owner.Config(), nil
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	//返回副本，调用方读取时不受之后的配置修改影响
+	conf := reflect.ValueOf(this.options.Owner.Config())
+	if conf.Kind() != reflect.Ptr || conf.IsNil() {
+		return this.options.Owner.Config(), nil
+	}
+	cp := reflect.New(conf.Elem().Type())
+	cp.Elem().Set(conf.Elem())
+	redactSecrets(cp.Elem())
+	return cp.Interface(), nil
+}
+
+func isSecretItem(conf IEntityConf, name string) bool {
+	t := reflect.TypeOf(conf)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	f, ok := t.FieldByName(name)
+	return ok && f.Tag.Get("secret") == "true"
+}
+
+// redactSecrets 替换v中标记为secret:"true"的配置项，v为配置的副本。数组复制后再修改，不影响原配置
+func redactSecrets(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		f, sf := v.Field(i), t.Field(i)
+		if !f.CanSet() {
+			continue
+		}
+		switch f.Kind() {
+		case reflect.String:
+			if sf.Tag.Get("secret") == "true" && f.String() != "" {
+				f.SetString(redactedValue)
+			}
+		case reflect.Struct:
+			redactSecrets(f)
+		case reflect.Slice:
+			if f.Type().Elem().Kind() != reflect.Struct || f.Len() == 0 {
+				continue
+			}
+			cp := reflect.MakeSlice(f.Type(), f.Len(), f.Len())
+			reflect.Copy(cp, f)
+			for j := 0; j < cp.Len(); j++ {
+				redactSecrets(cp.Index(j))
+			}
+			f.Set(cp)
+		}
+	}
 }
 
+// updateConfigItems 与重新加载配置相同，由Owner.OnConfigReload决定是否接受
 func (this *EntityStub) updateConfigItems(params htypes.Map) *herrors.Error {
-	if err := hruntime.SetObjectValues(this.options.Owner.Config(), params); err != nil {
-		return herrors.ErrCallerInvalidRequest.New(err.Error())
+	return this.changeConfig(func(conf IEntityConf) *herrors.Error {
+		if err := hruntime.SetObjectValues(conf, params); err != nil {
+			return herrors.ErrCallerInvalidRequest.New(err.Error())
+		}
+		return nil
+	})
+}
+
+// reloadConfig params为配置段内容。新配置替换原配置后由Owner.OnConfigReload决定是否接受，拒绝时恢复原配置
+func (this *EntityStub) reloadConfig(params htypes.Map) *herrors.Error {
+	return this.changeConfig(func(conf IEntityConf) *herrors.Error {
+		cur := reflect.ValueOf(conf).Elem()
+		fresh := reflect.New(cur.Type())
+		if err := hconf.Section(params, fresh.Interface()); err != nil {
+			return herrors.ErrCallerInvalidRequest.New(err.Error())
+		}
+
+		//EID运行期间不可修改，配置文件中未设置时沿用已分配的EID
+		fc := fresh.Interface().(IEntityConf)
+		if fc.GetEID() == "" {
+			fc.SetEID(conf.GetEID())
+		} else if fc.GetEID() != conf.GetEID() {
+			return herrors.ErrCallerInvalidRequest.New("EID can not be changed at runtime")
+		}
+
+		cur.Set(fresh.Elem())
+		return nil
+	})
+}
+
+// changeConfig 持有configLock修改配置并交由Owner.OnConfigReload处理，修改失败或被拒绝时恢复原配置
+func (this *EntityStub) changeConfig(change func(conf IEntityConf) *herrors.Error) *herrors.Error {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	conf := this.options.Owner.Config()
+	cur := reflect.ValueOf(conf)
+	if cur.Kind() != reflect.Ptr || cur.IsNil() {
+		return herrors.ErrSysInternal.New("config of [%s] not changeable", this.options.Owner.Class())
+	}
+	cur = cur.Elem()
+
+	old := reflect.New(cur.Type())
+	old.Elem().Set(cur)
+	if err := change(conf); err != nil {
+		cur.Set(old.Elem())
+		return err
+	}
+	if err := this.options.Owner.OnConfigReload(old.Interface().(IEntityConf)); err != nil {
+		cur.Set(old.Elem())
+		return err
 	}
 	return nil
 }
@@ -163,3 +370,62 @@ func CheckAndRegisterEntity(ins htypes.Any, router IRouter) *herrors.Error {
 	}
 	return nil
 }
+
+// ensureEID 配置中未设置EID时，使用运行状态中保存的EID，不存在则生成并保存
+func ensureEID(conf IEntityConf) {
+	if conf.GetEID() != "" {
+		return
+	}
+
+	key := hruntime.GetObjectName(conf) + ".EID"
+	eid := hstate.GetString(key, "")
+	if eid == "" {
+		eid = hrandom.UuidWithoutDash()
+		if err := hstate.Set(key, eid); err != nil {
+			hlogger.Error(herrors.ErrSysInternal.New(err.Error()).D("failed to save EID of [%s]", hruntime.GetObjectName(conf)))
+		}
+	}
+	conf.SetEID(eid)
+}
+
+// StateKey 实体的运行状态名，格式为 配置段名.状态项
+func StateKey(entity IEntity, item string) string {
+	return hruntime.GetObjectName(entity.Config()) + "." + item
+}
+
+// OneShotPending 一次性配置项(如AutoMigrate)是否需要执行，执行完成后调用OneShotDone。配置文件不再被改写，执行状态保存在hstate中
+func OneShotPending(entity IEntity, item string, enabled bool) bool {
+	pending, err := hstate.Pending(StateKey(entity, item), enabled)
+	if err != nil {
+		hlogger.Error(herrors.ErrSysInternal.New(err.Error()).D("failed to read state [%s]", StateKey(entity, item)))
+		return false
+	}
+	return pending
+}
+
+// allowDestructive 以--reset启动时为true，见OneShotDestructive
+var allowDestructive bool
+
+// OneShotDestructive 破坏性的一次性配置项(如数据库Reset、清空文件仓库)，除配置项开启外还需以--reset启动。
+// 执行前先保存已执行标志，保存失败时拒绝执行，返回true时由调用方执行，不需要再调用OneShotDone
+func OneShotDestructive(entity IEntity, item string, enabled bool) (bool, *herrors.Error) {
+	if !OneShotPending(entity, item, enabled) {
+		return false, nil
+	}
+
+	key := StateKey(entity, item)
+	if !allowDestructive {
+		hlogger.Warn("[%s] skipped, start with --reset to run it", key)
+		return false, nil
+	}
+	if err := hstate.Done(key); err != nil {
+		return false, herrors.ErrSysInternal.New(err.Error()).D("failed to save state [%s], operation refused", key)
+	}
+	return true, nil
+}
+
+func OneShotDone(entity IEntity, item string) {
+	if err := hstate.Done(StateKey(entity, item)); err != nil {
+		hlogger.Error(herrors.ErrSysInternal.New(err.Error()).D("failed to save state [%s]", StateKey(entity, item)))
+	}
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/drharryhe/has/common/hstate"
+	"github.com/drharryhe/has/common/htypes"
+)
+
+type oneShotConf struct {
+	PluginConf
+}
+
+type oneShotPlugin struct {
+	BasePlugin
+	conf oneShotConf
+}
+
+func (this *oneShotPlugin) Config() IEntityConf {
+	return &this.conf
+}
+
+func (this *oneShotPlugin) EntityStub() *EntityStub {
+	return NewEntityStub(&EntityStubOptions{Owner: this})
+}
+
+type failingStore struct{}
+
+func (this failingStore) Get(string) (string, bool, error) { return "", false, nil }
+func (this failingStore) Set(string, string) error         { return errors.New("read-only") }
+func (this failingStore) Delete(string) error              { return nil }
+
+func TestOneShotDestructive(t *testing.T) {
+	if err := hstate.Init(hstate.MemoryStateFile); err != nil {
+		t.Fatal(err)
+	}
+	defer func(allowed bool) { allowDestructive = allowed }(allowDestructive)
+	p := &oneShotPlugin{}
+
+	//未以--reset启动时不执行，也不记录
+	allowDestructive = false
+	if run, err := OneShotDestructive(p, "Reset", true); run || err != nil {
+		t.Fatalf("run without --reset: %v %v", run, err)
+	}
+
+	allowDestructive = true
+	if run, err := OneShotDestructive(p, "Reset", false); run || err != nil {
+		t.Fatalf("run while disabled: %v %v", run, err)
+	}
+	if run, err := OneShotDestructive(p, "Reset", true); !run || err != nil {
+		t.Fatalf("first run: %v %v", run, err)
+	}
+	if run, err := OneShotDestructive(p, "Reset", true); run || err != nil {
+		t.Fatalf("second run: %v %v", run, err)
+	}
+
+	//关闭后再开启时重新执行
+	_, _ = OneShotDestructive(p, "Reset", false)
+	if run, _ := OneShotDestructive(p, "Reset", true); !run {
+		t.Fatal("not run after re-enabled")
+	}
+
+	//无法保存执行标志时拒绝执行
+	hstate.Use(failingStore{})
+	if run, err := OneShotDestructive(p, "CleanFs", true); run || err == nil {
+		t.Fatalf("run without saved state: %v %v", run, err)
+	}
+	_ = hstate.Init(hstate.MemoryStateFile)
+}
+
+type secretConf struct {
+	PluginConf
+	User  string
+	Pwd   string `secret:"true"`
+	Conns []secretConn
+}
+
+type secretConn struct {
+	Key string
+	Pwd string `secret:"true"`
+}
+
+type secretPlugin struct {
+	BasePlugin
+	conf secretConf
+}
+
+func (this *secretPlugin) Config() IEntityConf {
+	return &this.conf
+}
+
+func (this *secretPlugin) EntityStub() *EntityStub {
+	return NewEntityStub(&EntityStubOptions{Owner: this})
+}
+
+func TestGetConfigRedactsSecrets(t *testing.T) {
+	p := &secretPlugin{conf: secretConf{User: "root", Pwd: "123456", Conns: []secretConn{{Key: "a", Pwd: "abc"}, {Key: "b"}}}}
+
+	val, err := p.EntityStub().Manage(ManageGetConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := val.(*secretConf)
+	if conf.User != "root" || conf.Pwd != redactedValue || conf.Conns[0].Pwd != redactedValue || conf.Conns[1].Pwd != "" {
+		t.Errorf("secrets not redacted: %+v", conf)
+	}
+	if p.conf.Pwd != "123456" || p.conf.Conns[0].Pwd != "abc" {
+		t.Errorf("original config changed: %+v", p.conf)
+	}
+
+	val, err = p.EntityStub().Manage(ManageGetConfigItems, htypes.Map{"User": nil, "Pwd": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items := val.(htypes.Map); items["User"] != "root" || items["Pwd"] != redactedValue {
+		t.Errorf("secret item not redacted: %v", items)
+	}
+}
+
+type reloadPluginConf struct {
+	PluginConf
+
+	Addr string
+}
+
+type reloadPlugin struct {
+	BasePlugin
+	conf reloadPluginConf
+}
+
+func (this *reloadPlugin) Config() IEntityConf {
+	return &this.conf
+}
+
+func (this *reloadPlugin) EntityStub() *EntityStub {
+	return NewEntityStub(&EntityStubOptions{Owner: this})
+}
+
+func TestBasePluginOnConfigReload(t *testing.T) {
+	p := &reloadPlugin{conf: reloadPluginConf{Addr: "a"}}
+	p.instance, p.class = p, "ReloadPlugin"
+
+	old := p.conf
+	p.conf.EID, p.conf.Disabled = "eid", true
+	if err := p.OnConfigReload(&old); err != nil {
+		t.Errorf("change of EntityConfBase rejected: %v", err)
+	}
+
+	p.conf.Addr = "b"
+	if err := p.OnConfigReload(&old); err == nil {
+		t.Error("change of connection settings accepted")
+	}
+}
@@ -0,0 +1,630 @@
+package core
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"go.uber.org/atomic"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/common/hmetrics"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/utils/hrandom"
+)
+
+//事件总线。服务通过PublishEvent发布事件，实现IEventSubscriber的服务声明订阅的主题及处理事件的slot，
+//事件数据作为slot的参数。至少投递一次：slot返回系统错误时按退避时间重试，重试用尽或返回其他错误的事件进入死信，
+//可通过实体管理查询及重新投递，死信缺省保存在内存，Server配置项EventDeadLetterStore指定的插件可将其持久化。
+//Server配置项EventBridge指定的插件可将主题桥接到消息队列，使其他节点上的订阅者也能收到；本节点的订阅者总是直接投递，
+//同一服务在多个节点上时可能重复处理，订阅者应按事件ID去重
+
+const (
+	ManageGetDeadLetters       = "GetDeadLetters"
+	ManageRedeliverDeadLetters = "RedeliverDeadLetters"
+
+	defaultEventWorkers      = 10
+	defaultEventQueue        = 1000
+	defaultEventRetries      = 3
+	defaultEventRetryBackoff = 100 //毫秒
+	defaultEventDeadLetters  = 1000
+	maxEventRetryBackoff     = 30 * time.Second
+)
+
+var (
+	eventPublished = hmetrics.NewCounterVec("has_event_published_total", "events published", "topic")
+	eventDelivered = hmetrics.NewCounterVec("has_event_delivered_total", "events handled by subscriber slots", "topic", "subscriber")
+	eventRetries   = hmetrics.NewCounterVec("has_event_retries_total", "event deliveries retried", "topic", "subscriber")
+	eventDead      = hmetrics.NewCounterVec("has_event_dead_letters_total", "events failed to deliver and dead-lettered", "topic", "subscriber")
+)
+
+// Event 发布的事件
+type Event struct {
+	ID        string     `json:"id"`
+	Topic     string     `json:"topic"`
+	Data      htypes.Map `json:"data"`
+	Source    string     `json:"source"` //发布事件的Server EID
+	Node      string     `json:"node"`   //发布事件的进程，经消息队列收到本进程发布的事件时忽略
+	RequestID string     `json:"request_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// DeadLetter 投递失败的事件
+type DeadLetter struct {
+	ID         string         `json:"id"`
+	Event      *Event         `json:"event"`
+	Subscriber string         `json:"subscriber"` //service.slot
+	Attempts   int            `json:"attempts"`
+	Error      *herrors.Error `json:"error"`
+	FailedAt   time.Time      `json:"failed_at"`
+}
+
+// IDeadLetterStore 死信存储
+type IDeadLetterStore interface {
+	Save(d *DeadLetter) *herrors.Error
+	List(topic string) ([]*DeadLetter, *herrors.Error) //按失败时间排序，topic为空时返回全部
+	Delete(ids []string) *herrors.Error
+	Count() (int64, *herrors.Error)
+}
+
+// IDeadLetterStoreProvider 可持久化死信的插件，通过Server配置项EventDeadLetterStore指定
+type IDeadLetterStoreProvider interface {
+	DeadLetterStore() (IDeadLetterStore, *herrors.Error)
+}
+
+// IEventPublisher 可发布事件的Server，ServerImplement实现该接口
+type IEventPublisher interface {
+	//event为htypes.Map或结构，作为订阅slot的参数。订阅者处理前即返回
+	PublishEvent(ctx context.Context, topic string, event htypes.Any) *herrors.Error
+}
+
+// PublishEvent 经Server发布事件，Server未实现IEventPublisher时返回错误
+func PublishEvent(ctx context.Context, s IServer, topic string, event htypes.Any) *herrors.Error {
+	p, ok := s.(IEventPublisher)
+	if !ok {
+		return herrors.ErrSysInternal.New("server %T not implement IEventPublisher", s)
+	}
+	return p.PublishEvent(ctx, topic, event)
+}
+
+// IEventSubscriber 订阅事件的服务，服务打开时订阅
+type IEventSubscriber interface {
+	Subscriptions() map[string]string //主题 -> 处理事件的slot
+}
+
+// IEventBridge 在节点间传递事件的消息队列
+type IEventBridge interface {
+	Publish(topic string, data []byte) *herrors.Error
+	//同一group只由其中一个订阅者处理，group为订阅服务的名称。handler处理完成后才返回，返回错误时消息应重新投递
+	Subscribe(topic string, group string, handler func(data []byte) *herrors.Error) *herrors.Error
+	Unsubscribe(topic string, group string)
+}
+
+// IEventBridgeProvider 可提供事件桥接的插件，通过Server配置项EventBridge指定
+type IEventBridgeProvider interface {
+	EventBridge() (IEventBridge, *herrors.Error)
+}
+
+type eventContextKey struct{}
+
+// EventFromContext 返回当前处理的事件，订阅者可按事件ID去重。非事件触发的调用返回nil
+func EventFromContext(ctx context.Context) *Event {
+	ev, _ := ctx.Value(eventContextKey{}).(*Event)
+	return ev
+}
+
+type eventSubscriber struct {
+	service string
+	slot    string
+}
+
+func (this *eventSubscriber) String() string {
+	return this.service + "." + this.slot
+}
+
+// subscriberOf 死信中记录的订阅者
+func subscriberOf(d *DeadLetter) *eventSubscriber {
+	service, slot, _ := strings.Cut(d.Subscriber, ".")
+	return &eventSubscriber{service: service, slot: slot}
+}
+
+type eventBus struct {
+	server      *ServerImplement
+	node        string
+	bridge      IEventBridge
+	bridgeAll   bool
+	bridged     map[string]bool
+	retries     int
+	backoff     time.Duration
+	workers     chan struct{}
+	limit       int64 //投递中及等待投递的事件上限
+	store       IDeadLetterStore
+	lock        sync.RWMutex
+	subscribers map[string][]*eventSubscriber //主题 -> 订阅者
+	groups      [][2]string                   //经桥接订阅的主题及group
+	topics      sync.Map                      //发布或订阅过的主题
+	pending     atomic.Int64
+	closing     atomic.Bool
+	ctx         context.Context
+	cancel      context.CancelFunc
+}
+
+// newEventBus store为nil时死信保存在内存
+func newEventBus(s *ServerImplement, bridge IEventBridge, store IDeadLetterStore) *eventBus {
+	conf := &s.conf
+	b := &eventBus{
+		server:      s,
+		node:        hrandom.UuidWithoutDash(),
+		bridge:      bridge,
+		bridged:     make(map[string]bool),
+		retries:     conf.EventRetries,
+		backoff:     time.Duration(conf.EventRetryBackoff) * time.Millisecond,
+		store:       store,
+		subscribers: make(map[string][]*eventSubscriber),
+	}
+
+	workers, queue := conf.EventWorkers, conf.EventQueue
+	if workers <= 0 {
+		workers = defaultEventWorkers
+	}
+	if queue <= 0 {
+		queue = defaultEventQueue
+	}
+	b.workers = make(chan struct{}, workers)
+	b.limit = int64(workers + queue)
+	if b.retries == 0 {
+		b.retries = defaultEventRetries
+	} else if b.retries < 0 {
+		b.retries = 0
+	}
+	if b.backoff <= 0 {
+		b.backoff = defaultEventRetryBackoff * time.Millisecond
+	}
+	if b.store == nil {
+		b.store = newMemDeadLetterStore(conf.EventDeadLetters)
+	}
+
+	for _, t := range strings.Split(conf.EventBridgeTopics, ",") {
+		if t = strings.TrimSpace(t); t == "*" {
+			b.bridgeAll = true
+		} else if t != "" {
+			b.bridged[t] = true
+		}
+	}
+	if len(b.bridged) == 0 {
+		b.bridgeAll = true
+	}
+
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	return b
+}
+
+func (this *eventBus) isBridged(topic string) bool {
+	return this.bridge != nil && (this.bridgeAll || this.bridged[topic])
+}
+
+// subscribe 订阅服务声明的主题，slot不存在时返回错误
+func (this *eventBus) subscribe(service IService) *herrors.Error {
+	s, ok := service.(IEventSubscriber)
+	if !ok {
+		return nil
+	}
+
+	subs := s.Subscriptions()
+	topics := make([]string, 0, len(subs))
+	for topic, slot := range subs {
+		if service.Slot(slot) == nil {
+			return herrors.ErrSysInternal.New("slot [%s.%s] subscribing topic [%s] not found", service.Name(), slot, topic)
+		}
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	for _, topic := range topics {
+		this.lock.Lock()
+		this.subscribers[topic] = append(this.subscribers[topic], &eventSubscriber{service: service.Name(), slot: subs[topic]})
+		this.lock.Unlock()
+		this.topics.Store(topic, true)
+
+		if !this.isBridged(topic) {
+			continue
+		}
+		group := service.Name()
+		if err := this.bridge.Subscribe(topic, group, this.bridgeHandler(topic, group)); err != nil {
+			return err
+		}
+		this.lock.Lock()
+		this.groups = append(this.groups, [2]string{topic, group})
+		this.lock.Unlock()
+	}
+	return nil
+}
+
+// bridgeHandler 从消息队列收到的事件投递给group对应的服务，投递完成(或进入死信)后才返回，
+// 消息队列此时才确认消息。本进程发布的事件已直接投递，不再处理
+func (this *eventBus) bridgeHandler(topic string, group string) func(data []byte) *herrors.Error {
+	return func(data []byte) *herrors.Error {
+		ev := new(Event)
+		if err := jsoniter.Unmarshal(data, ev); err != nil {
+			//无法解析的消息重投也不会成功
+			hlogger.Error("invalid event received on topic [%s]: %v", topic, err)
+			return nil
+		}
+		if ev.Node == this.node {
+			return nil
+		}
+		if this.closing.Load() {
+			return herrors.ErrSysBusy.New("server is shutting down")
+		}
+
+		subs := this.subscribersOf(ev.Topic, group)
+		this.pending.Add(int64(len(subs)))
+		defer this.pending.Sub(int64(len(subs)))
+		for _, sub := range subs {
+			if err := this.deliver(ev, sub, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// subscribersOf 主题的订阅者，service不为空时只返回该服务
+func (this *eventBus) subscribersOf(topic string, service string) []*eventSubscriber {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	var ret []*eventSubscriber
+	for _, sub := range this.subscribers[topic] {
+		if service == "" || sub.service == service {
+			ret = append(ret, sub)
+		}
+	}
+	return ret
+}
+
+func (this *eventBus) publish(ctx context.Context, topic string, event htypes.Any) *herrors.Error {
+	if this.closing.Load() {
+		return herrors.ErrSysBusy.New("server is shutting down")
+	}
+	if topic == "" {
+		return herrors.ErrCallerInvalidRequest.New("event topic cannot be empty")
+	}
+
+	data, err := encodeEvent(topic, event)
+	if err != nil {
+		return err
+	}
+	ev := &Event{
+		ID:        hrandom.UuidWithoutDash(),
+		Topic:     topic,
+		Data:      data,
+		Source:    this.server.conf.EID,
+		Node:      this.node,
+		CreatedAt: time.Now(),
+	}
+	if scope := ScopeFromContext(ctx); scope != nil {
+		ev.RequestID = scope.ID
+	}
+	var bs []byte
+	if this.isBridged(topic) {
+		var e error
+		if bs, e = jsoniter.Marshal(ev); e != nil {
+			return herrors.ErrCallerInvalidRequest.New("failed to encode event of topic [%s]: %v", topic, e)
+		}
+	}
+
+	//本节点的订阅者直接投递，桥接的主题再经消息队列发给其他节点
+	if err = this.dispatch(ev, this.subscribersOf(topic, "")); err != nil {
+		return err
+	}
+	eventPublished.With(topic).Inc()
+	this.topics.Store(topic, true)
+	if bs != nil {
+		return this.bridge.Publish(topic, bs)
+	}
+	return nil
+}
+
+// encodeEvent 事件为htypes.Map或结构，结构按json标签转换
+func encodeEvent(topic string, event htypes.Any) (htypes.Map, *herrors.Error) {
+	if m, ok := event.(htypes.Map); ok {
+		return m, nil
+	}
+
+	v := reflect.ValueOf(event)
+	if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return htypes.Map{}, nil
+	}
+	if derefType(v.Type()).Kind() != reflect.Struct {
+		return nil, herrors.ErrCallerInvalidRequest.New("event of topic [%s] must be a struct, but got %T", topic, event)
+	}
+
+	data, err := encodeParams(event)
+	if err != nil {
+		return nil, herrors.ErrCallerInvalidRequest.New("failed to encode event of topic [%s]: %v", topic, err)
+	}
+	return data, nil
+}
+
+// dispatch 异步投递给订阅者。每个投递占用一个goroutine，投递中及等待投递的事件超出上限时拒绝
+func (this *eventBus) dispatch(ev *Event, subs []*eventSubscriber) *herrors.Error {
+	n := int64(len(subs))
+	if n == 0 {
+		return nil
+	}
+	if this.pending.Add(n) > this.limit {
+		this.pending.Sub(n)
+		return herrors.ErrSysBusy.New("too many pending events")
+	}
+
+	for _, sub := range subs {
+		go func(sub *eventSubscriber) {
+			defer this.pending.Dec()
+			_ = this.deliver(ev, sub, false)
+		}(sub)
+	}
+	return nil
+}
+
+// deliver 投递事件，失败的事件进入死信。因退出而中断时，经消息队列收到的事件返回错误由消息队列重新投递
+func (this *eventBus) deliver(ev *Event, sub *eventSubscriber, bridged bool) *herrors.Error {
+	interrupted := func(attempts int, err *herrors.Error) *herrors.Error {
+		if bridged {
+			return herrors.ErrSysBusy.New("event delivery interrupted by server shutdown")
+		}
+		if err == nil {
+			err = herrors.ErrSysInternal.New("event delivery interrupted by server shutdown")
+		}
+		this.deadLetter(ev, sub, attempts, err)
+		return nil
+	}
+
+	ctx := context.WithValue(this.ctx, eventContextKey{}, ev)
+	ctx = ContextWithScope(ctx, NewRequestScope(ev.RequestID))
+	for attempt := 1; ; attempt++ {
+		select {
+		case this.workers <- struct{}{}:
+		case <-this.ctx.Done():
+			return interrupted(attempt-1, nil)
+		}
+
+		//slot可能修改参数，每次投递使用副本
+		params := make(htypes.Map, len(ev.Data))
+		for k, v := range ev.Data {
+			params[k] = v
+		}
+		_, err := this.server.RequestServiceContext(ctx, sub.service, sub.slot, params)
+		<-this.workers
+
+		if err == nil {
+			eventDelivered.With(ev.Topic, sub.String()).Inc()
+			return nil
+		}
+		if !failedCall(err) || attempt > this.retries {
+			this.deadLetter(ev, sub, attempt, err)
+			return nil
+		}
+
+		eventRetries.With(ev.Topic, sub.String()).Inc()
+		backoff := this.backoff << (attempt - 1)
+		if backoff <= 0 || backoff > maxEventRetryBackoff {
+			backoff = maxEventRetryBackoff
+		}
+		select {
+		case <-time.After(backoff):
+		case <-this.ctx.Done():
+			return interrupted(attempt, err)
+		}
+	}
+}
+
+// deadLetter 保存投递失败的事件
+func (this *eventBus) deadLetter(ev *Event, sub *eventSubscriber, attempts int, err *herrors.Error) {
+	eventDead.With(ev.Topic, sub.String()).Inc()
+	hlogger.Error("event [%s] of topic [%s] dead-lettered after %d attempts to [%s]: %s", ev.ID, ev.Topic, attempts, sub, err.Error())
+
+	d := &DeadLetter{
+		ID:         hrandom.UuidWithoutDash(),
+		Event:      ev,
+		Subscriber: sub.String(),
+		Attempts:   attempts,
+		Error:      err,
+		FailedAt:   time.Now(),
+	}
+	if e := this.store.Save(d); e != nil {
+		hlogger.Error(e.D("failed to save dead letter of event [%s]", ev.ID))
+	}
+}
+
+// getDeadLetters 参数topic可选，只返回该主题的死信
+func (this *eventBus) getDeadLetters(params htypes.Map) (htypes.Any, *herrors.Error) {
+	topic, _ := params["topic"].(string)
+	dead, err := this.store.List(topic)
+	if err != nil {
+		return nil, err
+	}
+	if dead == nil {
+		dead = []*DeadLetter{}
+	}
+	return dead, nil
+}
+
+// redeliverDeadLetters 参数ids为死信ID列表，未指定时重新投递全部死信
+func (this *eventBus) redeliverDeadLetters(params htypes.Map) (htypes.Any, *herrors.Error) {
+	if this.closing.Load() {
+		return nil, herrors.ErrSysBusy.New("server is shutting down")
+	}
+
+	var ids map[string]bool
+	if v, ok := params["ids"]; ok {
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, herrors.ErrCallerInvalidRequest.New("parameter [ids] must be a list of dead letter id")
+		}
+		ids = make(map[string]bool)
+		for _, id := range list {
+			s, _ := id.(string)
+			ids[s] = true
+		}
+	}
+
+	dead, err := this.store.List("")
+	if err != nil {
+		return nil, err
+	}
+	var redeliver []*DeadLetter
+	var redeliverIDs []string
+	for _, d := range dead {
+		if ids == nil || ids[d.ID] {
+			redeliver = append(redeliver, d)
+			redeliverIDs = append(redeliverIDs, d.ID)
+		}
+	}
+	if len(redeliver) == 0 {
+		return htypes.Map{"redelivered": 0}, nil
+	}
+
+	n := int64(len(redeliver))
+	if this.pending.Add(n) > this.limit {
+		this.pending.Sub(n)
+		return nil, herrors.ErrSysBusy.New("too many pending events")
+	}
+	if err = this.store.Delete(redeliverIDs); err != nil {
+		this.pending.Sub(n)
+		return nil, err
+	}
+	for _, d := range redeliver {
+		go func(d *DeadLetter) {
+			defer this.pending.Dec()
+			_ = this.deliver(d.Event, subscriberOf(d), false)
+		}(d)
+	}
+	return htypes.Map{"redelivered": len(redeliver)}, nil
+}
+
+// load 各主题的发布、投递、重试及死信数
+func (this *eventBus) load() htypes.Map {
+	topics := make(htypes.Map)
+	this.topics.Range(func(k, _ interface{}) bool {
+		match := hmetrics.Labels{"topic": k.(string)}
+		topics[k.(string)] = htypes.Map{
+			"published": eventPublished.Sum(match),
+			"delivered": eventDelivered.Sum(match),
+			"retries":   eventRetries.Sum(match),
+			"dead":      eventDead.Sum(match),
+		}
+		return true
+	})
+
+	dead, err := this.store.Count()
+	if err != nil {
+		hlogger.Error(err.D("failed to count dead letters"))
+	}
+
+	return htypes.Map{
+		"pending":      this.pending.Load(),
+		"dead_letters": dead,
+		"topics":       topics,
+	}
+}
+
+// drain 停止接收事件，并在timeout内等待投递中的事件完成，超时后未完成的事件进入死信
+func (this *eventBus) drain(timeout time.Duration, report *shutdownReport) {
+	this.closing.Store(true)
+
+	this.lock.RLock()
+	groups := this.groups
+	this.lock.RUnlock()
+	for _, g := range groups {
+		this.bridge.Unsubscribe(g[0], g[1])
+	}
+
+	if waitUntil(timeout, func() bool { return this.pending.Load() == 0 }) {
+		report.add("events delivered")
+		return
+	}
+
+	n := this.pending.Load()
+	this.cancel()
+	waitUntil(time.Second, func() bool { return this.pending.Load() == 0 })
+	report.add("%d event deliveries interrupted after %v", n, timeout)
+}
+
+// memDeadLetterStore 缺省的死信存储，超过EventDeadLetters时丢弃最早的
+type memDeadLetterStore struct {
+	lock sync.Mutex
+	max  int
+	dead []*DeadLetter
+}
+
+func newMemDeadLetterStore(max int) *memDeadLetterStore {
+	if max <= 0 {
+		max = defaultEventDeadLetters
+	}
+	return &memDeadLetterStore{max: max}
+}
+
+func (this *memDeadLetterStore) Save(d *DeadLetter) *herrors.Error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.dead = append(this.dead, d)
+	if n := len(this.dead) - this.max; n > 0 {
+		this.dead = append([]*DeadLetter{}, this.dead[n:]...)
+	}
+	return nil
+}
+
+func (this *memDeadLetterStore) List(topic string) ([]*DeadLetter, *herrors.Error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	ret := make([]*DeadLetter, 0, len(this.dead))
+	for _, d := range this.dead {
+		if topic == "" || d.Event.Topic == topic {
+			ret = append(ret, d)
+		}
+	}
+	return ret, nil
+}
+
+func (this *memDeadLetterStore) Delete(ids []string) *herrors.Error {
+	del := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		del[id] = true
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	var keep []*DeadLetter
+	for _, d := range this.dead {
+		if !del[d.ID] {
+			keep = append(keep, d)
+		}
+	}
+	this.dead = keep
+	return nil
+}
+
+func (this *memDeadLetterStore) Count() (int64, *herrors.Error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return int64(len(this.dead)), nil
+}
+
+// getDeadLetters 实体桩在事件总线创建之前生成，管理时再取事件总线
+func (this *ServerImplement) getDeadLetters(params htypes.Map) (htypes.Any, *herrors.Error) {
+	return this.events.getDeadLetters(params)
+}
+
+func (this *ServerImplement) redeliverDeadLetters(params htypes.Map) (htypes.Any, *herrors.Error) {
+	return this.events.redeliverDeadLetters(params)
+}
@@ -1,7 +1,12 @@
 package core
 
 import (
+	"context"
+	"time"
+
 	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hstate"
+	"github.com/drharryhe/has/common/htrace"
 	"github.com/drharryhe/has/common/htypes"
 )
 
@@ -12,6 +17,9 @@ type IEntity interface {
 
 	EntityMeta() *EntityMeta
 	EntityStub() *EntityStub
+
+	//配置文件重新加载后调用，此时Config()已是新配置，old为原配置。返回错误则拒绝修改并恢复原配置
+	OnConfigReload(old IEntityConf) *herrors.Error
 }
 
 type IEntityConf interface {
@@ -31,8 +39,13 @@ type IServer interface {
 	Slot(service string, slot string) *Slot
 	Assets() IAssetManager
 
+	//Start之前注册的服务只加载配置，在Start(或Open)时按依赖关系排序后依次打开，此前不能请求；Start之后注册的服务立即打开
 	RegisterService(service IService, options htypes.Any)
 	RequestService(service string, slot string, params htypes.Map) (htypes.Any, *herrors.Error)
+	RequestServiceContext(ctx context.Context, service string, slot string, params htypes.Map) (htypes.Any, *herrors.Error)
+	RequestServiceAsync(ctx context.Context, service string, slot string, params htypes.Map) (*JobHandle, *herrors.Error)
+	Job(id string) (*Job, *herrors.Error)
+	InvalidateSlotCache(service string, slot string, params htypes.Map) *herrors.Error
 }
 
 type IService interface {
@@ -48,6 +61,7 @@ type IService interface {
 
 	//服务调用相关方法
 	Request(slot string, params htypes.Map) (htypes.Any, *herrors.Error)
+	RequestContext(ctx context.Context, slot string, params htypes.Map) (htypes.Any, *herrors.Error)
 }
 
 type IRouter interface {
@@ -55,12 +69,14 @@ type IRouter interface {
 	Close()
 
 	//服务相关方法
-	RegisterService(s IService) *herrors.Error                                                  //注册服务
-	UnRegisterService(s IService)                                                               //注销服务
-	RequestService(service string, slot string, params htypes.Map) (htypes.Any, *herrors.Error) //同步请求服务
+	RegisterService(s IService) *herrors.Error                                                                              //注册服务
+	UnRegisterService(s IService)                                                                                           //注销服务
+	RequestService(service string, slot string, params htypes.Map) (htypes.Any, *herrors.Error)                             //同步请求服务
+	RequestServiceContext(ctx context.Context, service string, slot string, params htypes.Map) (htypes.Any, *herrors.Error) //带上下文的同步请求服务
 
 	// 实体治理相关方法
 	AllEntities() []*EntityMeta
+	Entity(eid string) IEntity
 	RegisterEntity(m IEntity) *herrors.Error
 	ManageEntity(mm *EntityMeta, slot string, params htypes.Map) (htypes.Any, *herrors.Error)
 }
@@ -100,12 +116,24 @@ type IAPIGateway interface {
 	Packer(name string) IAPIDataPacker
 	I18n() IAPIi18n
 	PreRequestMiddleware(version string, api string, params htypes.Map) *herrors.Error
+	RequestScope(seq uint64) *RequestScope
 	RequestAPI(version string, api string, params htypes.Map) (htypes.Any, *herrors.Error)
 	RequestWSAPI(version string, api string, params htypes.Map) (htypes.Any, *herrors.Error)
+	RequestAPIContext(ctx context.Context, version string, api string, params htypes.Map) (htypes.Any, *herrors.Error)
+	RequestWSAPIContext(ctx context.Context, version string, api string, params htypes.Map) (htypes.Any, *herrors.Error)
+	RequestAPIAsync(ctx context.Context, version string, api string, params htypes.Map) (*JobHandle, *herrors.Error)
+	Job(ctx context.Context, id string, params htypes.Map) (*Job, *herrors.Error)
+	APIVersions() []string
+	APIDocument(version string) (*APIDocument, *herrors.Error)
+
+	// 实体管理相关方法
+	AuthorizeManagement(token string) *herrors.Error
+	Entities() ([]*EntityMeta, *herrors.Error)
+	ManageEntity(serverEID string, eid string, act string, params htypes.Map) (htypes.Any, *herrors.Error)
 }
 
 type IAPIi18n interface {
-	Open() *herrors.Error
+	Open(gw IAPIGateway) *herrors.Error
 	Close()
 	Translate(lang string, text string) string
 }
@@ -113,4 +141,20 @@ type IAPIi18n interface {
 type IAssetManager interface {
 	Init() *herrors.Error
 	File(path string) ([]byte, *herrors.Error)
+	Files(dir string, ext string) ([]string, *herrors.Error) //目录下指定扩展名(如.json)的文件路径，不含子目录，按名称排序
+}
+
+// IAssetWatcher 可获取资源修改时间的资源管理器，用于api.json热加载
+type IAssetWatcher interface {
+	ModTime(path string) (time.Time, *herrors.Error)
+}
+
+// IStateStoreProvider 可保存运行状态的插件，通过Server配置项StateStore指定
+type IStateStoreProvider interface {
+	StateStore() (hstate.IStore, *herrors.Error)
+}
+
+// ITraceExporterProvider 可导出追踪数据的插件，通过Server配置项TraceExporter指定
+type ITraceExporterProvider interface {
+	TraceExporter() (htrace.IExporter, *herrors.Error)
 }
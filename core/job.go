@@ -0,0 +1,344 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/utils/hrandom"
+)
+
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+
+	defaultAsyncWorkers     = 10
+	defaultAsyncQueue       = 1000
+	defaultJobRetention     = 3600 //秒
+	jobProgressSaveInterval = time.Second
+)
+
+// Job 异步调用slot的任务
+type Job struct {
+	ID         string         `json:"id"`
+	Service    string         `json:"service"`
+	Slot       string         `json:"slot"`
+	Version    string         `json:"version,omitempty"` //经网关提交时的接口版本及名称，查询时按该接口执行in middleware
+	API        string         `json:"api,omitempty"`
+	Owner      string         `json:"owner,omitempty"` //提交者，网关配置项UserField对应的参数值，只有提交者可以查询
+	Node       string         `json:"-"`               //执行任务的Server EID
+	Status     string         `json:"status"`
+	Progress   float64        `json:"progress"` //进度，0~1
+	Result     htypes.Any     `json:"result,omitempty"`
+	Error      *herrors.Error `json:"error,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	StartedAt  *time.Time     `json:"started_at,omitempty"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+}
+
+func (this *Job) Finished() bool {
+	return this.Status == JobSucceeded || this.Status == JobFailed
+}
+
+// IJobStore 任务持久化，使任务状态在重启后仍可查询
+type IJobStore interface {
+	Save(job *Job) *herrors.Error
+	Load(id string) (*Job, *herrors.Error)           //任务不存在时返回nil, nil
+	Unfinished(node string) ([]*Job, *herrors.Error) //node(Server EID)执行的未结束的任务，多个节点共用存储时不返回其他节点的任务
+}
+
+// IJobStoreProvider 可提供任务持久化的插件，通过Server配置项JobStore指定
+type IJobStoreProvider interface {
+	JobStore() (IJobStore, *herrors.Error)
+}
+
+// JobHandle 异步调用返回的句柄
+type JobHandle struct {
+	ID string
+
+	entry *jobEntry
+}
+
+// Job 返回任务当前状态
+func (this *JobHandle) Job() *Job {
+	return this.entry.snapshot()
+}
+
+// Done 任务结束时关闭
+func (this *JobHandle) Done() <-chan struct{} {
+	return this.entry.done
+}
+
+// Wait 等待任务结束并返回结果，ctx结束时返回ctx的错误，任务不受影响
+func (this *JobHandle) Wait(ctx context.Context) (htypes.Any, *herrors.Error) {
+	select {
+	case <-this.entry.done:
+		job := this.entry.snapshot()
+		return job.Result, job.Error
+	case <-ctx.Done():
+		return nil, ContextError(ctx)
+	}
+}
+
+type jobContextKey struct{}
+
+// ReportJobProgress 在异步执行的slot中报告进度(0~1)，同步调用时忽略。进度按间隔写入任务存储
+func ReportJobProgress(ctx context.Context, progress float64) {
+	e, ok := ctx.Value(jobContextKey{}).(*jobEntry)
+	if !ok {
+		return
+	}
+
+	var job *Job
+	e.update(func(j *Job) {
+		j.Progress = progress
+		if now := time.Now(); now.Sub(e.saved) >= jobProgressSaveInterval {
+			e.saved = now
+			snapshot := *j
+			job = &snapshot
+		}
+	})
+	if job != nil && e.manager != nil {
+		e.manager.save(job)
+	}
+}
+
+// JobIDFromContext 返回当前异步任务的ID，同步调用时返回空字符串
+func JobIDFromContext(ctx context.Context) string {
+	if e, ok := ctx.Value(jobContextKey{}).(*jobEntry); ok {
+		return e.job.ID
+	}
+	return ""
+}
+
+type jobEntry struct {
+	lock    sync.RWMutex
+	job     Job
+	done    chan struct{}
+	manager *jobManager
+	saved   time.Time //上次保存进度的时间
+}
+
+func (this *jobEntry) snapshot() *Job {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	job := this.job
+	return &job
+}
+
+func (this *jobEntry) update(f func(job *Job)) *Job {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	f(&this.job)
+	job := this.job
+	return &job
+}
+
+// memJobStore 缺省的任务存储，已结束的任务由jobManager按保留时间清理
+type memJobStore struct{}
+
+func (this *memJobStore) Save(_ *Job) *herrors.Error {
+	return nil
+}
+
+func (this *memJobStore) Load(_ string) (*Job, *herrors.Error) {
+	return nil, nil
+}
+
+func (this *memJobStore) Unfinished(_ string) ([]*Job, *herrors.Error) {
+	return nil, nil
+}
+
+type jobManager struct {
+	server    *ServerImplement
+	store     IJobStore
+	retention time.Duration
+	workers   chan struct{}
+	limit     int64    //执行及等待执行的任务上限
+	entries   sync.Map //id -> *jobEntry
+	running   atomic.Int64
+	closing   atomic.Bool
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+func newJobManager(s *ServerImplement, store IJobStore, workers int, queue int, retention int) *jobManager {
+	if workers <= 0 {
+		workers = defaultAsyncWorkers
+	}
+	if queue <= 0 {
+		queue = defaultAsyncQueue
+	}
+	if retention <= 0 {
+		retention = defaultJobRetention
+	}
+
+	m := &jobManager{
+		server:    s,
+		store:     store,
+		retention: time.Duration(retention) * time.Second,
+		workers:   make(chan struct{}, workers),
+		limit:     int64(workers + queue),
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+
+	//本节点上次退出时未结束的任务已无法继续执行，其他节点的任务可能仍在执行
+	jobs, err := store.Unfinished(s.conf.EID)
+	if err != nil {
+		hlogger.Error(err.D("failed to load unfinished jobs"))
+	}
+	for _, job := range jobs {
+		m.finish(job, nil, herrors.ErrSysInternal.New("job interrupted by server restart"))
+		if err := store.Save(job); err != nil {
+			hlogger.Error(err.D("failed to save job [%s]", job.ID))
+		}
+	}
+
+	return m
+}
+
+func (this *jobManager) submit(ctx context.Context, service string, slot string, params htypes.Map) (*JobHandle, *herrors.Error) {
+	if this.closing.Load() {
+		return nil, herrors.ErrSysBusy.New("server is shutting down")
+	}
+	//每个任务占用一个goroutine，超出上限时拒绝
+	if this.running.Inc() > this.limit {
+		this.running.Dec()
+		return nil, herrors.ErrSysBusy.New("too many pending jobs")
+	}
+
+	e := &jobEntry{
+		job: Job{
+			ID:        hrandom.UuidWithoutDash(),
+			Service:   service,
+			Slot:      slot,
+			Node:      this.server.conf.EID,
+			Status:    JobPending,
+			CreatedAt: time.Now(),
+		},
+		done:    make(chan struct{}),
+		manager: this,
+	}
+	scope := ScopeFromContext(ctx)
+	if scope != nil {
+		e.job.Version = scope.Version
+		e.job.API = scope.API
+		e.job.Owner = scope.jobOwner
+	}
+	if err := this.store.Save(e.snapshot()); err != nil {
+		this.running.Dec()
+		return nil, err
+	}
+	this.entries.Store(e.job.ID, e)
+
+	//任务不受调用方的取消及超时影响，仅沿用请求ID
+	jobCtx := context.WithValue(this.ctx, jobContextKey{}, e)
+	if scope != nil {
+		jobCtx = ContextWithScope(jobCtx, NewRequestScope(scope.ID))
+	}
+
+	ps := make(htypes.Map, len(params))
+	for k, v := range params {
+		ps[k] = v
+	}
+
+	go this.run(jobCtx, e, ps)
+
+	return &JobHandle{ID: e.job.ID, entry: e}, nil
+}
+
+func (this *jobManager) run(ctx context.Context, e *jobEntry, params htypes.Map) {
+	defer this.running.Dec()
+
+	select {
+	case this.workers <- struct{}{}:
+		defer func() { <-this.workers }()
+	case <-ctx.Done():
+		this.complete(e, nil, herrors.ErrSysInternal.New("job interrupted by server shutdown"))
+		return
+	}
+
+	job := e.update(func(job *Job) {
+		now := time.Now()
+		job.Status = JobRunning
+		job.StartedAt = &now
+	})
+	this.save(job)
+
+	ret, err := this.server.RequestServiceContext(ctx, job.Service, job.Slot, params)
+	if err == nil && ctx.Err() != nil {
+		err = herrors.ErrSysInternal.New("job interrupted by server shutdown")
+	}
+	this.complete(e, ret, err)
+}
+
+func (this *jobManager) complete(e *jobEntry, ret htypes.Any, err *herrors.Error) {
+	job := e.update(func(job *Job) {
+		this.finish(job, ret, err)
+	})
+	this.save(job)
+	close(e.done)
+
+	//持久化的任务从存储中查询，内存中只保留一段时间
+	time.AfterFunc(this.retention, func() {
+		this.entries.Delete(job.ID)
+	})
+}
+
+func (this *jobManager) finish(job *Job, ret htypes.Any, err *herrors.Error) {
+	now := time.Now()
+	job.FinishedAt = &now
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = err
+	} else {
+		job.Status = JobSucceeded
+		job.Result = ret
+		job.Progress = 1
+	}
+}
+
+func (this *jobManager) save(job *Job) {
+	if err := this.store.Save(job); err != nil {
+		hlogger.Error(err.D("failed to save job [%s]", job.ID))
+	}
+}
+
+func (this *jobManager) get(id string) (*Job, *herrors.Error) {
+	if v, ok := this.entries.Load(id); ok {
+		return v.(*jobEntry).snapshot(), nil
+	}
+
+	job, err := this.store.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, herrors.ErrCallerInvalidRequest.New("job [%s] not found", id)
+	}
+	return job, nil
+}
+
+// drain 停止接收新任务，并在timeout内等待执行中的任务完成，超时后取消剩余任务
+func (this *jobManager) drain(timeout time.Duration, report *shutdownReport) {
+	this.closing.Store(true)
+
+	if waitUntil(timeout, func() bool { return this.running.Load() == 0 }) {
+		report.add("async jobs finished")
+		return
+	}
+
+	n := this.running.Load()
+	this.cancel()
+	waitUntil(time.Second, func() bool { return this.running.Load() == 0 })
+	report.add("%d async jobs interrupted after %v", n, timeout)
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+)
+
+// testJobStore 多个节点共用的任务存储
+type testJobStore struct {
+	lock sync.Mutex
+	jobs map[string]Job
+}
+
+func (this *testJobStore) Save(job *Job) *herrors.Error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.jobs[job.ID] = *job
+	return nil
+}
+
+func (this *testJobStore) Load(id string) (*Job, *herrors.Error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if job, ok := this.jobs[id]; ok {
+		return &job, nil
+	}
+	return nil, nil
+}
+
+func (this *testJobStore) Unfinished(node string) ([]*Job, *herrors.Error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	var ret []*Job
+	for id := range this.jobs {
+		if job := this.jobs[id]; job.Node == node && !job.Finished() {
+			ret = append(ret, &job)
+		}
+	}
+	return ret, nil
+}
+
+func jobTestServer(eid string) *ServerImplement {
+	s := &ServerImplement{}
+	s.conf.EID = eid
+	return s
+}
+
+func TestJobManagerRestart(t *testing.T) {
+	store := &testJobStore{jobs: make(map[string]Job)}
+
+	//节点a的任务等待执行中
+	a := newJobManager(jobTestServer("a"), store, 1, 1, 0)
+	a.workers <- struct{}{}
+	handle, err := a.submit(context.Background(), "svc", "Slot", htypes.Map{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.cancel()
+
+	//节点b重启，只结束b上次未结束的任务
+	if err := store.Save(&Job{ID: "b1", Node: "b", Status: JobRunning}); err != nil {
+		t.Fatal(err)
+	}
+	newJobManager(jobTestServer("b"), store, 1, 1, 0)
+
+	if job, _ := store.Load(handle.ID); job.Node != "a" || job.Status != JobPending {
+		t.Errorf("job of node a changed by restart of node b: %+v", job)
+	}
+	if job, _ := store.Load("b1"); job.Status != JobFailed || job.Error == nil {
+		t.Errorf("unfinished job of node b not failed: %+v", job)
+	}
+}
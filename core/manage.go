@@ -0,0 +1,92 @@
+package core
+
+import (
+	"crypto/subtle"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+)
+
+//实体远程管理。网关配置ManageToken后，connector可对外提供管理接口，
+//用于列出全部实体，调用实体的管理方法(Ping、GetConfig、UpdateConfigItems、Enable、Disable等)。
+//其他节点上的实体按ServerEID定位，由实现IClusterRouter的路由转发
+
+const (
+	ManageEnable  = "Enable"
+	ManageDisable = "Disable"
+
+	RpcManageRequestName = "HandleEntityManaged"
+)
+
+type RpcManageArguments struct {
+	EID    string
+	Act    string
+	Params map[string]interface{}
+}
+
+// IClusterRouter 可跨节点管理实体的路由
+type IClusterRouter interface {
+	RemoteEntities() ([]*EntityMeta, *herrors.Error)
+	ManageRemoteEntity(mm *EntityMeta, act string, params htypes.Map) (htypes.Any, *herrors.Error)
+}
+
+// 可在运行期间停用的实体类型
+var disableableTypes = map[string]bool{
+	EntityTypeService:    true,
+	EntityTypeMiddleware: true,
+	EntityTypeConnector:  true,
+}
+
+// EntityDisabled 实体是否已停用，与配置修改之间加锁，路由等在请求处理中使用
+func EntityDisabled(ins htypes.Any) bool {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	e, ok := ins.(IEntity)
+	return ok && e.Config().GetDisabled()
+}
+
+// setDisabled 修改配置项Disabled，与重新加载配置相同，由Owner.OnConfigReload决定是否接受
+func (this *EntityStub) setDisabled(disabled bool) *herrors.Error {
+	owner := this.options.Owner
+	if meta := owner.EntityMeta(); meta == nil || !disableableTypes[meta.Type] {
+		return herrors.ErrCallerInvalidRequest.New("[%s] can not be enabled or disabled", owner.Class())
+	}
+
+	return this.changeConfig(func(conf IEntityConf) *herrors.Error {
+		conf.SetDisabled(disabled)
+		return nil
+	})
+}
+
+// AuthorizeManagement 校验管理口令，未配置ManageToken时不允许远程管理
+func (this *APIGateWayImplement) AuthorizeManagement(token string) *herrors.Error {
+	if this.conf.ManageToken == "" {
+		return herrors.ErrCallerUnauthorizedAccess.New("entity management disabled")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(this.conf.ManageToken)) != 1 {
+		return herrors.ErrCallerUnauthorizedAccess.New("invalid manage token")
+	}
+	return nil
+}
+
+// Entities 本节点及其他节点的全部实体
+func (this *APIGateWayImplement) Entities() ([]*EntityMeta, *herrors.Error) {
+	ret := this.router.AllEntities()
+	if c, ok := this.router.(IClusterRouter); ok {
+		remote, err := c.RemoteEntities()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, remote...)
+	}
+	return ret, nil
+}
+
+// ManageEntity 管理实体，serverEID为空表示本节点
+func (this *APIGateWayImplement) ManageEntity(serverEID string, eid string, act string, params htypes.Map) (htypes.Any, *herrors.Error) {
+	if params == nil {
+		params = make(htypes.Map)
+	}
+	return this.router.ManageEntity(&EntityMeta{ServerEID: serverEID, EID: eid}, act, params)
+}
@@ -0,0 +1,239 @@
+package core
+
+import (
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/common/hmetrics"
+	"github.com/drharryhe/has/common/htypes"
+)
+
+//网关、路由及服务的运行指标，记录在hmetrics.Default中，可由connector或Server配置项MetricsPort导出为Prometheus格式。
+//插件及路由通过ILoadReporter提供的数值指标(如连接池状态)在导出时采集
+
+const (
+	MetricsPath = "/metrics"
+
+	defaultMetricsAddr = "127.0.0.1"
+)
+
+var (
+	apiRequests = hmetrics.NewCounterVec("has_api_requests_total", "API requests handled by the gateway", "version", "api")
+	apiErrors   = hmetrics.NewCounterVec("has_api_errors_total", "API requests failed, by error code", "version", "api", "code")
+	apiDuration = hmetrics.NewHistogramVec("has_api_request_duration_seconds", "API request latency", nil, "version", "api")
+	apiInflight = hmetrics.NewGaugeVec("has_api_inflight_requests", "API requests in progress", "version", "api")
+
+	apiRetries     = hmetrics.NewCounterVec("has_api_retries_total", "service calls retried by the gateway", "api")
+	apiFallbacks   = hmetrics.NewCounterVec("has_api_fallbacks_total", "failed API requests handled by the fallback slot", "api")
+	apiRejected    = hmetrics.NewCounterVec("has_api_bulkhead_rejected_total", "API requests rejected by max concurrent limit", "api")
+	apiRateLimited = hmetrics.NewCounterVec("has_api_rate_limited_total", "API requests rejected by the rate limiter", "api")
+
+	routerRequests = hmetrics.NewCounterVec("has_router_requests_total", "service requests dispatched by the router", "router", "service")
+	routerErrors   = hmetrics.NewCounterVec("has_router_errors_total", "service requests failed, by error code", "router", "service", "code")
+	routerDuration = hmetrics.NewHistogramVec("has_router_request_duration_seconds", "service request latency seen by the router", nil, "router", "service")
+
+	slotRequests   = hmetrics.NewCounterVec("has_slot_requests_total", "slot calls", "service", "slot")
+	slotErrors     = hmetrics.NewCounterVec("has_slot_errors_total", "slot calls failed, by error code", "service", "slot", "code")
+	slotDuration   = hmetrics.NewHistogramVec("has_slot_duration_seconds", "slot call latency", nil, "service", "slot")
+	slotInflight   = hmetrics.NewGaugeVec("has_slot_inflight_requests", "slot calls in progress", "service", "slot")
+	limiterRejects = hmetrics.NewCounterVec("has_slot_rate_limited_total", "slot calls rejected by the rate limiter", "service", "slot")
+)
+
+func init() {
+	hmetrics.Default.Register(breakerSamples)
+}
+
+// observe 开始记录一次请求，返回的函数在请求结束时调用
+func observe(requests *hmetrics.CounterVec, errs *hmetrics.CounterVec, duration *hmetrics.HistogramVec, inflight *hmetrics.GaugeVec, labels ...string) func(err *herrors.Error) {
+	start := time.Now()
+	requests.With(labels...).Inc()
+	var g *hmetrics.Gauge
+	if inflight != nil {
+		g = inflight.With(labels...)
+		g.Inc()
+	}
+
+	return func(err *herrors.Error) {
+		if g != nil {
+			g.Dec()
+		}
+		duration.With(labels...).Observe(time.Since(start).Seconds())
+		if err != nil {
+			errs.With(append(labels, strconv.Itoa(err.Code))...).Inc()
+		}
+	}
+}
+
+// requestStats 请求数、错误数及平均耗时(毫秒)
+func requestStats(requests *hmetrics.CounterVec, errs *hmetrics.CounterVec, duration *hmetrics.HistogramVec, match hmetrics.Labels) htypes.Map {
+	count, sum := duration.Stats(match)
+	avg := float64(0)
+	if count > 0 {
+		avg = sum * 1000 / float64(count)
+	}
+	return htypes.Map{
+		"requests":       requests.Sum(match),
+		"errors":         errs.Sum(match),
+		"avg_latency_ms": avg,
+	}
+}
+
+// breakerSamples 熔断器状态，1表示已熔断
+func breakerSamples() []hmetrics.Sample {
+	var ret []hmetrics.Sample
+	for cmd := range hystrix.GetCircuitSettings() {
+		ret = append(ret, hmetrics.Sample{
+			Name:   "has_breaker_open",
+			Help:   "circuit breaker state, 1 means open",
+			Labels: hmetrics.Labels{"command": cmd},
+			Value:  boolValue(breakerOpen(cmd)),
+		})
+	}
+	return ret
+}
+
+func breakerOpen(cmd string) bool {
+	cb, _, err := hystrix.GetCircuit(cmd)
+	return err == nil && cb.IsOpen()
+}
+
+// entitySamples 插件及路由通过ILoadReporter提供的数值指标，嵌套的项名以.连接
+func (this *ServerImplement) entitySamples() []hmetrics.Sample {
+	var ret []hmetrics.Sample
+	for _, meta := range this.router.AllEntities() {
+		if meta.Type != EntityTypePlugin && meta.Type != EntityTypeRouter {
+			continue
+		}
+		r, ok := this.router.Entity(meta.EID).(ILoadReporter)
+		if !ok {
+			continue
+		}
+		flattenLoad(r.Load(), nil, func(item string, v float64) {
+			ret = append(ret, hmetrics.Sample{
+				Name:   "has_entity_stats",
+				Help:   "statistics reported by plugins and routers, such as connection pools",
+				Labels: hmetrics.Labels{"type": meta.Type, "class": meta.Class, "item": item},
+				Value:  v,
+			})
+		})
+	}
+	return ret
+}
+
+func flattenLoad(m htypes.Map, path []string, f func(item string, v float64)) {
+	for k, val := range m {
+		p := append(append([]string{}, path...), k)
+		switch v := val.(type) {
+		case htypes.Map:
+			flattenLoad(v, p, f)
+		case map[string]interface{}:
+			flattenLoad(v, p, f)
+		default:
+			if n, ok := numberValue(v); ok {
+				f(strings.Join(p, "."), n)
+			}
+		}
+	}
+}
+
+func numberValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case bool:
+		return boolValue(n), true
+	case time.Duration:
+		return n.Seconds(), true
+	default:
+		return 0, false
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// serveMetrics 在单独的端口上导出指标
+func (this *ServerImplement) serveMetrics() {
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, hmetrics.Handler())
+	addr := metricsAddr(&this.conf)
+	hlogger.Info("metrics address: %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		hlogger.Error(herrors.ErrSysInternal.New(err.Error()).D("failed to serve metrics"))
+	}
+}
+
+// metricsAddr 导出指标的监听地址，未配置MetricsAddr时只监听本机
+func metricsAddr(conf *Server) string {
+	host := conf.MetricsAddr
+	if host == "" {
+		host = defaultMetricsAddr
+	}
+	return net.JoinHostPort(host, strconv.Itoa(conf.MetricsPort))
+}
+
+// Load 进程及服务请求情况
+func (this *ServerImplement) Load() htypes.Map {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	ret := requestStats(routerRequests, routerErrors, routerDuration, nil)
+	ret["goroutines"] = runtime.NumGoroutine()
+	ret["heap_alloc"] = mem.HeapAlloc
+	ret["gc_count"] = mem.NumGC
+	ret["events"] = this.events.load()
+	ret["schedules"] = this.schedules.load()
+	return ret
+}
+
+// Load API请求情况、熔断器状态及重试、降级、并发超限、限流次数
+func (this *APIGateWayImplement) Load() htypes.Map {
+	breakers := make(htypes.Map)
+	for cmd := range hystrix.GetCircuitSettings() {
+		breakers[cmd] = breakerOpen(cmd)
+	}
+
+	ret := requestStats(apiRequests, apiErrors, apiDuration, nil)
+	ret["inflight"] = this.inflight.Load()
+	ret["breakers_open"] = breakers
+	ret["retries"] = apiRetries.Sum(nil)
+	ret["fallbacks"] = apiFallbacks.Sum(nil)
+	ret["bulkhead_rejected"] = apiRejected.Sum(nil)
+	ret["rate_limited"] = apiRateLimited.Sum(nil)
+	return ret
+}
+
+// slotLoad 各slot的请求情况
+func (this *Service) slotLoad() htypes.Map {
+	ret := make(htypes.Map)
+	for slot := range this.slots {
+		match := hmetrics.Labels{"service": this.name, "slot": slot}
+		stats := requestStats(slotRequests, slotErrors, slotDuration, match)
+		stats["inflight"] = slotInflight.Sum(match)
+		stats["rate_limited"] = limiterRejects.Sum(match)
+		ret[slot] = stats
+	}
+	return ret
+}
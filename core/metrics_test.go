@@ -0,0 +1,21 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestMetricsAddr(t *testing.T) {
+	cases := []struct {
+		conf Server
+		addr string
+	}{
+		{conf: Server{MetricsPort: 9100}, addr: "127.0.0.1:9100"},
+		{conf: Server{MetricsPort: 9100, MetricsAddr: "0.0.0.0"}, addr: "0.0.0.0:9100"},
+		{conf: Server{MetricsPort: 9100, MetricsAddr: "::1"}, addr: "[::1]:9100"},
+	}
+	for _, c := range cases {
+		if addr := metricsAddr(&c.conf); addr != c.addr {
+			t.Errorf("%q: expected %s, got %s", c.conf.MetricsAddr, c.addr, addr)
+		}
+	}
+}
@@ -6,8 +6,9 @@ import (
 )
 
 type MethodCaller struct {
-	Object  reflect.Value
-	Handler reflect.Value
+	Object      reflect.Value
+	Handler     reflect.Value
+	WithContext bool //Handler第一个参数是否为context.Context
 }
 
 type CallerResponse struct {
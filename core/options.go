@@ -4,6 +4,9 @@ type ServerOptions struct {
 	Router        IRouter
 	Plugins       []IPlugin
 	AssetsManager IAssetManager
+
+	Config map[string]interface{} //内存中的配置(段名 -> 配置项)，不为nil时不读取配置文件
+	Args   *CmdArgs               //命令行参数，不为nil时不解析命令行
 }
 
 type APIGatewayOptions struct {
@@ -0,0 +1,356 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"gopkg.in/go-playground/validator.v9"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/utils/hruntime"
+)
+
+//slot参数定义的解析与校验。param标签格式：
+//	param:"require;insensitive;type:String;validate:max=10;default:abc;enum:a,b,c;min:1;max:10"
+//min/max为数组长度限制；结构体及结构体数组类型的字段会递归解析和校验
+
+// validateVar可校验的参数类型，数组及对象可使用len、dive等规则
+var validatableKinds = map[reflect.Kind]bool{
+	reflect.String: true, reflect.Bool: true,
+	reflect.Int: true, reflect.Int8: true, reflect.Int16: true, reflect.Int32: true, reflect.Int64: true,
+	reflect.Uint: true, reflect.Uint8: true, reflect.Uint16: true, reflect.Uint32: true, reflect.Uint64: true,
+	reflect.Float32: true, reflect.Float64: true,
+	reflect.Slice: true, reflect.Array: true, reflect.Map: true,
+}
+
+type ParamError struct {
+	Field  string `json:"field"`  //字段路径，如 items[0].name
+	Reason string `json:"reason"` //校验失败原因
+}
+
+type paramErrors []*ParamError
+
+func (this *paramErrors) add(field string, format string, v ...interface{}) {
+	*this = append(*this, &ParamError{Field: field, Reason: fmt.Sprintf(format, v...)})
+}
+
+func (this paramErrors) error() *herrors.Error {
+	sort.SliceStable(this, func(i, j int) bool {
+		return this[i].Field < this[j].Field
+	})
+
+	var ss []string
+	for _, e := range this {
+		ss = append(ss, fmt.Sprintf("[%s] %s", e.Field, e.Reason))
+	}
+	return herrors.ErrCallerInvalidRequest.New("invalid parameters: %s", strings.Join(ss, "; ")).WithDetails([]*ParamError(this))
+}
+
+func (this *Service) parseRequestParameters(request reflect.Type, slot *Slot) *herrors.Error {
+	slot.Params = make(map[string]*SlotParameter)
+	slot.ReqInstance = reflect.New(request.Elem()).Interface()
+
+	t := request.Elem()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Name == "SlotRequestBase" {
+			continue
+		}
+
+		//顶层参数必须是指针，以区分是否传入
+		if f.Type.Kind() != reflect.Ptr {
+			continue
+		}
+
+		p, err := parseSlotParameter(f, map[reflect.Type]bool{t: true})
+		if err != nil {
+			return err.D("failed to parse parameters of slot [%s]", slot.Name)
+		}
+		if p != nil {
+			slot.Params[p.Name] = p
+		}
+	}
+
+	return nil
+}
+
+// parseSlotParameter 解析字段的参数定义，visited用于避免自引用类型无限递归
+func parseSlotParameter(f reflect.StructField, visited map[reflect.Type]bool) (*SlotParameter, *herrors.Error) {
+	name := paramName(f)
+	if name == "" {
+		return nil, nil
+	}
+
+	p := &SlotParameter{
+		Name:   name,
+		goType: derefType(f.Type),
+	}
+
+	tag := f.Tag.Get("param")
+	if tag != "-" {
+		for k, v := range hruntime.ParseTag(tag) {
+			switch strings.TrimSpace(k) {
+			case "require":
+				p.Require = true
+			case "insensitive":
+				p.InsensitiveCase = true
+			case "validate":
+				p.Validate = v
+			case "type":
+				p.Type = v
+			case "default":
+				val, err := parseDefaultValue(v, p.goType)
+				if err != nil {
+					return nil, herrors.ErrSysInternal.New("invalid default value [%s] of parameter [%s]: %v", v, name, err)
+				}
+				p.Default = val
+			case "enum":
+				for _, e := range strings.Split(v, ",") {
+					p.Enum = append(p.Enum, strings.TrimSpace(e))
+				}
+			case "min", "max":
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					return nil, herrors.ErrSysInternal.New("invalid %s length [%s] of parameter [%s]", k, v, name)
+				}
+				if k == "min" {
+					p.MinLen = &n
+				} else {
+					p.MaxLen = &n
+				}
+			}
+		}
+	}
+
+	//结构体或结构体数组，递归解析字段
+	st := p.goType
+	if st.Kind() == reflect.Slice || st.Kind() == reflect.Array {
+		st = derefType(st.Elem())
+	}
+	if st.Kind() == reflect.Struct && !visited[st] {
+		visited[st] = true
+		p.Fields = make(map[string]*SlotParameter)
+		for i := 0; i < st.NumField(); i++ {
+			sf := st.Field(i)
+			if !sf.IsExported() || sf.Anonymous {
+				continue
+			}
+			sp, err := parseSlotParameter(sf, visited)
+			if err != nil {
+				return nil, err
+			}
+			if sp != nil {
+				p.Fields[sp.Name] = sp
+			}
+		}
+		delete(visited, st)
+	}
+
+	return p, nil
+}
+
+// checkParams 校验参数，并填充缺省值，所有校验失败的字段合并为一个错误返回
+func (this *Service) checkParams(ps htypes.Map, def map[string]*SlotParameter) *herrors.Error {
+	var errs paramErrors
+	this.checkObject("", ps, def, &errs)
+	if len(errs) > 0 {
+		return errs.error()
+	}
+	return nil
+}
+
+func (this *Service) checkObject(path string, ps map[string]interface{}, def map[string]*SlotParameter, errs *paramErrors) {
+	for _, p := range def {
+		field := p.Name
+		if path != "" {
+			field = path + "." + p.Name
+		}
+
+		v := ps[p.Name]
+		if v == nil && p.InsensitiveCase {
+			name := strings.ToLower(p.Name)
+			for k, t := range ps {
+				if strings.ToLower(k) == name {
+					v = t
+					delete(ps, k)
+					ps[p.Name] = v
+					break
+				}
+			}
+		}
+
+		if v == nil {
+			if p.Default != nil {
+				ps[p.Name] = copyDefault(p.Default)
+			} else if p.Require {
+				errs.add(field, "required parameter not found")
+			}
+			continue
+		}
+
+		this.checkValue(field, v, p, errs)
+	}
+}
+
+func (this *Service) checkValue(field string, v htypes.Any, p *SlotParameter, errs *paramErrors) {
+	if p.Type != "" {
+		if err := htypes.Validate(v, htypes.HType(p.Type)); err != nil {
+			errs.add(field, err.Error())
+			return
+		}
+	}
+
+	if len(p.Enum) > 0 {
+		s := fmt.Sprint(v)
+		found := false
+		for _, e := range p.Enum {
+			if e == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs.add(field, "value [%s] not in [%s]", s, strings.Join(p.Enum, ","))
+		}
+	}
+
+	if p.Validate != "" {
+		if err := this.validateVar(v, p.Validate); err != nil {
+			errs.add(field, err.Error())
+		}
+	}
+
+	rv := reflect.ValueOf(v)
+	isArray := rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array
+	if p.MinLen != nil || p.MaxLen != nil {
+		if !isArray {
+			errs.add(field, "array expected, but got [%s]", htypes.GetKindName(rv.Kind()))
+			return
+		}
+		if p.MinLen != nil && rv.Len() < *p.MinLen {
+			errs.add(field, "at least %d elements expected, but got %d", *p.MinLen, rv.Len())
+		}
+		if p.MaxLen != nil && rv.Len() > *p.MaxLen {
+			errs.add(field, "at most %d elements expected, but got %d", *p.MaxLen, rv.Len())
+		}
+	}
+
+	if p.Fields == nil {
+		return
+	}
+
+	if isArray {
+		for i := 0; i < rv.Len(); i++ {
+			elem := fmt.Sprintf("%s[%d]", field, i)
+			if m := asObject(rv.Index(i).Interface()); m != nil {
+				this.checkObject(elem, m, p.Fields, errs)
+			} else {
+				errs.add(elem, "object expected")
+			}
+		}
+	} else if m := asObject(v); m != nil {
+		this.checkObject(field, m, p.Fields, errs)
+	} else {
+		errs.add(field, "object expected, but got [%s]", htypes.GetKindName(rv.Kind()))
+	}
+}
+
+// validateVar 使用validator校验参数值。validator对不支持的类型(如对bool使用min/max)会panic，因此先检查类型，并将panic转为参数错误
+func (this *Service) validateVar(v htypes.Any, tag string) (herr *herrors.Error) {
+	if validate == nil {
+		validate = validator.New()
+	}
+
+	k := reflect.TypeOf(v).Kind()
+	if !validatableKinds[k] {
+		return herrors.ErrCallerInvalidRequest.New("invalid var kind [%s]", htypes.GetKindName(k))
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			herr = herrors.ErrCallerInvalidRequest.New("validate [%s] not applicable to [%s]: %v", tag, htypes.GetKindName(k), e)
+		}
+	}()
+
+	if err := validate.Var(v, tag); err != nil {
+		return herrors.ErrCallerInvalidRequest.New(err.Error())
+	}
+
+	return nil
+}
+
+// parseDefaultValue 将标签中的缺省值转换为与JSON解析结果一致的类型
+func parseDefaultValue(s string, t reflect.Type) (htypes.Any, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return s, nil
+	case reflect.Bool:
+		return strconv.ParseBool(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(s, 64)
+	default:
+		var v interface{}
+		if err := jsoniter.Unmarshal([]byte(s), &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
+
+// copyDefault 复制map及slice类型的缺省值，slot修改参数时不影响此后的请求
+func copyDefault(v htypes.Any) htypes.Any {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[k] = copyDefault(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, e := range t {
+			l[i] = copyDefault(e)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+func paramName(f reflect.StructField) string {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return f.Name
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func asObject(v htypes.Any) map[string]interface{} {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m
+	case htypes.Map:
+		return m
+	case *htypes.Map:
+		return *m
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+)
+
+type paramTestItem struct {
+	Name  string  `json:"name" param:"require;validate:max=5"`
+	Price float64 `json:"price" param:"validate:gt=0"`
+}
+
+type paramTestRequest struct {
+	SlotRequestBase
+
+	Name    *string          `json:"name" param:"require;validate:min=2"`
+	Mode    *string          `json:"mode" param:"default:fast;enum:fast,slow"`
+	Count   *int             `json:"count" param:"default:3"`
+	Enabled *bool            `json:"enabled" param:"validate:min=1"`
+	Tags    *[]string        `json:"tags" param:"min:1;max:2"`
+	Item    *paramTestItem   `json:"item"`
+	Items   *[]paramTestItem `json:"items" param:"max:2"`
+	Labels  *[]string        `json:"labels" param:"default:[\"a\"]"`
+	Options *htypes.Map      `json:"options" param:"default:{\"keys\":[\"k\"]}"`
+}
+
+func paramTestDefines(t *testing.T) map[string]*SlotParameter {
+	slot := &Slot{Name: "Test"}
+	if err := (&Service{}).parseRequestParameters(reflect.TypeOf(&paramTestRequest{}), slot); err != nil {
+		t.Fatalf("failed to parse parameters: %v", err)
+	}
+	return slot.Params
+}
+
+func TestCheckParams(t *testing.T) {
+	defs := paramTestDefines(t)
+
+	cases := []struct {
+		name   string
+		params htypes.Map
+		fields []string //校验失败的字段，为空表示通过
+	}{
+		{
+			name:   "minimal",
+			params: htypes.Map{"name": "ab"},
+		},
+		{
+			name:   "required missing",
+			params: htypes.Map{},
+			fields: []string{"name"},
+		},
+		{
+			name:   "validate tag",
+			params: htypes.Map{"name": "a"},
+			fields: []string{"name"},
+		},
+		{
+			name:   "enum",
+			params: htypes.Map{"name": "ab", "mode": "medium"},
+			fields: []string{"mode"},
+		},
+		{
+			name:   "array length",
+			params: htypes.Map{"name": "ab", "tags": []interface{}{"a", "b", "c"}},
+			fields: []string{"tags"},
+		},
+		{
+			name:   "array expected",
+			params: htypes.Map{"name": "ab", "tags": "a"},
+			fields: []string{"tags"},
+		},
+		{
+			name:   "empty array",
+			params: htypes.Map{"name": "ab", "tags": []interface{}{}},
+			fields: []string{"tags"},
+		},
+		{
+			name:   "nested object",
+			params: htypes.Map{"name": "ab", "item": map[string]interface{}{"name": "toolong", "price": float64(0)}},
+			fields: []string{"item.name", "item.price"},
+		},
+		{
+			name:   "nested object expected",
+			params: htypes.Map{"name": "ab", "item": "x"},
+			fields: []string{"item"},
+		},
+		{
+			name: "nested array",
+			params: htypes.Map{"name": "ab", "items": []interface{}{
+				map[string]interface{}{"name": "ok", "price": float64(1)},
+				map[string]interface{}{"price": float64(1)},
+			}},
+			fields: []string{"items[1].name"},
+		},
+		{
+			name:   "nested array element not object",
+			params: htypes.Map{"name": "ab", "items": []interface{}{float64(1)}},
+			fields: []string{"items[0]"},
+		},
+		{
+			name:   "validator not applicable to bool",
+			params: htypes.Map{"name": "ab", "enabled": true},
+			fields: []string{"enabled"},
+		},
+		{
+			name:   "multiple errors",
+			params: htypes.Map{"mode": "x", "tags": []interface{}{}},
+			fields: []string{"mode", "name", "tags"},
+		},
+	}
+
+	for _, c := range cases {
+		err := (&Service{}).checkParams(c.params, defs)
+		if len(c.fields) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", c.name, err)
+			}
+			continue
+		}
+
+		if err == nil {
+			t.Errorf("%s: expect errors on %v, got nil", c.name, c.fields)
+			continue
+		}
+		if err.Code != herrors.ECodeCallerInvalidRequest {
+			t.Errorf("%s: expect code %d, got %d", c.name, herrors.ECodeCallerInvalidRequest, err.Code)
+		}
+		var got []string
+		for _, e := range err.Details.([]*ParamError) {
+			got = append(got, e.Field)
+		}
+		if strings.Join(got, ",") != strings.Join(c.fields, ",") {
+			t.Errorf("%s: expect errors on %v, got %v (%s)", c.name, c.fields, got, err.Error())
+		}
+	}
+}
+
+func TestCheckParamsDefault(t *testing.T) {
+	defs := paramTestDefines(t)
+	params := htypes.Map{"name": "ab"}
+	if err := (&Service{}).checkParams(params, defs); err != nil {
+		t.Fatal(err)
+	}
+	if params["mode"] != "fast" || params["count"] != float64(3) {
+		t.Errorf("defaults not filled: %v", params)
+	}
+	if _, ok := params["tags"]; ok {
+		t.Errorf("parameter without default should not be filled: %v", params)
+	}
+
+	//修改填入的map及slice缺省值不影响此后的请求
+	params["labels"] = append(params["labels"].([]interface{})[:0], "changed")
+	keys := params["options"].(map[string]interface{})["keys"].([]interface{})
+	keys[0] = "changed"
+	params["options"].(map[string]interface{})["extra"] = true
+	next := htypes.Map{"name": "ab"}
+	if err := (&Service{}).checkParams(next, defs); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(next["labels"], next["options"]) != "[a] map[keys:[k]]" {
+		t.Errorf("defaults changed by previous request: %v %v", next["labels"], next["options"])
+	}
+}
+
+func TestParseSlotParameterInvalid(t *testing.T) {
+	type badDefault struct {
+		Count *int `json:"count" param:"default:abc"`
+	}
+	type badLen struct {
+		Tags *[]string `json:"tags" param:"min:-1"`
+	}
+
+	for _, typ := range []reflect.Type{reflect.TypeOf(&badDefault{}), reflect.TypeOf(&badLen{})} {
+		if err := (&Service{}).parseRequestParameters(typ, &Slot{Name: "Test"}); err == nil {
+			t.Errorf("%s: expect parse error", typ.Elem().Name())
+		}
+	}
+}
@@ -1,11 +1,12 @@
 package core
 
 import (
+	"reflect"
+
 	"github.com/drharryhe/has/common/hconf"
 	"github.com/drharryhe/has/common/herrors"
 	"github.com/drharryhe/has/common/hlogger"
 	"github.com/drharryhe/has/common/htypes"
-	"github.com/drharryhe/has/utils/hrandom"
 	"github.com/drharryhe/has/utils/hruntime"
 )
 
@@ -36,15 +37,55 @@ func (this *BasePlugin) Server() IServer {
 	return this.server
 }
 
+// OnConfigReload 缺省只接受EntityConfBase的修改，连接等其他设置在运行期间修改也不会生效，因此拒绝。
+// 可在运行期间应用修改的插件重写该方法
+func (this *BasePlugin) OnConfigReload(old IEntityConf) *herrors.Error {
+	if this.instance == nil {
+		return nil
+	}
+	if !sameConfig(old, this.instance.(IEntity).Config()) {
+		return herrors.ErrCallerInvalidRequest.New("settings of plugin [%s] can not be changed at runtime", this.class)
+	}
+	return nil
+}
+
+// sameConfig 两个配置除EntityConfBase外是否相同
+func sameConfig(a IEntityConf, b IEntityConf) bool {
+	return reflect.DeepEqual(withoutConfBase(a), withoutConfBase(b))
+}
+
+func withoutConfBase(conf IEntityConf) interface{} {
+	v := reflect.ValueOf(conf)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return conf
+	}
+	c := reflect.New(v.Elem().Type()).Elem()
+	c.Set(v.Elem())
+	clearConfBase(c)
+	return c.Interface()
+}
+
+// clearConfBase 清除结构及其嵌入结构中的EntityConfBase
+func clearConfBase(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if !f.Anonymous || f.Type.Kind() != reflect.Struct || !v.Field(i).CanSet() {
+			continue
+		}
+		if f.Type == reflect.TypeOf(EntityConfBase{}) {
+			v.Field(i).Set(reflect.Zero(f.Type))
+		} else {
+			clearConfBase(v.Field(i))
+		}
+	}
+}
+
 func (this *BasePlugin) Class() string {
 	return this.class
 }
 
 func (this *BasePlugin) EntityMeta() *EntityMeta {
-	if this.instance.(IEntity).Config().GetEID() == "" {
-		this.instance.(IEntity).Config().SetEID(hrandom.UuidWithoutDash())
-		hconf.Save()
-	}
+	ensureEID(this.instance.(IEntity).Config())
 
 	return &EntityMeta{
 		ServerEID: this.Server().(IEntity).Config().GetEID(),
@@ -56,5 +97,4 @@ func (this *BasePlugin) EntityMeta() *EntityMeta {
 
 func (this *BasePlugin) Capability() htypes.Any {
 	panic(herrors.ErrSysInternal.New(this.Class() + "Capability not implemented"))
-	return nil
 }
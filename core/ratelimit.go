@@ -0,0 +1,299 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/common/htypes"
+)
+
+//非阻塞限流。令牌桶每秒补充rate个令牌，容量为burst，令牌不足时立即以ErrSysBusy拒绝，
+//错误详情为*RateLimited，其中带建议的重试等待时间。限流拒绝不计入熔断，也不重试、降级。
+//服务在配置项LimitedSlots中声明；网关在配置中声明全局限流，在api.json中声明各接口的限流，均可按用户、IP、AppKey分别计数。
+//令牌桶缺省保存在本地内存，指定实现IRateLimitStoreProvider的插件(如RedisPlugin)时由各节点共享
+
+const (
+	RateLimitByAPI    = "api"
+	RateLimitByUser   = "user"   //网关配置项UserField
+	RateLimitByIP     = "ip"     //网关配置项AddressField
+	RateLimitByAppKey = "appkey" //网关配置项AppKeyField
+
+	rateSweepInterval = time.Minute
+)
+
+// IRateLimitStore 令牌桶存储。从key对应的令牌桶取一个令牌，不足时返回false及需等待的时间
+type IRateLimitStore interface {
+	Take(key string, rate float64, burst int) (bool, time.Duration, *herrors.Error)
+}
+
+// IRateLimitStoreProvider 可保存令牌桶的插件，通过服务配置项LimitPlugin或网关配置项RateLimitPlugin指定
+type IRateLimitStoreProvider interface {
+	RateLimitStore() (IRateLimitStore, *herrors.Error)
+}
+
+// RateLimited 限流拒绝时的错误详情
+type RateLimited struct {
+	RetryAfter int64 `json:"retryAfter" msgpack:"retryAfter"` //建议的重试等待(毫秒)
+}
+
+// RetryAfter 因限流被拒绝时返回建议的重试等待时间，否则返回0
+func RetryAfter(err *herrors.Error) time.Duration {
+	if err == nil {
+		return 0
+	}
+	if d, ok := err.Details.(*RateLimited); ok {
+		return time.Duration(d.RetryAfter) * time.Millisecond
+	}
+	return 0
+}
+
+// RestoreErrorDetails 远程调用返回的错误详情被解码为map，还原限流拒绝的*RateLimited
+func RestoreErrorDetails(err *herrors.Error) *herrors.Error {
+	if err == nil || err.Code != herrors.ECodeSysBusy {
+		return err
+	}
+	m, ok := err.Details.(map[string]interface{})
+	if !ok {
+		return err
+	}
+	v := reflect.ValueOf(m["retryAfter"])
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		err.Details = &RateLimited{RetryAfter: v.Int()}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		err.Details = &RateLimited{RetryAfter: int64(v.Uint())}
+	case reflect.Float32, reflect.Float64:
+		err.Details = &RateLimited{RetryAfter: int64(v.Float())}
+	}
+	return err
+}
+
+type rateRule struct {
+	rate  float64
+	burst int
+	by    []string //计数维度，api以外的值取自请求参数
+}
+
+func newRateRule(rate float64, burst int, by []string) (*rateRule, error) {
+	if rate <= 0 || burst < 0 {
+		return nil, fmt.Errorf("invalid rate limit %v:%d", rate, burst)
+	}
+	if burst == 0 {
+		burst = int(math.Ceil(rate))
+	}
+	for _, b := range by {
+		switch b {
+		case RateLimitByAPI, RateLimitByUser, RateLimitByIP, RateLimitByAppKey:
+		default:
+			return nil, fmt.Errorf("invalid rate limit dimension [%s]", b)
+		}
+	}
+	return &rateRule{rate: rate, burst: burst, by: by}, nil
+}
+
+// take 从令牌桶取一个令牌。存储出错时放行，避免存储故障导致全部请求被拒绝
+func (this *rateRule) take(store IRateLimitStore, key string, what string) *herrors.Error {
+	ok, wait, err := store.Take(key, this.rate, this.burst)
+	if err != nil {
+		hlogger.Error(err.D("failed to take rate limit token"))
+		return nil
+	}
+	if ok {
+		return nil
+	}
+
+	ms := int64(math.Ceil(float64(wait) / float64(time.Millisecond)))
+	return herrors.ErrSysBusy.New("%s rate limit exceeded, retry after %dms", what, ms).WithDetails(&RateLimited{RetryAfter: ms})
+}
+
+// parseLimitedSlots 解析服务配置项LimitedSlots，格式为 [slot:]rate[:burst],...
+// slot省略或为*时为服务整体的限流，服务整体的rate为0时使用defaultLimiter；
+// 指定slot的rate为0时该slot不限流，也不受服务整体限流
+func parseLimitedSlots(conf []string) (*rateRule, map[string]*rateRule, error) {
+	var limiter *rateRule
+	slotLimiters := make(map[string]*rateRule)
+
+	for _, s := range conf {
+		vv := strings.Split(s, ":")
+		if len(vv) == 1 {
+			vv = []string{"*", vv[0]}
+		}
+		if len(vv) > 3 {
+			return nil, nil, fmt.Errorf("invalid service limiter config [%s]", s)
+		}
+
+		slot := strings.TrimSpace(vv[0])
+		rate, err := strconv.ParseFloat(strings.TrimSpace(vv[1]), 64)
+		if err != nil || rate < 0 {
+			return nil, nil, fmt.Errorf("invalid service limiter config [%s]", s)
+		}
+		if rate == 0 && (slot == "*" || slot == "") {
+			rate = defaultLimiter
+		}
+		if rate == 0 {
+			slotLimiters[slot] = nil
+			continue
+		}
+		burst := 0
+		if len(vv) == 3 {
+			if burst, err = strconv.Atoi(strings.TrimSpace(vv[2])); err != nil {
+				return nil, nil, fmt.Errorf("invalid service limiter config [%s]", s)
+			}
+		}
+
+		r, err := newRateRule(rate, burst, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid service limiter config [%s]: %v", s, err)
+		}
+		if slot == "*" || slot == "" {
+			limiter = r
+		} else {
+			slotLimiters[slot] = r
+		}
+	}
+	return limiter, slotLimiters, nil
+}
+
+// parseRateLimitBy 解析逗号分隔的计数维度
+func parseRateLimitBy(s string) []string {
+	var ret []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// rateLimitStore plugin为空时使用本地内存
+func rateLimitStore(s IServer, plugin string) (IRateLimitStore, *herrors.Error) {
+	if plugin == "" {
+		return newMemRateStore(), nil
+	}
+	provider, ok := s.Plugin(plugin).(IRateLimitStoreProvider)
+	if !ok {
+		return nil, herrors.ErrSysInternal.New("rate limit plugin [%s] not found or not implement IRateLimitStoreProvider", plugin)
+	}
+	return provider.RateLimitStore()
+}
+
+// checkRateLimit 网关全局限流及接口限流，在middleware处理后进行，可使用middleware设置的用户字段
+func (this *APIGateWayImplement) checkRateLimit(v *API, params htypes.Map) *herrors.Error {
+	s := this.currentSettings()
+	if s.rateLimit != nil {
+		if err := s.rateLimit.take(s.rateStore, rateKey(&s.conf, "gw", s.rateLimit, v, params), "gateway"); err != nil {
+			apiRateLimited.With(v.Name).Inc()
+			return err
+		}
+	}
+	if v.rateLimit != nil {
+		if err := v.rateLimit.take(s.rateStore, rateKey(&s.conf, "api:"+v.Name, v.rateLimit, v, params), "api ["+v.Name+"]"); err != nil {
+			apiRateLimited.With(v.Name).Inc()
+			return err
+		}
+	}
+	return nil
+}
+
+func rateKey(conf *APIGateway, prefix string, r *rateRule, v *API, params htypes.Map) string {
+	key := prefix
+	for _, b := range r.by {
+		var val htypes.Any
+		switch b {
+		case RateLimitByAPI:
+			val = v.Name
+		case RateLimitByUser:
+			val = params[conf.UserField]
+		case RateLimitByIP:
+			val = params[conf.AddressField]
+		case RateLimitByAppKey:
+			val = params[conf.AppKeyField]
+		}
+		key += fmt.Sprintf("|%s=%v", b, val)
+	}
+	return key
+}
+
+// buildRateLimit 网关全局限流及令牌桶存储
+func (this *APIGateWayImplement) buildRateLimit(conf *APIGateway) (*rateRule, IRateLimitStore, *herrors.Error) {
+	store, err := rateLimitStore(&this.server, conf.RateLimitPlugin)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rule *rateRule
+	if conf.RateLimit > 0 {
+		var e error
+		if rule, e = newRateRule(float64(conf.RateLimit), conf.RateBurst, parseRateLimitBy(conf.RateLimitBy)); e != nil {
+			return nil, nil, herrors.ErrSysInternal.New("invalid gateway rate limit: %v", e)
+		}
+	}
+	return rule, store, nil
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time //补满的时间
+}
+
+// memRateStore 本地内存中的令牌桶，定期清除已补满的令牌桶
+type memRateStore struct {
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+	sweepAt time.Time
+}
+
+func newMemRateStore() *memRateStore {
+	return &memRateStore{
+		buckets: make(map[string]*tokenBucket),
+		sweepAt: time.Now().Add(rateSweepInterval),
+	}
+}
+
+func (this *memRateStore) Take(key string, rate float64, burst int) (bool, time.Duration, *herrors.Error) {
+	now := time.Now()
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if now.After(this.sweepAt) {
+		this.sweep(now)
+	}
+
+	b := this.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		this.buckets[key] = b
+	} else {
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last = now
+	}
+
+	ok := b.tokens >= 1
+	if ok {
+		b.tokens--
+	}
+	b.full = now.Add(time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second)))
+	if ok {
+		return true, 0, nil
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second)), nil
+}
+
+// sweep 已补满的令牌桶与新建的相同，可以删除
+func (this *memRateStore) sweep(now time.Time) {
+	for k, b := range this.buckets {
+		if now.After(b.full) {
+			delete(this.buckets, k)
+		}
+	}
+	this.sweepAt = now.Add(rateSweepInterval)
+}
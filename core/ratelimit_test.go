@@ -0,0 +1,111 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drharryhe/has/common/herrors"
+)
+
+func TestParseLimitedSlots(t *testing.T) {
+	cases := []struct {
+		conf    []string
+		limiter float64            //服务整体的rate，0为不限流
+		slots   map[string]float64 //slot的rate，0为不限流
+		err     bool
+	}{
+		{conf: nil, slots: map[string]float64{}},
+		{conf: []string{"10"}, limiter: 10, slots: map[string]float64{}},
+		{conf: []string{"0"}, limiter: defaultLimiter, slots: map[string]float64{}},
+		{conf: []string{"*:5:10"}, limiter: 5, slots: map[string]float64{}},
+		{conf: []string{"A:2", "B:0", "3"}, limiter: 3, slots: map[string]float64{"A": 2, "B": 0}},
+		{conf: []string{"A:-1"}, err: true},
+		{conf: []string{"A:x"}, err: true},
+		{conf: []string{"A:1:x"}, err: true},
+		{conf: []string{"A:1:2:3"}, err: true},
+	}
+	for _, c := range cases {
+		limiter, slots, err := parseLimitedSlots(c.conf)
+		if c.err {
+			if err == nil {
+				t.Errorf("%v: expected error", c.conf)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", c.conf, err)
+			continue
+		}
+		if (limiter == nil) != (c.limiter == 0) || limiter != nil && limiter.rate != c.limiter {
+			t.Errorf("%v: unexpected limiter %+v", c.conf, limiter)
+		}
+		if len(slots) != len(c.slots) {
+			t.Errorf("%v: unexpected slot limiters %v", c.conf, slots)
+		}
+		for slot, rate := range c.slots {
+			r, ok := slots[slot]
+			if !ok || (r == nil) != (rate == 0) || r != nil && r.rate != rate {
+				t.Errorf("%v: unexpected limiter %+v of slot %s", c.conf, r, slot)
+			}
+		}
+	}
+}
+
+func TestRateRuleTake(t *testing.T) {
+	r, e := newRateRule(1, 2, nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	store := newMemRateStore()
+	for i := 0; i < 2; i++ {
+		if err := r.take(store, "k", "test"); err != nil {
+			t.Fatalf("burst rejected: %v", err)
+		}
+	}
+	err := r.take(store, "k", "test")
+	if err == nil || err.Code != herrors.ECodeSysBusy {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+	if d := RetryAfter(err); d <= 0 || d > time.Second {
+		t.Errorf("unexpected retry after %v", d)
+	}
+	if err = r.take(store, "other", "test"); err != nil {
+		t.Errorf("keys not counted separately: %v", err)
+	}
+}
+
+func TestFailedCall(t *testing.T) {
+	cases := []struct {
+		err    *herrors.Error
+		failed bool
+	}{
+		{err: nil},
+		{err: herrors.ErrSysInternal.New("internal"), failed: true},
+		{err: herrors.ErrSysBusy.New("busy"), failed: true},
+		{err: herrors.ErrSysBusy.New("limited").WithDetails(&RateLimited{RetryAfter: 10})},
+		{err: herrors.ErrCallerInvalidRequest.New("invalid")},
+	}
+	for _, c := range cases {
+		if failedCall(c.err) != c.failed {
+			t.Errorf("%v: expected failed %v", c.err, c.failed)
+		}
+	}
+}
+
+func TestRestoreErrorDetails(t *testing.T) {
+	//msgpack按数值大小解码为不同的整数类型
+	for _, v := range []interface{}{int8(20), uint16(20), int64(20), float64(20)} {
+		err := RestoreErrorDetails(herrors.ErrSysBusy.New("limited").WithDetails(map[string]interface{}{"retryAfter": v}))
+		if RetryAfter(err) != 20*time.Millisecond {
+			t.Errorf("%T: details not restored %v", v, err.Details)
+		}
+	}
+
+	err := RestoreErrorDetails(herrors.ErrSysInternal.New("other").WithDetails(map[string]interface{}{"retryAfter": 20}))
+	if _, ok := err.Details.(map[string]interface{}); !ok {
+		t.Errorf("details of other errors changed: %v", err.Details)
+	}
+	if RestoreErrorDetails(nil) != nil {
+		t.Error("nil error restored")
+	}
+}
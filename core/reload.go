@@ -0,0 +1,88 @@
+package core
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/drharryhe/has/common/hconf"
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/common/htrace"
+	"github.com/drharryhe/has/utils/hruntime"
+)
+
+//配置文件热加载。Server配置ConfigWatchInterval大于0时定期检查配置文件，
+//发生变化的配置段交由对应实体的OnConfigReload处理，实体可拒绝无法在运行期间修改的配置
+
+func (this *ServerImplement) watchConfig() {
+	if this.conf.ConfigWatchInterval <= 0 || this.stopWatch != nil {
+		return
+	}
+	this.stopWatch = hconf.Watch(time.Duration(this.conf.ConfigWatchInterval)*time.Second, this.reloadConfig)
+	hlogger.Info("watching config file %s", hconf.ConfFile)
+}
+
+func (this *ServerImplement) reloadConfig(changed map[string]interface{}, err error) {
+	if err != nil {
+		hlogger.Error(herrors.ErrSysInternal.New(err.Error()).D("failed to reload config file %s", hconf.ConfFile))
+		return
+	}
+
+	if _, ok := changed[hconf.GlobalSection]; ok {
+		applyLogLevel()
+		hlogger.Info("global config reloaded")
+	}
+
+	for _, meta := range this.router.AllEntities() {
+		entity := this.router.Entity(meta.EID)
+		if entity == nil {
+			continue
+		}
+		name := hruntime.GetObjectName(entity.Config())
+		section, ok := changed[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if _, err := entity.EntityStub().Manage(ManageReloadConfig, section); err != nil {
+			hlogger.Warn("config [%s] of %s [%s] rejected: %s", name, meta.Type, meta.EID, err.Error())
+			continue
+		}
+		hlogger.Info("config [%s] of %s [%s] reloaded", name, meta.Type, meta.EID)
+	}
+}
+
+func (this *ServerImplement) OnConfigReload(old IEntityConf) *herrors.Error {
+	o := old.(*Server)
+	if o.PprofPort != this.conf.PprofPort || o.JobStore != this.conf.JobStore || o.AsyncWorkers != this.conf.AsyncWorkers || o.AsyncQueue != this.conf.AsyncQueue ||
+		o.MetricsPort != this.conf.MetricsPort || o.MetricsAddr != this.conf.MetricsAddr || o.TraceExporter != this.conf.TraceExporter ||
+		o.TraceEndpoint != this.conf.TraceEndpoint || o.TraceServiceName != this.conf.TraceServiceName {
+		return herrors.ErrCallerInvalidRequest.New("PprofPort, JobStore, AsyncWorkers, AsyncQueue, MetricsPort, MetricsAddr and trace exporter can not be changed at runtime")
+	}
+
+	if this.conf.MaxProcs > 0 && this.conf.MaxProcs != o.MaxProcs {
+		runtime.GOMAXPROCS(this.conf.MaxProcs)
+	}
+	if this.conf.TraceSampleRatio != o.TraceSampleRatio {
+		htrace.SetSampleRatio(this.conf.TraceSampleRatio)
+	}
+	if this.conf.ConfigWatchInterval != o.ConfigWatchInterval {
+		hlogger.Warn("ConfigWatchInterval takes effect after restart")
+	}
+	this.reloadCallPolicy()
+	return nil
+}
+
+// applyLogLevel 按配置项LogLevel设置日志级别，未设置时不做修改
+func applyLogLevel() {
+	name := hconf.LogLevel()
+	if name == "" {
+		return
+	}
+	level, ok := hlogger.ParseLevel(name)
+	if !ok {
+		hlogger.Warn("invalid LogLevel [%s]", name)
+		return
+	}
+	hlogger.SetLevel(level)
+}
@@ -0,0 +1,305 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+)
+
+//网关调用服务时的容错处理，RequestAPI及RequestWSAPI均经callEndpoint调用服务：
+//并发上限(bulkhead) -> 超时 -> 重试(仅幂等接口) -> 熔断 -> 服务，失败时调用降级slot。
+//服务端错误(ErrSysInternal、ErrSysBusy)计为失败，调用方错误原样返回，不重试也不降级。
+//服务间调用(Server.RequestService)经callService：并发上限 -> 超时 -> 熔断(按目标服务) -> 服务，不重试
+
+const (
+	defaultRetryBackoff = 100 //毫秒
+
+	serviceCmdPrefix     = "service:" //服务间调用的熔断器名称前缀
+	serviceBreakerMaxRun = 10000      //服务间调用的熔断器不限制并发，并发上限由ServiceMaxConcurrent控制
+	serviceBreakerNoWait = 1 << 30    //服务间调用的超时由ctx控制，熔断器不另设超时(毫秒)
+)
+
+// initResilience 检查重试、并发上限及降级设置
+func (this *API) initResilience() *herrors.Error {
+	if this.Retries < 0 || this.RetryBackoff < 0 || this.MaxConcurrent < 0 {
+		return herrors.ErrSysInternal.New("retries, retryBackoff and maxConcurrent of api [%s] can not be negative", this.Name)
+	}
+	if this.Retries > 0 && !this.Idempotent {
+		return herrors.ErrSysInternal.New("api [%s] is not idempotent and can not be retried", this.Name)
+	}
+	if this.Fallback != nil && (this.Fallback.Service == "" || this.Fallback.Slot == "") {
+		return herrors.ErrSysInternal.New("invalid fallback of api [%s]", this.Name)
+	}
+
+	if this.MaxConcurrent > 0 {
+		this.bulkhead = make(chan struct{}, this.MaxConcurrent)
+	}
+	return nil
+}
+
+// enter 占用并发数，已达上限时返回false
+func (this *API) enter() bool {
+	if this.bulkhead == nil {
+		return true
+	}
+	select {
+	case this.bulkhead <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (this *API) leave() {
+	if this.bulkhead != nil {
+		<-this.bulkhead
+	}
+}
+
+// failedCall 服务端错误，计入熔断并可重试、降级。限流拒绝是对调用方的流量控制，不视为失败
+func failedCall(err *herrors.Error) bool {
+	return err != nil && (err.Code == herrors.ECodeSysInternal || err.Code == herrors.ECodeSysBusy) && RetryAfter(err) == 0
+}
+
+// callEndpoint 调用接口映射的slot
+func (this *APIGateWayImplement) callEndpoint(ctx context.Context, v *API, params htypes.Map) (htypes.Any, *herrors.Error) {
+	if !v.enter() {
+		apiRejected.With(v.Name).Inc()
+		return nil, herrors.ErrSysBusy.New("api [%s] reached max concurrent requests %d", v.Name, v.MaxConcurrent)
+	}
+
+	ret, err := this.callTimeout(ctx, v, params)
+	if v.Fallback == nil || !failedCall(err) || ctx.Err() != nil {
+		return ret, err
+	}
+
+	apiFallbacks.With(v.Name).Inc()
+	if ret, ferr := this.server.requestService(ctx, v.Fallback.Service, v.Fallback.Slot, params); ferr == nil {
+		return ret, nil
+	} else {
+		return nil, ferr.D("fallback of api [%s] failed after: %s", v.Name, err.Error())
+	}
+}
+
+// callTimeout 超过接口或网关设置的超时时间后取消ctx并立即返回，支持ctx的slot及远程调用随之结束。
+// 调用结束时释放callEndpoint占用的并发数，超时后仍在执行的调用继续占用并发数并计入处理中的请求
+func (this *APIGateWayImplement) callTimeout(ctx context.Context, v *API, params htypes.Map) (htypes.Any, *herrors.Error) {
+	timeout := v.Timeout
+	if timeout <= 0 {
+		timeout = this.currentSettings().conf.RequestTimeout
+	}
+	if timeout <= 0 {
+		defer v.leave()
+		return this.callRetry(ctx, v, params)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+
+	type result struct {
+		ret htypes.Any
+		err *herrors.Error
+	}
+	ch := make(chan result, 1)
+	this.inflight.Inc()
+	go func() {
+		defer this.inflight.Dec()
+		defer v.leave()
+		defer cancel()
+
+		ret, err := this.callRetry(ctx, v, params)
+		ch <- result{ret: ret, err: err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.ret, r.err
+	case <-ctx.Done():
+		return nil, ContextError(ctx)
+	}
+}
+
+// callRetry 服务端错误时按指数退避重试，每次使用参数的副本
+func (this *APIGateWayImplement) callRetry(ctx context.Context, v *API, params htypes.Map) (htypes.Any, *herrors.Error) {
+	if v.Retries <= 0 {
+		return this.callBreaker(ctx, v, params)
+	}
+
+	backoff := time.Duration(v.RetryBackoff) * time.Millisecond
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff * time.Millisecond
+	}
+	for i := 0; ; i++ {
+		ret, err := this.callBreaker(ctx, v, copyParams(params))
+		if !failedCall(err) || i >= v.Retries {
+			return ret, err
+		}
+
+		apiRetries.With(v.Name).Inc()
+		select {
+		case <-time.After(backoff << uint(i)):
+		case <-ctx.Done():
+			return nil, ContextError(ctx)
+		}
+	}
+}
+
+// callBreaker 开启熔断时经熔断器调用服务，熔断器打开或并发超限时返回ErrSysBusy
+func (this *APIGateWayImplement) callBreaker(ctx context.Context, v *API, params htypes.Map) (htypes.Any, *herrors.Error) {
+	s := this.currentSettings()
+	if !s.conf.UseBreaker {
+		return this.server.requestService(ctx, v.EndPoint.Service, v.EndPoint.Slot, params)
+	}
+
+	cmd := this.cmdName(&s.conf, v)
+	if hystrix.GetCircuitSettings()[cmd] == nil {
+		hystrix.ConfigureCommand(cmd, s.breaker)
+	}
+
+	//熔断器超时后run仍可能在执行，仅在run正常返回后读取结果
+	var ret htypes.Any
+	var callErr *herrors.Error
+	e := hystrix.DoC(ctx, cmd, func(ctx context.Context) error {
+		ret, callErr = this.server.requestService(ctx, v.EndPoint.Service, v.EndPoint.Slot, params)
+		if failedCall(callErr) {
+			return callErr
+		}
+		return nil
+	}, nil)
+
+	switch err := e.(type) {
+	case nil:
+		return ret, callErr
+	case *herrors.Error:
+		return nil, err
+	default:
+		if ctx.Err() != nil {
+			return nil, ContextError(ctx)
+		}
+		return nil, herrors.ErrSysBusy.New(err.Error()).D("circuit breaker [%s] rejected api [%s]", cmd, v.Name)
+	}
+}
+
+func copyParams(params htypes.Map) htypes.Map {
+	ret := make(htypes.Map, len(params))
+	for k, v := range params {
+		ret[k] = v
+	}
+	return ret
+}
+
+// serviceCallPolicy 服务间调用的容错设置
+type serviceCallPolicy struct {
+	timeout   time.Duration
+	breaker   *hystrix.CommandConfig //未开启熔断时为nil
+	max       int
+	bulkheads sync.Map //服务名 -> chan struct{}
+}
+
+func newServiceCallPolicy(conf *Server) *serviceCallPolicy {
+	p := &serviceCallPolicy{
+		timeout: time.Duration(conf.ServiceCallTimeout) * time.Millisecond,
+		max:     conf.ServiceMaxConcurrent,
+	}
+	if conf.ServiceBreaker {
+		p.breaker = &hystrix.CommandConfig{
+			Timeout:                serviceBreakerNoWait,
+			MaxConcurrentRequests:  serviceBreakerMaxRun,
+			RequestVolumeThreshold: defaultRequestVolumeThreshold,
+			SleepWindow:            defaultSleepWindow,
+			ErrorPercentThreshold:  defaultErrorPercentThreshold,
+		}
+	}
+	return p
+}
+
+// bulkhead 目标服务的并发计数，不限制时为nil
+func (this *serviceCallPolicy) bulkhead(service string) chan struct{} {
+	if this.max <= 0 {
+		return nil
+	}
+	v, _ := this.bulkheads.LoadOrStore(service, make(chan struct{}, this.max))
+	return v.(chan struct{})
+}
+
+func (this *ServerImplement) currentCallPolicy() *serviceCallPolicy {
+	return this.callPolicy.Load().(*serviceCallPolicy)
+}
+
+// reloadCallPolicy 替换服务间调用的容错设置，已创建的熔断器同时更新
+func (this *ServerImplement) reloadCallPolicy() {
+	p := newServiceCallPolicy(&this.conf)
+	if p.breaker != nil {
+		for cmd := range hystrix.GetCircuitSettings() {
+			if strings.HasPrefix(cmd, serviceCmdPrefix) {
+				hystrix.ConfigureCommand(cmd, *p.breaker)
+			}
+		}
+	}
+	this.callPolicy.Store(p)
+}
+
+// callService 服务间调用，超出并发上限或熔断器打开时立即返回ErrSysBusy
+func (this *ServerImplement) callService(ctx context.Context, service string, slot string, params htypes.Map) (htypes.Any, *herrors.Error) {
+	p := this.currentCallPolicy()
+	if sem := p.bulkhead(service); sem != nil {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+		default:
+			return nil, herrors.ErrSysBusy.New("service [%s] reached max concurrent calls %d", service, p.max)
+		}
+	}
+
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	ret, err := this.callBreaker(ctx, p, service, slot, params)
+	//超时后slot返回的结果不再使用
+	if herr := ContextError(ctx); herr != nil && p.timeout > 0 {
+		return nil, herr
+	}
+	return ret, err
+}
+
+// callBreaker 开启熔断时经目标服务的熔断器调用
+func (this *ServerImplement) callBreaker(ctx context.Context, p *serviceCallPolicy, service string, slot string, params htypes.Map) (htypes.Any, *herrors.Error) {
+	if p.breaker == nil {
+		return this.requestService(ctx, service, slot, params)
+	}
+
+	cmd := serviceCmdPrefix + service
+	if hystrix.GetCircuitSettings()[cmd] == nil {
+		hystrix.ConfigureCommand(cmd, *p.breaker)
+	}
+
+	//熔断器因ctx结束返回后run仍可能在执行，仅在run正常返回后读取结果
+	var ret htypes.Any
+	var callErr *herrors.Error
+	e := hystrix.DoC(ctx, cmd, func(ctx context.Context) error {
+		ret, callErr = this.requestService(ctx, service, slot, params)
+		if failedCall(callErr) {
+			return callErr
+		}
+		return nil
+	}, nil)
+
+	switch err := e.(type) {
+	case nil:
+		return ret, callErr
+	case *herrors.Error:
+		return nil, err
+	default:
+		if ctx.Err() != nil {
+			return nil, ContextError(ctx)
+		}
+		return nil, herrors.ErrSysBusy.New(err.Error()).D("circuit breaker [%s] rejected call of service [%s]", cmd, service)
+	}
+}
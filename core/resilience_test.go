@@ -0,0 +1,53 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestCmdName(t *testing.T) {
+	gw := &APIGateWayImplement{}
+	v := &API{Name: "login", EndPoint: EndPoint{Service: "user", Slot: "Login"}}
+
+	cases := []struct {
+		conf APIGateway
+		cmd  string
+	}{
+		{conf: APIGateway{}, cmd: "default"},
+		{conf: APIGateway{BreakerLimitAPI: true}, cmd: "login"},
+		{conf: APIGateway{BreakerLimitService: true}, cmd: "user"},
+		{conf: APIGateway{BreakerLimitAPI: true, BreakerLimitService: true}, cmd: "login_user"},
+	}
+	for _, c := range cases {
+		if cmd := gw.cmdName(&c.conf, v); cmd != c.cmd {
+			t.Errorf("%+v: expected %s, got %s", c.conf, c.cmd, cmd)
+		}
+	}
+}
+
+func TestBreakerConfig(t *testing.T) {
+	c := breakerConfig(&APIGateway{})
+	if c.Timeout != defaultRequestTimeout || c.SleepWindow != defaultSleepWindow || c.MaxConcurrentRequests != defaultMaxConcurrentRequests ||
+		c.RequestVolumeThreshold != defaultRequestVolumeThreshold || c.ErrorPercentThreshold != defaultErrorPercentThreshold {
+		t.Errorf("unexpected defaults %+v", c)
+	}
+
+	c = breakerConfig(&APIGateway{BreakerRequestTimeout: 200, BreakerSleepWindow: 3000})
+	if c.Timeout != 200 || c.SleepWindow != 3000 {
+		t.Errorf("configured values not used %+v", c)
+	}
+}
+
+func TestServiceCallPolicy(t *testing.T) {
+	p := newServiceCallPolicy(&Server{})
+	if p.timeout != 0 || p.breaker != nil || p.bulkhead("a") != nil {
+		t.Errorf("policy enabled by default %+v", p)
+	}
+
+	p = newServiceCallPolicy(&Server{ServiceCallTimeout: 500, ServiceBreaker: true, ServiceMaxConcurrent: 2})
+	if p.timeout.Milliseconds() != 500 || p.breaker == nil || cap(p.bulkhead("a")) != 2 {
+		t.Errorf("unexpected policy %+v", p)
+	}
+	if p.bulkhead("a") != p.bulkhead("a") || p.bulkhead("a") == p.bulkhead("b") {
+		t.Error("bulkhead not kept per service")
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"github.com/drharryhe/has/common/hconf"
 	"github.com/drharryhe/has/common/herrors"
 	"github.com/drharryhe/has/common/htypes"
-	"github.com/drharryhe/has/utils/hrandom"
 	"github.com/drharryhe/has/utils/hruntime"
 )
 
@@ -15,9 +14,11 @@ const (
 )
 
 type RpcRequestArguments struct {
-	Service string
-	Slot    string
-	Params  map[string]interface{}
+	Service  string
+	Slot     string
+	Params   map[string]interface{}
+	Deadline int64  //请求截止时间(UnixNano)，0表示无截止时间
+	Trace    string //调用方的trace上下文(W3C traceparent)，为空表示未追踪
 }
 
 type BaseRouter struct {
@@ -40,11 +41,12 @@ func (this *BaseRouter) Server() IServer {
 	return this.server
 }
 
+func (this *BaseRouter) OnConfigReload(_ IEntityConf) *herrors.Error {
+	return nil
+}
+
 func (this *BaseRouter) EntityMeta() *EntityMeta {
-	if this.instance.(IEntity).Config().GetEID() == "" {
-		this.instance.(IEntity).Config().SetEID(hrandom.UuidWithoutDash())
-		hconf.Save()
-	}
+	ensureEID(this.instance.(IEntity).Config())
 
 	return &EntityMeta{
 		ServerEID: this.Server().(IEntity).EntityMeta().EID,
@@ -88,6 +90,10 @@ func (this *BaseRouter) AllEntities() []*EntityMeta {
 	return ret
 }
 
+func (this *BaseRouter) Entity(eid string) IEntity {
+	return this.Entities[eid]
+}
+
 func (this *BaseRouter) RegisterEntity(m IEntity) *herrors.Error {
 	if m.EntityMeta() == nil {
 		return herrors.ErrSysInternal.New("Entity %s EntityMeta is null", hruntime.GetObjectName(m))
@@ -97,7 +103,15 @@ func (this *BaseRouter) RegisterEntity(m IEntity) *herrors.Error {
 	return nil
 }
 
+// ManageEntity 管理实体，mm.ServerEID为其他节点时由IClusterRouter转发
 func (this *BaseRouter) ManageEntity(mm *EntityMeta, slot string, params htypes.Map) (htypes.Any, *herrors.Error) {
+	if mm.ServerEID != "" && mm.ServerEID != this.Server().(IEntity).EntityMeta().EID {
+		if c, ok := this.instance.(IClusterRouter); ok {
+			return c.ManageRemoteEntity(mm, slot, params)
+		}
+		return nil, herrors.ErrCallerInvalidRequest.New("server [%s] not reachable", mm.ServerEID)
+	}
+
 	m := this.Entities[mm.EID]
 	if m == nil {
 		return nil, herrors.ErrSysInternal.New("Entity entity [" + mm.EID + "] not found")
@@ -0,0 +1,654 @@
+package core
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/common/hstate"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/utils/hcron"
+	"github.com/drharryhe/has/utils/hrandom"
+	"github.com/drharryhe/has/utils/hruntime"
+)
+
+//定时任务。服务在代码中实现IScheduledService，或在配置项ScheduledSlots中声明按cron表达式定时执行的slot。
+//Server配置项ScheduleLock指定的插件(如RedisPlugin)提供节点间的锁，只有持有锁的节点执行定时任务；
+//每次执行另外持有该任务的锁并在执行期间延长，失去锁时取消执行，锁的fencing token可通过ScheduleTokenFromContext取得；
+//上次执行时间保存在锁所在的存储中，接替的节点据此补充错过的执行。
+//未指定时每个节点都执行，上次执行时间保存在本地状态中。执行记录及下次执行时间可通过实体管理GetSchedules查询
+
+const (
+	ScheduleSucceeded = "succeeded"
+	ScheduleFailed    = "failed"
+	ScheduleTimeout   = "timeout"
+	ScheduleSkipped   = "skipped" //上次执行未结束或其他节点正在执行，本次不执行
+
+	MissedSkip    = "skip" //错过的执行不再补充，缺省
+	MissedRunOnce = "once" //错过一次或多次执行时，尽快补充执行一次
+
+	ManageGetSchedules = "GetSchedules"
+	ManageRunSchedule  = "RunSchedule"
+
+	defaultScheduleHistory = 20
+	defaultScheduleLockTTL = 15 //秒
+	scheduleLockName       = "schedule.leader"
+	scheduleJobLockPrefix  = "schedule.job." //每个任务执行期间持有的锁
+)
+
+// ScheduledSlot 定时执行的slot
+type ScheduledSlot struct {
+	Slot    string
+	Cron    string     //cron表达式，见hcron
+	Params  htypes.Map //执行时的参数
+	Missed  string     //MissedSkip或MissedRunOnce，包括停机期间及上次执行未结束时错过的执行
+	Timeout int        //执行超时(秒)，0表示不限
+	//集群中必须由ScheduleLock保证只有一个节点执行，集群部署而Server未配置ScheduleLock时不安排
+	Exclusive bool
+}
+
+// IScheduledService 在代码中声明定时执行的slot，配置项ScheduledSlots中同名的slot优先，参数仍使用代码中声明的
+type IScheduledService interface {
+	Schedules() []*ScheduledSlot
+}
+
+// IScheduleLock 节点间互斥的锁
+type IScheduleLock interface {
+	//获取或延长锁，返回锁的fencing token，锁由其他owner持有时返回0。每次获得锁时token递增，延长时不变
+	TryLock(name string, owner string, ttl time.Duration) (int64, *herrors.Error)
+	Unlock(name string, owner string)
+	//锁name对应的定时任务上次执行的时间，未执行过时返回零值
+	LastRun(name string) (time.Time, *herrors.Error)
+	//保存上次执行的时间，token已不是锁当前的token(锁已由其他节点获得)时不保存并返回错误
+	SetLastRun(name string, token int64, at time.Time) *herrors.Error
+}
+
+// IScheduleLockProvider 可提供定时任务锁的插件，通过Server配置项ScheduleLock指定
+type IScheduleLockProvider interface {
+	ScheduleLock() (IScheduleLock, *herrors.Error)
+}
+
+// ScheduleRun 一次执行的记录
+type ScheduleRun struct {
+	ScheduledAt time.Time      `json:"scheduled_at"`
+	StartedAt   *time.Time     `json:"started_at,omitempty"`
+	FinishedAt  *time.Time     `json:"finished_at,omitempty"`
+	Status      string         `json:"status"`
+	Missed      bool           `json:"missed,omitempty"` //补充执行
+	Error       *herrors.Error `json:"error,omitempty"`
+}
+
+// ScheduleInfo 定时任务的状态
+type ScheduleInfo struct {
+	Name    string         `json:"name"` //service.slot
+	Cron    string         `json:"cron"`
+	Missed  string         `json:"missed"`
+	Timeout int            `json:"timeout"`
+	Running bool           `json:"running"`
+	NextRun *time.Time     `json:"next_run,omitempty"`
+	LastRun *time.Time     `json:"last_run,omitempty"`
+	History []*ScheduleRun `json:"history"` //最近的执行记录，新的在前
+}
+
+type scheduleContextKey struct{}
+
+// ScheduleTokenFromContext 定时执行时持有的锁的fencing token。slot写入外部存储时可一并写入并拒绝小于已写入的token，
+// 以免失去锁后仍未结束的执行覆盖接替节点的结果。非定时执行或Server未配置ScheduleLock时返回0
+func ScheduleTokenFromContext(ctx context.Context) int64 {
+	token, _ := ctx.Value(scheduleContextKey{}).(int64)
+	return token
+}
+
+type scheduledJob struct {
+	name     string
+	service  string
+	spec     ScheduledSlot
+	schedule *hcron.Schedule
+	stateKey string
+	lockName string
+
+	lock    sync.Mutex
+	timer   *time.Timer
+	next    time.Time
+	last    time.Time
+	running bool
+	pending bool //执行期间错过的执行，MissedRunOnce时在结束后补充
+	history []*ScheduleRun
+}
+
+type scheduler struct {
+	server  *ServerImplement
+	lock    IScheduleLock
+	owner   string //锁的owner，每个进程不同
+	cluster bool
+	ttl     time.Duration
+	history int
+	leader  atomic.Bool
+	jobs    sync.Map //service.slot -> *scheduledJob
+	running atomic.Int64
+	closing atomic.Bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func newScheduler(s *ServerImplement, lock IScheduleLock) *scheduler {
+	m := &scheduler{
+		server:  s,
+		lock:    lock,
+		owner:   hrandom.UuidWithoutDash(),
+		ttl:     time.Duration(s.conf.ScheduleLockTTL) * time.Second,
+		history: s.conf.ScheduleHistory,
+	}
+	if m.ttl <= 0 {
+		m.ttl = defaultScheduleLockTTL * time.Second
+	}
+	if m.history <= 0 {
+		m.history = defaultScheduleHistory
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+
+	_, m.cluster = s.router.(IClusterRouter)
+	if lock == nil {
+		m.leader.Store(true)
+		if m.cluster {
+			hlogger.Warn("ScheduleLock of Server not configured, scheduled slots will run on every node")
+		}
+		return m
+	}
+
+	m.elect()
+	go func() {
+		ticker := time.NewTicker(m.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.elect()
+			case <-m.ctx.Done():
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// elect 获取或延长锁，持有锁的节点执行定时任务。获得锁时补充此前错过的执行
+func (this *scheduler) elect() {
+	token, err := this.lock.TryLock(scheduleLockName, this.owner, this.ttl)
+	if err != nil {
+		hlogger.Error("failed to acquire schedule lock: %s", err.Error())
+	}
+	ok := token > 0
+	if was := this.leader.Swap(ok); was == ok {
+		return
+	}
+	hlogger.Info("schedule lock acquired: %v", ok)
+	if ok {
+		this.jobs.Range(func(_, v interface{}) bool {
+			this.catchUp(v.(*scheduledJob))
+			return true
+		})
+	}
+}
+
+// add 按服务的声明及配置安排定时执行，slot不存在或cron表达式错误时返回错误
+func (this *scheduler) add(service IService) *herrors.Error {
+	specs := make(map[string]*ScheduledSlot)
+	if s, ok := service.(IScheduledService); ok {
+		for _, spec := range s.Schedules() {
+			specs[spec.Slot] = spec
+		}
+	}
+	items, err := scheduledSlotsOf(service)
+	if err != nil {
+		return err
+	}
+	for _, spec := range items {
+		//参数不能在配置中设置，沿用代码中的声明
+		if old := specs[spec.Slot]; old != nil {
+			spec.Params = old.Params
+			spec.Exclusive = old.Exclusive
+		}
+		specs[spec.Slot] = spec
+	}
+
+	slots := make([]string, 0, len(specs))
+	for slot := range specs {
+		slots = append(slots, slot)
+	}
+	sort.Strings(slots)
+
+	for _, slot := range slots {
+		spec := specs[slot]
+		if service.Slot(slot) == nil {
+			return herrors.ErrSysInternal.New("scheduled slot [%s.%s] not found", service.Name(), slot)
+		}
+		schedule, e := hcron.Parse(spec.Cron)
+		if e != nil {
+			return herrors.ErrSysInternal.New("schedule of slot [%s.%s]: %v", service.Name(), slot, e)
+		}
+		if spec.Missed == "" {
+			spec.Missed = MissedSkip
+		} else if spec.Missed != MissedSkip && spec.Missed != MissedRunOnce {
+			return herrors.ErrSysInternal.New("schedule of slot [%s.%s]: invalid missed policy [%s]", service.Name(), slot, spec.Missed)
+		}
+		if spec.Exclusive && this.lock == nil && this.cluster {
+			hlogger.Warn("scheduled slot [%s.%s] not scheduled, it requires ScheduleLock of Server in cluster", service.Name(), slot)
+			continue
+		}
+
+		job := &scheduledJob{
+			name:     service.Name() + "." + slot,
+			service:  service.Name(),
+			spec:     *spec,
+			schedule: schedule,
+			stateKey: StateKey(service.(IEntity), "Schedule."+slot),
+			lockName: scheduleJobLockPrefix + service.Name() + "." + slot,
+		}
+		if old, ok := this.jobs.Load(job.name); ok {
+			old.(*scheduledJob).stop()
+		}
+		this.jobs.Store(job.name, job)
+
+		//停机期间错过的执行
+		this.catchUp(job)
+		this.arm(job, time.Now())
+	}
+	return nil
+}
+
+// catchUp 读取上次执行的时间，MissedRunOnce时补充执行错过的执行。未持有锁的节点在获得锁后再补充
+func (this *scheduler) catchUp(job *scheduledJob) {
+	last := this.lastRun(job)
+	job.lock.Lock()
+	if last.After(job.last) {
+		job.last = last
+	}
+	last = job.last
+	job.lock.Unlock()
+
+	if job.spec.Missed != MissedRunOnce || last.IsZero() {
+		return
+	}
+	if next := job.schedule.Next(last); !next.IsZero() && next.Before(time.Now()) {
+		go this.fire(job, next, true)
+	}
+}
+
+// lastRun 上次执行的时间，配置了ScheduleLock时由各节点共享，否则保存在本地状态中
+func (this *scheduler) lastRun(job *scheduledJob) time.Time {
+	if this.lock != nil {
+		last, err := this.lock.LastRun(job.lockName)
+		if err != nil {
+			hlogger.Error("failed to get last run of schedule [%s]: %s", job.name, err.Error())
+		}
+		return last
+	}
+
+	var last time.Time
+	if v, ok, _ := hstate.Get(job.stateKey); ok {
+		last, _ = time.Parse(time.RFC3339, v)
+	}
+	return last
+}
+
+func (this *scheduler) saveLastRun(job *scheduledJob, token int64, at time.Time) {
+	if this.lock != nil {
+		if err := this.lock.SetLastRun(job.lockName, token, at); err != nil {
+			hlogger.Error("failed to save last run of schedule [%s]: %s", job.name, err.Error())
+		}
+		return
+	}
+	if err := hstate.Set(job.stateKey, at.Format(time.RFC3339)); err != nil {
+		hlogger.Error("failed to save last run of schedule [%s]: %v", job.name, err)
+	}
+}
+
+// scheduledSlotsOf 解析服务配置项ScheduledSlots，格式为 slot:cron[:missed[:timeout]]
+func scheduledSlotsOf(service IService) ([]*ScheduledSlot, *herrors.Error) {
+	items, _ := hruntime.GetObjectFieldValue(service.(IEntity).Config(), "ScheduledSlots").([]string)
+	var ret []*ScheduledSlot
+	for _, item := range items {
+		vv := strings.Split(item, ":")
+		if len(vv) < 2 || len(vv) > 4 || vv[0] == "" {
+			return nil, herrors.ErrSysInternal.New("invalid ScheduledSlots item [%s] of service [%s]", item, service.Name())
+		}
+		spec := &ScheduledSlot{Slot: strings.TrimSpace(vv[0]), Cron: strings.TrimSpace(vv[1])}
+		if len(vv) > 2 {
+			spec.Missed = strings.TrimSpace(vv[2])
+		}
+		if len(vv) > 3 {
+			n, err := strconv.Atoi(strings.TrimSpace(vv[3]))
+			if err != nil {
+				return nil, herrors.ErrSysInternal.New("invalid timeout in ScheduledSlots item [%s] of service [%s]", item, service.Name())
+			}
+			spec.Timeout = n
+		}
+		ret = append(ret, spec)
+	}
+	return ret, nil
+}
+
+// arm 安排下一次执行
+func (this *scheduler) arm(job *scheduledJob, from time.Time) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+
+	if this.closing.Load() {
+		return
+	}
+	job.next = job.schedule.Next(from)
+	if job.next.IsZero() {
+		return
+	}
+	at := job.next
+	job.timer = time.AfterFunc(time.Until(at), func() {
+		this.arm(job, at)
+		this.fire(job, at, false)
+	})
+}
+
+// fire 到达执行时间。未持有锁的节点不执行；上次执行未结束时按Missed策略跳过或在结束后补充
+func (this *scheduler) fire(job *scheduledJob, at time.Time, missed bool) {
+	if this.closing.Load() || !this.leader.Load() {
+		return
+	}
+
+	job.lock.Lock()
+	if job.running {
+		if job.spec.Missed == MissedRunOnce {
+			job.pending = true
+		} else {
+			job.record(&ScheduleRun{ScheduledAt: at, Status: ScheduleSkipped}, this.history)
+		}
+		job.lock.Unlock()
+		return
+	}
+	job.running = true
+	job.lock.Unlock()
+
+	this.running.Inc()
+	go this.run(job, at, missed)
+}
+
+func (this *scheduler) run(job *scheduledJob, at time.Time, missed bool) {
+	defer this.running.Dec()
+
+	for {
+		run := this.execute(job, at, missed)
+
+		job.lock.Lock()
+		job.record(run, this.history)
+		if run.Status != ScheduleSkipped {
+			job.last = at
+		}
+		again := job.pending && !this.closing.Load()
+		job.pending = false
+		job.running = again
+		job.lock.Unlock()
+
+		if !again {
+			return
+		}
+		at, missed = time.Now(), true
+	}
+}
+
+// execute 持有任务的锁调用slot并保存执行时间，任务由其他节点执行时跳过。
+// 超时后不再等待slot返回，slot可通过context得知已取消
+func (this *scheduler) execute(job *scheduledJob, at time.Time, missed bool) *ScheduleRun {
+	start := time.Now()
+	run := &ScheduleRun{ScheduledAt: at, StartedAt: &start, Missed: missed}
+
+	ctx, cancel := context.WithCancel(ContextWithScope(this.ctx, NewRequestScope("")))
+	defer cancel()
+	var token int64
+	var lost atomic.Bool
+	if this.lock != nil {
+		t, release, err := this.acquire(job, func() {
+			lost.Store(true)
+			cancel()
+		})
+		if err != nil {
+			run.Status, run.Error = ScheduleFailed, err
+			hlogger.Error("failed to acquire lock of schedule [%s]: %s", job.name, err.Error())
+			return run
+		}
+		if t == 0 {
+			//其他节点正在执行
+			run.StartedAt, run.Status = nil, ScheduleSkipped
+			return run
+		}
+		defer release()
+		token = t
+		ctx = context.WithValue(ctx, scheduleContextKey{}, token)
+	}
+	if job.spec.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(job.spec.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	params := make(htypes.Map, len(job.spec.Params))
+	for k, v := range job.spec.Params {
+		params[k] = v
+	}
+	ch := make(chan *herrors.Error, 1)
+	go func() {
+		_, err := this.server.RequestServiceContext(ctx, job.service, job.spec.Slot, params)
+		ch <- err
+	}()
+
+	var err *herrors.Error
+	select {
+	case err = <-ch:
+	case <-ctx.Done():
+		err = ContextError(ctx)
+		if lost.Load() {
+			//失去锁时等待slot结束(最多ttl)，尽量避免与接替的节点同时执行
+			select {
+			case <-ch:
+			case <-time.After(this.ttl):
+			}
+		}
+	}
+
+	end := time.Now()
+	run.FinishedAt = &end
+	switch {
+	case err == nil:
+		run.Status = ScheduleSucceeded
+	case ctx.Err() == context.DeadlineExceeded:
+		run.Status = ScheduleTimeout
+		run.Error = err
+	case lost.Load():
+		run.Status = ScheduleFailed
+		run.Error = herrors.ErrSysBusy.New("lock of schedule [%s] lost", job.name)
+	default:
+		run.Status = ScheduleFailed
+		run.Error = err
+	}
+	if run.Error != nil {
+		hlogger.Error("scheduled slot [%s] %s: %s", job.name, run.Status, run.Error.Error())
+	}
+	if !lost.Load() {
+		this.saveLastRun(job, token, at)
+	}
+	return run
+}
+
+// acquire 获取任务的锁，由其他节点持有时返回0。持有期间每ttl/3延长一次，锁由其他节点获得或超过ttl未能延长时调用lost。
+// release停止延长并释放锁
+func (this *scheduler) acquire(job *scheduledJob, lost func()) (int64, func(), *herrors.Error) {
+	token, err := this.lock.TryLock(job.lockName, this.owner, this.ttl)
+	if err != nil || token == 0 {
+		return 0, nil, err
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(this.ttl / 3)
+		defer ticker.Stop()
+		renewed := time.Now()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+
+			t, err := this.lock.TryLock(job.lockName, this.owner, this.ttl)
+			if err == nil && t == token {
+				renewed = time.Now()
+				continue
+			}
+			if err != nil {
+				hlogger.Error("failed to renew lock of schedule [%s]: %s", job.name, err.Error())
+				if time.Since(renewed) < this.ttl {
+					continue
+				}
+			}
+			hlogger.Error("lock of schedule [%s] lost, run canceled", job.name)
+			lost()
+			return
+		}
+	}()
+
+	release := func() {
+		close(stop)
+		<-done
+		this.lock.Unlock(job.lockName, this.owner)
+	}
+	return token, release, nil
+}
+
+func (this *scheduledJob) record(run *ScheduleRun, max int) {
+	this.history = append([]*ScheduleRun{run}, this.history...)
+	if len(this.history) > max {
+		this.history = this.history[:max]
+	}
+}
+
+func (this *scheduledJob) stop() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.timer != nil {
+		this.timer.Stop()
+	}
+}
+
+func (this *scheduledJob) info() *ScheduleInfo {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	ret := &ScheduleInfo{
+		Name:    this.name,
+		Cron:    this.spec.Cron,
+		Missed:  this.spec.Missed,
+		Timeout: this.spec.Timeout,
+		Running: this.running,
+		History: append([]*ScheduleRun{}, this.history...),
+	}
+	if !this.next.IsZero() {
+		next := this.next
+		ret.NextRun = &next
+	}
+	if !this.last.IsZero() {
+		last := this.last
+		ret.LastRun = &last
+	}
+	return ret
+}
+
+// getSchedules 参数service可选，只返回该服务的定时任务
+func (this *scheduler) getSchedules(params htypes.Map) (htypes.Any, *herrors.Error) {
+	service, _ := params["service"].(string)
+
+	var ret []*ScheduleInfo
+	this.jobs.Range(func(_, v interface{}) bool {
+		if job := v.(*scheduledJob); service == "" || job.service == service {
+			ret = append(ret, job.info())
+		}
+		return true
+	})
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return htypes.Map{"leader": this.leader.Load(), "schedules": ret}, nil
+}
+
+// runSchedule 立即执行参数name(service.slot)指定的定时任务，不论本节点是否持有锁
+func (this *scheduler) runSchedule(params htypes.Map) (htypes.Any, *herrors.Error) {
+	name, _ := params["name"].(string)
+	v, ok := this.jobs.Load(name)
+	if !ok {
+		return nil, herrors.ErrCallerInvalidRequest.New("schedule [%s] not found", name)
+	}
+	if this.closing.Load() {
+		return nil, herrors.ErrSysBusy.New("server is shutting down")
+	}
+
+	job := v.(*scheduledJob)
+	job.lock.Lock()
+	if job.running {
+		job.lock.Unlock()
+		return nil, herrors.ErrSysBusy.New("schedule [%s] is running", name)
+	}
+	job.running = true
+	job.lock.Unlock()
+
+	this.running.Inc()
+	go this.run(job, time.Now(), false)
+	return nil, nil
+}
+
+func (this *scheduler) load() htypes.Map {
+	n := 0
+	this.jobs.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return htypes.Map{
+		"jobs":    n,
+		"running": this.running.Load(),
+		"leader":  this.leader.Load(),
+	}
+}
+
+// drain 停止定时，并在timeout内等待执行中的任务完成，超时后取消。释放锁以便其他节点接替
+func (this *scheduler) drain(timeout time.Duration, report *shutdownReport) {
+	this.closing.Store(true)
+	this.jobs.Range(func(_, v interface{}) bool {
+		v.(*scheduledJob).stop()
+		return true
+	})
+
+	if waitUntil(timeout, func() bool { return this.running.Load() == 0 }) {
+		report.add("scheduled slots finished")
+	} else {
+		report.add("%d scheduled slots interrupted after %v", this.running.Load(), timeout)
+	}
+	this.cancel()
+
+	if this.lock != nil && this.leader.Load() {
+		this.lock.Unlock(scheduleLockName, this.owner)
+		this.leader.Store(false)
+	}
+}
+
+// getSchedules 实体桩在定时任务创建之前生成，管理时再取
+func (this *ServerImplement) getSchedules(params htypes.Map) (htypes.Any, *herrors.Error) {
+	return this.schedules.getSchedules(params)
+}
+
+func (this *ServerImplement) runSchedule(params htypes.Map) (htypes.Any, *herrors.Error) {
+	return this.schedules.runSchedule(params)
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"context"
+	"sync"
+
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/utils/hrandom"
+)
+
+const (
+	MaxRequestIDLength = 64
+)
+
+type scopeContextKey struct{}
+
+// RequestScope 单次API请求的作用域，在in/out middleware及slot之间共享
+type RequestScope struct {
+	Seq        uint64 //请求流水号，进程内唯一
+	ID         string //请求ID，用于日志关联，可由调用方传入
+	Version    string
+	API        string
+	Definition *API //接口定义，以别名请求时API为原接口名称
+
+	jobOwner string //异步请求的提交者，由网关在in middleware之后设置
+
+	ctx     context.Context
+	lock    sync.RWMutex
+	attrs   htypes.Map
+	exposed map[string]bool
+}
+
+// NewRequestScope 调用方传入的id不合法(为空、过长或含字母数字及-_.:以外的字符)时生成新的id，避免日志注入
+func NewRequestScope(id string) *RequestScope {
+	if !validRequestID(id) {
+		id = hrandom.UuidWithoutDash()
+	}
+
+	return &RequestScope{
+		ID:      id,
+		attrs:   make(htypes.Map),
+		exposed: make(map[string]bool),
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > MaxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ContextWithScope 将RequestScope绑定到context
+func ContextWithScope(ctx context.Context, scope *RequestScope) context.Context {
+	ctx = context.WithValue(ctx, scopeContextKey{}, scope)
+	scope.ctx = ctx
+	return ctx
+}
+
+// ScopeFromContext 获取context上绑定的RequestScope，没有则返回nil
+func ScopeFromContext(ctx context.Context) *RequestScope {
+	if ctx == nil {
+		return nil
+	}
+	scope, _ := ctx.Value(scopeContextKey{}).(*RequestScope)
+	return scope
+}
+
+// Context 返回请求的context，middleware调用服务时应使用该context
+func (this *RequestScope) Context() context.Context {
+	if this.ctx == nil {
+		return context.Background()
+	}
+	return this.ctx
+}
+
+func (this *RequestScope) Get(key string) (htypes.Any, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	v, ok := this.attrs[key]
+	return v, ok
+}
+
+// Set 设置仅在服务端内部可见的属性
+func (this *RequestScope) Set(key string, val htypes.Any) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.attrs[key] = val
+}
+
+// Expose 设置属性，并由connector随响应返回给调用方
+func (this *RequestScope) Expose(key string, val htypes.Any) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.attrs[key] = val
+	this.exposed[key] = true
+}
+
+func (this *RequestScope) Delete(key string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	delete(this.attrs, key)
+	delete(this.exposed, key)
+}
+
+// Exposed 返回需要返回给调用方的属性
+func (this *RequestScope) Exposed() htypes.Map {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	ret := make(htypes.Map)
+	for k := range this.exposed {
+		ret[k] = this.attrs[k]
+	}
+	return ret
+}
@@ -0,0 +1,33 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewRequestScopeID(t *testing.T) {
+	cases := []struct {
+		id   string
+		keep bool
+	}{
+		{"", false},
+		{"req-1", true},
+		{"A.b_c:9-z", true},
+		{strings.Repeat("a", MaxRequestIDLength), true},
+		{strings.Repeat("a", MaxRequestIDLength+1), false},
+		{"bad id", false},
+		{"line\nbreak", false},
+		{"<script>", false},
+		{"中文", false},
+	}
+
+	for _, c := range cases {
+		scope := NewRequestScope(c.id)
+		if c.keep && scope.ID != c.id {
+			t.Errorf("id [%q] should be kept, got [%s]", c.id, scope.ID)
+		}
+		if !c.keep && (scope.ID == c.id || !validRequestID(scope.ID)) {
+			t.Errorf("id [%q] should be replaced, got [%s]", c.id, scope.ID)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/drharryhe/has/common/hconf"
+	"github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/utils/hruntime"
+)
+
+//启动自检。打开实体前检查各实体的配置段，缺少配置段时不再启动；服务打开后检查api.json映射的slot、
+//未被接口映射的slot及middleware等配置中引用的接口。启动时记录到日志，Server配置项StrictCheck为true时有错误即退出；
+//命令行参数--check只输出检查结果，有错误时退出码为1
+
+const (
+	CheckError   = "error"
+	CheckWarning = "warning"
+)
+
+// IAPIReferrer 在配置中引用接口的实体，如middleware的接口白名单，自检时检查引用的接口是否存在
+type IAPIReferrer interface {
+	ReferencedAPIs() map[string][]string //版本 -> 接口名，*表示该版本的全部接口
+}
+
+// CheckProblem 自检发现的问题
+type CheckProblem struct {
+	Level   string `json:"level"`   //CheckError或CheckWarning
+	Subject string `json:"subject"` //配置段、接口(版本:接口名)或实体
+	Message string `json:"message"`
+}
+
+// SelfCheckReport 自检结果
+type SelfCheckReport struct {
+	Problems []*CheckProblem `json:"problems"`
+}
+
+func (this *SelfCheckReport) add(level string, subject string, format string, args ...interface{}) {
+	this.Problems = append(this.Problems, &CheckProblem{Level: level, Subject: subject, Message: fmt.Sprintf(format, args...)})
+}
+
+// HasErrors 是否有错误，警告不计
+func (this *SelfCheckReport) HasErrors() bool {
+	for _, p := range this.Problems {
+		if p.Level == CheckError {
+			return true
+		}
+	}
+	return false
+}
+
+// Write 逐行输出问题及统计
+func (this *SelfCheckReport) Write(w io.Writer) error {
+	errs := 0
+	for _, p := range this.Problems {
+		if p.Level == CheckError {
+			errs++
+		}
+		if _, err := fmt.Fprintf(w, "%-8s [%s] %s\n", p.Level, p.Subject, p.Message); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "self check: %d errors, %d warnings\n", errs, len(this.Problems)-errs)
+	return err
+}
+
+func logProblems(problems []*CheckProblem) {
+	for _, p := range problems {
+		if p.Level == CheckError {
+			hlogger.Error("self check: [%s] %s", p.Subject, p.Message)
+		} else {
+			hlogger.Warn("self check: [%s] %s", p.Subject, p.Message)
+		}
+	}
+}
+
+// checkConfs 检查实体的配置段，返回发现的问题及缺少的配置段
+func checkConfs(confs ...IEntityConf) ([]*CheckProblem, []string) {
+	var report SelfCheckReport
+	var lost []string
+	for _, conf := range confs {
+		section := hruntime.GetObjectName(conf)
+		found, unknown, missing := hconf.CheckSection(conf)
+		if !found {
+			report.add(CheckError, section, "config section not found")
+			lost = append(lost, section)
+			continue
+		}
+		if len(unknown) > 0 {
+			report.add(CheckWarning, section, "unknown config keys: %s", strings.Join(unknown, ", "))
+		}
+		if len(missing) > 0 {
+			report.add(CheckWarning, section, "required config keys not set: %s", strings.Join(missing, ", "))
+		}
+	}
+	return report.Problems, lost
+}
+
+// checkEntityConfs 打开实体前检查配置段。缺少配置段时，--check模式输出结果后退出，否则panic
+func (this *ServerImplement) checkEntityConfs(confs ...IEntityConf) {
+	problems, lost := checkConfs(confs...)
+	this.checked = append(this.checked, problems...)
+	if len(lost) == 0 {
+		return
+	}
+
+	if this.args.Check {
+		this.exitWithReport(&SelfCheckReport{Problems: this.checked})
+	}
+	panic(fmt.Sprintf("failed to load conf, config sections [%s] not found", strings.Join(lost, ", ")))
+}
+
+// checkServiceConf 注册服务前检查配置段，缺少配置段时返回false。
+// --check模式下记录后跳过该服务，以便继续检查其他部分
+func (this *ServerImplement) checkServiceConf(conf IEntityConf) bool {
+	problems, lost := checkConfs(conf)
+	this.checked = append(this.checked, problems...)
+	if this.servicesReady {
+		logProblems(problems)
+	}
+	return len(lost) == 0 || !this.args.Check
+}
+
+// SelfCheck 配置段的检查结果及服务打开后的检查结果
+func (this *ServerImplement) SelfCheck() *SelfCheckReport {
+	report := &SelfCheckReport{Problems: append([]*CheckProblem{}, this.checked...)}
+	if this.checkAPIs != nil {
+		report.Problems = append(report.Problems, this.checkAPIs()...)
+	}
+	return report
+}
+
+// selfCheck 服务打开后进行，--check模式输出结果后退出
+func (this *ServerImplement) selfCheck() {
+	report := this.SelfCheck()
+	if this.args.Check {
+		this.exitWithReport(report)
+	}
+
+	logProblems(report.Problems)
+	if this.conf.StrictCheck && report.HasErrors() {
+		panic("self check failed, set StrictCheck of Server to false to start anyway")
+	}
+}
+
+func (this *ServerImplement) exitWithReport(report *SelfCheckReport) {
+	_ = report.Write(os.Stdout)
+	if report.HasErrors() {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// checkAPIs api.json中映射的slot、未被映射的本地slot及实体配置中引用的接口
+func (this *APIGateWayImplement) checkAPIs() []*CheckProblem {
+	this.apiLock.RLock()
+	set := this.apiSet
+	this.apiLock.RUnlock()
+
+	var report SelfCheckReport
+	_, cluster := this.router.(IClusterRouter)
+	mapped := make(map[string]bool) //service.slot
+
+	checkEndPoint := func(subject string, what string, ep *EndPoint) {
+		mapped[ep.Service+"."+ep.Slot] = true
+		s := this.server.services[ep.Service]
+		switch {
+		case s == nil && cluster:
+			report.add(CheckWarning, subject, "service [%s] of %s not registered on this node", ep.Service, what)
+		case s == nil:
+			report.add(CheckError, subject, "service [%s] of %s not registered", ep.Service, what)
+		case s.Slot(ep.Slot) == nil:
+			report.add(CheckError, subject, "slot [%s.%s] of %s not found", ep.Service, ep.Slot, what)
+		}
+	}
+
+	for _, version := range sortedKeys(set) {
+		seen := make(map[*API]bool)
+		for _, name := range sortedKeys(set[version]) {
+			v := set[version][name]
+			if seen[v] {
+				continue
+			}
+			seen[v] = true
+
+			subject := version + ":" + v.Name
+			checkEndPoint(subject, "endpoint", &v.EndPoint)
+			if v.Fallback != nil {
+				checkEndPoint(subject, "fallback", v.Fallback)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(this.server.services) {
+		s, ok := this.server.services[name].(interface{ slotNames() []string })
+		if !ok {
+			continue
+		}
+		for _, slot := range s.slotNames() {
+			if !mapped[name+"."+slot] {
+				report.add(CheckWarning, name+"."+slot, "slot not mapped by any api, reachable by service calls only")
+			}
+		}
+	}
+
+	var referrers []interface{}
+	for _, m := range this.middlewares {
+		referrers = append(referrers, m)
+	}
+	for _, c := range this.connectors {
+		referrers = append(referrers, c)
+	}
+	for _, e := range referrers {
+		r, ok := e.(IAPIReferrer)
+		if !ok {
+			continue
+		}
+		subject := e.(IEntity).Class()
+		refs := r.ReferencedAPIs()
+		for _, version := range sortedKeys(refs) {
+			if set[version] == nil {
+				report.add(CheckWarning, subject, "version [%s] not found in %s", version, apiFileName)
+				continue
+			}
+			apis := append([]string{}, refs[version]...)
+			sort.Strings(apis)
+			for _, api := range apis {
+				if api != "*" && set[version][api] == nil {
+					report.add(CheckWarning, subject, "api [%s:%s] not found in %s", version, api, apiFileName)
+				}
+			}
+		}
+	}
+
+	return report.Problems
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,303 +1,628 @@
-package core
-
-import (
-	"fmt"
-	"github.com/mkideal/cli"
-	"net/http"
-	_ "net/http/pprof"
-	"os"
-	"os/signal"
-	"runtime"
-	"runtime/debug"
-	"syscall"
-
-	"go.uber.org/atomic"
-
-	"github.com/drharryhe/has/common/hconf"
-	"github.com/drharryhe/has/common/herrors"
-	hlogger "github.com/drharryhe/has/common/hlogger"
-	"github.com/drharryhe/has/common/htypes"
-	"github.com/drharryhe/has/utils/hio"
-	"github.com/drharryhe/has/utils/hrandom"
-	"github.com/drharryhe/has/utils/hruntime"
-)
-
-const (
-	defaultMaxProcs = 1
-
-	//熔断器缺省设置
-	defaultRequestTimeout         = 1000
-	defaultMaxConcurrentRequests  = 10
-	defaultRequestVolumeThreshold = 20
-	defaultSleepWindow            = 5000
-	defaultErrorPercentThreshold  = 50
-)
-
-type Server struct {
-	EntityConfBase
-
-	MaxProcs  int
-	PprofPort int
-}
-
-type CmdArgs struct {
-	Env string `cli:"e,env" usage:"当前运行环境(dev/test)"`
-}
-
-func NewServer(opt *ServerOptions, args ...htypes.Any) *ServerImplement {
-	s := new(ServerImplement)
-	s.init(opt, args)
-	return s
-}
-
-type ServerImplement struct {
-	Instance      IServer
-	class         string
-	conf          Server
-	quitSignal    chan os.Signal //退出信号
-	router        IRouter
-	plugins       map[string]IPlugin
-	services      map[string]IService
-	assetsManager IAssetManager
-	requestNo     atomic.Uint64
-}
-
-func (this *ServerImplement) Class() string {
-	return this.class
-}
-
-func (this *ServerImplement) Server() IServer {
-	return this
-}
-
-func (this *ServerImplement) Config() IEntityConf {
-	return &this.conf
-}
-
-func (this *ServerImplement) EntityMeta() *EntityMeta {
-	if this.conf.EID == "" {
-		this.conf.EID = hrandom.UuidWithoutDash()
-		hconf.Save()
-	}
-
-	return &EntityMeta{
-		ServerEID: this.conf.EID,
-		EID:       this.conf.EID,
-		Type:      EntityTypeServer,
-		Class:     this.class,
-	}
-}
-
-func (this *ServerImplement) EntityStub() *EntityStub {
-	return NewEntityStub(
-		&EntityStubOptions{
-			Owner:       this,
-			ResetConfig: this.resetConfig,
-		})
-}
-
-func (this *ServerImplement) Assets() IAssetManager {
-	return this.assetsManager
-}
-
-func (this *ServerImplement) Router() IRouter {
-	return this.router
-}
-
-func (this *ServerImplement) Services() map[string]IService {
-	return this.services
-}
-
-func (this *ServerImplement) init(opt *ServerOptions, args ...htypes.Any) {
-	if opt == nil {
-		panic("ServerOptions cannot be nil")
-	}
-
-	cli.Run(new(CmdArgs), func(ctx *cli.Context) error {
-		arg := ctx.Args()
-		if len(arg) == 0 {
-			hlogger.Alert(">生产环境<")
-			return nil
-		}
-		switch arg[0] {
-		case "dev":
-			hlogger.Alert(">开发环境<")
-			hconf.ConfFile = "conf_dev.toml"
-		case "test":
-			hlogger.Alert(">测试环境<")
-			hconf.ConfFile = "conf_test.toml"
-		default:
-			hlogger.Alert(">自定义: %s<", arg[0])
-			hconf.ConfFile = fmt.Sprintf("conf_%s.toml", arg[0])
-		}
-		return nil
-	})
-
-	hconf.Init()
-	hconf.Load(&this.conf)
-	hlogger.Init(hconf.LogOutputs(), hconf.LogFileName())
-
-	if hconf.IsDebug() {
-		go func() {
-			if this.conf.PprofPort == 0 {
-				this.conf.PprofPort = 6060
-			}
-		RETRY:
-			hlogger.Info("pprof port: %d", this.conf.PprofPort)
-			err := http.ListenAndServe(fmt.Sprintf(":%d", this.conf.PprofPort), nil)
-			if err != nil {
-				hlogger.Error(err)
-				this.conf.PprofPort++
-				goto RETRY
-			}
-		}()
-	}
-
-	this.class = hruntime.GetObjectName(&this.conf)
-	this.Instance = this
-
-	if opt.AssetsManager == nil {
-		this.assetsManager = &FileAssets{}
-	} else {
-		this.assetsManager = opt.AssetsManager
-	}
-
-	if err := opt.Router.Open(this, opt.Router); err != nil {
-		hlogger.Critical(err)
-		panic("failed to init server")
-	}
-	if err := CheckAndRegisterEntity(opt.Router, opt.Router); err != nil {
-		hlogger.Critical(err)
-		panic("failed to init server")
-	}
-	this.router = opt.Router
-
-	this.plugins = make(map[string]IPlugin)
-	for _, p := range opt.Plugins {
-		if err := p.Open(this, p); err != nil {
-			panic(err.D("failed to init server"))
-		}
-		if err := CheckAndRegisterEntity(p, this.router); err != nil {
-			panic(err.D("failed to init Server"))
-		}
-		this.plugins[p.(IEntity).Class()] = p
-	}
-
-	if err := this.router.RegisterEntity(this); err != nil {
-		panic(err.D("failed to init Server"))
-	}
-
-	this.services = make(map[string]IService)
-}
-
-func (this *ServerImplement) Plugin(cls string) IPlugin {
-	if this.plugins == nil {
-		return nil
-	}
-	return this.plugins[cls]
-}
-
-func (this *ServerImplement) Start() {
-	if this.conf.MaxProcs > 0 {
-		runtime.GOMAXPROCS(this.conf.MaxProcs)
-	}
-
-	pid := fmt.Sprintf("%d", os.Getpid())
-	if err := hio.CreateFile("./pid.pid", []byte(pid)); err != nil {
-		hlogger.Error(err)
-	}
-	hlogger.Info("server started...")
-
-	this.waitForQuit()
-}
-
-func (this *ServerImplement) Shutdown() {
-	this.quitSignal <- syscall.SIGQUIT
-}
-
-func (this *ServerImplement) RegisterService(service IService, options htypes.Any) {
-	var herr *herrors.Error
-
-	if entity, ok := service.(IEntity); !ok {
-		herr = herrors.ErrSysInternal.New("plugin [%s] not implement IEntity interface", hruntime.GetObjectName(service))
-		goto panic
-	} else {
-		//hconf.Load(entity.Config())
-
-		if herr = service.Open(this, service, options); herr != nil {
-			goto panic
-		}
-
-		if herr = this.router.RegisterService(service); herr != nil {
-			goto panic
-		}
-
-		if herr = this.router.RegisterEntity(entity); herr != nil {
-			goto panic
-		}
-
-		this.services[entity.(IService).Name()] = service
-	}
-	return
-
-panic:
-	panic(herr.D("failed to register service [%s] ", hruntime.GetObjectName(service)))
-}
-
-func (this *ServerImplement) Slot(service string, slot string) *Slot {
-	s := this.services[service]
-	if s == nil {
-		return nil
-	}
-
-	return s.Slot(slot)
-}
-
-func (this *ServerImplement) RequestService(service string, slot string, params htypes.Map) (ret htypes.Any, err *herrors.Error) {
-	if !hconf.IsDebug() {
-		defer func() {
-			e := recover()
-			if e != nil {
-				hlogger.Error(herrors.ErrSysInternal.New(e.(error).Error()))
-				hlogger.Error(string(debug.Stack()))
-			}
-		}()
-	}
-
-	return this.router.RequestService(service, slot, params)
-}
-
-func (this *ServerImplement) waitForQuit() {
-	this.quitSignal = make(chan os.Signal)
-	signal.Notify(this.quitSignal,
-		os.Interrupt,
-		os.Kill,
-		syscall.SIGINT,
-		syscall.SIGTERM,
-		syscall.SIGKILL,
-		syscall.SIGQUIT)
-
-	<-this.quitSignal
-	this.close()
-	hlogger.Info("server exited")
-}
-
-func (this *ServerImplement) close() {
-	if this.router != nil {
-		this.router.Close()
-	}
-	for _, p := range this.plugins {
-		p.Close()
-	}
-}
-
-func (this *ServerImplement) newRequestNo() uint64 {
-	return this.requestNo.Add(1)
-}
-
-func (this *ServerImplement) resetConfig(ps htypes.Map) *herrors.Error {
-	this.conf.MaxProcs = 1
-
-	hconf.Save()
-	return nil
-}
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/mkideal/cli"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"syscall"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/drharryhe/has/common/hconf"
+	"github.com/drharryhe/has/common/herrors"
+	hlogger "github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/common/hmetrics"
+	"github.com/drharryhe/has/common/hstate"
+	"github.com/drharryhe/has/common/htrace"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/utils/hio"
+	"github.com/drharryhe/has/utils/hruntime"
+)
+
+const (
+	defaultMaxProcs = 1
+
+	//熔断器缺省设置
+	defaultRequestTimeout         = 1000
+	defaultMaxConcurrentRequests  = 10
+	defaultRequestVolumeThreshold = 20
+	defaultSleepWindow            = 5000
+	defaultErrorPercentThreshold  = 50
+)
+
+type Server struct {
+	EntityConfBase
+
+	MaxProcs        int
+	StrictCheck     bool   //启动自检发现错误时不启动，缺省只记录日志
+	PprofPort       int    //Debug时的pprof端口，缺省为6060，小于0时不启动
+	ShutdownTimeout int    //退出时等待处理中请求的最长时间(秒)
+	AsyncWorkers    int    //同时执行的异步任务数
+	AsyncQueue      int    //等待执行的异步任务上限，超出时拒绝新任务，缺省1000
+	JobRetention    int    //已结束的异步任务在内存中保留的时间(秒)
+	JobStore        string //持久化异步任务的插件Class，如DatabasePlugin，为空时仅保存在内存
+
+	EventWorkers         int    //同时投递的事件数
+	EventQueue           int    //等待投递的事件上限，超出时拒绝发布，缺省1000
+	EventRetries         int    //slot返回系统错误时的重试次数，0表示缺省的3次，小于0时不重试
+	EventRetryBackoff    int    //首次重试前等待的时间(毫秒)，此后每次加倍
+	EventDeadLetters     int    //内存中保留的死信事件数
+	EventDeadLetterStore string //持久化死信的插件Class，如DatabasePlugin，为空时保存在内存
+	EventBridge          string //桥接事件的插件Class，如NsqPlugin、RedisPlugin，为空时事件只在进程内投递
+	EventBridgeTopics    string //经桥接投递的主题，逗号分隔，为空或*表示全部主题
+
+	ServiceCallTimeout   int  //服务间调用(RequestService)的超时(毫秒)，超时后取消ctx，0表示不限制
+	ServiceBreaker       bool //服务间调用按目标服务熔断，熔断器打开时立即以ErrSysBusy拒绝
+	ServiceMaxConcurrent int  //服务间调用每个目标服务同时进行的调用数上限，超出时立即拒绝，0表示不限制
+
+	ScheduleHistory int    //每个定时任务保留的执行记录数
+	ScheduleLock    string //节点间互斥执行定时任务的插件Class，如RedisPlugin，为空时每个节点都执行
+	ScheduleLockTTL int    //定时任务锁的有效时间(秒)，持有锁的节点停止后由其他节点接替
+
+	ConfigWatchInterval int    //检查配置文件修改的间隔(秒)，0表示不重新加载
+	StateStore          string //保存运行状态的插件Class，如DatabasePlugin，为空时保存在本地文件StateFile中
+	MetricsPort         int    //在单独端口上导出Prometheus格式的运行指标，0(默认)表示不导出。不校验身份，仅在内网开启
+	MetricsAddr         string //导出指标时监听的地址，缺省为127.0.0.1，只能从本机访问
+
+	TraceExporter    string  //追踪数据导出方式：otlp、file、log或插件Class，为空时不记录
+	TraceEndpoint    string  //OTLP/HTTP地址(如http://localhost:4318/v1/traces)或file方式的文件路径
+	TraceSampleRatio float64 //新trace的采样比例，0表示全部采样
+	TraceServiceName string  //导出时的服务名，缺省为Server
+}
+
+type CmdArgs struct {
+	Env      string `cli:"e,env" usage:"当前运行环境(dev/test)"`
+	APIDoc   string `cli:"apidoc" usage:"导出OpenAPI文档到指定目录后退出"`
+	APITable bool   `cli:"apitable" usage:"输出api.json中各版本生效的API及相对上一版本的变化后退出"`
+	Encrypt  bool   `cli:"encrypt" usage:"从标准输入读取配置值，使用环境变量HAS_SECRET_KEY中的密钥加密，输出ENC(...)格式的密文后退出"`
+	Check    bool   `cli:"check" usage:"检查配置段、api.json映射的slot及引用的接口，输出结果后退出，有错误时退出码为1"`
+	Reset    bool   `cli:"reset" usage:"执行配置中开启的破坏性一次性操作(如DatabasePlugin的Reset、FileService的CleanFs)，每项只执行一次"`
+}
+
+func NewServer(opt *ServerOptions, args ...htypes.Any) *ServerImplement {
+	s := new(ServerImplement)
+	s.init(opt, args)
+	return s
+}
+
+type ServerImplement struct {
+	Instance      IServer
+	class         string
+	conf          Server
+	args          CmdArgs
+	quitSignal    chan os.Signal //退出信号
+	router        IRouter
+	plugins       map[string]IPlugin
+	services      map[string]IService
+	pluginList    []IPlugin  //按注册顺序
+	serviceList   []IService //按打开顺序
+	pending       []*pendingService
+	dependencies  map[string]*ServiceDependencies
+	servicesReady bool //服务是否已按依赖打开
+	assetsManager IAssetManager
+	requestNo     atomic.Uint64
+	callPolicy    atomic.Value //*serviceCallPolicy，重新加载配置时整体替换
+	jobs          *jobManager
+	events        *eventBus
+	schedules     *scheduler
+	beforeClose   func(timeout time.Duration, report *shutdownReport) //关闭服务前的处理，如网关停止接收请求
+	stopWatch     func()                                              //停止监视配置文件
+	entityConfs   []IEntityConf                                       //由网关打开的实体的配置，与路由、插件的配置一起自检
+	checkAPIs     func() []*CheckProblem                              //服务打开后的自检，由网关设置
+	checked       []*CheckProblem                                     //配置段的自检结果
+}
+
+func (this *ServerImplement) Class() string {
+	return this.class
+}
+
+func (this *ServerImplement) Server() IServer {
+	return this
+}
+
+func (this *ServerImplement) Config() IEntityConf {
+	return &this.conf
+}
+
+func (this *ServerImplement) EntityMeta() *EntityMeta {
+	ensureEID(&this.conf)
+
+	return &EntityMeta{
+		ServerEID: this.conf.EID,
+		EID:       this.conf.EID,
+		Type:      EntityTypeServer,
+		Class:     this.class,
+	}
+}
+
+func (this *ServerImplement) EntityStub() *EntityStub {
+	return NewEntityStub(
+		&EntityStubOptions{
+			Owner:                this,
+			ResetConfig:          this.resetConfig,
+			GetDependencies:      this.getDependencies,
+			GetDeadLetters:       this.getDeadLetters,
+			RedeliverDeadLetters: this.redeliverDeadLetters,
+			GetSchedules:         this.getSchedules,
+			RunSchedule:          this.runSchedule,
+		})
+}
+
+func (this *ServerImplement) Assets() IAssetManager {
+	return this.assetsManager
+}
+
+func (this *ServerImplement) Router() IRouter {
+	return this.router
+}
+
+func (this *ServerImplement) Services() map[string]IService {
+	return this.services
+}
+
+func (this *ServerImplement) init(opt *ServerOptions, args ...htypes.Any) {
+	if opt == nil {
+		panic("ServerOptions cannot be nil")
+	}
+
+	if opt.Args != nil {
+		this.args = *opt.Args
+	} else {
+		this.parseArgs()
+	}
+
+	allowDestructive = this.args.Reset
+
+	//明文从标准输入读取，不出现在命令行参数及shell历史中。标准输出只有密文，便于重定向
+	if this.args.Encrypt {
+		fmt.Fprintln(os.Stderr, "enter the value to encrypt, end with EOF (Ctrl+D):")
+		s, err := hconf.EncryptFrom(os.Stdin)
+		if err != nil {
+			panic("failed to encrypt config value: " + err.Error())
+		}
+		_, _ = fmt.Fprintln(os.Stdout, s)
+		os.Exit(0)
+	}
+
+	if opt.Config != nil {
+		hconf.InitFromMap(opt.Config)
+	} else {
+		hconf.Init()
+	}
+	this.checkEntityConfs(this.optionConfs(opt)...)
+	hconf.Load(&this.conf)
+	this.callPolicy.Store(newServiceCallPolicy(&this.conf))
+	if err := hstate.Init(hconf.StateFile()); err != nil {
+		panic("failed to init state store: " + err.Error())
+	}
+	hlogger.Init(hconf.LogOutputs(), hconf.LogFileName())
+	applyLogLevel()
+
+	if hconf.IsDebug() && this.conf.PprofPort >= 0 {
+		go func() {
+			if this.conf.PprofPort == 0 {
+				this.conf.PprofPort = 6060
+			}
+		RETRY:
+			hlogger.Info("pprof port: %d", this.conf.PprofPort)
+			err := http.ListenAndServe(fmt.Sprintf(":%d", this.conf.PprofPort), nil)
+			if err != nil {
+				hlogger.Error(err)
+				this.conf.PprofPort++
+				goto RETRY
+			}
+		}()
+	}
+
+	if this.conf.MetricsPort > 0 {
+		go this.serveMetrics()
+	}
+
+	this.class = hruntime.GetObjectName(&this.conf)
+	this.Instance = this
+
+	if opt.AssetsManager == nil {
+		this.assetsManager = &FileAssets{}
+	} else {
+		this.assetsManager = opt.AssetsManager
+	}
+	if err := this.assetsManager.Init(); err != nil {
+		panic(err.D("failed to init assets"))
+	}
+
+	if err := opt.Router.Open(this, opt.Router); err != nil {
+		hlogger.Critical(err)
+		panic("failed to init server")
+	}
+	if err := CheckAndRegisterEntity(opt.Router, opt.Router); err != nil {
+		hlogger.Critical(err)
+		panic("failed to init server")
+	}
+	this.router = opt.Router
+
+	this.plugins = make(map[string]IPlugin)
+	for _, p := range opt.Plugins {
+		if err := p.Open(this, p); err != nil {
+			panic(err.D("failed to init server"))
+		}
+		if err := CheckAndRegisterEntity(p, this.router); err != nil {
+			panic(err.D("failed to init Server"))
+		}
+		this.plugins[p.(IEntity).Class()] = p
+		this.pluginList = append(this.pluginList, p)
+	}
+
+	if err := this.router.RegisterEntity(this); err != nil {
+		panic(err.D("failed to init Server"))
+	}
+
+	hmetrics.Default.Register(this.entitySamples)
+	this.initState()
+	this.initTrace()
+	this.initJobs()
+	this.initEvents()
+	this.initSchedules()
+	this.services = make(map[string]IService)
+	this.dependencies = make(map[string]*ServiceDependencies)
+}
+
+// optionConfs 服务器、路由、插件及网关实体的配置
+func (this *ServerImplement) optionConfs(opt *ServerOptions) []IEntityConf {
+	confs := []IEntityConf{&this.conf}
+	if e, ok := opt.Router.(IEntity); ok {
+		confs = append(confs, e.Config())
+	}
+	for _, p := range opt.Plugins {
+		if e, ok := p.(IEntity); ok {
+			confs = append(confs, e.Config())
+		}
+	}
+	return append(confs, this.entityConfs...)
+}
+
+// parseArgs 解析命令行，第一个参数为运行环境
+func (this *ServerImplement) parseArgs() {
+	cli.Run(&this.args, func(ctx *cli.Context) error {
+		arg := ctx.Args()
+		if len(arg) == 0 {
+			hlogger.Alert(">生产环境<")
+			return nil
+		}
+		switch arg[0] {
+		case "dev":
+			hlogger.Alert(">开发环境<")
+			hconf.EnvConfFile = "conf_dev.toml"
+		case "test":
+			hlogger.Alert(">测试环境<")
+			hconf.EnvConfFile = "conf_test.toml"
+		default:
+			hlogger.Alert(">自定义: %s<", arg[0])
+			hconf.EnvConfFile = fmt.Sprintf("conf_%s.toml", arg[0])
+		}
+		return nil
+	})
+}
+
+func (this *ServerImplement) Plugin(cls string) IPlugin {
+	if this.plugins == nil {
+		return nil
+	}
+	return this.plugins[cls]
+}
+
+func (this *ServerImplement) Start() {
+	if this.conf.MaxProcs > 0 {
+		runtime.GOMAXPROCS(this.conf.MaxProcs)
+	}
+
+	this.openServices()
+	this.selfCheck()
+	this.watchConfig()
+
+	pid := fmt.Sprintf("%d", os.Getpid())
+	if err := hio.CreateFile("./pid.pid", []byte(pid)); err != nil {
+		hlogger.Error(err)
+	}
+	hlogger.Info("server started...")
+
+	this.waitForQuit()
+}
+
+func (this *ServerImplement) Shutdown() {
+	this.quitSignal <- syscall.SIGQUIT
+}
+
+// Open 打开已注册的服务，不监视配置文件、不写pid文件、不等待退出信号，用于测试或嵌入其他程序。结束时调用Close
+func (this *ServerImplement) Open() {
+	this.openServices()
+}
+
+// Close 关闭由Open打开的服务、插件及路由
+func (this *ServerImplement) Close() {
+	this.close()
+}
+
+// RegisterService 注册服务。服务在Start时按声明的依赖关系依次打开，Start之后注册的服务立即打开
+func (this *ServerImplement) RegisterService(service IService, options htypes.Any) {
+	entity, ok := service.(IEntity)
+	if !ok {
+		panic(herrors.ErrSysInternal.New("service [%s] not implement IEntity interface", hruntime.GetObjectName(service)).
+			D("failed to register service [%s] ", hruntime.GetObjectName(service)))
+	}
+
+	// 提前加载配置，以便获取服务名称及依赖
+	if !this.checkServiceConf(entity.Config()) {
+		return
+	}
+	hconf.Load(entity.Config())
+	this.pending = append(this.pending, &pendingService{
+		name:    serviceNameOf(service),
+		service: service,
+		options: options,
+		deps:    serviceDependencies(service),
+	})
+
+	if this.servicesReady {
+		this.openServices()
+	}
+}
+
+func (this *ServerImplement) openServices() {
+	order, err := resolveServiceOrder(this.pending, this.services, this.plugins)
+	if err != nil {
+		panic(err.D("failed to register services"))
+	}
+	this.pending = nil
+
+	for _, ps := range order {
+		this.openService(ps.service, ps.options)
+		this.dependencies[ps.name] = ps.deps
+	}
+	this.servicesReady = true
+}
+
+func (this *ServerImplement) openService(service IService, options htypes.Any) {
+	var herr *herrors.Error
+
+	if herr = service.Open(this, service, options); herr != nil {
+		goto panic
+	}
+
+	if herr = this.router.RegisterService(service); herr != nil {
+		goto panic
+	}
+
+	if herr = this.router.RegisterEntity(service.(IEntity)); herr != nil {
+		goto panic
+	}
+
+	if herr = this.events.subscribe(service); herr != nil {
+		goto panic
+	}
+
+	if herr = this.schedules.add(service); herr != nil {
+		goto panic
+	}
+
+	this.services[service.Name()] = service
+	this.serviceList = append(this.serviceList, service)
+	return
+
+panic:
+	panic(herr.D("failed to register service [%s] ", hruntime.GetObjectName(service)))
+}
+
+func (this *ServerImplement) Slot(service string, slot string) *Slot {
+	s := this.services[service]
+	if s == nil {
+		return nil
+	}
+
+	return s.Slot(slot)
+}
+
+func (this *ServerImplement) RequestService(service string, slot string, params htypes.Map) (ret htypes.Any, err *herrors.Error) {
+	return this.RequestServiceContext(context.Background(), service, slot, params)
+}
+
+// RequestServiceContext 经服务间调用的容错处理(见resilience.go)请求服务
+func (this *ServerImplement) RequestServiceContext(ctx context.Context, service string, slot string, params htypes.Map) (htypes.Any, *herrors.Error) {
+	return this.callService(ctx, service, slot, params)
+}
+
+// requestService 经路由请求服务，不做容错处理。网关按接口的策略调用
+func (this *ServerImplement) requestService(ctx context.Context, service string, slot string, params htypes.Map) (ret htypes.Any, err *herrors.Error) {
+	if !hconf.IsDebug() {
+		defer func() {
+			e := recover()
+			if e != nil {
+				hlogger.Error(herrors.ErrSysInternal.New(e.(error).Error()))
+				hlogger.Error(string(debug.Stack()))
+			}
+		}()
+	}
+
+	done := observe(routerRequests, routerErrors, routerDuration, nil, this.router.(IEntity).Class(), service)
+	defer func() { done(err) }()
+	ctx, end := startSpan(ctx, "call "+service+"."+slot, htrace.KindClient)
+	defer func() { end(err) }()
+
+	return this.router.RequestServiceContext(ctx, service, slot, params)
+}
+
+// RequestServiceAsync 异步请求服务，立即返回任务句柄，任务状态可通过Job查询
+func (this *ServerImplement) RequestServiceAsync(ctx context.Context, service string, slot string, params htypes.Map) (*JobHandle, *herrors.Error) {
+	return this.jobs.submit(ctx, service, slot, params)
+}
+
+// InvalidateSlotCache 使本地服务slot的缓存失效，params为nil时清除该slot的全部缓存
+func (this *ServerImplement) InvalidateSlotCache(service string, slot string, params htypes.Map) *herrors.Error {
+	s := this.services[service]
+	if s == nil {
+		return herrors.ErrCallerInvalidRequest.New("service [%s] not found", service)
+	}
+	c, ok := s.(ISlotCacheInvalidator)
+	if !ok {
+		return herrors.ErrCallerInvalidRequest.New("service [%s] not implement ISlotCacheInvalidator", service)
+	}
+	c.InvalidateCache(slot, params)
+	return nil
+}
+
+func (this *ServerImplement) Job(id string) (*Job, *herrors.Error) {
+	return this.jobs.get(id)
+}
+
+// PublishEvent 实现IEventPublisher，发布事件，event为htypes.Map或结构，作为订阅slot的参数。订阅者处理前即返回
+func (this *ServerImplement) PublishEvent(ctx context.Context, topic string, event htypes.Any) *herrors.Error {
+	return this.events.publish(ctx, topic, event)
+}
+
+func (this *ServerImplement) waitForQuit() {
+	this.quitSignal = make(chan os.Signal)
+	signal.Notify(this.quitSignal,
+		os.Interrupt,
+		os.Kill,
+		syscall.SIGINT,
+		syscall.SIGTERM,
+		syscall.SIGKILL,
+		syscall.SIGQUIT)
+
+	<-this.quitSignal
+	this.close()
+	hlogger.Info("server exited")
+}
+
+func (this *ServerImplement) close() {
+	report := newShutdownReport()
+	if this.stopWatch != nil {
+		this.stopWatch()
+	}
+
+	timeout := this.conf.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	//各步骤共用一个截止时间，每步只等待剩余的时间
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	if this.beforeClose != nil {
+		this.beforeClose(remaining(deadline), report)
+	}
+	//路由先注销本节点并排空远程请求，服务关闭前不再有新的流量进入
+	if d, ok := this.router.(IRouterDrainer); ok {
+		d.Drain(remaining(deadline))
+		report.add("router [%s] drained", this.router.(IEntity).Class())
+	}
+	this.jobs.drain(remaining(deadline), report)
+	this.schedules.drain(remaining(deadline), report)
+	this.events.drain(remaining(deadline), report)
+
+	//服务按打开的逆序关闭，被依赖的服务最后关闭
+	for i := len(this.serviceList) - 1; i >= 0; i-- {
+		s := this.serviceList[i]
+		s.Close()
+		report.add("service [%s] closed", s.Name())
+	}
+
+	for i := len(this.pluginList) - 1; i >= 0; i-- {
+		p := this.pluginList[i]
+		p.Close()
+		report.add("plugin [%s] closed", p.(IEntity).Class())
+	}
+
+	if this.router != nil {
+		this.router.Close()
+		report.add("router [%s] closed", this.router.(IEntity).Class())
+	}
+
+	if htrace.Enabled() {
+		htrace.Shutdown()
+		report.add("trace exporter flushed")
+	}
+
+	report.log()
+}
+
+// initState 使用插件保存运行状态，此前产生的状态(如路由及插件的EID)仍从本地文件读取
+func (this *ServerImplement) initState() {
+	if this.conf.StateStore == "" {
+		return
+	}
+
+	hstate.Use(mustProvide(this, this.conf.StateStore, IStateStoreProvider.StateStore))
+}
+
+// mustProvide 由配置项指定的插件plugin(须实现P)创建所需的对象，插件不存在、未实现P或创建失败时panic
+func mustProvide[P any, T any](s *ServerImplement, plugin string, provide func(P) (T, *herrors.Error)) T {
+	provider, ok := s.plugins[plugin].(P)
+	if !ok {
+		panic(herrors.ErrSysInternal.New("plugin [%s] not found or not implement %s", plugin, reflect.TypeOf((*P)(nil)).Elem().Name()).D("failed to init Server"))
+	}
+	ret, err := provide(provider)
+	if err != nil {
+		panic(err.D("failed to init Server"))
+	}
+	return ret
+}
+
+func (this *ServerImplement) initJobs() {
+	var store IJobStore = &memJobStore{}
+	if this.conf.JobStore != "" {
+		store = mustProvide(this, this.conf.JobStore, IJobStoreProvider.JobStore)
+	}
+
+	this.jobs = newJobManager(this, store, this.conf.AsyncWorkers, this.conf.AsyncQueue, this.conf.JobRetention)
+}
+
+func (this *ServerImplement) initEvents() {
+	var bridge IEventBridge
+	if this.conf.EventBridge != "" {
+		bridge = mustProvide(this, this.conf.EventBridge, IEventBridgeProvider.EventBridge)
+	}
+	var store IDeadLetterStore
+	if this.conf.EventDeadLetterStore != "" {
+		store = mustProvide(this, this.conf.EventDeadLetterStore, IDeadLetterStoreProvider.DeadLetterStore)
+	}
+
+	this.events = newEventBus(this, bridge, store)
+}
+
+func (this *ServerImplement) initSchedules() {
+	var lock IScheduleLock
+	if this.conf.ScheduleLock != "" {
+		lock = mustProvide(this, this.conf.ScheduleLock, IScheduleLockProvider.ScheduleLock)
+	}
+
+	this.schedules = newScheduler(this, lock)
+}
+
+func (this *ServerImplement) newRequestNo() uint64 {
+	return this.requestNo.Add(1)
+}
+
+func (this *ServerImplement) getDependencies(_ htypes.Map) (htypes.Any, *herrors.Error) {
+	graph := &DependencyGraph{
+		Services: this.dependencies,
+	}
+	for _, s := range this.serviceList {
+		graph.Order = append(graph.Order, s.Name())
+	}
+	return graph, nil
+}
+
+func (this *ServerImplement) resetConfig(ps htypes.Map) *herrors.Error {
+	this.conf.MaxProcs = 1
+
+	if err := hconf.Persist(); err != nil {
+		return herrors.ErrSysInternal.New(err.Error()).D("failed to persist config")
+	}
+	return nil
+}
@@ -1,20 +1,21 @@
 package core
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/drharryhe/has/common/hlogger"
 	"reflect"
+	"sort"
 	"strings"
 
 	jsoniter "github.com/json-iterator/go"
-	"go.uber.org/ratelimit"
+	"go.uber.org/atomic"
 	"gopkg.in/go-playground/validator.v9"
 
 	"github.com/drharryhe/has/common/hconf"
 	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htrace"
 	"github.com/drharryhe/has/common/htypes"
-	"github.com/drharryhe/has/utils/hconverter"
-	"github.com/drharryhe/has/utils/hrandom"
 	"github.com/drharryhe/has/utils/hruntime"
 )
 
@@ -25,13 +26,21 @@ const (
 	defaultLimiter = 100 //缺省限流设置，每秒100个请求
 )
 
-var validate *validator.Validate
+var (
+	validate    *validator.Validate
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
 
 type ServiceConf struct {
 	EntityConfBase
 
-	Name         string
-	LimitedSlots string
+	Name         string `require:"true"`
+	LimitedSlots string //限流，格式为 [slot:]rate[:burst],...，slot省略或为*时为服务整体，rate为每秒请求数，burst为允许的突发请求数
+	LimitPlugin  string //限流令牌桶存储插件Class，为空时使用本地内存，如RedisPlugin使各节点共享配额
+	CachedSlots  string //缓存的slot，格式为 slot:ttl[:key1|key2],...
+	CachePlugin  string //缓存插件Class，缺省为MemCachePlugin
+
+	ScheduledSlots []string //定时执行的slot，每项格式为 slot:cron[:missed[:timeout]]，如 'Cleanup:0 3 * * *:once:600'
 }
 
 type Service struct {
@@ -39,31 +48,41 @@ type Service struct {
 	instance IService
 
 	class        string
+	name         string
 	slots        map[string]*Slot
 	slotHandlers map[string]*MethodCaller
-	limiter      ratelimit.Limiter            //服务整体限流
-	slotLimiters map[string]ratelimit.Limiter //slot限制器
+	cacheTags    map[string]string //slot请求对象上声明的缓存标签
+	runtime      atomic.Value      //*serviceRuntime
+}
+
+// serviceRuntime 由配置生成的限流及缓存设置。重新加载配置时先生成并校验新的设置，再整体替换，请求处理中不会看到部分更新的状态
+type serviceRuntime struct {
+	limiter      *rateRule            //服务整体限流
+	slotLimiters map[string]*rateRule //slot限制器
+	limitStore   IRateLimitStore
+
+	cachePolicies map[string]*slotCachePolicy
+	cache         ISlotCache
+	cacheClass    string //缓存插件Class
 }
 
+// Name 服务名运行期间不可修改，Open之后返回已读取的服务名
 func (this *Service) Name() string {
-	val, err := this.instance.(IEntity).EntityStub().Manage(ManageGetConfigItems, htypes.Map{"Name": nil})
-	if err != nil {
-		panic(err)
+	if this.name != "" {
+		return this.name
 	}
-	if val.(htypes.Map)["Name"].(string) == "" {
+
+	name, _ := hruntime.GetObjectFieldValue(this.instance.(IEntity).Config(), "Name").(string)
+	if name == "" {
 		panic(herrors.ErrSysInternal.New("service [%s] name not configured", this.class).D("failed to open service"))
 	}
-
-	return val.(htypes.Map)["Name"].(string)
+	return name
 }
 
+// LimitedSlots 直接读取配置，重新加载配置时也会调用
 func (this *Service) LimitedSlots() []string {
-	val, err := this.instance.(IEntity).EntityStub().Manage(ManageGetConfigItems, htypes.Map{"LimitedSlots": nil})
-	if err != nil {
-		return nil
-	}
-
-	slots := strings.TrimSpace(val.(htypes.Map)["LimitedSlots"].(string))
+	val, _ := hruntime.GetObjectFieldValue(this.instance.(IEntity).Config(), "LimitedSlots").(string)
+	slots := strings.TrimSpace(val)
 	if slots == "" {
 		return nil
 	}
@@ -85,10 +104,7 @@ func (this *Service) Server() IServer {
 }
 
 func (this *Service) EntityMeta() *EntityMeta {
-	if this.instance.(IEntity).Config().GetEID() == "" {
-		this.instance.(IEntity).Config().SetEID(hrandom.UuidWithoutDash())
-		hconf.Save()
-	}
+	ensureEID(this.instance.(IEntity).Config())
 
 	return &EntityMeta{
 		ServerEID: this.Server().(IEntity).Config().GetEID(),
@@ -109,7 +125,7 @@ func (this *Service) Open(s IServer, instance IService, options htypes.Any) *her
 	hconf.Load(this.instance.(IEntity).Config()) // 为什么注释了这行?
 
 	this.server = s
-	if this.Name() == "" {
+	if this.name = this.Name(); this.name == "" {
 		return herrors.ErrSysInternal.New("service [%s] name not configured", this.class).D("failed to open service")
 	}
 
@@ -117,7 +133,14 @@ func (this *Service) Open(s IServer, instance IService, options htypes.Any) *her
 		return err
 	}
 
-	this.initLimiter()
+	rt := &serviceRuntime{}
+	if err := this.buildLimiter(rt); err != nil {
+		return err.D("failed to open service [%s]", this.class)
+	}
+	if err := this.buildCache(rt); err != nil {
+		return err.D("failed to open service [%s]", this.class)
+	}
+	this.runtime.Store(rt)
 	hlogger.Info("Service [%s] Registered...", this.class)
 	return nil
 }
@@ -134,11 +157,12 @@ func (this *Service) Slot(slot string) *Slot {
 }
 
 func (this *Service) Request(slot string, params htypes.Map) (htypes.Any, *herrors.Error) {
-	//如果配置了限流，则先进行限流处理
-	if this.slotLimiters[slot] != nil {
-		this.slotLimiters[slot].Take()
-	} else if this.limiter != nil {
-		this.limiter.Take()
+	return this.RequestContext(context.Background(), slot, params)
+}
+
+func (this *Service) RequestContext(ctx context.Context, slot string, params htypes.Map) (ret htypes.Any, err *herrors.Error) {
+	if ctx == nil {
+		ctx = context.Background()
 	}
 
 	s := this.slots[slot]
@@ -146,6 +170,16 @@ func (this *Service) Request(slot string, params htypes.Map) (htypes.Any, *herro
 		return nil, herrors.ErrCallerInvalidRequest.New("slot [%s] not found or disabled", slot)
 	}
 
+	done := observe(slotRequests, slotErrors, slotDuration, slotInflight, this.name, slot)
+	defer func() { done(err) }()
+	ctx, end := startSpan(ctx, "slot "+this.name+"."+slot, htrace.KindInternal)
+	defer func() { end(err) }()
+
+	//如果配置了限流，令牌不足时立即拒绝
+	if err = this.takeLimiter(slot); err != nil {
+		return nil, err
+	}
+
 	//处理传入参数
 	if params["INITWS"] == nil || !params["INITWS"].(bool) {
 		if err := this.checkParams(params, s.Params); err != nil {
@@ -153,8 +187,51 @@ func (this *Service) Request(slot string, params htypes.Map) (htypes.Any, *herro
 		}
 	}
 
+	//请求已取消或超时，不再调用服务
+	if err := ContextError(ctx); err != nil {
+		return nil, err
+	}
+
+	//声明了缓存的slot，先查缓存
+	rt := this.currentRuntime()
+	policy := rt.cachePolicies[slot]
+	if policy == nil || rt.cache == nil {
+		return this.callSlotHandler(ctx, s, params)
+	}
+
+	//缓存中保存及返回的都是副本，调用方修改结果不影响缓存
+	bucket, key := this.cacheBucket(slot), policy.key(params)
+	if ret, ok := rt.cache.Get(bucket, key); ok {
+		policy.hits.Inc()
+		return hruntime.DeepCopy(ret), nil
+	}
+	policy.misses.Inc()
+
 	//正式调用服务
-	return this.callSlotHandler(s, params)
+	ret, err = this.callSlotHandler(ctx, s, params)
+	if err == nil {
+		rt.cache.Set(bucket, key, hruntime.DeepCopy(ret), policy.ttl)
+	}
+	return ret, err
+}
+
+// takeLimiter slot的限流优先于服务整体限流，记录拒绝次数
+func (this *Service) takeLimiter(slot string) *herrors.Error {
+	rt := this.currentRuntime()
+	limiter, ok := rt.slotLimiters[slot]
+	key := "svc:" + this.name + "." + slot
+	if !ok {
+		limiter, key = rt.limiter, "svc:"+this.name
+	}
+	if limiter == nil {
+		return nil
+	}
+
+	err := limiter.take(rt.limitStore, key, "service ["+this.name+"]")
+	if err != nil {
+		limiterRejects.With(this.name, slot).Inc()
+	}
+	return err
 }
 
 type SlotsRequest struct {
@@ -178,6 +255,7 @@ func (this *Service) Response(res *SlotResponse, data htypes.Any, err *herrors.E
 func (this *Service) mountSlots(instance IService) *herrors.Error {
 	this.slotHandlers = make(map[string]*MethodCaller)
 	this.slots = make(map[string]*Slot)
+	this.cacheTags = make(map[string]string)
 
 	typ := reflect.TypeOf(instance)
 	val := reflect.ValueOf(instance)
@@ -198,16 +276,27 @@ func (this *Service) mountSlots(instance IService) *herrors.Error {
 			continue
 		}
 
-		if mType.NumIn() != 3 {
+		//slot支持两种形式：(req, res) 以及 (ctx, req, res)
+		withCtx := false
+		switch mType.NumIn() {
+		case 3:
+		case 4:
+			if !mType.In(1).Implements(contextType) {
+				continue
+			}
+			withCtx = true
+		default:
 			continue
 		}
+		reqIndex := mType.NumIn() - 2
 
-		ctxType := mType.In(1)
-		if !ctxType.Implements(reflect.TypeOf((*ISlotRequest)(nil)).Elem()) && ctxType.Elem().Name() != "Map" {
+		ctxType := mType.In(reqIndex)
+		if !ctxType.Implements(reflect.TypeOf((*ISlotRequest)(nil)).Elem()) &&
+			(ctxType.Kind() != reflect.Ptr || ctxType.Elem().Name() != "Map") {
 			continue
 		}
 
-		ctxType = mType.In(2)
+		ctxType = mType.In(reqIndex + 1)
 		if ctxType.Kind() != reflect.Ptr {
 			continue
 		}
@@ -215,23 +304,26 @@ func (this *Service) mountSlots(instance IService) *herrors.Error {
 			continue
 		}
 
-		this.slotHandlers[mName] = &MethodCaller{Object: val, Handler: method.Func}
+		this.slotHandlers[mName] = &MethodCaller{Object: val, Handler: method.Func, WithContext: withCtx}
 
 		//解析请求参数
 		this.slots[mName] = &Slot{
 			Name: mName,
 		}
-		if mType.In(1).Elem().Name() != "Map" {
-			if err := this.parseRequestParameters(mType.In(1), this.slots[mName]); err != nil {
+		if mType.In(reqIndex).Elem().Name() != "Map" {
+			if err := this.parseRequestParameters(mType.In(reqIndex), this.slots[mName]); err != nil {
 				return err
 			}
+			if tag := cacheTagOf(mType.In(reqIndex)); tag != "" {
+				this.cacheTags[mName] = tag
+			}
 		}
 	}
 
 	return nil
 }
 
-func (this *Service) callSlotHandler(slot *Slot, params htypes.Map) (htypes.Any, *herrors.Error) {
+func (this *Service) callSlotHandler(ctx context.Context, slot *Slot, params htypes.Map) (htypes.Any, *herrors.Error) {
 	handler := this.slotHandlers[slot.Name]
 	if handler != nil {
 		var (
@@ -257,14 +349,18 @@ func (this *Service) callSlotHandler(slot *Slot, params htypes.Map) (htypes.Any,
 		//	return nil, herrors.ErrSysInternal.New(err.Error())
 		//}
 
-		handler.Handler.Call([]reflect.Value{handler.Object, reflect.ValueOf(req), reflect.ValueOf(&res)})
+		if handler.WithContext {
+			handler.Handler.Call([]reflect.Value{handler.Object, reflect.ValueOf(ctx), reflect.ValueOf(req), reflect.ValueOf(&res)})
+		} else {
+			handler.Handler.Call([]reflect.Value{handler.Object, reflect.ValueOf(req), reflect.ValueOf(&res)})
+		}
 		if res.Error != nil {
 			return nil, res.Error
 		} else {
 			return res.Data, nil
 		}
 	} else {
-		return nil, herrors.ErrCallerInvalidRequest.New("service [%s] slot [%s] not found", this.class, slot).D("failed to call slot")
+		return nil, herrors.ErrCallerInvalidRequest.New("service [%s] slot [%s] not found", this.class, slot.Name).D("failed to call slot")
 	}
 }
 
@@ -279,144 +375,65 @@ func (this *Service) BoolTypeTransform(params *htypes.Map) {
 	}
 }
 
-func (this *Service) checkParams(ps htypes.Map, def map[string]*SlotParameter) *herrors.Error {
-	var v htypes.Any
-	var toDelNames []string
-	replaceParams := make(map[string]htypes.Any)
-
-	for _, p := range def {
-		if !p.InsensitiveCase {
-			v = ps[p.Name]
-		} else {
-			name := strings.ToLower(p.Name)
-			for k, t := range ps {
-				if strings.ToLower(k) == name {
-					v = t
-					toDelNames = append(toDelNames, k)
-					replaceParams[p.Name] = v
-					break
-				}
-			}
-		}
-
-		if v == nil {
-			if p.Require {
-				return herrors.ErrCallerInvalidRequest.New("required parameter [%s] not found", p.Name)
-			} else {
-				continue
-			}
-		}
-
-		if p.Type != "" {
-			if err := htypes.Validate(v, htypes.HType(p.Type)); err != nil {
-				return herrors.ErrCallerInvalidRequest.New(err.Error())
-			}
-		}
-		if p.Validate != "" {
-			if err := this.validateVar(v, p.Validate); err != nil {
-				return err
-			}
-		}
-	}
-
-	for _, k := range toDelNames {
-		delete(ps, k)
+func (this *Service) currentRuntime() *serviceRuntime {
+	if rt, ok := this.runtime.Load().(*serviceRuntime); ok {
+		return rt
 	}
-	for k, v := range replaceParams {
-		ps[k] = v
-	}
-
-	return nil
+	return &serviceRuntime{}
 }
 
-func (this *Service) initLimiter() {
-	this.slotLimiters = make(map[string]ratelimit.Limiter)
-
-	limitSlots := this.LimitedSlots()
-	if len(limitSlots) == 0 {
-		this.limiter = nil
-		return
+func (this *Service) buildLimiter(rt *serviceRuntime) *herrors.Error {
+	limiter, slotLimiters, err := parseLimitedSlots(this.LimitedSlots())
+	if err != nil {
+		return herrors.ErrSysInternal.New("service [%s] %v", this.class, err)
 	}
 
-	for _, s := range limitSlots {
-		vv := strings.Split(s, ":")
-		if len(vv) == 1 {
-			l, ok := hconverter.String2NumberDecimal(s)
-			if !ok || l < 0 {
-				panic(herrors.ErrSysInternal.New("invalid service limiter config. [%s]:%s", this.class, s))
-			}
-			if l == 0 {
-				l = defaultLimiter
-			}
-			this.limiter = ratelimit.New(int(l))
-		} else if len(vv) != 2 {
-			panic(herrors.ErrSysInternal.New("invalid service limiter config. [%s]:%s", this.class, s))
-		} else {
-			l, ok := hconverter.String2NumberDecimal(vv[1])
-			if !ok || l < 0 {
-				panic(herrors.ErrSysInternal.New("invalid service limiter config. [%s]:%s", this.class, vv[1]))
-			}
-			this.slotLimiters[strings.TrimSpace(vv[0])] = ratelimit.New(int(l))
+	var store IRateLimitStore
+	if limiter != nil || len(slotLimiters) > 0 {
+		plugin, _ := hruntime.GetObjectFieldValue(this.instance.(IEntity).Config(), "LimitPlugin").(string)
+		var e *herrors.Error
+		if store, e = rateLimitStore(this.server, plugin); e != nil {
+			return e
 		}
 	}
+	rt.limiter, rt.slotLimiters, rt.limitStore = limiter, slotLimiters, store
+	return nil
 }
 
-func (this *Service) validateVar(v htypes.Any, tag string) *herrors.Error {
-	if validate == nil {
-		validate = validator.New()
+// OnConfigReload 服务名不可修改，限流及缓存设置全部校验通过后整体替换，未修改的部分沿用原有的令牌桶及缓存统计
+func (this *Service) OnConfigReload(old IEntityConf) *herrors.Error {
+	conf := this.instance.(IEntity).Config()
+	if hruntime.GetObjectFieldValue(old, "Name") != hruntime.GetObjectFieldValue(conf, "Name") {
+		return herrors.ErrCallerInvalidRequest.New("service name can not be changed at runtime")
 	}
 
-	k := reflect.TypeOf(v).Kind()
-	if k != reflect.String && k != reflect.Float64 && k != reflect.Float32 && k != reflect.Uint && k != reflect.Uint8 && k != reflect.Uint16 && k != reflect.Uint32 && k != reflect.Uint64 && k != reflect.Int && k != reflect.Int8 && k != reflect.Int16 && k != reflect.Int32 && k != reflect.Int64 {
-		return herrors.ErrCallerInvalidRequest.New("invalid var kind [%s]", htypes.GetKindName(k))
+	cur := this.currentRuntime()
+	rt := *cur
+	if hruntime.GetObjectFieldValue(old, "LimitedSlots") != hruntime.GetObjectFieldValue(conf, "LimitedSlots") ||
+		hruntime.GetObjectFieldValue(old, "LimitPlugin") != hruntime.GetObjectFieldValue(conf, "LimitPlugin") {
+		if err := this.buildLimiter(&rt); err != nil {
+			return err
+		}
 	}
 
-	if err := validate.Var(v, tag); err != nil {
-		return herrors.ErrCallerInvalidRequest.New(err.Error())
+	if hruntime.GetObjectFieldValue(old, "CachedSlots") != hruntime.GetObjectFieldValue(conf, "CachedSlots") ||
+		hruntime.GetObjectFieldValue(old, "CachePlugin") != hruntime.GetObjectFieldValue(conf, "CachePlugin") {
+		if err := this.buildCache(&rt); err != nil {
+			return err
+		}
 	}
-
+	this.runtime.Store(&rt)
 	return nil
 }
 
-func (this *Service) parseRequestParameters(request reflect.Type, slot *Slot) *herrors.Error {
-	slot.Params = make(map[string]*SlotParameter)
-	slot.ReqInstance = reflect.New(request.Elem()).Interface()
-
-	t := request.Elem()
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
-		if f.Name == "SlotRequestBase" {
-			continue
-		}
-
-		if f.Type.Kind() != reflect.Ptr {
-			continue
-		}
-
-		name := f.Tag.Get("json")
-		p := &SlotParameter{
-			Name: name,
-		}
-		slot.Params[name] = p
-
-		tag := f.Tag.Get("param")
-		if tag == "-" {
-			continue
-		}
-		kv := hruntime.ParseTag(tag)
-		for k, v := range kv {
-			switch k {
-			case "require":
-				p.Require = true
-			case "insensitive":
-				p.InsensitiveCase = true
-			case "validate":
-				p.Validate = v
-			case "type":
-				p.Type = v
-			}
+// slotNames 服务的slot，不含框架提供的Slots，供启动自检
+func (this *Service) slotNames() []string {
+	var names []string
+	for name := range this.slots {
+		if name != "Slots" {
+			names = append(names, name)
 		}
 	}
-
-	return nil
+	sort.Strings(names)
+	return names
 }
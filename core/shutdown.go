@@ -0,0 +1,64 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/drharryhe/has/common/hlogger"
+)
+
+const (
+	defaultShutdownTimeout = 10 //秒
+	drainCheckInterval     = 50 * time.Millisecond
+)
+
+// IRouterDrainer 退出时在关闭服务之前注销本节点并等待处理中的远程请求完成的路由，Close时只需释放连接
+type IRouterDrainer interface {
+	Drain(timeout time.Duration)
+}
+
+// shutdownReport 记录优雅退出过程中每一步的结果及耗时
+type shutdownReport struct {
+	start time.Time
+	last  time.Time
+	items []string
+}
+
+func newShutdownReport() *shutdownReport {
+	now := time.Now()
+	return &shutdownReport{
+		start: now,
+		last:  now,
+	}
+}
+
+func (this *shutdownReport) add(format string, v ...interface{}) {
+	now := time.Now()
+	this.items = append(this.items, fmt.Sprintf("%s (%v)", fmt.Sprintf(format, v...), now.Sub(this.last)))
+	this.last = now
+}
+
+func (this *shutdownReport) log() {
+	hlogger.Info("shutdown report:\r\n\t%s\r\n\ttotal: %v", strings.Join(this.items, "\r\n\t"), time.Since(this.start))
+}
+
+// remaining 距deadline的剩余时间，已过时返回0
+func remaining(deadline time.Time) time.Duration {
+	if d := time.Until(deadline); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// waitUntil 每隔一段时间检查done，直到其返回true或超时，返回是否在超时前完成
+func waitUntil(timeout time.Duration, done func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(drainCheckInterval)
+	}
+	return true
+}
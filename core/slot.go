@@ -1,6 +1,8 @@
 package core
 
 import (
+	"reflect"
+
 	jsoniter "github.com/json-iterator/go"
 
 	"github.com/drharryhe/has/common/herrors"
@@ -24,6 +26,13 @@ type SlotParameter struct {
 	InsensitiveCase bool
 	Validate        string
 	Type            string
+	Default         htypes.Any                //缺省值，参数未传入时使用
+	Enum            []string                  //可选值
+	MinLen          *int                      //数组最小长度
+	MaxLen          *int                      //数组最大长度
+	Fields          map[string]*SlotParameter //结构体或结构体数组的字段定义
+
+	goType reflect.Type //参数字段类型
 }
 
 type SlotRequestBase struct {
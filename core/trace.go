@@ -0,0 +1,71 @@
+package core
+
+import (
+	"context"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htrace"
+)
+
+//分布式追踪。Server配置项TraceExporter指定导出方式后，connector、网关、middleware、路由及slot各自创建Span，
+//经rpcx调用时trace上下文随RpcRequestArguments传递
+
+const (
+	TraceExporterOTLP = "otlp" //OTLP/HTTP，地址为TraceEndpoint
+	TraceExporterFile = "file" //写入本地文件TraceEndpoint
+	TraceExporterLog  = "log"  //写入日志
+
+	defaultTraceFile = "./trace.json"
+)
+
+// initTrace TraceExporter为otlp、file、log或实现ITraceExporterProvider的插件Class
+func (this *ServerImplement) initTrace() {
+	var exporter htrace.IExporter
+	switch this.conf.TraceExporter {
+	case "":
+		return
+	case TraceExporterOTLP:
+		exporter = htrace.NewOTLPExporter(this.conf.TraceEndpoint, nil)
+	case TraceExporterFile:
+		file := this.conf.TraceEndpoint
+		if file == "" {
+			file = defaultTraceFile
+		}
+		var err error
+		if exporter, err = htrace.NewFileExporter(file); err != nil {
+			panic(herrors.ErrSysInternal.New(err.Error()).D("failed to init Server"))
+		}
+	case TraceExporterLog:
+		exporter = htrace.NewLogExporter()
+	default:
+		exporter = mustProvide(this, this.conf.TraceExporter, ITraceExporterProvider.TraceExporter)
+	}
+
+	name := this.conf.TraceServiceName
+	if name == "" {
+		name = this.class
+	}
+	htrace.Init(exporter, htrace.Options{
+		ServiceName: name,
+		SampleRatio: this.conf.TraceSampleRatio,
+	})
+}
+
+// startSpan 开始Span，返回的函数在操作结束时以结果错误调用
+func startSpan(ctx context.Context, name string, kind int) (context.Context, func(err *herrors.Error)) {
+	ctx, span := htrace.Start(ctx, name, kind)
+	if span == nil {
+		return ctx, func(*herrors.Error) {}
+	}
+	if scope := ScopeFromContext(ctx); scope != nil {
+		span.SetAttribute("request.id", scope.ID)
+	}
+
+	return ctx, func(err *herrors.Error) {
+		if err != nil {
+			span.SetAttribute("error.code", err.Code)
+			span.SetError(err.Error())
+		}
+		span.Finish()
+	}
+}
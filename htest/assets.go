@@ -0,0 +1,67 @@
+package htest
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/core"
+)
+
+const (
+	apiFile = "api.json"
+)
+
+// Assets 内存中的资源文件，路径按path.Clean比较，"./api.json"与"api.json"相同
+type Assets struct {
+	core.BaseAssetManager
+
+	files map[string][]byte
+}
+
+func NewAssets(files map[string][]byte) *Assets {
+	a := &Assets{files: make(map[string][]byte)}
+	for p, bs := range files {
+		a.Set(p, bs)
+	}
+	return a
+}
+
+func (this *Assets) Set(p string, bs []byte) {
+	this.files[path.Clean(filepath.ToSlash(p))] = bs
+}
+
+// SetAPI 以接口定义生成api.json
+func (this *Assets) SetAPI(def *core.APIDefine) *herrors.Error {
+	bs, err := jsoniter.Marshal(def)
+	if err != nil {
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+	this.Set(apiFile, bs)
+	return nil
+}
+
+func (this *Assets) File(p string) ([]byte, *herrors.Error) {
+	bs, ok := this.files[path.Clean(filepath.ToSlash(p))]
+	if !ok {
+		return nil, herrors.ErrSysInternal.New("asset [%s] not found", p)
+	}
+	return bs, nil
+}
+
+func (this *Assets) Files(dir string, ext string) ([]string, *herrors.Error) {
+	dir = path.Clean(filepath.ToSlash(dir))
+
+	var ret []string
+	for p := range this.files {
+		if path.Dir(p) == dir && (ext == "" || strings.EqualFold(path.Ext(p), ext)) {
+			ret = append(ret, p)
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
@@ -0,0 +1,56 @@
+//go:build cgo
+
+package htest
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/drharryhe/has/plugins/hdatabaseplugin"
+)
+
+var (
+	memoryDBs int64
+)
+
+// NewDatabasePlugin 使用sqlite内存数据库的数据库插件，keys为连接Key，缺省只有Key为空的缺省连接。
+// 各连接为独立的数据库，测试结束时释放，不同测试之间互不影响。sqlite驱动需启用cgo，未启用时见database_nocgo.go
+func NewDatabasePlugin(t testing.TB, keys ...string) *hdatabaseplugin.Plugin {
+	t.Helper()
+
+	if len(keys) == 0 {
+		keys = []string{""}
+	}
+	dbs := make(map[string]*gorm.DB)
+	for _, k := range keys {
+		dbs[k] = NewMemoryDB(t)
+	}
+	return hdatabaseplugin.NewWithDB(dbs)
+}
+
+// NewMemoryDB 打开一个sqlite内存数据库，测试结束时关闭。同一数据库的多个连接共享数据
+func NewMemoryDB(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:htest%d?mode=memory&cache=shared&_busy_timeout=5000", atomic.AddInt64(&memoryDBs, 1))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open memory database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to open memory database: %v", err)
+	}
+	//内存数据库在最后一个连接关闭时释放，保留空闲连接直至测试结束
+	sqlDB.SetMaxIdleConns(1)
+	sqlDB.SetConnMaxLifetime(0)
+	t.Cleanup(func() {
+		_ = sqlDB.Close()
+	})
+	return db
+}
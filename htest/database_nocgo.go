@@ -0,0 +1,27 @@
+//go:build !cgo
+
+package htest
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+
+	"github.com/drharryhe/has/plugins/hdatabaseplugin"
+)
+
+// NewDatabasePlugin sqlite驱动需启用cgo，未启用时跳过使用数据库的测试，使模块仍可在CGO_ENABLED=0时构建
+func NewDatabasePlugin(t testing.TB, keys ...string) *hdatabaseplugin.Plugin {
+	t.Helper()
+
+	t.Skip("sqlite memory database requires cgo")
+	return nil
+}
+
+// NewMemoryDB 同NewDatabasePlugin，未启用cgo时跳过测试
+func NewMemoryDB(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	t.Skip("sqlite memory database requires cgo")
+	return nil
+}
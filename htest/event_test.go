@@ -0,0 +1,256 @@
+package htest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/core"
+)
+
+type EventService struct {
+	core.ServiceConf
+}
+
+// eventService Created记录收到的事件，Fail总是返回用户错误，Slow阻塞到release关闭或ctx取消
+type eventService struct {
+	core.Service
+	conf     EventService
+	received chan *core.Event
+	release  chan struct{}
+}
+
+type EventRequest struct {
+	core.SlotRequestBase
+
+	Order *string `json:"order"`
+}
+
+func newEventService() *eventService {
+	return &eventService{received: make(chan *core.Event, 10), release: make(chan struct{})}
+}
+
+func (this *eventService) Subscriptions() map[string]string {
+	return map[string]string{"order.created": "Created", "order.failed": "Fail", "order.slow": "Slow"}
+}
+
+func (this *eventService) Created(ctx context.Context, req *EventRequest, res *core.SlotResponse) {
+	this.received <- core.EventFromContext(ctx)
+	this.Response(res, nil, nil)
+}
+
+func (this *eventService) Fail(req *EventRequest, res *core.SlotResponse) {
+	this.Response(res, nil, herrors.ErrUserInvalidAct.New("rejected"))
+}
+
+func (this *eventService) Slow(ctx context.Context, req *EventRequest, res *core.SlotResponse) {
+	select {
+	case <-this.release:
+	case <-ctx.Done():
+	}
+	this.Response(res, nil, nil)
+}
+
+func (this *eventService) Config() core.IEntityConf {
+	return &this.conf
+}
+
+func (this *eventService) EntityStub() *core.EntityStub {
+	return core.NewEntityStub(&core.EntityStubOptions{Owner: this})
+}
+
+type QueuePlugin struct {
+	core.PluginConf
+}
+
+// queuePlugin 记录发布的消息及订阅的handler，fail不为nil时发布失败
+type queuePlugin struct {
+	core.BasePlugin
+	conf      QueuePlugin
+	lock      sync.Mutex
+	published [][]byte
+	handlers  map[string]func(data []byte) *herrors.Error //topic/group -> handler
+	fail      *herrors.Error
+}
+
+func (this *queuePlugin) EventBridge() (core.IEventBridge, *herrors.Error) {
+	return this, nil
+}
+
+func (this *queuePlugin) Publish(topic string, data []byte) *herrors.Error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.fail != nil {
+		return this.fail
+	}
+	this.published = append(this.published, data)
+	return nil
+}
+
+func (this *queuePlugin) Subscribe(topic string, group string, handler func(data []byte) *herrors.Error) *herrors.Error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.handlers[topic+"/"+group] = handler
+	return nil
+}
+
+func (this *queuePlugin) Unsubscribe(topic string, group string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	delete(this.handlers, topic+"/"+group)
+}
+
+func (this *queuePlugin) Capability() htypes.Any {
+	return nil
+}
+
+func (this *queuePlugin) Config() core.IEntityConf {
+	return &this.conf
+}
+
+func (this *queuePlugin) EntityStub() *core.EntityStub {
+	return core.NewEntityStub(&core.EntityStubOptions{Owner: this})
+}
+
+func receive(t *testing.T, svc *eventService) *core.Event {
+	t.Helper()
+
+	select {
+	case ev := <-svc.received:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+		return nil
+	}
+}
+
+func TestEventBridge(t *testing.T) {
+	svc := newEventService()
+	queue := &queuePlugin{handlers: make(map[string]func(data []byte) *herrors.Error)}
+	h := NewServer(t, &Options{
+		Config: map[string]htypes.Map{
+			"EventService": {"Name": "events"},
+			"QueuePlugin":  {},
+			"Server":       {"EventBridge": "QueuePlugin"},
+		},
+		Plugins:  []core.IPlugin{queue},
+		Services: []core.IService{svc},
+	})
+
+	//本节点的订阅者直接收到，事件同时发往消息队列
+	order := "1"
+	AssertOK(t, core.PublishEvent(context.Background(), h.Server, "order.created", &EventRequest{Order: &order}))
+	ev := receive(t, svc)
+	if ev.Data["order"] != "1" || len(queue.published) != 1 {
+		t.Fatalf("unexpected event %+v, %d published", ev, len(queue.published))
+	}
+
+	//消息队列送回本节点发布的事件时忽略
+	handler := queue.handlers["order.created/events"]
+	AssertOK(t, handler(queue.published[0]))
+	select {
+	case ev = <-svc.received:
+		t.Fatalf("event delivered twice: %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	//其他节点发布的事件处理完成后handler才返回
+	remote := &core.Event{ID: "remote", Topic: "order.created", Data: htypes.Map{"order": "2"}, Node: "other"}
+	bs, _ := jsoniter.Marshal(remote)
+	AssertOK(t, handler(bs))
+	select {
+	case ev = <-svc.received:
+		if ev.ID != "remote" {
+			t.Errorf("unexpected event %+v", ev)
+		}
+	default:
+		t.Fatal("bridge handler returned before the event was handled")
+	}
+
+	//消息队列不可用时本节点的订阅者仍然收到
+	queue.fail = herrors.ErrSysInternal.New("queue down")
+	err := core.PublishEvent(context.Background(), h.Server, "order.created", htypes.Map{"order": "3"})
+	AssertCode(t, err, herrors.ErrSysInternal.Code)
+	if ev = receive(t, svc); ev.Data["order"] != "3" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}
+
+func TestEventDeadLetters(t *testing.T) {
+	db := NewDatabasePlugin(t)
+	svc := newEventService()
+	h := NewServer(t, &Options{
+		Config: map[string]htypes.Map{
+			"EventService": {"Name": "events"},
+			"Server":       {"EventDeadLetterStore": "DatabasePlugin"},
+		},
+		Plugins:  []core.IPlugin{db},
+		Services: []core.IService{svc},
+	})
+	stub := h.Server.EntityStub()
+
+	deadLetters := func() []*core.DeadLetter {
+		t.Helper()
+		var dead []*core.DeadLetter
+		deadline := time.Now().Add(time.Second)
+		for len(dead) == 0 && time.Now().Before(deadline) {
+			ret, err := stub.Manage(core.ManageGetDeadLetters, htypes.Map{"topic": "order.failed"})
+			AssertOK(t, err)
+			dead = ret.([]*core.DeadLetter)
+			time.Sleep(10 * time.Millisecond)
+		}
+		return dead
+	}
+
+	AssertOK(t, core.PublishEvent(context.Background(), h.Server, "order.failed", htypes.Map{"order": "1"}))
+	dead := deadLetters()
+	if len(dead) != 1 || dead[0].Subscriber != "events.Fail" || dead[0].Attempts != 1 || dead[0].Event.Data["order"] != "1" {
+		t.Fatalf("unexpected dead letters %+v", dead)
+	}
+
+	//死信保存在数据库中
+	store, err := db.DeadLetterStore()
+	AssertOK(t, err)
+	if c, err := store.Count(); err != nil || c != 1 {
+		t.Fatalf("dead letter not persisted: %d %v", c, err)
+	}
+
+	//重新投递后再次失败，生成新的死信
+	ret, err := stub.Manage(core.ManageRedeliverDeadLetters, htypes.Map{"ids": []interface{}{dead[0].ID}})
+	AssertOK(t, err)
+	if ret.(htypes.Map)["redelivered"] != 1 {
+		t.Errorf("unexpected result %v", ret)
+	}
+	time.Sleep(50 * time.Millisecond)
+	redelivered := deadLetters()
+	if len(redelivered) != 1 || redelivered[0].ID == dead[0].ID || redelivered[0].Event.ID != dead[0].Event.ID {
+		t.Errorf("unexpected dead letters after redelivery %+v", redelivered)
+	}
+}
+
+func TestEventQueueLimit(t *testing.T) {
+	svc := newEventService()
+	h := NewServer(t, &Options{
+		Config: map[string]htypes.Map{
+			"EventService": {"Name": "events"},
+			"Server":       {"EventWorkers": 1, "EventQueue": 1},
+		},
+		Services: []core.IService{svc},
+	})
+	defer close(svc.release)
+
+	//一个投递中，一个等待，第三个被拒绝
+	for i := 0; i < 2; i++ {
+		AssertOK(t, core.PublishEvent(context.Background(), h.Server, "order.slow", nil))
+	}
+	err := core.PublishEvent(context.Background(), h.Server, "order.slow", nil)
+	AssertCode(t, err, herrors.ErrSysBusy.Code)
+}
@@ -0,0 +1,279 @@
+package htest
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hstate"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/core"
+	"github.com/drharryhe/has/routers/hlocalrouter"
+	"github.com/drharryhe/has/utils/hruntime"
+)
+
+//进程内测试环境。配置、接口定义及状态均在内存中，使用本地路由，不监听端口、不写pid文件，
+//测试中可直接调用RequestService或RequestAPI并检查返回的herrors错误码。
+//配置(hconf)、日志(hlogger)、状态(hstate)及运行指标为进程内的全局状态，同一时间只能打开一个Harness，
+//使用Harness的测试不能调用t.Parallel，同时打开第二个Harness时测试失败
+
+type Options struct {
+	Config map[string]htypes.Map //配置段(段名 -> 配置项)，与缺省配置按配置项合并。顶层配置项使用GlobalSection
+	API    *core.APIDefine       //网关接口定义，作为api.json
+	Assets map[string][]byte     //其他资源文件(路径 -> 内容)
+
+	Router      core.IRouter //缺省为hlocalrouter
+	Plugins     []core.IPlugin
+	Services    []core.IService
+	Middlewares []core.IAPIMiddleware
+	Packers     []core.IAPIDataPacker
+	Connectors  []core.IAPIConnector
+}
+
+const (
+	GlobalSection = "" //顶层配置项，如Debug、LogLevel
+)
+
+var (
+	opened int32 //已打开的Harness数量，不超过1
+)
+
+type Harness struct {
+	Server  *core.ServerImplement
+	Gateway *core.APIGateWayImplement //NewServer时为nil
+	Assets  *Assets
+
+	t      testing.TB
+	closed int32
+}
+
+// NewServer 创建并打开服务器，测试结束时自动关闭
+func NewServer(t testing.TB, opt *Options) *Harness {
+	t.Helper()
+
+	if opt == nil {
+		opt = &Options{}
+	}
+	acquire(t)
+	h, sopt := prepare(t, opt, false)
+	if err := protect(func() {
+		h.Server = core.NewServer(sopt)
+		for _, s := range opt.Services {
+			h.Server.RegisterService(s, nil)
+		}
+		h.Server.Open()
+	}); err != nil {
+		t.Fatalf("failed to open server: %v", err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+// NewGateway 创建并打开网关，接口定义为opt.API，测试结束时自动关闭
+func NewGateway(t testing.TB, opt *Options) *Harness {
+	t.Helper()
+
+	if opt == nil {
+		opt = &Options{}
+	}
+	acquire(t)
+	h, sopt := prepare(t, opt, true)
+	if opt.API != nil {
+		if err := h.Assets.SetAPI(opt.API); err != nil {
+			t.Fatalf("failed to marshal api: %v", err)
+		}
+	} else if _, err := h.Assets.File(apiFile); err != nil {
+		_ = h.Assets.SetAPI(&core.APIDefine{})
+	}
+
+	if err := protect(func() {
+		h.Gateway = core.NewAPIGateway(&core.APIGatewayOptions{
+			ServerOptions: *sopt,
+			Connectors:    opt.Connectors,
+			Middlewares:   opt.Middlewares,
+			Packers:       opt.Packers,
+		})
+		h.Server = h.Gateway.Server().(*core.ServerImplement)
+		for _, s := range opt.Services {
+			h.Server.RegisterService(s, nil)
+		}
+		h.Gateway.Open()
+	}); err != nil {
+		t.Fatalf("failed to open gateway: %v", err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+func (this *Harness) Close() {
+	if this.Gateway != nil {
+		this.Gateway.Close()
+	} else if this.Server != nil {
+		this.Server.Close()
+	}
+	this.Gateway = nil
+	this.Server = nil
+	if atomic.CompareAndSwapInt32(&this.closed, 0, 1) {
+		atomic.StoreInt32(&opened, 0)
+	}
+}
+
+// acquire 已有打开的Harness时结束测试
+func acquire(t testing.TB) {
+	t.Helper()
+
+	if !atomic.CompareAndSwapInt32(&opened, 0, 1) {
+		t.Fatalf("htest harness uses process-wide config, logger and state: only one can be open at a time, tests using it can not call t.Parallel")
+	}
+	//打开失败时同样释放
+	t.Cleanup(func() {
+		atomic.StoreInt32(&opened, 0)
+	})
+}
+
+func (this *Harness) RequestService(service string, slot string, params htypes.Map) (htypes.Any, *herrors.Error) {
+	return this.Server.RequestService(service, slot, params)
+}
+
+func (this *Harness) RequestAPI(version string, api string, params htypes.Map) (htypes.Any, *herrors.Error) {
+	if this.Gateway == nil {
+		this.t.Fatalf("RequestAPI requires a harness created by NewGateway")
+	}
+	return this.Gateway.RequestAPI(version, api, params)
+}
+
+// MustRequestService 请求失败时结束测试
+func (this *Harness) MustRequestService(service string, slot string, params htypes.Map) htypes.Any {
+	this.t.Helper()
+
+	ret, err := this.RequestService(service, slot, params)
+	AssertOK(this.t, err)
+	return ret
+}
+
+// MustRequestAPI 请求失败时结束测试
+func (this *Harness) MustRequestAPI(version string, api string, params htypes.Map) htypes.Any {
+	this.t.Helper()
+
+	ret, err := this.RequestAPI(version, api, params)
+	AssertOK(this.t, err)
+	return ret
+}
+
+// AssertOK 错误不为nil时结束测试
+func AssertOK(t testing.TB, err *herrors.Error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("unexpected error: [%d] %s", err.Code, err.Error())
+	}
+}
+
+// AssertCode 错误为nil或错误码不同时结束测试，code可使用herrors中的错误，如herrors.ErrCallerInvalidRequest.Code
+func AssertCode(t testing.TB, err *herrors.Error, code int) {
+	t.Helper()
+
+	if err == nil {
+		t.Fatalf("expected error code %d, got nil", code)
+	}
+	if err.Code != code {
+		t.Fatalf("expected error code %d, got [%d] %s", code, err.Code, err.Error())
+	}
+}
+
+func prepare(t testing.TB, opt *Options, gateway bool) (*Harness, *core.ServerOptions) {
+	router := opt.Router
+	if router == nil {
+		router = hlocalrouter.New()
+	}
+
+	h := &Harness{
+		Assets: NewAssets(opt.Assets),
+		t:      t,
+	}
+	return h, &core.ServerOptions{
+		Router:        router,
+		Plugins:       opt.Plugins,
+		AssetsManager: h.Assets,
+		Config:        buildConfig(opt, router, gateway),
+		Args:          &core.CmdArgs{},
+	}
+}
+
+// buildConfig 缺省配置加上各实体的空配置段，再合并opt.Config
+func buildConfig(opt *Options, router core.IRouter, gateway bool) map[string]interface{} {
+	sections := map[string]htypes.Map{
+		GlobalSection: {
+			"Version":    "test",
+			"Debug":      true,
+			"LogLevel":   "warn",
+			"LogOutputs": []string{"console"},
+			"StateFile":  hstate.MemoryStateFile,
+		},
+		"Server": {
+			"PprofPort":       -1,
+			"ShutdownTimeout": 1,
+		},
+	}
+	if gateway {
+		sections["APIGateway"] = htypes.Map{}
+	}
+
+	var entities []interface{}
+	entities = append(entities, router)
+	for _, p := range opt.Plugins {
+		entities = append(entities, p)
+	}
+	for _, s := range opt.Services {
+		entities = append(entities, s)
+	}
+	for _, m := range opt.Middlewares {
+		entities = append(entities, m)
+	}
+	for _, p := range opt.Packers {
+		entities = append(entities, p)
+	}
+	for _, c := range opt.Connectors {
+		entities = append(entities, c)
+	}
+	for _, e := range entities {
+		if entity, ok := e.(core.IEntity); ok {
+			name := hruntime.GetObjectName(entity.Config())
+			if sections[name] == nil {
+				sections[name] = htypes.Map{}
+			}
+		}
+	}
+
+	for name, items := range opt.Config {
+		if sections[name] == nil {
+			sections[name] = htypes.Map{}
+		}
+		for k, v := range items {
+			sections[name][k] = v
+		}
+	}
+
+	values := make(map[string]interface{})
+	for k, v := range sections[GlobalSection] {
+		values[k] = v
+	}
+	for name, items := range sections {
+		if name != GlobalSection {
+			values[name] = map[string]interface{}(items)
+		}
+	}
+	return values
+}
+
+// protect 将初始化过程中的panic转为错误
+func protect(f func()) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	f()
+	return nil
+}
@@ -0,0 +1,81 @@
+package htest
+
+import (
+	"testing"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/core"
+	"github.com/drharryhe/has/plugins/hmemcacheplugin"
+	"github.com/drharryhe/has/services/hellosvs"
+	"github.com/drharryhe/has/services/hsessionsvs"
+)
+
+func TestGateway(t *testing.T) {
+	h := NewGateway(t, &Options{
+		Config: map[string]htypes.Map{
+			"HelloService": {"Name": "hello"},
+		},
+		API: &core.APIDefine{
+			Name: "test",
+			APIVersions: []core.OpenAPI{{
+				Version: "v1",
+				APIs: []core.API{
+					{Name: "hello", EndPoint: core.EndPoint{Service: "hello", Slot: "HelloSlot"}},
+				},
+			}},
+		},
+		Plugins:  []core.IPlugin{NewPlugin("FakePlugin", 1)},
+		Services: []core.IService{&hellosvs.Service{}},
+	})
+
+	if ret := h.MustRequestAPI("v1", "hello", htypes.Map{"name": "has"}); ret != "Hello has" {
+		t.Errorf("unexpected result %v", ret)
+	}
+	_, err := h.RequestAPI("v1", "missing", nil)
+	AssertCode(t, err, herrors.ErrCallerInvalidRequest.Code)
+	_, err = h.RequestAPI("v1", "hello", htypes.Map{})
+	AssertCode(t, err, herrors.ErrCallerInvalidRequest.Code)
+	_, err = h.RequestService("hello", "NoSlot", nil)
+	AssertCode(t, err, herrors.ErrCallerInvalidRequest.Code)
+	if h.Server.Plugin("FakePlugin").Capability() != 1 {
+		t.Error("fake plugin not registered")
+	}
+}
+
+func TestServer(t *testing.T) {
+	h := NewServer(t, &Options{
+		Config: map[string]htypes.Map{
+			"HelloService": {"Name": "hello"},
+		},
+		Plugins:  []core.IPlugin{hmemcacheplugin.New()},
+		Services: []core.IService{&hellosvs.Service{}},
+	})
+
+	if ret := h.MustRequestService("hello", "HelloSlot", htypes.Map{"name": "has"}); ret != "Hello has" {
+		t.Errorf("unexpected result %v", ret)
+	}
+	if h.Server.Plugin("MemCachePlugin").(*hmemcacheplugin.Plugin).GetCache("test") == nil {
+		t.Error("memory cache not available")
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	db := NewDatabasePlugin(t)
+	h := NewServer(t, &Options{
+		Config: map[string]htypes.Map{
+			"SessionService": {"Name": "session", "AutoMigrate": true, "SessionsPerUser": 1, "TokenExpire": 60},
+		},
+		Plugins:  []core.IPlugin{db, hmemcacheplugin.New()},
+		Services: []core.IService{&hsessionsvs.Service{}},
+	})
+
+	token := string(h.MustRequestService("session", "CreateToken", htypes.Map{"user": "has"}).([]byte))
+	h.MustRequestService("session", "CreateToken", htypes.Map{"user": "has"})
+	var c int64
+	if err := db.DB("").Model(&hsessionsvs.SvsSessionToken{}).Where("value = ?", token).Count(&c).Error; err != nil || c != 0 {
+		t.Errorf("old token not removed: %d %v", c, err)
+	}
+	_, err := h.RequestService("session", "VerifyToken", htypes.Map{"token": token, "user": "has"})
+	AssertCode(t, err, herrors.ErrCallerInvalidRequest.Code)
+}
@@ -0,0 +1,73 @@
+package htest
+
+import (
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/core"
+)
+
+type FakePlugin struct {
+	core.PluginConf
+}
+
+// Plugin 替代真实插件，Class为指定名称，Capability返回指定值，不需要配置段。
+// 服务通过UsePlugin(class).Capability()使用插件时可用其替换；断言具体插件类型的服务需使用该插件本身，
+// 如hmemcacheplugin或NewDatabasePlugin
+type Plugin struct {
+	server     core.IServer
+	class      string
+	capability htypes.Any
+	conf       FakePlugin
+}
+
+func NewPlugin(class string, capability htypes.Any) *Plugin {
+	return &Plugin{
+		class:      class,
+		capability: capability,
+	}
+}
+
+func (this *Plugin) Open(s core.IServer, _ core.IPlugin) *herrors.Error {
+	this.server = s
+	this.conf.EID = "htest." + this.class
+	return nil
+}
+
+func (this *Plugin) Close() {
+}
+
+func (this *Plugin) Capability() htypes.Any {
+	return this.capability
+}
+
+func (this *Plugin) Class() string {
+	return this.class
+}
+
+func (this *Plugin) Server() core.IServer {
+	return this.server
+}
+
+func (this *Plugin) Config() core.IEntityConf {
+	return &this.conf
+}
+
+func (this *Plugin) EntityMeta() *core.EntityMeta {
+	return &core.EntityMeta{
+		ServerEID: this.server.(core.IEntity).Config().GetEID(),
+		EID:       this.conf.EID,
+		Type:      core.EntityTypePlugin,
+		Class:     this.class,
+	}
+}
+
+func (this *Plugin) EntityStub() *core.EntityStub {
+	return core.NewEntityStub(
+		&core.EntityStubOptions{
+			Owner: this,
+		})
+}
+
+func (this *Plugin) OnConfigReload(_ core.IEntityConf) *herrors.Error {
+	return nil
+}
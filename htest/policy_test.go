@@ -0,0 +1,163 @@
+package htest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/core"
+	"github.com/drharryhe/has/services/hellosvs"
+)
+
+type WaitService struct {
+	core.ServiceConf
+}
+
+// waitService Wait阻塞到请求被取消，canceled收到取消的通知
+type waitService struct {
+	core.Service
+	conf     WaitService
+	canceled chan struct{}
+}
+
+type WaitRequest struct {
+	core.SlotRequestBase
+}
+
+func (this *waitService) Wait(ctx context.Context, req *WaitRequest, res *core.SlotResponse) {
+	select {
+	case <-ctx.Done():
+		this.canceled <- struct{}{}
+	case <-time.After(5 * time.Second):
+	}
+	this.Response(res, "done", nil)
+}
+
+func (this *waitService) Config() core.IEntityConf {
+	return &this.conf
+}
+
+func (this *waitService) EntityStub() *core.EntityStub {
+	return core.NewEntityStub(&core.EntityStubOptions{Owner: this})
+}
+
+type RecordMiddleware struct {
+	core.EntityConfBase
+}
+
+// recordMiddleware 记录HandleIn收到的接口名
+type recordMiddleware struct {
+	core.InMiddleware
+	conf RecordMiddleware
+	apis []string
+}
+
+func (this *recordMiddleware) HandleIn(seq uint64, version string, api string, data htypes.Map) (bool, *herrors.Error) {
+	this.apis = append(this.apis, api)
+	return false, nil
+}
+
+func (this *recordMiddleware) Config() core.IEntityConf {
+	return &this.conf
+}
+
+func (this *recordMiddleware) EntityStub() *core.EntityStub {
+	return core.NewEntityStub(&core.EntityStubOptions{Owner: this})
+}
+
+func TestAliasMiddleware(t *testing.T) {
+	m := &recordMiddleware{}
+	h := NewGateway(t, &Options{
+		Config: map[string]htypes.Map{
+			"HelloService": {"Name": "hello"},
+		},
+		API: &core.APIDefine{
+			Name: "test",
+			APIVersions: []core.OpenAPI{{
+				Version: "v1",
+				APIs: []core.API{
+					{Name: "hello", Aliases: []string{"hi"}, EndPoint: core.EndPoint{Service: "hello", Slot: "HelloSlot"}},
+				},
+			}},
+		},
+		Services:    []core.IService{&hellosvs.Service{}},
+		Middlewares: []core.IAPIMiddleware{m},
+	})
+
+	AssertOK(t, h.Gateway.PreRequestMiddleware("v1", "hi", htypes.Map{}))
+	h.MustRequestAPI("v1", "hi", htypes.Map{"name": "has"})
+	if len(m.apis) != 2 || m.apis[0] != "hello" || m.apis[1] != "hello" {
+		t.Errorf("middleware got alias instead of api name: %v", m.apis)
+	}
+}
+
+func TestAPITimeoutCancels(t *testing.T) {
+	svc := &waitService{canceled: make(chan struct{}, 1)}
+	h := NewGateway(t, &Options{
+		Config: map[string]htypes.Map{
+			"WaitService": {"Name": "wait"},
+		},
+		API: &core.APIDefine{
+			Name: "test",
+			APIVersions: []core.OpenAPI{{
+				Version: "v1",
+				APIs: []core.API{
+					{Name: "wait", Timeout: 50, MaxConcurrent: 1, EndPoint: core.EndPoint{Service: "wait", Slot: "Wait"}},
+				},
+			}},
+		},
+		Services: []core.IService{svc},
+	})
+
+	_, err := h.RequestAPI("v1", "wait", htypes.Map{})
+	AssertCode(t, err, herrors.ErrSysBusy.Code)
+	select {
+	case <-svc.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("slot not canceled after api timeout")
+	}
+
+	//slot返回后释放并发数
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err = h.RequestAPI("v1", "wait", htypes.Map{})
+		if err != nil && err.Error() == "request timeout" {
+			<-svc.canceled
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("max concurrent not released: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAPIRateLimitRejects(t *testing.T) {
+	h := NewGateway(t, &Options{
+		Config: map[string]htypes.Map{
+			"HelloService": {"Name": "hello"},
+		},
+		API: &core.APIDefine{
+			Name: "test",
+			APIVersions: []core.OpenAPI{{
+				Version: "v1",
+				APIs: []core.API{
+					{Name: "hello", RateLimit: 1, RateBurst: 1, EndPoint: core.EndPoint{Service: "hello", Slot: "HelloSlot"}},
+				},
+			}},
+		},
+		Services: []core.IService{&hellosvs.Service{}},
+	})
+
+	h.MustRequestAPI("v1", "hello", htypes.Map{"name": "has"})
+	start := time.Now()
+	_, err := h.RequestAPI("v1", "hello", htypes.Map{"name": "has"})
+	if err == nil || core.RetryAfter(err) <= 0 {
+		t.Fatalf("expected request to be rejected by rate limit: %v", err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("rate limited request blocked for %v", d)
+	}
+}
@@ -0,0 +1,172 @@
+package htest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/core"
+	"github.com/drharryhe/has/plugins/hmemcacheplugin"
+	"github.com/drharryhe/has/services/hellosvs"
+)
+
+// TestReloadConcurrent 请求处理与重新加载配置并发进行，需使用-race运行
+func TestReloadConcurrent(t *testing.T) {
+	svc := &hellosvs.Service{}
+	h := NewServer(t, &Options{
+		Config: map[string]htypes.Map{
+			"HelloService": {"Name": "hello"},
+		},
+		Plugins:  []core.IPlugin{hmemcacheplugin.New()},
+		Services: []core.IService{svc},
+	})
+
+	sections := []htypes.Map{
+		{"Name": "hello", "CachedSlots": "HelloSlot:60:name"},
+		{"Name": "hello", "LimitedSlots": "HelloSlot:100000"},
+		{"Name": "hello", "CachedSlots": "HelloSlot:30", "LimitedSlots": "100000"},
+		{"Name": "hello"},
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := h.RequestService("hello", "HelloSlot", htypes.Map{"name": "has"}); err != nil {
+					t.Errorf("unexpected error: [%d] %s", err.Code, err.Error())
+					return
+				}
+				svc.Load()
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		if _, err := svc.EntityStub().Manage(core.ManageReloadConfig, sections[i%len(sections)]); err != nil {
+			t.Fatalf("unexpected reload error: %s", err.Error())
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// TestReloadRejected 部分配置无效时整个配置被拒绝，已生效的设置保持不变
+func TestReloadRejected(t *testing.T) {
+	svc := &hellosvs.Service{}
+	h := NewServer(t, &Options{
+		Config: map[string]htypes.Map{
+			"HelloService": {"Name": "hello", "CachedSlots": "HelloSlot:60"},
+		},
+		Plugins:  []core.IPlugin{hmemcacheplugin.New()},
+		Services: []core.IService{svc},
+	})
+
+	_, err := svc.EntityStub().Manage(core.ManageReloadConfig, htypes.Map{
+		"Name":         "hello",
+		"LimitedSlots": "HelloSlot:1:1",
+		"CachedSlots":  "NoSlot:60",
+	})
+	if err == nil {
+		t.Fatal("expected reload to be rejected")
+	}
+
+	//限流设置未生效，缓存设置保持不变
+	for i := 0; i < 3; i++ {
+		h.MustRequestService("hello", "HelloSlot", htypes.Map{"name": "has"})
+	}
+	stats := svc.Load()["cache"].(htypes.Map)["HelloSlot"].(htypes.Map)
+	if stats["hits"] != uint64(2) || stats["misses"] != uint64(1) {
+		t.Errorf("unexpected cache stats %v", stats)
+	}
+	if _, err = svc.EntityStub().Manage(core.ManageGetConfigItems, htypes.Map{"LimitedSlots": nil}); err != nil {
+		t.Fatal(err)
+	}
+	conf, _ := svc.EntityStub().Manage(core.ManageGetConfigItems, htypes.Map{"LimitedSlots": nil, "CachedSlots": nil})
+	if conf.(htypes.Map)["LimitedSlots"] != "" || conf.(htypes.Map)["CachedSlots"] != "HelloSlot:60" {
+		t.Errorf("config not restored: %v", conf)
+	}
+
+	//被拒绝的配置不影响之后的修改
+	_, err = svc.EntityStub().Manage(core.ManageReloadConfig, htypes.Map{"Name": "hello", "LimitedSlots": "HelloSlot:1:1"})
+	AssertOK(t, err)
+	h.MustRequestService("hello", "HelloSlot", htypes.Map{"name": "other"})
+	_, err = h.RequestService("hello", "HelloSlot", htypes.Map{"name": "another"})
+	if err == nil {
+		t.Fatal("expected request to be rate limited")
+	}
+	if err.Code != herrors.ErrSysBusy.Code {
+		t.Errorf("unexpected error: [%d] %s", err.Code, err.Error())
+	}
+}
+
+// TestReloadAPIs 重新加载api.json后按新定义处理请求，新定义无效时保留原接口
+func TestReloadAPIs(t *testing.T) {
+	hello := core.EndPoint{Service: "hello", Slot: "HelloSlot"}
+	h := NewGateway(t, &Options{
+		Config: map[string]htypes.Map{
+			"HelloService": {"Name": "hello"},
+		},
+		API: &core.APIDefine{
+			Name: "test",
+			APIVersions: []core.OpenAPI{{
+				Version: "v1",
+				APIs:    []core.API{{Name: "hello", EndPoint: hello}},
+			}},
+		},
+		Services: []core.IService{&hellosvs.Service{}},
+	})
+
+	AssertOK(t, h.Assets.SetAPI(&core.APIDefine{
+		Name: "test",
+		APIVersions: []core.OpenAPI{
+			{Version: "v1", APIs: []core.API{{Name: "greet", EndPoint: hello}}},
+			{Version: "v2", Extends: "v1"},
+		},
+	}))
+	AssertOK(t, h.Gateway.ReloadAPIs())
+	if ret := h.MustRequestAPI("v2", "greet", htypes.Map{"name": "has"}); ret != "Hello has" {
+		t.Errorf("unexpected result %v", ret)
+	}
+	_, err := h.RequestAPI("v1", "hello", htypes.Map{"name": "has"})
+	AssertCode(t, err, herrors.ErrCallerInvalidRequest.Code)
+
+	invalids := map[string]*core.APIDefine{
+		"circular extends": {
+			Name: "test",
+			APIVersions: []core.OpenAPI{
+				{Version: "v1", Extends: "v2", APIs: []core.API{{Name: "hello", EndPoint: hello}}},
+				{Version: "v2", Extends: "v1"},
+			},
+		},
+		"duplicated alias": {
+			Name: "test",
+			APIVersions: []core.OpenAPI{{
+				Version: "v1",
+				APIs: []core.API{
+					{Name: "hello", EndPoint: hello},
+					{Name: "greet", EndPoint: hello, Aliases: []string{"hello"}},
+				},
+			}},
+		},
+	}
+	for name, def := range invalids {
+		AssertOK(t, h.Assets.SetAPI(def))
+		if err := h.Gateway.ReloadAPIs(); err == nil {
+			t.Errorf("%s: expected reload to be rejected", name)
+		}
+		if ret := h.MustRequestAPI("v2", "greet", htypes.Map{"name": "has"}); ret != "Hello has" {
+			t.Errorf("%s: unexpected result %v", name, ret)
+		}
+		_, err := h.RequestAPI("v1", "hello", htypes.Map{"name": "has"})
+		AssertCode(t, err, herrors.ErrCallerInvalidRequest.Code)
+	}
+}
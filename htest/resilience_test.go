@@ -0,0 +1,221 @@
+package htest
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/core"
+)
+
+type FlakyService struct {
+	core.ServiceConf
+}
+
+// flakyService Flaky前failures次调用返回系统错误，Fail总是返回系统错误
+type flakyService struct {
+	core.Service
+	conf     FlakyService
+	failures int64
+	calls    int64
+}
+
+type FlakyRequest struct {
+	core.SlotRequestBase
+}
+
+func (this *flakyService) Flaky(req *FlakyRequest, res *core.SlotResponse) {
+	if atomic.AddInt64(&this.calls, 1) <= atomic.LoadInt64(&this.failures) {
+		this.Response(res, nil, herrors.ErrSysInternal.New("flaky"))
+		return
+	}
+	this.Response(res, "ok", nil)
+}
+
+func (this *flakyService) Fail(req *FlakyRequest, res *core.SlotResponse) {
+	atomic.AddInt64(&this.calls, 1)
+	this.Response(res, nil, herrors.ErrSysInternal.New("failed"))
+}
+
+func (this *flakyService) Fallback(req *FlakyRequest, res *core.SlotResponse) {
+	this.Response(res, "fallback", nil)
+}
+
+func (this *flakyService) Config() core.IEntityConf {
+	return &this.conf
+}
+
+func (this *flakyService) EntityStub() *core.EntityStub {
+	return core.NewEntityStub(&core.EntityStubOptions{Owner: this})
+}
+
+func TestAPIRetryAndFallback(t *testing.T) {
+	svc := &flakyService{failures: 2}
+	h := NewGateway(t, &Options{
+		Config: map[string]htypes.Map{
+			"FlakyService": {"Name": "flaky"},
+		},
+		API: &core.APIDefine{
+			Name: "test",
+			APIVersions: []core.OpenAPI{{
+				Version: "v1",
+				APIs: []core.API{
+					{Name: "retry", Idempotent: true, Retries: 2, RetryBackoff: 1, EndPoint: core.EndPoint{Service: "flaky", Slot: "Flaky"}},
+					{Name: "fallback", EndPoint: core.EndPoint{Service: "flaky", Slot: "Fail"}, Fallback: &core.EndPoint{Service: "flaky", Slot: "Fallback"}},
+				},
+			}},
+		},
+		Services: []core.IService{svc},
+	})
+
+	if ret := h.MustRequestAPI("v1", "retry", htypes.Map{}); ret != "ok" || svc.calls != 3 {
+		t.Errorf("unexpected result %v after %d calls", ret, svc.calls)
+	}
+	if ret := h.MustRequestAPI("v1", "fallback", htypes.Map{}); ret != "fallback" {
+		t.Errorf("unexpected result %v", ret)
+	}
+}
+
+// waitOpen 持续调用直至熔断器打开
+func waitOpen(t *testing.T, call func() *herrors.Error) {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if err := call(); err != nil && err.Code == herrors.ErrSysBusy.Code {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("circuit breaker not opened")
+}
+
+func TestAPIBreaker(t *testing.T) {
+	svc := &flakyService{}
+	h := NewGateway(t, &Options{
+		Config: map[string]htypes.Map{
+			"FlakyService": {"Name": "flakyapi"},
+			"APIGateway":   {"UseBreaker": true, "BreakerLimitAPI": true, "BreakerRequestVolumeThreshold": 5},
+		},
+		API: &core.APIDefine{
+			Name: "test",
+			APIVersions: []core.OpenAPI{{
+				Version: "v1",
+				APIs: []core.API{
+					{Name: "breaker_fail", EndPoint: core.EndPoint{Service: "flakyapi", Slot: "Fail"}},
+				},
+			}},
+		},
+		Services: []core.IService{svc},
+	})
+
+	waitOpen(t, func() *herrors.Error {
+		_, err := h.RequestAPI("v1", "breaker_fail", htypes.Map{})
+		return err
+	})
+	calls := atomic.LoadInt64(&svc.calls)
+	_, err := h.RequestAPI("v1", "breaker_fail", htypes.Map{})
+	AssertCode(t, err, herrors.ErrSysBusy.Code)
+	if atomic.LoadInt64(&svc.calls) != calls {
+		t.Error("slot called while circuit open")
+	}
+}
+
+func TestServiceCallBreaker(t *testing.T) {
+	svc := &flakyService{}
+	h := NewServer(t, &Options{
+		Config: map[string]htypes.Map{
+			"FlakyService": {"Name": "flakysvc"},
+			"Server":       {"ServiceBreaker": true},
+		},
+		Services: []core.IService{svc},
+	})
+
+	waitOpen(t, func() *herrors.Error {
+		_, err := h.RequestService("flakysvc", "Fail", nil)
+		return err
+	})
+	calls := atomic.LoadInt64(&svc.calls)
+	_, err := h.RequestService("flakysvc", "Fail", nil)
+	AssertCode(t, err, herrors.ErrSysBusy.Code)
+	if atomic.LoadInt64(&svc.calls) != calls {
+		t.Error("slot called while circuit open")
+	}
+}
+
+func TestServiceCallTimeout(t *testing.T) {
+	svc := &waitService{canceled: make(chan struct{}, 2)}
+	h := NewServer(t, &Options{
+		Config: map[string]htypes.Map{
+			"WaitService": {"Name": "wait"},
+			"Server":      {"ServiceCallTimeout": 200, "ServiceMaxConcurrent": 1},
+		},
+		Services: []core.IService{svc},
+	})
+
+	start := time.Now()
+	_, err := h.RequestService("wait", "Wait", nil)
+	AssertCode(t, err, herrors.ErrSysBusy.Code)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("service call not canceled after %v", d)
+	}
+	<-svc.canceled
+
+	//并发上限：同时进行的两个调用，其中一个被拒绝
+	errs := make(chan *herrors.Error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := h.RequestService("wait", "Wait", nil)
+			errs <- err
+		}()
+	}
+	rejected := 0
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil && strings.Contains(err.Error(), "max concurrent") {
+			rejected++
+		}
+	}
+	if rejected != 1 {
+		t.Errorf("expected one call rejected, got %d", rejected)
+	}
+}
+
+// TestRateLimitedNotFailed 服务限流拒绝不重试、不降级，rate为0的slot不受服务整体限流
+func TestRateLimitedNotFailed(t *testing.T) {
+	svc := &flakyService{}
+	h := NewGateway(t, &Options{
+		Config: map[string]htypes.Map{
+			"FlakyService": {"Name": "limited", "LimitedSlots": "Flaky:1:1,Fallback:0,1:1"},
+		},
+		API: &core.APIDefine{
+			Name: "test",
+			APIVersions: []core.OpenAPI{{
+				Version: "v1",
+				APIs: []core.API{
+					{Name: "limited", Idempotent: true, Retries: 2, RetryBackoff: 200,
+						EndPoint: core.EndPoint{Service: "limited", Slot: "Flaky"}, Fallback: &core.EndPoint{Service: "limited", Slot: "Fallback"}},
+				},
+			}},
+		},
+		Services: []core.IService{svc},
+	})
+
+	h.MustRequestAPI("v1", "limited", htypes.Map{})
+	start := time.Now()
+	ret, err := h.RequestAPI("v1", "limited", htypes.Map{})
+	if err == nil || core.RetryAfter(err) <= 0 {
+		t.Fatalf("expected rate limit error, got %v %v", ret, err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("rate limited request retried for %v", d)
+	}
+
+	for i := 0; i < 3; i++ {
+		if ret := h.MustRequestService("limited", "Fallback", nil); ret != "fallback" {
+			t.Errorf("unexpected result %v", ret)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package htest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/core"
+	"github.com/drharryhe/has/plugins/hmemcacheplugin"
+	"github.com/drharryhe/has/routers/hlocalrouter"
+	"github.com/drharryhe/has/services/hsessionsvs"
+)
+
+type ScheduleService struct {
+	core.ServiceConf
+}
+
+// scheduleService Tick记录执行时的fencing token，Block阻塞到ctx取消
+type scheduleService struct {
+	core.Service
+	conf    ScheduleService
+	ticks   chan int64
+	blocked chan struct{}
+	stopped chan struct{}
+}
+
+type ScheduleRequest struct {
+	core.SlotRequestBase
+}
+
+func newScheduleService() *scheduleService {
+	return &scheduleService{ticks: make(chan int64, 10), blocked: make(chan struct{}, 1), stopped: make(chan struct{}, 1)}
+}
+
+func (this *scheduleService) Schedules() []*core.ScheduledSlot {
+	return []*core.ScheduledSlot{
+		{Slot: "Tick", Cron: "@hourly", Missed: core.MissedRunOnce},
+		{Slot: "Block", Cron: "@yearly"},
+	}
+}
+
+func (this *scheduleService) Tick(ctx context.Context, req *ScheduleRequest, res *core.SlotResponse) {
+	this.ticks <- core.ScheduleTokenFromContext(ctx)
+	this.Response(res, nil, nil)
+}
+
+func (this *scheduleService) Block(ctx context.Context, req *ScheduleRequest, res *core.SlotResponse) {
+	this.blocked <- struct{}{}
+	<-ctx.Done()
+	this.stopped <- struct{}{}
+	this.Response(res, nil, core.ContextError(ctx))
+}
+
+func (this *scheduleService) Config() core.IEntityConf {
+	return &this.conf
+}
+
+func (this *scheduleService) EntityStub() *core.EntityStub {
+	return core.NewEntityStub(&core.EntityStubOptions{Owner: this})
+}
+
+type LockPlugin struct {
+	core.PluginConf
+}
+
+// lockPlugin 内存中的定时任务锁，锁不过期
+type lockPlugin struct {
+	core.BasePlugin
+	conf   LockPlugin
+	lock   sync.Mutex
+	owners map[string]string
+	fences map[string]int64
+	last   map[string]time.Time
+}
+
+func newLockPlugin() *lockPlugin {
+	return &lockPlugin{owners: make(map[string]string), fences: make(map[string]int64), last: make(map[string]time.Time)}
+}
+
+func (this *lockPlugin) ScheduleLock() (core.IScheduleLock, *herrors.Error) {
+	return this, nil
+}
+
+func (this *lockPlugin) TryLock(name string, owner string, ttl time.Duration) (int64, *herrors.Error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	switch this.owners[name] {
+	case owner:
+		return this.fences[name], nil
+	case "":
+		this.owners[name] = owner
+		this.fences[name]++
+		return this.fences[name], nil
+	default:
+		return 0, nil
+	}
+}
+
+func (this *lockPlugin) Unlock(name string, owner string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.owners[name] == owner {
+		delete(this.owners, name)
+	}
+}
+
+func (this *lockPlugin) LastRun(name string) (time.Time, *herrors.Error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return this.last[name], nil
+}
+
+func (this *lockPlugin) SetLastRun(name string, token int64, at time.Time) *herrors.Error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.fences[name] != token {
+		return herrors.ErrSysBusy.New("lock [%s] acquired by other owner", name)
+	}
+	this.last[name] = at
+	return nil
+}
+
+// take 锁由其他节点获得
+func (this *lockPlugin) take(name string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.owners[name] = "other"
+	this.fences[name]++
+}
+
+func (this *lockPlugin) lastRun(name string) time.Time {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return this.last[name]
+}
+
+func (this *lockPlugin) Capability() htypes.Any {
+	return nil
+}
+
+func (this *lockPlugin) Config() core.IEntityConf {
+	return &this.conf
+}
+
+func (this *lockPlugin) EntityStub() *core.EntityStub {
+	return core.NewEntityStub(&core.EntityStubOptions{Owner: this})
+}
+
+// clusterRouter 本地路由，作为集群路由时定时任务需要锁
+type clusterRouter struct {
+	*hlocalrouter.Router
+}
+
+func (this *clusterRouter) RemoteEntities() ([]*core.EntityMeta, *herrors.Error) {
+	return nil, nil
+}
+
+func (this *clusterRouter) ManageRemoteEntity(mm *core.EntityMeta, act string, params htypes.Map) (htypes.Any, *herrors.Error) {
+	return nil, herrors.ErrCallerInvalidRequest.New("no remote entity")
+}
+
+func schedules(t *testing.T, h *Harness) map[string]*core.ScheduleInfo {
+	t.Helper()
+
+	ret, err := h.Server.EntityStub().Manage(core.ManageGetSchedules, htypes.Map{})
+	AssertOK(t, err)
+	infos := make(map[string]*core.ScheduleInfo)
+	for _, info := range ret.(htypes.Map)["schedules"].([]*core.ScheduleInfo) {
+		infos[info.Name] = info
+	}
+	return infos
+}
+
+// lastStatus 等待定时任务执行结束，返回最近一次执行的状态
+func lastStatus(t *testing.T, h *Harness, name string) *core.ScheduleRun {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if info := schedules(t, h)[name]; info != nil && !info.Running && len(info.History) > 0 {
+			return info.History[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("schedule [%s] not finished", name)
+	return nil
+}
+
+func TestScheduleMissedRunOnce(t *testing.T) {
+	svc := newScheduleService()
+	lock := newLockPlugin()
+	before := time.Now().Add(-2 * time.Hour)
+	lock.last["schedule.job.sched.Tick"] = before
+	h := NewServer(t, &Options{
+		Config: map[string]htypes.Map{
+			"ScheduleService": {"Name": "sched"},
+			"LockPlugin":      {},
+			"Server":          {"ScheduleLock": "LockPlugin"},
+		},
+		Plugins:  []core.IPlugin{lock},
+		Services: []core.IService{svc},
+	})
+
+	//其他节点记录的上次执行时间已错过执行，打开时补充执行
+	select {
+	case token := <-svc.ticks:
+		if token <= 0 {
+			t.Errorf("unexpected fencing token %d", token)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("missed run not executed")
+	}
+	if run := lastStatus(t, h, "sched.Tick"); run.Status != core.ScheduleSucceeded || !run.Missed {
+		t.Errorf("unexpected run %+v", run)
+	}
+	if last := lock.lastRun("schedule.job.sched.Tick"); !last.After(before) {
+		t.Errorf("last run not saved to lock: %v", last)
+	}
+}
+
+func TestScheduleLockLost(t *testing.T) {
+	svc := newScheduleService()
+	lock := newLockPlugin()
+	h := NewServer(t, &Options{
+		Config: map[string]htypes.Map{
+			"ScheduleService": {"Name": "sched"},
+			"LockPlugin":      {},
+			"Server":          {"ScheduleLock": "LockPlugin", "ScheduleLockTTL": 1},
+		},
+		Plugins:  []core.IPlugin{lock},
+		Services: []core.IService{svc},
+	})
+	stub := h.Server.EntityStub()
+
+	//任务由其他节点执行时跳过
+	lock.take("schedule.job.sched.Tick")
+	_, err := stub.Manage(core.ManageRunSchedule, htypes.Map{"name": "sched.Tick"})
+	AssertOK(t, err)
+	if run := lastStatus(t, h, "sched.Tick"); run.Status != core.ScheduleSkipped || len(svc.ticks) != 0 {
+		t.Errorf("unexpected run %+v", run)
+	}
+
+	//执行期间锁由其他节点获得，取消执行且不保存执行时间
+	_, err = stub.Manage(core.ManageRunSchedule, htypes.Map{"name": "sched.Block"})
+	AssertOK(t, err)
+	<-svc.blocked
+	lock.take("schedule.job.sched.Block")
+	select {
+	case <-svc.stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("run not canceled after lock lost")
+	}
+	if run := lastStatus(t, h, "sched.Block"); run.Status != core.ScheduleFailed {
+		t.Errorf("unexpected run %+v", run)
+	}
+	if last := lock.lastRun("schedule.job.sched.Block"); !last.IsZero() {
+		t.Errorf("last run saved after lock lost: %v", last)
+	}
+}
+
+func TestSessionPurgeSchedule(t *testing.T) {
+	cases := []struct {
+		name      string
+		purge     string
+		lock      bool
+		scheduled bool
+	}{
+		{name: "not configured", lock: true},
+		{name: "without lock in cluster", purge: "@hourly"},
+		{name: "with lock", purge: "@hourly", lock: true, scheduled: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := map[string]htypes.Map{
+				"SessionService": {"Name": "session", "AutoMigrate": true, "TokenExpire": 60, "PurgeCron": c.purge},
+				"LockPlugin":     {},
+			}
+			if c.lock {
+				config["Server"] = htypes.Map{"ScheduleLock": "LockPlugin"}
+			}
+			h := NewServer(t, &Options{
+				Config:   config,
+				Router:   &clusterRouter{hlocalrouter.New()},
+				Plugins:  []core.IPlugin{NewDatabasePlugin(t), hmemcacheplugin.New(), newLockPlugin()},
+				Services: []core.IService{&hsessionsvs.Service{}},
+			})
+			defer h.Close()
+
+			if _, ok := schedules(t, h)["session.PurgeExpiredTokens"]; ok != c.scheduled {
+				t.Errorf("expected scheduled %v", c.scheduled)
+			}
+		})
+	}
+}
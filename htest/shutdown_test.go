@@ -0,0 +1,35 @@
+package htest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/core"
+)
+
+// TestShutdownDeadline 定时任务及事件投递都未结束时，退出共用ShutdownTimeout，而不是各等待一次
+func TestShutdownDeadline(t *testing.T) {
+	sched := newScheduleService()
+	events := newEventService()
+	h := NewServer(t, &Options{
+		Config: map[string]htypes.Map{
+			"ScheduleService": {"Name": "sched"},
+			"EventService":    {"Name": "events"},
+			"Server":          {"ShutdownTimeout": 1},
+		},
+		Services: []core.IService{sched, events},
+	})
+
+	_, err := h.Server.EntityStub().Manage(core.ManageRunSchedule, htypes.Map{"name": "sched.Block"})
+	AssertOK(t, err)
+	<-sched.blocked
+	AssertOK(t, core.PublishEvent(context.Background(), h.Server, "order.slow", nil))
+
+	start := time.Now()
+	h.Close()
+	if d := time.Since(start); d > 1600*time.Millisecond {
+		t.Errorf("shutdown took %v, longer than ShutdownTimeout", d)
+	}
+}
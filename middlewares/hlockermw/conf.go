@@ -5,8 +5,8 @@ import "github.com/drharryhe/has/core"
 type LockerMiddleware struct {
 	core.EntityConfBase
 
-	Model        string //模式：whitelist / blacklist
-	APIList      []string
+	Model        string   //模式：whitelist / blacklist
+	APIList      []string //也可在api.json中为接口设置middlewares或skipMiddlewares
 	MaxFails     int
 	UserField    string
 	AddressField string
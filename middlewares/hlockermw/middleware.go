@@ -2,9 +2,10 @@ package hlockermw
 
 import (
 	"strings"
-	"sync"
 	"time"
 
+	"go.uber.org/atomic"
+
 	"github.com/drharryhe/has/common/herrors"
 	"github.com/drharryhe/has/common/htypes"
 	"github.com/drharryhe/has/core"
@@ -13,6 +14,7 @@ import (
 
 const (
 	failsCacheBucket = "LockerMwFailBucket"
+	userAttribute    = "LockerMiddleware.User"
 )
 
 func New() core.IAPIMiddleware {
@@ -21,10 +23,15 @@ func New() core.IAPIMiddleware {
 
 type Middleware struct {
 	core.InOutMiddleware
+	conf     LockerMiddleware
+	settings atomic.Value //*settings
+	cache    *hmemcacheplugin.Plugin
+}
+
+// settings 请求处理中使用的配置副本及API列表，重新加载配置时整体替换
+type settings struct {
 	conf    LockerMiddleware
 	apiList map[string] /*version*/ map[string] /*api*/ bool
-	cache   *hmemcacheplugin.Plugin
-	users   sync.Map
 }
 
 func (this *Middleware) Open(gw core.IAPIGateway, ins core.IAPIMiddleware) *herrors.Error {
@@ -32,36 +39,54 @@ func (this *Middleware) Open(gw core.IAPIGateway, ins core.IAPIMiddleware) *herr
 
 	this.cache = this.Server().Plugin("MemCachePlugin").(*hmemcacheplugin.Plugin)
 
-	this.parseAPIList()
+	apiList, err := this.parseAPIList()
+	if err != nil {
+		return err
+	}
+	this.settings.Store(&settings{conf: this.conf, apiList: apiList})
+
+	return nil
+}
 
+// OnConfigReload API列表及锁定设置立即生效
+func (this *Middleware) OnConfigReload(_ core.IEntityConf) *herrors.Error {
+	apiList, err := this.parseAPIList()
+	if err != nil {
+		return err
+	}
+	this.settings.Store(&settings{conf: this.conf, apiList: apiList})
 	return nil
 }
 
 func (this *Middleware) HandleIn(seq uint64, version string, api string, data htypes.Map) (bool, *herrors.Error) {
+	s := this.settings.Load().(*settings)
+
 	//白名单slot，不需要session验证
-	if this.conf.Model == "whitelist" {
-		if this.apiList[version] != nil && (this.apiList[version][api] || this.apiList[version]["*"]) {
+	if s.conf.Model == "whitelist" {
+		if s.apiList[version] != nil && (s.apiList[version][api] || s.apiList[version]["*"]) {
 			return false, nil
 		}
 	} else {
-		if this.apiList[version] == nil || (!this.apiList[version]["*"] && !this.apiList[version][api]) {
+		if s.apiList[version] == nil || (!s.apiList[version]["*"] && !s.apiList[version][api]) {
 			return false, nil
 		}
 	}
 
-	user, ok := data[this.conf.UserField].(string)
+	user, ok := data[s.conf.UserField].(string)
 	if !ok {
-		if address, ok := data[this.conf.AddressField].(string); !ok {
-			return false, herrors.ErrCallerUnauthorizedAccess.New("parameter [%s] or [%s] required", this.conf.UserField, this.conf.AddressField).D("unauthorized access")
+		if address, ok := data[s.conf.AddressField].(string); !ok {
+			return false, herrors.ErrCallerUnauthorizedAccess.New("parameter [%s] or [%s] required", s.conf.UserField, s.conf.AddressField).D("unauthorized access")
 		} else {
 			user = address
 		}
 	}
-	this.users.Store(seq, user)
+	if scope := this.Gateway.RequestScope(seq); scope != nil {
+		scope.Set(userAttribute, user)
+	}
 	if fails, ok := this.cache.GetCache(failsCacheBucket).Get(user); !ok {
 		return false, nil
 	} else {
-		if fails.(int) >= this.conf.MaxFails {
+		if fails.(int) >= s.conf.MaxFails {
 			return false, herrors.ErrCallerUnauthorizedAccess.New("too many fails").D(strUserLocked)
 		}
 	}
@@ -71,8 +96,10 @@ func (this *Middleware) HandleIn(seq uint64, version string, api string, data ht
 }
 
 func (this *Middleware) HandleOut(seq uint64, version string, api string, result htypes.Any, e *herrors.Error) (stop bool, err *herrors.Error) {
-	if e != nil {
-		user, ok := this.users.Load(seq)
+	s := this.settings.Load().(*settings)
+	scope := this.Gateway.RequestScope(seq)
+	if e != nil && scope != nil {
+		user, ok := scope.Get(userAttribute)
 		if ok {
 			var fails int
 			val, ok := this.cache.GetValue(failsCacheBucket, user.(string))
@@ -80,14 +107,24 @@ func (this *Middleware) HandleOut(seq uint64, version string, api string, result
 				fails = val.(int)
 			}
 			fails++
-			this.cache.SetValue(failsCacheBucket, user.(string), fails, time.Duration(time.Minute*time.Duration(this.conf.LockDuration)))
+			this.cache.SetValue(failsCacheBucket, user.(string), fails, time.Duration(time.Minute*time.Duration(s.conf.LockDuration)))
 		}
 	}
-	this.users.Delete(seq)
 
 	return false, nil
 }
 
+// ReferencedAPIs API列表中的接口，供启动自检
+func (this *Middleware) ReferencedAPIs() map[string][]string {
+	ret := make(map[string][]string)
+	for version, apis := range this.settings.Load().(*settings).apiList {
+		for api := range apis {
+			ret[version] = append(ret[version], api)
+		}
+	}
+	return ret
+}
+
 func (this *Middleware) Config() core.IEntityConf {
 	return &this.conf
 }
@@ -99,21 +136,21 @@ func (this *Middleware) EntityStub() *core.EntityStub {
 		})
 }
 
-func (this *Middleware) parseAPIList() {
-	this.apiList = make(map[string]map[string]bool)
+func (this *Middleware) parseAPIList() (map[string]map[string]bool, *herrors.Error) {
+	apiList := make(map[string]map[string]bool)
 	for _, s := range this.conf.APIList {
 		kv := strings.Split(s, ":")
 		if len(kv) != 2 {
-			panic(herrors.ErrSysInternal.New("invalid %s config:%s", this.Class(), s))
+			return nil, herrors.ErrSysInternal.New("invalid %s config:%s", this.Class(), s)
 		}
-		if this.apiList[kv[0]] == nil {
-			this.apiList[kv[0]] = make(map[string]bool)
+		if apiList[kv[0]] == nil {
+			apiList[kv[0]] = make(map[string]bool)
 		}
 
 		cc := strings.Split(kv[1], ",")
 		for _, c := range cc {
-			this.apiList[kv[0]][c] = true
+			apiList[kv[0]][c] = true
 		}
 	}
-	return
+	return apiList, nil
 }
@@ -13,7 +13,6 @@ import (
 	"github.com/drharryhe/has/common/herrors"
 	"github.com/drharryhe/has/common/htypes"
 	"github.com/drharryhe/has/core"
-	"github.com/drharryhe/has/utils/hio"
 )
 
 const (
@@ -99,13 +98,13 @@ func (this *Middleware) prepareEnv(data htypes.Map) htypes.Map {
 }
 
 func (this *Middleware) loadPerms() *herrors.Error {
-	bs, err := hio.ReadFile(PermFile)
-	if err != nil {
-		return herrors.ErrSysInternal.New(err.Error()).D("failed to load perm.json")
+	bs, herr := this.Server().Assets().File(PermFile)
+	if herr != nil {
+		return herr.D("failed to load perm.json")
 	}
 
 	var perms []Perm
-	if err = jsoniter.Unmarshal(bs, &perms); err != nil {
+	if err := jsoniter.Unmarshal(bs, &perms); err != nil {
 		return herrors.ErrSysInternal.New("failed to unmarshal perm.json")
 	}
 
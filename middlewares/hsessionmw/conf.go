@@ -5,9 +5,9 @@ import "github.com/drharryhe/has/core"
 type SessionMiddleware struct {
 	core.EntityConfBase
 
-	SessionService  string
-	VerifySlot      string
-	APIWhiteList    []string
+	SessionService  string   `require:"true"`
+	VerifySlot      string   `require:"true"`
+	APIWhiteList    []string //不检查会话的接口，也可在api.json中为接口设置skipMiddlewares
 	InUserField     string
 	InTokenField    string
 	InAgentField    string
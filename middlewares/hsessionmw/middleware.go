@@ -1,8 +1,11 @@
 package hsessionmw
 
 import (
+	"context"
 	"strings"
 
+	"go.uber.org/atomic"
+
 	"github.com/drharryhe/has/common/herrors"
 	"github.com/drharryhe/has/common/htypes"
 	"github.com/drharryhe/has/core"
@@ -14,6 +17,12 @@ func New() core.IAPIMiddleware {
 
 type Middleware struct {
 	core.InMiddleware
+	conf     SessionMiddleware
+	settings atomic.Value //*settings
+}
+
+// settings 请求处理中使用的配置副本及白名单，重新加载配置时整体替换
+type settings struct {
 	conf      SessionMiddleware
 	whiteList map[string] /*version*/ map[string] /*api*/ bool
 }
@@ -29,23 +38,48 @@ func (this *Middleware) Open(gw core.IAPIGateway, ins core.IAPIMiddleware) *herr
 		return herrors.ErrSysInternal.New("VerifySlot not configured")
 	}
 
-	this.parseWhitelist()
+	whiteList, err := this.parseWhitelist()
+	if err != nil {
+		return err
+	}
+	this.settings.Store(&settings{conf: this.conf, whiteList: whiteList})
 
 	return nil
 }
 
+// OnConfigReload 白名单及session服务设置立即生效
+func (this *Middleware) OnConfigReload(_ core.IEntityConf) *herrors.Error {
+	if this.conf.SessionService == "" || this.conf.VerifySlot == "" {
+		return herrors.ErrCallerInvalidRequest.New("SessionService and VerifySlot required")
+	}
+
+	whiteList, err := this.parseWhitelist()
+	if err != nil {
+		return err
+	}
+	this.settings.Store(&settings{conf: this.conf, whiteList: whiteList})
+	return nil
+}
+
 func (this *Middleware) HandleIn(seq uint64, version string, api string, data htypes.Map) (bool, *herrors.Error) {
+	s := this.settings.Load().(*settings)
+
 	//白名单slot，不需要session验证
-	if this.whiteList[version] != nil && (this.whiteList[version][api] || this.whiteList[version]["*"]) {
+	if s.whiteList[version] != nil && (s.whiteList[version][api] || s.whiteList[version]["*"]) {
 		return false, nil
 	}
 
-	_, err := this.Server().RequestService(this.conf.SessionService, this.conf.VerifySlot,
+	ctx := context.Background()
+	if scope := this.Gateway.RequestScope(seq); scope != nil {
+		ctx = scope.Context()
+	}
+
+	_, err := this.Server().RequestServiceContext(ctx, s.conf.SessionService, s.conf.VerifySlot,
 		htypes.Map{
-			this.conf.OutUserField:    data[this.conf.InUserField],
-			this.conf.OutTokenField:   data[this.conf.InTokenField],
-			this.conf.OutAddressField: data[this.conf.InAddressField],
-			this.conf.OutAgentField:   data[this.conf.InAgentField],
+			s.conf.OutUserField:    data[s.conf.InUserField],
+			s.conf.OutTokenField:   data[s.conf.InTokenField],
+			s.conf.OutAddressField: data[s.conf.InAddressField],
+			s.conf.OutAgentField:   data[s.conf.InAgentField],
 		})
 	if err != nil {
 		return true, err
@@ -54,6 +88,17 @@ func (this *Middleware) HandleIn(seq uint64, version string, api string, data ht
 	}
 }
 
+// ReferencedAPIs 白名单中的接口，供启动自检
+func (this *Middleware) ReferencedAPIs() map[string][]string {
+	ret := make(map[string][]string)
+	for version, apis := range this.settings.Load().(*settings).whiteList {
+		for api := range apis {
+			ret[version] = append(ret[version], api)
+		}
+	}
+	return ret
+}
+
 func (this *Middleware) Config() core.IEntityConf {
 	return &this.conf
 }
@@ -65,21 +110,21 @@ func (this *Middleware) EntityStub() *core.EntityStub {
 		})
 }
 
-func (this *Middleware) parseWhitelist() {
-	this.whiteList = make(map[string]map[string]bool)
+func (this *Middleware) parseWhitelist() (map[string]map[string]bool, *herrors.Error) {
+	whiteList := make(map[string]map[string]bool)
 	for _, s := range this.conf.APIWhiteList {
 		kv := strings.Split(s, ":")
 		if len(kv) != 2 {
-			panic(herrors.ErrSysInternal.New("[%s] invalid config [%s]", this.Class(), s))
+			return nil, herrors.ErrSysInternal.New("[%s] invalid config [%s]", this.Class(), s)
 		}
-		if this.whiteList[kv[0]] == nil {
-			this.whiteList[kv[0]] = make(map[string]bool)
+		if whiteList[kv[0]] == nil {
+			whiteList[kv[0]] = make(map[string]bool)
 		}
 
 		cc := strings.Split(kv[1], ",")
 		for _, c := range cc {
-			this.whiteList[kv[0]][c] = true
+			whiteList[kv[0]][c] = true
 		}
 	}
-	return
+	return whiteList, nil
 }
@@ -4,7 +4,10 @@ import "github.com/drharryhe/has/core"
 
 type DatabasePlugin struct {
 	core.PluginConf
-	Connections []connection
+	Connections      []connection
+	JobDatabaseKey   string //异步任务持久化使用的数据库Key，为空时使用第一个数据库
+	StateDatabaseKey string //运行状态持久化使用的数据库Key，为空时使用第一个数据库
+	EventDatabaseKey string //死信持久化使用的数据库Key，为空时使用第一个数据库
 }
 
 type connection struct {
@@ -14,10 +17,10 @@ type connection struct {
 	Type                string
 	Name                string
 	User                string
-	Pwd                 string
+	Pwd                 string `secret:"true"`
 	MaxOpenConns        int
 	MaxIdleConns        int
-	Reset               bool
+	Reset               bool //删除并重建数据库，需以--reset启动，只执行一次
 	InitData            bool
 	InitDataDir         string
 	InitDataAfterSecond int
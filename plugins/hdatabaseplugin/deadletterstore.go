@@ -0,0 +1,110 @@
+package hdatabaseplugin
+
+import (
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"gorm.io/gorm"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/core"
+)
+
+/// 死信持久化，在Server配置中设置 EventDeadLetterStore = "DatabasePlugin" 启用
+
+type HasDeadLetter struct {
+	ID         string `gorm:"primaryKey;size:64"`
+	Topic      string `gorm:"size:128;index"`
+	Subscriber string `gorm:"size:256"`
+	Attempts   int
+	Event      string    `gorm:"type:text"`
+	Error      string    `gorm:"type:text"`
+	FailedAt   time.Time `gorm:"index"`
+}
+
+type deadLetterStore struct {
+	db *gorm.DB
+}
+
+func (this *Plugin) DeadLetterStore() (core.IDeadLetterStore, *herrors.Error) {
+	db, err := this.AutoMigrate(this.Conf.EventDatabaseKey, []interface{}{&HasDeadLetter{}})
+	if err != nil {
+		return nil, err.D("failed to create dead letter store")
+	}
+	return &deadLetterStore{db: db}, nil
+}
+
+func (this *deadLetterStore) Save(d *core.DeadLetter) *herrors.Error {
+	ev, err := jsoniter.Marshal(d.Event)
+	if err != nil {
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+	row := HasDeadLetter{
+		ID:         d.ID,
+		Topic:      d.Event.Topic,
+		Subscriber: d.Subscriber,
+		Attempts:   d.Attempts,
+		Event:      string(ev),
+		FailedAt:   d.FailedAt,
+	}
+	if d.Error != nil {
+		bs, _ := jsoniter.Marshal(d.Error)
+		row.Error = string(bs)
+	}
+
+	if err := this.db.Save(&row).Error; err != nil {
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+	return nil
+}
+
+func (this *deadLetterStore) List(topic string) ([]*core.DeadLetter, *herrors.Error) {
+	q := this.db.Order("failed_at")
+	if topic != "" {
+		q = q.Where("topic = ?", topic)
+	}
+	var rows []HasDeadLetter
+	if err := q.Find(&rows).Error; err != nil {
+		return nil, herrors.ErrSysInternal.New(err.Error())
+	}
+
+	dead := make([]*core.DeadLetter, 0, len(rows))
+	for i := range rows {
+		dead = append(dead, this.toDeadLetter(&rows[i]))
+	}
+	return dead, nil
+}
+
+func (this *deadLetterStore) Delete(ids []string) *herrors.Error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := this.db.Where("id IN ?", ids).Delete(&HasDeadLetter{}).Error; err != nil {
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+	return nil
+}
+
+func (this *deadLetterStore) Count() (int64, *herrors.Error) {
+	var c int64
+	if err := this.db.Model(&HasDeadLetter{}).Count(&c).Error; err != nil {
+		return 0, herrors.ErrSysInternal.New(err.Error())
+	}
+	return c, nil
+}
+
+func (this *deadLetterStore) toDeadLetter(row *HasDeadLetter) *core.DeadLetter {
+	d := &core.DeadLetter{
+		ID:         row.ID,
+		Event:      new(core.Event),
+		Subscriber: row.Subscriber,
+		Attempts:   row.Attempts,
+		FailedAt:   row.FailedAt,
+	}
+	_ = jsoniter.UnmarshalFromString(row.Event, d.Event)
+	if row.Error != "" {
+		d.Error = new(herrors.Error)
+		_ = jsoniter.UnmarshalFromString(row.Error, d.Error)
+	}
+	return d
+}
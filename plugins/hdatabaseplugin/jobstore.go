@@ -0,0 +1,125 @@
+package hdatabaseplugin
+
+import (
+	"errors"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"gorm.io/gorm"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/core"
+)
+
+/// 异步任务持久化，在Server配置中设置 JobStore = "DatabasePlugin" 启用
+
+type HasJob struct {
+	ID         string `gorm:"primaryKey;size:64"`
+	Service    string `gorm:"size:128"`
+	Slot       string `gorm:"size:128"`
+	Version    string `gorm:"size:32"`
+	API        string `gorm:"size:128"`
+	Owner      string `gorm:"size:128"`
+	Node       string `gorm:"size:64;index"` //执行任务的Server EID
+	Status     string `gorm:"size:16;index"`
+	Progress   float64
+	Result     string `gorm:"type:text"`
+	Error      string `gorm:"type:text"`
+	CreatedAt  time.Time
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+}
+
+type jobStore struct {
+	db *gorm.DB
+}
+
+func (this *Plugin) JobStore() (core.IJobStore, *herrors.Error) {
+	db, err := this.AutoMigrate(this.Conf.JobDatabaseKey, []interface{}{&HasJob{}})
+	if err != nil {
+		return nil, err.D("failed to create job store")
+	}
+	return &jobStore{db: db}, nil
+}
+
+func (this *jobStore) Save(job *core.Job) *herrors.Error {
+	row := HasJob{
+		ID:         job.ID,
+		Service:    job.Service,
+		Slot:       job.Slot,
+		Version:    job.Version,
+		API:        job.API,
+		Owner:      job.Owner,
+		Node:       job.Node,
+		Status:     job.Status,
+		Progress:   job.Progress,
+		CreatedAt:  job.CreatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+	}
+	if job.Result != nil {
+		bs, err := jsoniter.Marshal(job.Result)
+		if err != nil {
+			return herrors.ErrSysInternal.New(err.Error())
+		}
+		row.Result = string(bs)
+	}
+	if job.Error != nil {
+		bs, _ := jsoniter.Marshal(job.Error)
+		row.Error = string(bs)
+	}
+
+	if err := this.db.Save(&row).Error; err != nil {
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+	return nil
+}
+
+func (this *jobStore) Load(id string) (*core.Job, *herrors.Error) {
+	var row HasJob
+	if err := this.db.Where("id = ?", id).First(&row).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, herrors.ErrSysInternal.New(err.Error())
+	}
+	return this.toJob(&row), nil
+}
+
+func (this *jobStore) Unfinished(node string) ([]*core.Job, *herrors.Error) {
+	var rows []HasJob
+	if err := this.db.Where("node = ? AND status IN ?", node, []string{core.JobPending, core.JobRunning}).Find(&rows).Error; err != nil {
+		return nil, herrors.ErrSysInternal.New(err.Error())
+	}
+
+	var jobs []*core.Job
+	for i := range rows {
+		jobs = append(jobs, this.toJob(&rows[i]))
+	}
+	return jobs, nil
+}
+
+func (this *jobStore) toJob(row *HasJob) *core.Job {
+	job := &core.Job{
+		ID:         row.ID,
+		Service:    row.Service,
+		Slot:       row.Slot,
+		Version:    row.Version,
+		API:        row.API,
+		Owner:      row.Owner,
+		Node:       row.Node,
+		Status:     row.Status,
+		Progress:   row.Progress,
+		CreatedAt:  row.CreatedAt,
+		StartedAt:  row.StartedAt,
+		FinishedAt: row.FinishedAt,
+	}
+	if row.Result != "" {
+		_ = jsoniter.UnmarshalFromString(row.Result, &job.Result)
+	}
+	if row.Error != "" {
+		job.Error = new(herrors.Error)
+		_ = jsoniter.UnmarshalFromString(row.Error, job.Error)
+	}
+	return job
+}
@@ -5,6 +5,7 @@ package hdatabaseplugin
 import (
 	"database/sql"
 	"fmt"
+	"reflect"
 	"strings"
 	"time"
 
@@ -14,12 +15,10 @@ import (
 	"gorm.io/gorm"
 	"gorm.io/gorm/schema"
 
-	"github.com/drharryhe/has/common/hconf"
 	"github.com/drharryhe/has/common/herrors"
 	"github.com/drharryhe/has/common/hlogger"
 	"github.com/drharryhe/has/common/htypes"
 	"github.com/drharryhe/has/core"
-	"github.com/drharryhe/has/utils/hio"
 	"github.com/drharryhe/has/utils/hruntime"
 	"github.com/drharryhe/has/utils/htext"
 )
@@ -48,6 +47,7 @@ type Plugin struct {
 	dbs     []*gorm.DB
 	dbMap   map[string]*gorm.DB
 	objects htypes.Map
+	preset  map[string]*gorm.DB //由NewWithDB传入的连接
 	Conf    DatabasePlugin
 }
 
@@ -57,6 +57,10 @@ func (this *Plugin) Open(s core.IServer, ins core.IPlugin) *herrors.Error {
 	}
 
 	this.dbMap = make(map[string]*gorm.DB)
+	if this.preset != nil {
+		this.usePreset()
+		return nil
+	}
 	for i := 0; i < len(this.Conf.Connections); i++ {
 		db, herr := this.openDatabase(&this.Conf.Connections[i])
 		if herr != nil {
@@ -115,6 +119,16 @@ func (this *Plugin) Config() core.IEntityConf {
 	return &this.Conf
 }
 
+// OnConfigReload 数据库连接无法在运行期间修改
+func (this *Plugin) OnConfigReload(old core.IEntityConf) *herrors.Error {
+	o := old.(*DatabasePlugin)
+	if !reflect.DeepEqual(o.Connections, this.Conf.Connections) || o.JobDatabaseKey != this.Conf.JobDatabaseKey ||
+		o.EventDatabaseKey != this.Conf.EventDatabaseKey {
+		return herrors.ErrCallerInvalidRequest.New("database connections can not be changed at runtime")
+	}
+	return nil
+}
+
 func (this *Plugin) EntityStub() *core.EntityStub {
 	return core.NewEntityStub(
 		&core.EntityStubOptions{
@@ -139,7 +153,7 @@ func (this *Plugin) AutoMigrate(key string, objs []interface{}) (*gorm.DB, *herr
 	}
 
 	switch conn.Type {
-	case dbTypeClickhouse, dbTypePostgres:
+	case dbTypeClickhouse, dbTypePostgres, dbTypeSqlLite:
 		if err := db.AutoMigrate(objs...); err != nil {
 			return nil, herrors.ErrSysInternal.New(err.Error())
 		}
@@ -154,7 +168,11 @@ func (this *Plugin) AutoMigrate(key string, objs []interface{}) (*gorm.DB, *herr
 
 func (this *Plugin) openDatabase(conn *connection) (*gorm.DB, *herrors.Error) {
 	shouldCreateDB := false
-	if conn.Reset {
+	reset, herr := core.OneShotDestructive(this, fmt.Sprintf("Connections.%s.Reset", conn.Key), conn.Reset)
+	if herr != nil {
+		return nil, herr
+	}
+	if reset {
 		if err := this.dropDatabase(conn); err != nil {
 			return nil, err
 		}
@@ -162,13 +180,6 @@ func (this *Plugin) openDatabase(conn *connection) (*gorm.DB, *herrors.Error) {
 		if err := this.createDatabase(conn); err != nil {
 			return nil, err
 		}
-
-		conn.Reset = false
-		_, err := this.EntityStub().Manage(core.ManageUpdateConfigItems, htypes.Map{fmt.Sprintf("Connections.%s.Reset", conn.Key): false})
-		if err != nil {
-			return nil, err
-		}
-		hconf.Save()
 	}
 
 LOOP:
@@ -287,7 +298,8 @@ func (this *Plugin) dropDatabase(conn *connection) *herrors.Error {
 }
 
 func (this *Plugin) initDatabaseDataIfNeeded(db *gorm.DB, conn *connection) *herrors.Error {
-	if !conn.InitData {
+	initItem := fmt.Sprintf("Connections.%s.InitData", conn.Key)
+	if !core.OneShotPending(this, initItem, conn.InitData) {
 		return nil
 	}
 
@@ -295,18 +307,17 @@ func (this *Plugin) initDatabaseDataIfNeeded(db *gorm.DB, conn *connection) *her
 		return herrors.ErrSysInternal.New("DataDir of init database not configured")
 	}
 
-	if !hio.IsDirExist(conn.InitDataDir) {
-		return herrors.ErrSysInternal.New("DataDir [%s] not found", conn.InitDataDir)
+	fs, herr := this.Server().Assets().Files(conn.InitDataDir, ".sql")
+	if herr != nil {
+		return herr.D("DataDir [%s] not found", conn.InitDataDir)
 	}
 
 	hlogger.Debug("start to init database data...")
-	fs := hio.IteratorFiles(conn.InitDataDir, "sql")
 	for _, f := range fs {
 		hlogger.Debug("importing %s", f)
-		file, err := hio.ReadFile(fmt.Sprintf("%s/%s", conn.InitDataDir, f))
-
-		if err != nil {
-			return herrors.ErrSysInternal.New(err.Error()).D("failed to read data file %s", f)
+		file, herr := this.Server().Assets().File(f)
+		if herr != nil {
+			return herr.D("failed to read data file %s", f)
 		}
 
 		requests := strings.Split(string(file), ";")
@@ -314,15 +325,14 @@ func (this *Plugin) initDatabaseDataIfNeeded(db *gorm.DB, conn *connection) *her
 			if htext.IsEmptyLine(request) {
 				continue
 			}
-			err = db.Exec(request).Error
+			err := db.Exec(request).Error
 			if err != nil {
 				return herrors.ErrSysInternal.New(err.Error()).D("failed to exe sql %s", requests)
 			}
 		}
 	}
 
-	conn.InitData = false
-	hconf.Save()
+	core.OneShotDone(this, initItem)
 	hlogger.Debug("init database data done.")
 	return nil
 }
@@ -0,0 +1,32 @@
+package hdatabaseplugin
+
+import (
+	"sort"
+
+	"gorm.io/gorm"
+
+	"github.com/drharryhe/has/common/htypes"
+)
+
+// NewWithDB 使用已打开的数据库连接(连接Key -> gorm.DB)，不按配置连接数据库，
+// 用于测试时传入内存数据库(如sqlite的file::memory:)。Key为空的连接作为缺省连接
+func NewWithDB(dbs map[string]*gorm.DB) *Plugin {
+	return &Plugin{preset: dbs}
+}
+
+// usePreset 按Key排序，缺省连接在前；连接配置只记录Key和数据库类型
+func (this *Plugin) usePreset() {
+	keys := make([]string, 0, len(this.preset))
+	for k := range this.preset {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	this.Conf.Connections = make([]connection, 0, len(keys))
+	for _, k := range keys {
+		this.dbMap[k] = this.preset[k]
+		this.dbs = append(this.dbs, this.preset[k])
+		this.Conf.Connections = append(this.Conf.Connections, connection{Key: k, Type: this.preset[k].Dialector.Name()})
+	}
+	this.objects = make(htypes.Map)
+}
@@ -0,0 +1,51 @@
+package hdatabaseplugin
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hstate"
+)
+
+/// 运行状态持久化，在Server配置中设置 StateStore = "DatabasePlugin" 启用
+
+type HasState struct {
+	Key       string `gorm:"primaryKey;size:255"`
+	Value     string `gorm:"type:text"`
+	UpdatedAt time.Time
+}
+
+type stateStore struct {
+	db *gorm.DB
+}
+
+func (this *Plugin) StateStore() (hstate.IStore, *herrors.Error) {
+	db, err := this.AutoMigrate(this.Conf.StateDatabaseKey, []interface{}{&HasState{}})
+	if err != nil {
+		return nil, err.D("failed to create state store")
+	}
+	return &stateStore{db: db}, nil
+}
+
+func (this *stateStore) Get(key string) (string, bool, error) {
+	var row HasState
+	if err := this.db.Where(&HasState{Key: key}).First(&row).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return row.Value, true, nil
+}
+
+func (this *stateStore) Set(key string, val string) error {
+	return this.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&HasState{Key: key, Value: val}).Error
+}
+
+func (this *stateStore) Delete(key string) error {
+	return this.db.Delete(&HasState{Key: key}).Error
+}
@@ -0,0 +1,28 @@
+package hdatabaseplugin
+
+import (
+	"github.com/drharryhe/has/common/htypes"
+)
+
+// Load 各数据库连接池状态，按连接Key区分
+func (this *Plugin) Load() htypes.Map {
+	ret := make(htypes.Map)
+	for key, db := range this.dbMap {
+		sqlDB, err := db.DB()
+		if err != nil {
+			continue
+		}
+		s := sqlDB.Stats()
+		ret[key] = htypes.Map{
+			"max_open_connections": s.MaxOpenConnections,
+			"open_connections":     s.OpenConnections,
+			"in_use":               s.InUse,
+			"idle":                 s.Idle,
+			"wait_count":           s.WaitCount,
+			"wait_duration":        s.WaitDuration,
+			"max_idle_closed":      s.MaxIdleClosed,
+			"max_lifetime_closed":  s.MaxLifetimeClosed,
+		}
+	}
+	return ret
+}
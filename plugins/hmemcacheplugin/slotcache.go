@@ -0,0 +1,62 @@
+package hmemcacheplugin
+
+import (
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/core"
+)
+
+// SlotCache 提供slot结果缓存，每个slot使用独立的bucket。bucket只在首次写入时加写锁，读写缓存项由go-cache自身同步
+func (this *Plugin) SlotCache() core.ISlotCache {
+	return &slotCache{plugin: this, buckets: make(map[string]*cache.Cache)}
+}
+
+type slotCache struct {
+	lock    sync.RWMutex
+	plugin  *Plugin
+	buckets map[string]*cache.Cache
+}
+
+func (this *slotCache) bucket(name string, create bool) *cache.Cache {
+	this.lock.RLock()
+	c := this.buckets[name]
+	this.lock.RUnlock()
+	if c != nil || !create {
+		return c
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if c = this.buckets[name]; c == nil {
+		c = cache.New(time.Duration(this.plugin.conf.ExpireDuration)*time.Second, time.Duration(this.plugin.conf.CleanupDuration)*time.Second)
+		this.buckets[name] = c
+	}
+	return c
+}
+
+func (this *slotCache) Get(bucket string, key string) (htypes.Any, bool) {
+	if c := this.bucket(bucket, false); c != nil {
+		return c.Get(key)
+	}
+	return nil, false
+}
+
+func (this *slotCache) Set(bucket string, key string, val htypes.Any, ttl time.Duration) {
+	this.bucket(bucket, true).Set(key, val, ttl)
+}
+
+func (this *slotCache) Delete(bucket string, key string) {
+	if c := this.bucket(bucket, false); c != nil {
+		c.Delete(key)
+	}
+}
+
+func (this *slotCache) Clear(bucket string) {
+	if c := this.bucket(bucket, false); c != nil {
+		c.Flush()
+	}
+}
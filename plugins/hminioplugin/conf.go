@@ -7,6 +7,6 @@ type MinioPlugin struct {
 
 	Endpoint        string
 	AccessKeyID     string
-	SecretAccessKey string
+	SecretAccessKey string `secret:"true"`
 	Tls             bool
 }
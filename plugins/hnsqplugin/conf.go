@@ -5,5 +5,5 @@ import "github.com/drharryhe/has/core"
 type NsqPlugin struct {
 	core.PluginConf
 
-	ServerAddr string
+	ServerAddr string `require:"true"`
 }
@@ -0,0 +1,91 @@
+package hnsqplugin
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/core"
+)
+
+const (
+	eventTopicPrefix   = "has.event."
+	eventTouchInterval = 30 * time.Second //nsqd缺省的消息超时为60秒
+)
+
+// EventBridge 经NSQ在节点间投递事件，订阅的group作为channel，同一服务的多个实例中只有一个收到事件
+func (this *Plugin) EventBridge() (core.IEventBridge, *herrors.Error) {
+	if this.producer == nil {
+		return nil, herrors.ErrSysInternal.New("nsq not connected")
+	}
+	return &eventBridge{plugin: this, consumers: make(map[string]*nsq.Consumer)}, nil
+}
+
+type eventBridge struct {
+	plugin    *Plugin
+	lock      sync.Mutex
+	consumers map[string]*nsq.Consumer //topic/group -> consumer
+}
+
+func (this *eventBridge) Publish(topic string, data []byte) *herrors.Error {
+	if err := this.plugin.producer.Publish(eventTopicPrefix+topic, data); err != nil {
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+	return nil
+}
+
+func (this *eventBridge) Subscribe(topic string, group string, handler func(data []byte) *herrors.Error) *herrors.Error {
+	consumer, err := nsq.NewConsumer(eventTopicPrefix+topic, group, nsq.NewConfig())
+	if err != nil {
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+
+	//事件处理完成后才确认，返回错误时消息由NSQ重新投递。处理期间定期延长消息的超时，避免NSQ超时重投
+	consumer.AddHandler(nsq.HandlerFunc(func(m *nsq.Message) error {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			t := time.NewTicker(eventTouchInterval)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					m.Touch()
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		if err := handler(m.Body); err != nil {
+			return err
+		}
+		return nil
+	}))
+
+	if err = consumer.ConnectToNSQD(this.plugin.conf.ServerAddr); err != nil {
+		consumer.Stop()
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if c := this.consumers[topic+"/"+group]; c != nil {
+		c.Stop()
+	}
+	this.consumers[topic+"/"+group] = consumer
+	return nil
+}
+
+func (this *eventBridge) Unsubscribe(topic string, group string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if c := this.consumers[topic+"/"+group]; c != nil {
+		c.Stop()
+		<-c.StopChan
+	}
+	delete(this.consumers, topic+"/"+group)
+}
@@ -5,6 +5,6 @@ import "github.com/drharryhe/has/core"
 type RedisPlugin struct {
 	core.PluginConf
 	Backend   string
-	Password  string
+	Password  string `secret:"true"`
 	DefaultDB int
 }
@@ -0,0 +1,156 @@
+package hredisplugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/core"
+	"github.com/drharryhe/has/utils/hrandom"
+)
+
+const (
+	eventStreamPrefix = "has:event:"
+	eventStreamMaxLen = 10000       //每个主题的流保留的事件数(近似)
+	eventReadBlock    = time.Second //等待新事件的时间，Unsubscribe最多等待这么久
+	eventReadCount    = 10
+	eventClaimIdle    = time.Minute //未确认的事件超过该时间由其他消费者重新处理
+	eventField        = "data"
+)
+
+// EventBridge 经redis流(Stream)在节点间投递事件，订阅的group作为消费组，同一服务的多个实例中只有一个收到事件。
+// 事件处理完成后确认，处理失败或处理中节点停止的事件在eventClaimIdle后由同组的其他消费者重新处理；
+// 消费组在首次订阅时创建，此前发布的事件不会投递
+func (this *Plugin) EventBridge() (core.IEventBridge, *herrors.Error) {
+	if this.redis == nil {
+		return nil, herrors.ErrSysInternal.New("redis not connected")
+	}
+	return &eventBridge{plugin: this, consumer: hrandom.UuidWithoutDash(), subs: make(map[string]*eventConsumer)}, nil
+}
+
+type eventBridge struct {
+	plugin   *Plugin
+	consumer string //本进程的消费者名称
+	lock     sync.Mutex
+	subs     map[string]*eventConsumer //topic/group -> 订阅
+}
+
+type eventConsumer struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (this *eventBridge) Publish(topic string, data []byte) *herrors.Error {
+	err := this.plugin.redis.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: eventStreamPrefix + topic,
+		MaxLen: eventStreamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{eventField: data},
+	}).Err()
+	if err != nil {
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+	return nil
+}
+
+func (this *eventBridge) Subscribe(topic string, group string, handler func(data []byte) *herrors.Error) *herrors.Error {
+	stream := eventStreamPrefix + topic
+	err := this.plugin.redis.XGroupCreateMkStream(context.Background(), stream, group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &eventConsumer{cancel: cancel, done: make(chan struct{})}
+	go this.consume(ctx, c, stream, group, handler)
+
+	this.lock.Lock()
+	old := this.subs[topic+"/"+group]
+	this.subs[topic+"/"+group] = c
+	this.lock.Unlock()
+	if old != nil {
+		old.stop()
+	}
+	return nil
+}
+
+func (this *eventBridge) Unsubscribe(topic string, group string) {
+	this.lock.Lock()
+	c := this.subs[topic+"/"+group]
+	delete(this.subs, topic+"/"+group)
+	this.lock.Unlock()
+
+	if c != nil {
+		c.stop()
+	}
+}
+
+// stop 停止读取并等待处理中的事件完成
+func (this *eventConsumer) stop() {
+	this.cancel()
+	<-this.done
+}
+
+// consume 先接管超时未确认的事件，再读取新事件，处理成功后确认
+func (this *eventBridge) consume(ctx context.Context, c *eventConsumer, stream string, group string, handler func(data []byte) *herrors.Error) {
+	defer close(c.done)
+
+	rds := this.plugin.redis
+	claimAt := time.Now()
+	for ctx.Err() == nil {
+		var msgs []redis.XMessage
+		if now := time.Now(); now.After(claimAt) {
+			claimAt = now.Add(eventClaimIdle / 2)
+			claimed, _, err := rds.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   stream,
+				Group:    group,
+				Consumer: this.consumer,
+				MinIdle:  eventClaimIdle,
+				Start:    "0",
+				Count:    eventReadCount,
+			}).Result()
+			if err != nil && ctx.Err() == nil {
+				hlogger.Error("failed to claim pending events of [%s]: %v", stream, err)
+			}
+			msgs = claimed
+		}
+
+		if len(msgs) == 0 {
+			streams, err := rds.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    group,
+				Consumer: this.consumer,
+				Streams:  []string{stream, ">"},
+				Count:    eventReadCount,
+				Block:    eventReadBlock,
+			}).Result()
+			if err != nil {
+				if !errors.Is(err, redis.Nil) && ctx.Err() == nil {
+					hlogger.Error("failed to read events of [%s]: %v", stream, err)
+					time.Sleep(eventReadBlock)
+				}
+				continue
+			}
+			for _, s := range streams {
+				msgs = append(msgs, s.Messages...)
+			}
+		}
+
+		for _, msg := range msgs {
+			data, _ := msg.Values[eventField].(string)
+			if err := handler([]byte(data)); err != nil {
+				//不确认，超时后重新处理
+				hlogger.Error("event [%s] on [%s] not handled: %s", msg.ID, stream, err.Error())
+				continue
+			}
+			if err := rds.XAck(context.Background(), stream, group, msg.ID).Err(); err != nil {
+				hlogger.Error("failed to ack event [%s] on [%s]: %v", msg.ID, stream, err)
+			}
+		}
+	}
+}
@@ -47,6 +47,22 @@ func (this *Plugin) Capability() htypes.Any {
 	return this.redis
 }
 
+// Load redis连接池状态
+func (this *Plugin) Load() htypes.Map {
+	if this.redis == nil {
+		return nil
+	}
+	s := this.redis.PoolStats()
+	return htypes.Map{
+		"hits":        s.Hits,
+		"misses":      s.Misses,
+		"timeouts":    s.Timeouts,
+		"total_conns": s.TotalConns,
+		"idle_conns":  s.IdleConns,
+		"stale_conns": s.StaleConns,
+	}
+}
+
 func (this *Plugin) Config() core.IEntityConf {
 	return &this.conf
 }
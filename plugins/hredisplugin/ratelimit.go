@@ -0,0 +1,66 @@
+package hredisplugin
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/core"
+)
+
+const (
+	rateLimitKeyPrefix = "has:ratelimit:"
+)
+
+//令牌桶保存在hash中，t为剩余令牌，ts为上次更新的毫秒时间。使用redis服务器时间，各节点时钟不一致时不影响计数。
+//返回需等待的毫秒数，0表示已取得令牌
+var takeTokenScript = redis.NewScript(`
+if redis.replicate_commands then redis.replicate_commands() end
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local b = redis.call('HMGET', KEYS[1], 't', 'ts')
+local tokens = tonumber(b[1])
+local ts = tonumber(b[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+redis.call('HMSET', KEYS[1], 't', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) * 1000 / rate) + 1000)
+return wait
+`)
+
+// RateLimitStore 提供各节点共享的限流令牌桶
+func (this *Plugin) RateLimitStore() (core.IRateLimitStore, *herrors.Error) {
+	if this.redis == nil {
+		return nil, herrors.ErrSysInternal.New("redis not connected")
+	}
+	return &rateLimitStore{plugin: this}, nil
+}
+
+type rateLimitStore struct {
+	plugin *Plugin
+}
+
+func (this *rateLimitStore) Take(key string, rate float64, burst int) (bool, time.Duration, *herrors.Error) {
+	wait, err := takeTokenScript.Run(context.Background(), this.plugin.redis, []string{rateLimitKeyPrefix + key},
+		strconv.FormatFloat(rate, 'f', -1, 64), burst).Int64()
+	if err != nil {
+		return true, 0, herrors.ErrSysInternal.New(err.Error())
+	}
+	return wait == 0, time.Duration(wait) * time.Millisecond, nil
+}
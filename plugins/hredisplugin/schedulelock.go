@@ -0,0 +1,99 @@
+package hredisplugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/core"
+)
+
+const (
+	lockKeyPrefix   = "has:lock:"
+	lockFenceSuffix = ":fence" //锁的fencing token，每次获得锁时递增
+	lockLastSuffix  = ":last"  //定时任务上次执行的时间(毫秒时间戳)
+)
+
+// 锁已由owner持有时延长有效时间并返回当前token；锁不存在时设置并返回递增后的token；由其他owner持有时返回0
+var tryLockScript = redis.NewScript(`
+local v = redis.call('GET', KEYS[1])
+if v == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return tonumber(redis.call('GET', KEYS[2]) or '0')
+end
+if v then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return redis.call('INCR', KEYS[2])
+`)
+
+// 只释放owner持有的锁
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// token仍是锁当前的token时保存上次执行时间，返回1，否则返回0
+var setLastRunScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[2], ARGV[2])
+return 1
+`)
+
+// ScheduleLock 提供节点间互斥执行定时任务的锁，定时任务上次执行的时间保存在锁旁边
+func (this *Plugin) ScheduleLock() (core.IScheduleLock, *herrors.Error) {
+	if this.redis == nil {
+		return nil, herrors.ErrSysInternal.New("redis not connected")
+	}
+	return &scheduleLock{plugin: this}, nil
+}
+
+type scheduleLock struct {
+	plugin *Plugin
+}
+
+func (this *scheduleLock) TryLock(name string, owner string, ttl time.Duration) (int64, *herrors.Error) {
+	key := lockKeyPrefix + name
+	token, err := tryLockScript.Run(context.Background(), this.plugin.redis, []string{key, key + lockFenceSuffix}, owner, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return 0, herrors.ErrSysInternal.New(err.Error())
+	}
+	return token, nil
+}
+
+func (this *scheduleLock) Unlock(name string, owner string) {
+	if err := unlockScript.Run(context.Background(), this.plugin.redis, []string{lockKeyPrefix + name}, owner).Err(); err != nil {
+		hlogger.Error("failed to release lock [%s]: %v", name, err)
+	}
+}
+
+func (this *scheduleLock) LastRun(name string) (time.Time, *herrors.Error) {
+	ms, err := this.plugin.redis.Get(context.Background(), lockKeyPrefix+name+lockLastSuffix).Int64()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, herrors.ErrSysInternal.New(err.Error())
+	}
+	return time.UnixMilli(ms), nil
+}
+
+func (this *scheduleLock) SetLastRun(name string, token int64, at time.Time) *herrors.Error {
+	key := lockKeyPrefix + name
+	ok, err := setLastRunScript.Run(context.Background(), this.plugin.redis, []string{key + lockFenceSuffix, key + lockLastSuffix}, token, at.UnixMilli()).Int64()
+	if err != nil {
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+	if ok == 0 {
+		return herrors.ErrSysBusy.New("lock [%s] acquired by other owner", name)
+	}
+	return nil
+}
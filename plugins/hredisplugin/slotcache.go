@@ -0,0 +1,68 @@
+package hredisplugin
+
+import (
+	"context"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/common/htypes"
+	"github.com/drharryhe/has/core"
+)
+
+const (
+	slotCacheKeyPrefix = "has:slotcache:"
+	slotCacheScanCount = 100
+)
+
+// SlotCache 提供slot结果缓存。结果以JSON保存，取出后为通用的map/slice结构
+func (this *Plugin) SlotCache() core.ISlotCache {
+	return &slotCache{plugin: this}
+}
+
+type slotCache struct {
+	plugin *Plugin
+}
+
+func (this *slotCache) Get(bucket string, key string) (htypes.Any, bool) {
+	bs, err := this.plugin.redis.Get(context.Background(), slotCacheKeyPrefix+bucket+":"+key).Bytes()
+	if err != nil {
+		return nil, false
+	}
+
+	var val htypes.Any
+	if err = jsoniter.Unmarshal(bs, &val); err != nil {
+		return nil, false
+	}
+	return val, true
+}
+
+func (this *slotCache) Set(bucket string, key string, val htypes.Any, ttl time.Duration) {
+	bs, err := jsoniter.Marshal(val)
+	if err != nil {
+		hlogger.Error(herrors.ErrSysInternal.New(err.Error()).D("failed to marshal slot cache"))
+		return
+	}
+	if err = this.plugin.redis.Set(context.Background(), slotCacheKeyPrefix+bucket+":"+key, bs, ttl).Err(); err != nil {
+		hlogger.Error(herrors.ErrSysInternal.New(err.Error()).D("failed to set slot cache"))
+	}
+}
+
+func (this *slotCache) Delete(bucket string, key string) {
+	if err := this.plugin.redis.Del(context.Background(), slotCacheKeyPrefix+bucket+":"+key).Err(); err != nil {
+		hlogger.Error(herrors.ErrSysInternal.New(err.Error()).D("failed to delete slot cache"))
+	}
+}
+
+func (this *slotCache) Clear(bucket string) {
+	ctx := context.Background()
+	iter := this.plugin.redis.Scan(ctx, 0, slotCacheKeyPrefix+bucket+":*", slotCacheScanCount).Iterator()
+	for iter.Next(ctx) {
+		this.plugin.redis.Del(ctx, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		hlogger.Error(herrors.ErrSysInternal.New(err.Error()).D("failed to clear slot cache"))
+	}
+}
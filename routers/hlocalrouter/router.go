@@ -1,6 +1,8 @@
 package hlocalrouter
 
 import (
+	"context"
+
 	"github.com/drharryhe/has/common/herrors"
 	"github.com/drharryhe/has/common/htypes"
 	"github.com/drharryhe/has/core"
@@ -26,9 +28,13 @@ func (this *Router) Open(s core.IServer, ins core.IRouter) *herrors.Error {
 }
 
 func (this *Router) RequestService(service string, slot string, params htypes.Map) (htypes.Any, *herrors.Error) {
+	return this.RequestServiceContext(context.Background(), service, slot, params)
+}
+
+func (this *Router) RequestServiceContext(ctx context.Context, service string, slot string, params htypes.Map) (htypes.Any, *herrors.Error) {
 	s := this.Services[service]
 
-	if s == nil || s.(core.IEntity).Config().GetDisabled() {
+	if s == nil || core.EntityDisabled(s) {
 		return nil, herrors.ErrCallerInvalidRequest.New("service [%s] not available", service)
 	}
 
@@ -36,7 +42,7 @@ func (this *Router) RequestService(service string, slot string, params htypes.Ma
 		return nil, herrors.ErrCallerInvalidRequest.New("slot [%s] not available", slot)
 	}
 
-	return s.Request(slot, params)
+	return s.RequestContext(ctx, slot, params)
 }
 
 func (this *Router) EntityStub() *core.EntityStub {
@@ -7,9 +7,11 @@ type RedisRouter struct {
 
 	RedisServers  []string //如果cluster=false，使用第一个
 	RedisUserName string
-	RedisPassword string
+	RedisPassword string `secret:"true"`
 	Database      int
 	RpcxAddr      string
 	Domain        string
 	Cluster       bool
+	NodeTTL       int //本节点的服务地址及实体在Redis中的有效时间(秒)，运行期间定期续期，节点异常退出后自动失效，缺省30
+	ManageTimeout int //转发到其他节点的管理请求的超时(秒)，缺省10
 }
@@ -4,10 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
 	"runtime/debug"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-redis/redis/v8"
+	jsoniter "github.com/json-iterator/go"
 	"github.com/smallnest/rpcx/client"
 	"github.com/smallnest/rpcx/protocol"
 	"github.com/smallnest/rpcx/server"
@@ -15,12 +19,19 @@ import (
 	"github.com/drharryhe/has/common/hconf"
 	"github.com/drharryhe/has/common/herrors"
 	"github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/common/htrace"
 	"github.com/drharryhe/has/common/htypes"
 	"github.com/drharryhe/has/core"
 )
 
 const (
 	RpcxServer = "HasServices"
+
+	rpcxShutdownTimeout  = 5 * time.Second
+	defaultNodeTTL       = 30
+	defaultManageTimeout = 10
+	redisTimeout         = 5 * time.Second
+	scanCount            = 100
 )
 
 func New() *Router {
@@ -34,6 +45,9 @@ type Router struct {
 	redis        *redis.Client
 	rpcxServer   *server.Server
 	conf         RedisRouter
+	drainOnce    sync.Once
+	stopRenew    chan struct{}
+	lock         sync.Mutex //登记服务、实体与定期续期之间互斥
 }
 
 func (this *Router) Open(s core.IServer, ins core.IRouter) *herrors.Error {
@@ -44,6 +58,12 @@ func (this *Router) Open(s core.IServer, ins core.IRouter) *herrors.Error {
 	if len(this.conf.RedisServers) == 0 {
 		return herrors.ErrSysInternal.New("RedisRouter has no RedisServers configure")
 	}
+	if this.conf.NodeTTL <= 0 {
+		this.conf.NodeTTL = defaultNodeTTL
+	}
+	if this.conf.ManageTimeout <= 0 {
+		this.conf.ManageTimeout = defaultManageTimeout
+	}
 
 	this.rpcxServer = server.NewServer()
 	if err := this.rpcxServer.RegisterName(RpcxServer, this, ""); err != nil {
@@ -65,10 +85,72 @@ func (this *Router) Open(s core.IServer, ins core.IRouter) *herrors.Error {
 
 	go this.startRpcxServer()
 
+	this.stopRenew = make(chan struct{})
+	go this.renew()
+
 	return nil
 }
 
+// renew 定期续期本节点登记的服务地址及实体，Drain时停止
+func (this *Router) renew() {
+	ticker := time.NewTicker(this.nodeTTL() / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.stopRenew:
+			return
+		case <-ticker.C:
+			this.lock.Lock()
+			for name := range this.Services {
+				if err := this.addServerAddr(name, this.conf.RpcxAddr); err != nil {
+					hlogger.Error(err.D("failed to renew service [%s]", name))
+				}
+			}
+			if err := this.saveNode(); err != nil {
+				hlogger.Error(err.D("failed to renew node"))
+			}
+			this.lock.Unlock()
+		}
+	}
+}
+
+// Drain 从Redis注销本节点的服务地址及实体，然后关闭rpcx服务并等待处理中的请求完成
+func (this *Router) Drain(timeout time.Duration) {
+	this.drainOnce.Do(func() {
+		if this.stopRenew != nil {
+			close(this.stopRenew)
+		}
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		for name := range this.Services {
+			this.delServerAddr(name, this.conf.RpcxAddr)
+		}
+		this.delNode()
+
+		this.shutdownRpcxServer(timeout)
+	})
+}
+
+// Close 未经Drain时先注销并关闭rpcx服务，然后断开Redis
+func (this *Router) Close() {
+	this.Drain(rpcxShutdownTimeout)
+
+	var err error
+	if this.conf.Cluster {
+		err = this.redisCluster.Close()
+	} else {
+		err = this.redis.Close()
+	}
+	if err != nil {
+		hlogger.Error(err.Error())
+	}
+}
+
 func (this *Router) RegisterService(s core.IService) *herrors.Error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
 	if err := this.BaseRouter.RegisterService(s); err != nil {
 		return err
 	}
@@ -76,7 +158,23 @@ func (this *Router) RegisterService(s core.IService) *herrors.Error {
 	return this.addServerAddr(s.Name(), this.conf.RpcxAddr)
 }
 
+// RegisterEntity 同时在Redis中登记本节点的实体，供其他节点管理
+func (this *Router) RegisterEntity(m core.IEntity) *herrors.Error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if err := this.BaseRouter.RegisterEntity(m); err != nil {
+		return err
+	}
+
+	return this.saveNode()
+}
+
 func (this *Router) RequestService(service string, slot string, params htypes.Map) (htypes.Any, *herrors.Error) {
+	return this.RequestServiceContext(context.Background(), service, slot, params)
+}
+
+func (this *Router) RequestServiceContext(ctx context.Context, service string, slot string, params htypes.Map) (htypes.Any, *herrors.Error) {
 	defer func() {
 		e := recover()
 		if e != nil {
@@ -94,23 +192,35 @@ func (this *Router) RequestService(service string, slot string, params htypes.Ma
 		Service: service,
 		Slot:    slot,
 		Params:  params,
+		Trace:   htrace.Traceparent(ctx),
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		args.Deadline = deadline.UnixNano()
 	}
 	resp := &core.SlotResponse{}
 	for _, a := range addrs {
+		if err := core.ContextError(ctx); err != nil {
+			return nil, err
+		}
+
 		d, _ := client.NewPeer2PeerDiscovery("tcp@"+a, "")
 		opt := client.DefaultOption
 		opt.SerializeType = protocol.SerializeNone
 
 		xclient := client.NewXClient(RpcxServer, client.Failtry, client.RandomSelect, d, client.DefaultOption)
 
-		if err := xclient.Call(context.Background(), core.RpcServiceRequestName, args, resp); err != nil {
-			go this.delServerAddr(service, a)
+		if err := xclient.Call(ctx, core.RpcServiceRequestName, args, resp); err != nil {
 			_ = xclient.Close()
+			//调用方取消或超时不代表服务节点失效
+			if herr := core.ContextError(ctx); herr != nil {
+				return nil, herr
+			}
+			go this.delServerAddr(service, a)
 			continue
 		}
 		_ = xclient.Close()
 
-		return resp.Data, resp.Error
+		return resp.Data, core.RestoreErrorDetails(resp.Error)
 	}
 
 	return nil, herrors.ErrSysInternal.New("no service available")
@@ -123,15 +233,47 @@ func (this *Router) EntityStub() *core.EntityStub {
 		})
 }
 
+// Load redis连接池状态
+func (this *Router) Load() htypes.Map {
+	var s *redis.PoolStats
+	if this.conf.Cluster {
+		s = this.redisCluster.PoolStats()
+	} else {
+		s = this.redis.PoolStats()
+	}
+	return htypes.Map{
+		"hits":        s.Hits,
+		"misses":      s.Misses,
+		"timeouts":    s.Timeouts,
+		"total_conns": s.TotalConns,
+		"idle_conns":  s.IdleConns,
+		"stale_conns": s.StaleConns,
+	}
+}
+
 func (this *Router) Config() core.IEntityConf {
 	return &this.conf
 }
 
+// OnConfigReload redis及rpcx连接设置无法在运行期间修改
+func (this *Router) OnConfigReload(old core.IEntityConf) *herrors.Error {
+	o := *old.(*RedisRouter)
+	n := this.conf
+	o.EntityConfBase, n.EntityConfBase = core.EntityConfBase{}, core.EntityConfBase{}
+	if !reflect.DeepEqual(o, n) {
+		return herrors.ErrCallerInvalidRequest.New("redis and rpcx settings can not be changed at runtime")
+	}
+	return nil
+}
+
 func (this *Router) UnRegisterService(s core.IService) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
 	this.BaseRouter.UnRegisterService(s)
 }
 
-func (this *Router) HandleServiceRequested(_ context.Context, args *core.RpcRequestArguments, resp *core.SlotResponse) error {
+func (this *Router) HandleServiceRequested(ctx context.Context, args *core.RpcRequestArguments, resp *core.SlotResponse) error {
 	service := args.Service
 	slot := args.Slot
 	ps := args.Params
@@ -141,28 +283,164 @@ func (this *Router) HandleServiceRequested(_ context.Context, args *core.RpcRequ
 		return errors.New("service not found")
 	}
 
+	if core.EntityDisabled(s) {
+		resp.Error = herrors.ErrCallerInvalidRequest.New("service [%s] not available", service)
+		return nil
+	}
+
 	if s.Slot(slot) == nil {
 		return errors.New("slot not found")
 	}
 
-	ret, err := s.Request(slot, ps)
+	if args.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.Unix(0, args.Deadline))
+		defer cancel()
+	}
+
+	ctx, span := htrace.Start(htrace.ContextWithRemote(ctx, args.Trace), "rpc "+service+"."+slot, htrace.KindServer)
+	span.SetAttribute("rpc.addr", this.conf.RpcxAddr)
+	ret, err := s.RequestContext(ctx, slot, ps)
+	if err != nil {
+		span.SetError(err.Error())
+	}
+	span.Finish()
 	resp.Data = ret
 	resp.Error = err
 
 	return nil
 }
 
-func (this *Router) getServerAddrs(service string) ([]string, *herrors.Error) {
+// HandleEntityManaged 处理其他节点转发的实体管理请求
+func (this *Router) HandleEntityManaged(_ context.Context, args *core.RpcManageArguments, resp *core.SlotResponse) error {
+	resp.Data, resp.Error = this.BaseRouter.ManageEntity(&core.EntityMeta{EID: args.EID}, args.Act, args.Params)
+	return nil
+}
+
+// RemoteEntities 其他节点登记的实体
+func (this *Router) RemoteEntities() ([]*core.EntityMeta, *herrors.Error) {
+	keys, err := this.scanKeys(this.nodeKey("*"))
+	if err != nil {
+		return nil, herrors.ErrSysInternal.New(err.Error())
+	}
+
+	self := this.nodeKey(this.serverEID())
+	var ret []*core.EntityMeta
+	for _, k := range keys {
+		if k == self {
+			continue
+		}
+		n, herr := this.getNode(k)
+		if herr != nil {
+			return nil, herr
+		}
+		if n != nil {
+			ret = append(ret, n.Entities...)
+		}
+	}
+	return ret, nil
+}
+
+// ManageRemoteEntity 通过rpcx将管理请求转发到实体所在节点
+func (this *Router) ManageRemoteEntity(mm *core.EntityMeta, act string, params htypes.Map) (htypes.Any, *herrors.Error) {
+	n, err := this.getNode(this.nodeKey(mm.ServerEID))
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
+		return nil, herrors.ErrCallerInvalidRequest.New("server [%s] not found", mm.ServerEID)
+	}
+
+	d, _ := client.NewPeer2PeerDiscovery("tcp@"+n.Addr, "")
+	xclient := client.NewXClient(RpcxServer, client.Failtry, client.RandomSelect, d, client.DefaultOption)
+	defer xclient.Close()
+
+	args := core.RpcManageArguments{
+		EID:    mm.EID,
+		Act:    act,
+		Params: params,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(this.conf.ManageTimeout)*time.Second)
+	defer cancel()
+	resp := &core.SlotResponse{}
+	if err := xclient.Call(ctx, core.RpcManageRequestName, args, resp); err != nil {
+		return nil, herrors.ErrSysInternal.New(err.Error()).D("failed to reach server [%s]", mm.ServerEID)
+	}
+	return resp.Data, resp.Error
+}
+
+// node 节点在Redis中登记的rpcx地址及实体
+type node struct {
+	Addr     string
+	Entities []*core.EntityMeta
+}
+
+func (this *Router) serverEID() string {
+	return this.Server().(core.IEntity).EntityMeta().EID
+}
+
+func (this *Router) saveNode() *herrors.Error {
+	bs, err := jsoniter.Marshal(&node{
+		Addr:     this.conf.RpcxAddr,
+		Entities: this.AllEntities(),
+	})
+	if err != nil {
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+
+	key := this.nodeKey(this.serverEID())
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if this.conf.Cluster {
+		err = this.redisCluster.Set(ctx, key, bs, this.nodeTTL()).Err()
+	} else {
+		err = this.redis.Set(ctx, key, bs, this.nodeTTL()).Err()
+	}
+	if err != nil {
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+	return nil
+}
+
+func (this *Router) getNode(key string) (*node, *herrors.Error) {
 	var (
-		vals []string
-		err  error
+		bs  []byte
+		err error
 	)
 	if this.conf.Cluster {
-		vals, err = this.redisCluster.Keys(context.Background(), this.prefix(service)).Result()
+		bs, err = this.redisCluster.Get(context.Background(), key).Bytes()
 	} else {
-		vals, err = this.redis.Keys(context.Background(), this.prefix(service)).Result()
+		bs, err = this.redis.Get(context.Background(), key).Bytes()
+	}
+	if err == redis.Nil {
+		return nil, nil
 	}
+	if err != nil {
+		return nil, herrors.ErrSysInternal.New(err.Error())
+	}
+
+	n := &node{}
+	if err = jsoniter.Unmarshal(bs, n); err != nil {
+		return nil, herrors.ErrSysInternal.New(err.Error()).D("invalid node [%s]", key)
+	}
+	return n, nil
+}
 
+func (this *Router) delNode() {
+	var err error
+	if this.conf.Cluster {
+		err = this.redisCluster.Del(context.Background(), this.nodeKey(this.serverEID())).Err()
+	} else {
+		err = this.redis.Del(context.Background(), this.nodeKey(this.serverEID())).Err()
+	}
+
+	if err != nil {
+		hlogger.Error(err.Error())
+	}
+}
+
+func (this *Router) getServerAddrs(service string) ([]string, *herrors.Error) {
+	vals, err := this.scanKeys(this.prefix(service))
 	if err != nil {
 		return nil, herrors.ErrSysInternal.New(err.Error())
 	}
@@ -171,6 +449,8 @@ func (this *Router) getServerAddrs(service string) ([]string, *herrors.Error) {
 		ss := strings.Split(v, "->")
 		if len(ss) == 2 {
 			addrs = append(addrs, ss[1])
+		} else if this.conf.Cluster {
+			_ = this.redisCluster.Del(context.Background(), v).Err()
 		} else {
 			_ = this.redis.Del(context.Background(), v).Err()
 		}
@@ -180,10 +460,12 @@ func (this *Router) getServerAddrs(service string) ([]string, *herrors.Error) {
 
 func (this *Router) addServerAddr(service string, addr string) *herrors.Error {
 	var err error
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
 	if this.conf.Cluster {
-		_, err = this.redisCluster.Set(context.Background(), this.key(service, addr), this.conf.RpcxAddr, 0).Result()
+		_, err = this.redisCluster.Set(ctx, this.key(service, addr), this.conf.RpcxAddr, this.nodeTTL()).Result()
 	} else {
-		_, err = this.redis.Set(context.Background(), this.key(service, addr), this.conf.RpcxAddr, 0).Result()
+		_, err = this.redis.Set(ctx, this.key(service, addr), this.conf.RpcxAddr, this.nodeTTL()).Result()
 	}
 
 	if err != nil {
@@ -223,6 +505,60 @@ func (this *Router) startRpcxServer() {
 	}
 }
 
+func (this *Router) shutdownRpcxServer(timeout time.Duration) {
+	defer func() {
+		//rpcx服务未能启动时，Shutdown会因listener为空而panic
+		if e := recover(); e != nil {
+			hlogger.Error(e)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := this.rpcxServer.Shutdown(ctx); err != nil {
+		hlogger.Error(err.Error())
+	}
+}
+
+// scanKeys 以SCAN分批查找匹配的key，不像KEYS那样阻塞redis。集群时遍历各主节点
+func (this *Router) scanKeys(pattern string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if !this.conf.Cluster {
+		return scanClient(ctx, this.redis, pattern)
+	}
+
+	var (
+		lock sync.Mutex
+		keys []string
+	)
+	err := this.redisCluster.ForEachMaster(ctx, func(ctx context.Context, c *redis.Client) error {
+		kk, err := scanClient(ctx, c, pattern)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		keys = append(keys, kk...)
+		lock.Unlock()
+		return nil
+	})
+	return keys, err
+}
+
+func scanClient(ctx context.Context, c *redis.Client, pattern string) ([]string, error) {
+	var keys []string
+	iter := c.Scan(ctx, 0, pattern, scanCount).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+func (this *Router) nodeTTL() time.Duration {
+	return time.Duration(this.conf.NodeTTL) * time.Second
+}
+
 func (this *Router) prefix(service string) string {
 	return fmt.Sprintf("%s-%s->*", this.conf.Domain, service)
 }
@@ -230,3 +566,7 @@ func (this *Router) prefix(service string) string {
 func (this *Router) key(service string, addr string) string {
 	return fmt.Sprintf("%s-%s->%s", this.conf.Domain, service, addr)
 }
+
+func (this *Router) nodeKey(serverEID string) string {
+	return fmt.Sprintf("%s#node->%s", this.conf.Domain, serverEID)
+}
@@ -9,12 +9,12 @@ type ApAuthService struct {
 
 	DatabaseKey            string
 	AutoMigrate            bool
-	SessionService         string
-	SessionCreateSlot      string
-	SessionVerifySlot      string
-	SessionRevokeSlot      string
+	SessionService         string `require:"true"`
+	SessionCreateSlot      string `require:"true"`
+	SessionVerifySlot      string `require:"true"`
+	SessionRevokeSlot      string `require:"true"`
 	PwdEncoding            string
-	PwdSecret              string
+	PwdSecret              string `secret:"true"`
 	PwdMinLen              int
 	PwdMaxLen              int
 	PwdUpperAndLowerLetter bool
@@ -23,11 +23,9 @@ type ApAuthService struct {
 	PwdSymbols             string
 	DefaultPwd             string
 	SuperName              string
-	SuperPwd               string
+	SuperPwd               string `secret:"true"`
 	SuperFails             int
-	SuperFailed            int
 	UnlockTime             int
-	LockTime               string
 	LockAfterFails         int
 	OutAddressField        string
 	OutAgentField          string
@@ -51,10 +51,9 @@ func (this *Service) Open(s core.IServer, instance core.IService, options htypes
 	plugin := this.UsePlugin("DatabasePlugin").(*hdatabaseplugin.Plugin)
 	this.db = plugin.Capability().(map[string]*gorm.DB)[this.conf.DatabaseKey]
 
-	if this.conf.AutoMigrate {
+	if core.OneShotPending(this, "AutoMigrate", this.conf.AutoMigrate) {
 		this.db.AutoMigrate(&SvsApAuthUser{})
-		this.conf.AutoMigrate = false
-		hconf.Save()
+		core.OneShotDone(this, "AutoMigrate")
 	}
 
 	if this.conf.SessionService == "" {
@@ -87,6 +86,16 @@ func (this *Service) Config() core.IEntityConf {
 	return &this.conf
 }
 
+func (this *Service) Dependencies() *core.ServiceDependencies {
+	deps := &core.ServiceDependencies{
+		Plugins: []string{"DatabasePlugin"},
+	}
+	if this.conf.SessionService != "" {
+		deps.Services = append(deps.Services, this.conf.SessionService)
+	}
+	return deps
+}
+
 func (this *Service) Objects() []interface{} {
 	return []interface{}{
 		&SvsApAuthUser{},
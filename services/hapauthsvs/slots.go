@@ -5,7 +5,6 @@ import (
 	"gorm.io/gorm"
 	"time"
 
-	"github.com/drharryhe/has/common/hconf"
 	"github.com/drharryhe/has/common/herrors"
 	"github.com/drharryhe/has/common/htypes"
 	"github.com/drharryhe/has/core"
@@ -32,31 +31,27 @@ func (this *Service) Login(req *LoginRequest, res *core.SlotResponse) {
 
 	isRoot := false
 	if *req.Name == this.conf.SuperName {
-		if this.conf.SuperFailed >= this.conf.SuperFails {
+		if this.superFailed() >= this.conf.SuperFails {
 			// 添加锁定时间
-			if this.conf.LockTime == "" {
-				this.conf.LockTime = time.Now().Format("2006-01-02 15:04:05")
-				hconf.Save()
+			if this.lockTime() == "" {
+				this.setLockTime(time.Now().Format("2006-01-02 15:04:05"))
 			}
 			// 解锁
-			lockTime, _ := time.Parse("2006-01-02 15:04:05", this.conf.LockTime)
+			lockTime, _ := time.Parse("2006-01-02 15:04:05", this.lockTime())
 			if time.Now().Sub(lockTime) * time.Second >= time.Duration(this.conf.UnlockTime) * time.Second {
-				this.conf.LockTime = ""
-				hconf.Save()
+				this.setLockTime("")
 				goto UNLOCK
 			}
 			this.Response(res, nil, herrors.ErrUserUnauthorizedAct.New(fmt.Sprintf(strUserLocked, this.conf.UnlockTime)))
 			return
 		}
 		UNLOCK:
-		if this.conf.SuperPwd == "" || this.conf.SuperPwd != this.pwdEncodingFunc(pwd) {
-			this.conf.SuperFailed++
-			hconf.Save()
+		if this.superPwd() == "" || this.superPwd() != this.pwdEncodingFunc(pwd) {
+			this.incSuperFailed()
 			this.Response(res, nil, herrors.ErrUserInvalidAct.New(strInvalidUserOrPassword))
 			return
 		}
-		this.conf.SuperFailed = 0
-		hconf.Save()
+		this.setSuperFailed(0)
 		isRoot = true
 	}
 
@@ -123,12 +118,12 @@ func (this *Service) Login(req *LoginRequest, res *core.SlotResponse) {
 		ps[this.conf.OutAgentField] = *req.Agent
 	}
 
-	bs, err := this.Server().RequestService(this.conf.SessionService, this.conf.SessionCreateSlot, ps)
+	token, err := core.Call[htypes.Map, string](this.Server(), this.conf.SessionService, this.conf.SessionCreateSlot, ps)
 	if err != nil {
 		this.Response(res, nil, err)
 		return
 	} else {
-		result["token"] = string(bs.([]byte))
+		result["token"] = token
 	}
 	this.Response(res, &result, nil)
 }
@@ -213,14 +208,13 @@ func (this *Service) ChangeSuperPwd(req *ChangeSuperPwdRequest, res *core.SlotRe
 		return
 	}
 
-	if this.conf.SuperFails >= this.conf.LockAfterFails {
-		this.conf.SuperFails++
-		hconf.Save()
+	if this.superFailed() >= this.conf.LockAfterFails {
+		this.incSuperFailed()
 		this.Response(res, nil, herrors.ErrUserUnauthorizedAct.New(strUserLocked))
 		return
 	}
 
-	if this.conf.SuperPwd != this.pwdEncodingFunc(hencoder.Sha256Hash(pwdOld)) {
+	if this.superPwd() != this.pwdEncodingFunc(hencoder.Sha256Hash(pwdOld)) {
 		this.Response(res, nil, herrors.ErrUserInvalidAct.New(strInvalidUserOrPassword))
 		return
 	}
@@ -231,8 +225,10 @@ func (this *Service) ChangeSuperPwd(req *ChangeSuperPwdRequest, res *core.SlotRe
 		return
 	}
 
-	this.conf.SuperPwd = this.pwdEncodingFunc(hencoder.Sha256Hash(pwdNew))
-	hconf.Save()
+	if err := this.setSuperPwd(this.pwdEncodingFunc(hencoder.Sha256Hash(pwdNew))); err != nil {
+		this.Response(res, nil, err)
+		return
+	}
 }
 
 type ChangePwdRequest struct {
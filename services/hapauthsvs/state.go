@@ -0,0 +1,62 @@
+package hapauthsvs
+
+import (
+	"github.com/drharryhe/has/common/herrors"
+	"github.com/drharryhe/has/common/hlogger"
+	"github.com/drharryhe/has/common/hstate"
+	"github.com/drharryhe/has/core"
+)
+
+//超级用户的登录失败次数、锁定时间及修改后的密码保存在运行状态中，不再写回配置文件
+
+const (
+	stateSuperFailed  = "SuperFailed"
+	stateLockTime     = "LockTime"
+	stateSuperPwd     = "SuperPwd"
+	stateSuperPwdBase = "SuperPwdBase" //修改密码时配置文件中的密码，配置文件中的密码变化后以配置文件为准
+)
+
+func (this *Service) superPwd() string {
+	pwd := hstate.GetString(core.StateKey(this, stateSuperPwd), "")
+	if pwd == "" || hstate.GetString(core.StateKey(this, stateSuperPwdBase), "") != this.conf.SuperPwd {
+		return this.conf.SuperPwd
+	}
+	return pwd
+}
+
+func (this *Service) setSuperPwd(pwd string) *herrors.Error {
+	if err := hstate.Set(core.StateKey(this, stateSuperPwdBase), this.conf.SuperPwd); err != nil {
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+	if err := hstate.Set(core.StateKey(this, stateSuperPwd), pwd); err != nil {
+		return herrors.ErrSysInternal.New(err.Error())
+	}
+	return nil
+}
+
+func (this *Service) superFailed() int {
+	return int(hstate.GetInt(core.StateKey(this, stateSuperFailed), 0))
+}
+
+func (this *Service) setSuperFailed(n int) {
+	this.saveState(hstate.SetInt(core.StateKey(this, stateSuperFailed), int64(n)))
+}
+
+func (this *Service) incSuperFailed() {
+	_, err := hstate.Incr(core.StateKey(this, stateSuperFailed), 1)
+	this.saveState(err)
+}
+
+func (this *Service) lockTime() string {
+	return hstate.GetString(core.StateKey(this, stateLockTime), "")
+}
+
+func (this *Service) setLockTime(t string) {
+	this.saveState(hstate.Set(core.StateKey(this, stateLockTime), t))
+}
+
+func (this *Service) saveState(err error) {
+	if err != nil {
+		hlogger.Error(herrors.ErrSysInternal.New(err.Error()).D("failed to save state of [%s]", this.Class()))
+	}
+}
@@ -1,6 +1,6 @@
 /**********************************
 	Service 关系数据管理服务
-	为了保证Service可以对所有注册到DatabasePlugin的对象进行管理，Service需要在其他服务注册之后，最后进行注册
+	为了保证Service可以对所有注册到DatabasePlugin的对象进行管理，Service依赖其他所有服务，将在其他服务之后打开
  **********************************/
 
 package hdatasvs
@@ -112,6 +112,7 @@ func (this *Service) Open(s core.IServer, instance core.IService, options htypes
 		}
 
 		if ops.Objects != nil {
+			autoMigrate := core.OneShotPending(this, "AutoMigrate", this.conf.AutoMigrate)
 			for i, o := range ops.Objects {
 				n := hruntime.GetObjectName(o)
 				this.instancesWithName[n] = ops.Objects[i]
@@ -127,7 +128,7 @@ func (this *Service) Open(s core.IServer, instance core.IService, options htypes
 				this.objectsByKey[obj.key] = obj
 				this.objectsByName[n] = obj
 
-				if this.conf.AutoMigrate {
+				if autoMigrate {
 					if err := this.getDB(obj.database).AutoMigrate(o); err != nil {
 						if hconf.IsDebug() {
 							_ = herrors.ErrSysInternal.New(err.Error())
@@ -137,9 +138,10 @@ func (this *Service) Open(s core.IServer, instance core.IService, options htypes
 					}
 				}
 			}
-			hlogger.Info("AutoMigrate Done")
-			this.conf.AutoMigrate = false
-			hconf.Save()
+			if autoMigrate {
+				core.OneShotDone(this, "AutoMigrate")
+				hlogger.Info("AutoMigrate Done")
+			}
 		}
 
 		if ops.Views != nil {
@@ -167,6 +169,21 @@ func (this *Service) Config() core.IEntityConf {
 	return &this.conf
 }
 
+// invalidateReadCache 数据变更成功后，清除Query及View的缓存
+func (this *Service) invalidateReadCache(res *core.SlotResponse) {
+	if res.Error == nil {
+		this.InvalidateCache("Query", nil)
+		this.InvalidateCache("View", nil)
+	}
+}
+
+func (this *Service) Dependencies() *core.ServiceDependencies {
+	return &core.ServiceDependencies{
+		Plugins:  []string{"DatabasePlugin"},
+		Services: []string{core.AllServices},
+	}
+}
+
 func (this *Service) buildWhereClause(fs *filter) (string, []interface{}, *herrors.Error) {
 	var (
 		where string
@@ -2003,7 +2020,7 @@ func (this *Service) parseView(o htypes.Any) (*view, *herrors.Error) {
 		return vw, nil
 	}
 
-	return nil, herrors.ErrSysInternal.New("invalid view [%s], not derived from DataView")
+	return nil, herrors.ErrSysInternal.New("invalid view [%s], not derived from DataView", vw.name)
 }
 
 func (this *Service) parseViewField(view *view, f *viewField, s string) *herrors.Error {
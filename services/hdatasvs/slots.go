@@ -1,6 +1,7 @@
 package hdatasvs
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"strings"
@@ -21,7 +22,9 @@ type CreateRequest struct {
 	Object *htypes.Map `json:"object" param:"require"`
 }
 
-func (this *Service) Create(req *CreateRequest, res *core.SlotResponse) {
+func (this *Service) Create(ctx context.Context, req *CreateRequest, res *core.SlotResponse) {
+	defer this.invalidateReadCache(res)
+
 	o := this.objectsByKey[*req.Key]
 	if o == nil {
 		this.Response(res, nil, herrors.ErrSysInternal.New("key [%s] not found", *req.Key).D("failed to create data"))
@@ -74,7 +77,7 @@ func (this *Service) Create(req *CreateRequest, res *core.SlotResponse) {
 		return
 	}
 
-	if err := this.getDB(o.database).Table(tabName).Create(ins).Error; err != nil {
+	if err := this.getDB(o.database).WithContext(ctx).Table(tabName).Create(ins).Error; err != nil {
 		if strings.Index(err.Error(), "Error 1062") >= 0 {
 			this.Response(res, nil, herrors.ErrCallerInvalidRequest.New("object duplicated"))
 		} else {
@@ -102,7 +105,9 @@ type CreateMRequest struct {
 	Objects *[]htypes.Map `json:"objects" param:"require"`
 }
 
-func (this *Service) CreateM(req *CreateMRequest, res *core.SlotResponse) {
+func (this *Service) CreateM(ctx context.Context, req *CreateMRequest, res *core.SlotResponse) {
+	defer this.invalidateReadCache(res)
+
 	o := this.objectsByKey[*req.Key]
 	if o == nil {
 		this.Response(res, nil, herrors.ErrSysInternal.New("key [%s] not found", *req.Key).D("failed to create data"))
@@ -160,7 +165,7 @@ func (this *Service) CreateM(req *CreateMRequest, res *core.SlotResponse) {
 	//批量创建记录
 	var ids []htypes.Any
 	for tab, instances := range instancesByTabName {
-		if err := this.getDB(o.database).Table(tab).Create(instances).Error; err != nil {
+		if err := this.getDB(o.database).WithContext(ctx).Table(tab).Create(instances).Error; err != nil {
 			this.Response(res, nil, herrors.ErrSysInternal.New(err.Error()))
 			return
 		}
@@ -197,7 +202,9 @@ type UpdateRequest struct {
 	Bucket htypes.Map
 }
 
-func (this *Service) Update(req *UpdateRequest, res *core.SlotResponse) {
+func (this *Service) Update(ctx context.Context, req *UpdateRequest, res *core.SlotResponse) {
+	defer this.invalidateReadCache(res)
+
 	o := this.objectsByKey[*req.Key]
 	if o == nil {
 		this.Response(res, nil, herrors.ErrCallerInvalidRequest.New("key [%s] not found", *req.Key))
@@ -277,7 +284,7 @@ func (this *Service) Update(req *UpdateRequest, res *core.SlotResponse) {
 		values[o.fieldMapByName[n].col] = v
 	}
 	//if err := this.getDB(o.database).Table(fmt.Sprintf("`%s` AS `%s`", tableName, o.key)).Where(where, vals...).Updates(values).Error; err != nil {
-	if err := this.getDB(o.database).Table(fmt.Sprintf("%s AS %s", tableName, o.key)).Where(where, vals...).Updates(values).Error; err != nil {
+	if err := this.getDB(o.database).WithContext(ctx).Table(fmt.Sprintf("%s AS %s", tableName, o.key)).Where(where, vals...).Updates(values).Error; err != nil {
 		this.Response(res, nil, herrors.ErrSysInternal.New(err.Error()))
 		return
 	}
@@ -299,7 +306,9 @@ type DeleteRequest struct {
 	Objects *[]htypes.Map `json:"objects" param:"require;type:ObjectArray"`
 }
 
-func (this *Service) Delete(req *DeleteRequest, res *core.SlotResponse) {
+func (this *Service) Delete(ctx context.Context, req *DeleteRequest, res *core.SlotResponse) {
+	defer this.invalidateReadCache(res)
+
 	o := this.objectsByKey[*req.Key]
 	if o == nil {
 		this.Response(res, nil, herrors.ErrSysInternal.New("key [%s] not found:", *req.Key))
@@ -331,7 +340,7 @@ func (this *Service) Delete(req *DeleteRequest, res *core.SlotResponse) {
 		instancesByTabName[tabName] = append(instancesByTabName[tabName], vals)
 	}
 
-	db := this.getDB(o.database)
+	db := this.getDB(o.database).WithContext(ctx)
 	ins := hruntime.CloneObject(o.instance)
 	for tab, valsSlice := range instancesByTabName {
 		var where []string
@@ -370,7 +379,7 @@ type QueryRequest struct {
 	Records []htypes.Any `param:"-"`
 }
 
-func (this *Service) Query(req *QueryRequest, res *core.SlotResponse) {
+func (this *Service) Query(ctx context.Context, req *QueryRequest, res *core.SlotResponse) {
 	o := this.objectsByKey[*req.Key]
 	if o == nil {
 		this.Response(res, nil, herrors.ErrSysInternal.New("key [%s] not found", *req.Key))
@@ -475,7 +484,7 @@ func (this *Service) Query(req *QueryRequest, res *core.SlotResponse) {
 	var scope *gorm.DB
 
 	//scope = this.getDB(o.database).Table(fmt.Sprintf("`%s` AS `%s`", tableName, o.key))
-	scope = this.getDB(o.database).Table(fmt.Sprintf("%s AS %s", tableName, o.key))
+	scope = this.getDB(o.database).WithContext(ctx).Table(fmt.Sprintf("%s AS %s", tableName, o.key))
 	scope = scope.Select(selectFieldNames)
 
 	if strings.Trim(strings.Trim(where, ")"), "(") != "" {
@@ -520,12 +529,12 @@ func (this *Service) Query(req *QueryRequest, res *core.SlotResponse) {
 		var total int64
 		var err error
 		//scope = this.getDB(o.database).Table(fmt.Sprintf("`%s` AS `%s`", tableName, o.key))
-		scope = this.getDB(o.database).Table(fmt.Sprintf("%s AS %s", tableName, o.key))
-		scope = scope.Select(fmt.Sprintf("%s"), o.primaryField.col)
+		scope = this.getDB(o.database).WithContext(ctx).Table(fmt.Sprintf("%s AS %s", tableName, o.key))
+		scope = scope.Select(fmt.Sprintf("%s", o.primaryField.col))
 		if where != "" && where != "()" {
 			err = scope.Where(where, vals...).Count(&total).Error
 		} else {
-			err = scope.Select(fmt.Sprintf("%s"), o.primaryField.col).Where("1>0").Count(&total).Error
+			err = scope.Select(fmt.Sprintf("%s", o.primaryField.col)).Where("1>0").Count(&total).Error
 		}
 
 		if err != nil {
@@ -561,13 +570,13 @@ type ViewRequest struct {
 	Records []htypes.Any `param:"-"`
 }
 
-func (this *Service) View(req *ViewRequest, res *core.SlotResponse) {
+func (this *Service) View(ctx context.Context, req *ViewRequest, res *core.SlotResponse) {
 	vw := this.viewsWithKey[*req.Key]
 	if vw == nil {
 		this.Response(res, nil, herrors.ErrSysInternal.New("key [%s] not found", *req.Key))
 		return
 	}
-	db := this.dbs[vw.from.database]
+	db := this.dbs[vw.from.database].WithContext(ctx)
 
 	//解析filters
 	filters, herr := this.parseRawFilter(vw.iFieldMap, req.Filter)
@@ -10,6 +10,6 @@ type FileService struct {
 	Name        string
 	Hash        string
 	Storage     string
-	CleanFs     bool
+	CleanFs     bool //清空本地文件仓库，需以--reset启动，只执行一次
 	MinioBucket string
 }
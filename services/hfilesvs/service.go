@@ -57,10 +57,9 @@ func (this *Service) Open(s core.IServer, instance core.IService, options htypes
 	if err != nil {
 		return err
 	}
-	if this.conf.AutoMigrate {
+	if core.OneShotPending(this, "AutoMigrate", this.conf.AutoMigrate) {
 		this.db.AutoMigrate(&SvsFile{})
-		this.conf.AutoMigrate = false
-		hconf.Save()
+		core.OneShotDone(this, "AutoMigrate")
 	}
 	if this.conf.Storage == "" {
 		this.conf.Storage = storageFS
@@ -102,6 +101,16 @@ func (this *Service) Config() core.IEntityConf {
 	return &this.conf
 }
 
+func (this *Service) Dependencies() *core.ServiceDependencies {
+	deps := &core.ServiceDependencies{
+		Plugins: []string{"DatabasePlugin"},
+	}
+	if this.conf.Storage == storageMinio {
+		deps.Plugins = append(deps.Plugins, "MinioPlugin")
+	}
+	return deps
+}
+
 func (this *Service) mountHook(anchor interface{}) {
 	typ := reflect.TypeOf(anchor)
 	val := reflect.ValueOf(anchor)
@@ -178,10 +187,12 @@ func (this *Service) computeHash(data []byte) string {
 }
 
 func (this *Service) checkRepository() *herrors.Error {
-	if this.conf.CleanFs {
+	clean, err := core.OneShotDestructive(this, "CleanFs", this.conf.CleanFs)
+	if err != nil {
+		return err
+	}
+	if clean {
 		_ = os.RemoveAll(repositoryDir)
-		this.conf.CleanFs = false
-		hconf.Save()
 	}
 
 	if !hio.IsDirExist(repositoryDir) {
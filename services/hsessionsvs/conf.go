@@ -12,5 +12,6 @@ type SessionService struct {
 	CheckIP         bool
 	CheckUser       bool
 	CheckAgent      bool
-	MagicToken      string
+	MagicToken      string `secret:"true"`
+	PurgeCron       string //定时清除过期令牌的cron表达式，如@hourly，为空时不清除
 }
@@ -1,7 +1,6 @@
 package hsessionsvs
 
 import (
-	"github.com/drharryhe/has/common/hconf"
 	cache2 "github.com/patrickmn/go-cache"
 	"gorm.io/gorm"
 
@@ -40,10 +39,9 @@ func (this *Service) Open(s core.IServer, instance core.IService, options htypes
 	this.db = this.UsePlugin("DatabasePlugin").(*hdatabaseplugin.Plugin).Capability().(map[string]*gorm.DB)[this.conf.DatabaseKey]
 
 	this.cache = this.UsePlugin("MemCachePlugin").(*hmemcacheplugin.Plugin).GetCache(this.Class())
-	if this.conf.AutoMigrate {
+	if core.OneShotPending(this, "AutoMigrate", this.conf.AutoMigrate) {
 		this.db.AutoMigrate(&SvsSessionToken{})
-		this.conf.AutoMigrate = false
-		hconf.Save()
+		core.OneShotDone(this, "AutoMigrate")
 	}
 	if this.conf.SessionsPerUser <= 0 {
 		this.conf.SessionsPerUser = defaultSessionPerUser
@@ -67,6 +65,22 @@ func (this *Service) Config() core.IEntityConf {
 	return &this.conf
 }
 
+func (this *Service) Dependencies() *core.ServiceDependencies {
+	return &core.ServiceDependencies{
+		Plugins: []string{"DatabasePlugin", "MemCachePlugin"},
+	}
+}
+
+// Schedules 配置了PurgeCron时定时清除过期的令牌，集群中只由持有Server的ScheduleLock的节点执行
+func (this *Service) Schedules() []*core.ScheduledSlot {
+	if this.conf.PurgeCron == "" {
+		return nil
+	}
+	return []*core.ScheduledSlot{
+		{Slot: "PurgeExpiredTokens", Cron: this.conf.PurgeCron, Missed: core.MissedRunOnce, Timeout: 300, Exclusive: true},
+	}
+}
+
 func (this *Service) Objects() []interface{} {
 	return []interface{}{
 		&SvsSessionToken{},
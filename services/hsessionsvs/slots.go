@@ -1,6 +1,7 @@
 package hsessionsvs
 
 import (
+	"context"
 	"time"
 
 	"gorm.io/gorm"
@@ -120,3 +121,17 @@ func (this *Service) VerifyToken(req *VerifyTokenRequest, res *core.SlotResponse
 	err := this.checkToken(st.(*SvsSessionToken), req)
 	this.Response(res, nil, err)
 }
+
+type PurgeExpiredTokensRequest struct {
+	core.SlotRequestBase
+}
+
+// PurgeExpiredTokens 删除已过期的令牌，由定时任务执行，返回删除的数量
+func (this *Service) PurgeExpiredTokens(ctx context.Context, req *PurgeExpiredTokensRequest, res *core.SlotResponse) {
+	ret := this.db.WithContext(ctx).Where("validity < ?", time.Now()).Delete(&SvsSessionToken{})
+	if ret.Error != nil {
+		this.Response(res, nil, herrors.ErrSysInternal.New(ret.Error.Error()))
+		return
+	}
+	this.Response(res, ret.RowsAffected, nil)
+}
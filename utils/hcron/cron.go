@@ -0,0 +1,257 @@
+package hcron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//cron表达式解析。支持5个字段(分 时 日 月 周)或6个字段(秒 分 时 日 月 周)，
+//字段可以是*、?、数值、范围a-b、步长*/n或a-b/n及逗号分隔的列表，月和周可以使用英文缩写(JAN、MON)，周日为0或7。
+//日和周都不是*时满足其一即可，与标准cron相同。另支持@yearly、@monthly、@weekly、@daily、@hourly及@every <duration>
+
+type bounds struct {
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	secondBounds = bounds{0, 59, nil}
+	minuteBounds = bounds{0, 59, nil}
+	hourBounds   = bounds{0, 23, nil}
+	domBounds    = bounds{1, 31, nil}
+	monthBounds  = bounds{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowBounds = bounds{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	descriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// Schedule 解析后的cron表达式
+type Schedule struct {
+	expr    string
+	second  uint64
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool
+	dowStar bool
+	every   time.Duration
+}
+
+// Parse 解析cron表达式
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	s := &Schedule{expr: expr}
+
+	spec := expr
+	if strings.HasPrefix(spec, "@every") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression [%s]: %v", expr, err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("invalid cron expression [%s]: interval less than 1s", expr)
+		}
+		s.every = d
+		return s, nil
+	}
+	if strings.HasPrefix(spec, "@") {
+		if spec = descriptors[strings.ToLower(spec)]; spec == "" {
+			return nil, fmt.Errorf("invalid cron expression [%s]: unknown descriptor", expr)
+		}
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("invalid cron expression [%s]: 5 or 6 fields expected", expr)
+	}
+
+	var err error
+	parts := []struct {
+		bits *uint64
+		b    bounds
+	}{
+		{&s.second, secondBounds},
+		{&s.minute, minuteBounds},
+		{&s.hour, hourBounds},
+		{&s.dom, domBounds},
+		{&s.month, monthBounds},
+		{&s.dow, dowBounds},
+	}
+	for i, p := range parts {
+		if *p.bits, err = parseField(fields[i], p.b); err != nil {
+			return nil, fmt.Errorf("invalid cron expression [%s]: %v", expr, err)
+		}
+	}
+
+	//周日可以写作7
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	s.domStar = fields[3] == "*" || fields[3] == "?"
+	s.dowStar = fields[5] == "*" || fields[5] == "?"
+	return s, nil
+}
+
+// MustParse 解析失败时panic，用于固定的表达式
+func MustParse(expr string) *Schedule {
+	s, err := Parse(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (this *Schedule) String() string {
+	return this.expr
+}
+
+// Next t之后最近一次执行的时间，精确到秒。5年内没有满足的时间时返回零值
+func (this *Schedule) Next(t time.Time) time.Time {
+	if this.every > 0 {
+		return t.Add(this.every - time.Duration(t.Nanosecond())%time.Second)
+	}
+
+	loc := t.Location()
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+	limit := t.Year() + 5
+
+WRAP:
+	if t.Year() > limit {
+		return time.Time{}
+	}
+
+	for !has(this.month, int(t.Month())) {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+
+	for !this.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+
+	for !has(this.hour, t.Hour()) {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+
+	for !has(this.minute, t.Minute()) {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+
+	for !has(this.second, t.Second()) {
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto WRAP
+		}
+	}
+
+	return t
+}
+
+func (this *Schedule) dayMatches(t time.Time) bool {
+	dom := has(this.dom, t.Day())
+	dow := has(this.dow, int(t.Weekday()))
+	if this.domStar || this.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func has(bits uint64, n int) bool {
+	return bits&(1<<uint(n)) != 0
+}
+
+// parseField 解析一个字段，返回各取值对应位为1的位图
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		i := strings.Index(item, "/")
+		if i >= 0 {
+			rng = item[:i]
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in [%s]", item)
+			}
+			step = n
+		}
+
+		lo, hi := b.min, b.max
+		switch {
+		case rng == "*" || rng == "?":
+		case strings.Contains(rng, "-"):
+			vv := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = parseValue(vv[0], b); err != nil {
+				return 0, err
+			}
+			if hi, err = parseValue(vv[1], b); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range [%s]", rng)
+			}
+		default:
+			v, err := parseValue(rng, b)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			//a/n表示从a开始到最大值
+			if i < 0 {
+				hi = v
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(s string, b bounds) (int, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value [%s]", s)
+	}
+	if v < b.min || v > b.max {
+		return 0, fmt.Errorf("value [%d] out of range %d-%d", v, b.min, b.max)
+	}
+	return v, nil
+}
@@ -0,0 +1,48 @@
+package hcron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	from := time.Date(2024, 1, 31, 10, 15, 30, 500, time.UTC)
+	cases := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 31, 10, 16, 0, 0, time.UTC)},
+		{"*/10 * * * * *", time.Date(2024, 1, 31, 10, 15, 40, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, 2, 1, 3, 0, 0, 0, time.UTC)},
+		{"30 10-12/2 * * *", time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * sun", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * MON", time.Date(2024, 2, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan-mar/2 *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2024, 1, 31, 10, 17, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		s, err := Parse(c.expr)
+		if err != nil {
+			t.Errorf("failed to parse [%s]: %v", c.expr, err)
+			continue
+		}
+		if next := s.Next(from); !next.Equal(c.next) {
+			t.Errorf("next of [%s] is %v, %v expected", c.expr, next, c.next)
+		}
+	}
+
+	if next := MustParse("0 0 30 2 *").Next(from); !next.IsZero() {
+		t.Errorf("impossible schedule got %v", next)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * * * * * *", "5-1 * * * *", "*/0 * * * *", "@often", "@every 1ms", "0 0 * foo *"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("[%s] should be invalid", expr)
+		}
+	}
+}
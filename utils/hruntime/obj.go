@@ -123,7 +123,7 @@ func ParseTag(tag string) map[string]string {
 		if strings.TrimSpace(s) == "" {
 			continue
 		}
-		kv := strings.Split(s, ":")
+		kv := strings.SplitN(s, ":", 2)
 		if len(kv) == 1 {
 			ret[s] = ""
 		} else {
@@ -326,3 +326,75 @@ func KindName(k reflect.Kind) string {
 		return "Invalid"
 	}
 }
+
+// DeepCopy 深度复制map、slice、数组、指针及结构体的导出字段，结构体的未导出字段及chan、func等为浅复制
+func DeepCopy(v htypes.Any) htypes.Any {
+	if v == nil {
+		return nil
+	}
+	return deepCopy(reflect.ValueOf(v), make(map[uintptr]reflect.Value)).Interface()
+}
+
+func deepCopy(v reflect.Value, visited map[uintptr]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		//指针相互引用时保持引用关系
+		if c, ok := visited[v.Pointer()]; ok {
+			return c
+		}
+		c := reflect.New(v.Elem().Type())
+		visited[v.Pointer()] = c
+		c.Elem().Set(deepCopy(v.Elem(), visited))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopy(v.Elem(), visited))
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), deepCopy(iter.Value(), visited))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			reflect.Copy(c, v)
+			return c
+		}
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), visited))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), visited))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if f := c.Field(i); f.CanSet() {
+				f.Set(deepCopy(v.Field(i), visited))
+			}
+		}
+		return c
+	default:
+		return v
+	}
+}
@@ -0,0 +1,55 @@
+package hruntime
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type copyItem struct {
+	Name  string
+	Tags  []string
+	Attrs map[string]interface{}
+	Next  *copyItem
+	At    time.Time
+	Raw   []byte
+
+	hidden *int
+}
+
+func TestDeepCopy(t *testing.T) {
+	n := 1
+	src := &copyItem{
+		Name:   "a",
+		Tags:   []string{"x", "y"},
+		Attrs:  map[string]interface{}{"list": []interface{}{1.0, map[string]interface{}{"k": "v"}}},
+		At:     time.Now(),
+		Raw:    []byte("raw"),
+		hidden: &n,
+	}
+	src.Next = src
+
+	dst := DeepCopy(src).(*copyItem)
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("copy not equal: %+v", dst)
+	}
+	if dst.Next != dst {
+		t.Error("pointer cycle not preserved")
+	}
+
+	dst.Tags[0] = "changed"
+	dst.Attrs["list"].([]interface{})[1].(map[string]interface{})["k"] = "changed"
+	dst.Raw[0] = 'R'
+	if src.Tags[0] != "x" || src.Attrs["list"].([]interface{})[1].(map[string]interface{})["k"] != "v" || string(src.Raw) != "raw" {
+		t.Errorf("source modified through copy: %+v", src)
+	}
+	if dst.hidden != src.hidden {
+		t.Error("unexported field should be copied shallowly")
+	}
+
+	for _, v := range []interface{}{nil, 1, "s", []int(nil), map[string]int(nil)} {
+		if got := DeepCopy(v); !reflect.DeepEqual(got, v) {
+			t.Errorf("copy of %v got %v", v, got)
+		}
+	}
+}